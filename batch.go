@@ -0,0 +1,16 @@
+package catrina
+
+type (
+	// Outcome of one row of a batch operation (InsertMany, UpdateMany,
+	// DeleteMany), in the order of the input rows. Id is the id of the
+	// row, generated on insert.
+	BatchResult struct {
+		Id    Value
+		Error error
+	}
+
+	TypedBatchResult[ID any] struct {
+		Id    ID
+		Error error
+	}
+)
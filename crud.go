@@ -1,14 +1,71 @@
 package catrina
 
+import "context"
+
 type (
+	// Select channels must be read until they are closed, as streaming
+	// backends (e.g. the SQL ones) hold a connection until then. To stop
+	// reading early, use the ContextCRUD variants and cancel the context.
 	CRUD interface {
 		Insert(values []Value) (id Value, e error)
 		Select(id Value) (Object, error)
 		SelectWhereFields(fields []string, values []Value) (<-chan Row, error)
 		SelectWhereRange(field string, min, max Value) (<-chan Row, error)
+		SelectWhere(filter Filter) (<-chan Row, error)
+		SelectQuery(query Query) (<-chan Row, error)
+		Count(filter Filter) (int64, error)
+		// The expression is pasted into the query as is: never build it
+		// from user input, use SelectWhere instead.
 		SelectWhereExpression(expr string, values []Value) (<-chan Row, error)
 		Update(id Value, values []Value) error
+		// Inserts the row with the given id, or updates it if it exists
+		// (in MySQL, also if another unique key matches). A nil id is
+		// generated as by Insert. Returns the id of the row.
+		Upsert(id Value, values []Value) (Value, error)
+		// Updates the row only if its field still holds version, for
+		// optimistic concurrency: values usually hold the next version.
+		// Returns ConflictErr otherwise, and the same error as Select
+		// if the row does not exist.
+		UpdateIfVersion(id Value, field string, version Value, values []Value) error
 		Delete(id Value) error
+		// Deletes the row only if its field still holds version, with
+		// the same errors as UpdateIfVersion
+		DeleteIfVersion(id Value, field string, version Value) error
+		// Batch operations report errors per row. The error is only set
+		// when the whole batch fails (e.g. the context is cancelled):
+		// rows processed before keep their results, and the others
+		// report the same error. Batches are not atomic, unless run in
+		// a transaction.
+		InsertMany(rows [][]Value) ([]BatchResult, error)
+		UpdateMany(ids []Value, rows [][]Value) ([]BatchResult, error)
+		DeleteMany(ids []Value) ([]BatchResult, error)
+	}
+
+	// Context-aware variant of CRUD, following the database/sql naming
+	// convention. Implementations should abort the underlying queries
+	// when the context is cancelled.
+	//
+	// Select channels must be read until they are closed, or their
+	// context cancelled, for the backend to release the query. After a
+	// cancellation the channel may close without an error row, so
+	// check ctx.Err(); Iterate does both.
+	ContextCRUD interface {
+		InsertContext(ctx context.Context, values []Value) (id Value, e error)
+		SelectContext(ctx context.Context, id Value) (Object, error)
+		SelectWhereFieldsContext(ctx context.Context, fields []string, values []Value) (<-chan Row, error)
+		SelectWhereRangeContext(ctx context.Context, field string, min, max Value) (<-chan Row, error)
+		SelectWhereContext(ctx context.Context, filter Filter) (<-chan Row, error)
+		SelectQueryContext(ctx context.Context, query Query) (<-chan Row, error)
+		CountContext(ctx context.Context, filter Filter) (int64, error)
+		SelectWhereExpressionContext(ctx context.Context, expr string, values []Value) (<-chan Row, error)
+		UpdateContext(ctx context.Context, id Value, values []Value) error
+		UpsertContext(ctx context.Context, id Value, values []Value) (Value, error)
+		UpdateIfVersionContext(ctx context.Context, id Value, field string, version Value, values []Value) error
+		DeleteContext(ctx context.Context, id Value) error
+		DeleteIfVersionContext(ctx context.Context, id Value, field string, version Value) error
+		InsertManyContext(ctx context.Context, rows [][]Value) ([]BatchResult, error)
+		UpdateManyContext(ctx context.Context, ids []Value, rows [][]Value) ([]BatchResult, error)
+		DeleteManyContext(ctx context.Context, ids []Value) ([]BatchResult, error)
 	}
 
 	Row struct {
@@ -0,0 +1,30 @@
+package crud
+
+import (
+	"context"
+	"github.com/buduchail/catrina"
+)
+
+// Runs a batch one row at a time, for the operations that have no
+// faster way. run returns the id and the error of the ith row.
+func eachRow(ctx context.Context, count int, run func(i int) (catrina.Value, error)) ([]catrina.BatchResult, error) {
+
+	results := make([]catrina.BatchResult, count)
+
+	for i := range results {
+		err := ctx.Err()
+		if err != nil {
+			failRows(results[i:], err)
+			return results, err
+		}
+		results[i].Id, results[i].Error = run(i)
+	}
+
+	return results, nil
+}
+
+func failRows(results []catrina.BatchResult, err error) {
+	for i := range results {
+		results[i].Error = err
+	}
+}
@@ -0,0 +1,1110 @@
+package crud
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+	"bytes"
+	"errors"
+	"context"
+	"encoding/binary"
+	"github.com/buduchail/catrina"
+	bolt "go.etcd.io/bbolt"
+)
+
+type (
+	// CRUD over an embedded bbolt key-value store. It behaves like
+	// MemoryCRUD: ids are auto-incremented int64 values, the first field
+	// is the id field, values are compared like MySQL does and missing
+	// rows are reported with the same errors.
+	//
+	// Rows are stored in a bucket, encoded with a RowCodec and keyed by
+	// id. Fields given to AddIndex get a secondary index, so that
+	// SelectWhereFields and SelectWhereRange on them do not scan the
+	// whole bucket. SelectWhereExpression always scans.
+	//
+	// Selects read every matching row in one read transaction, which
+	// would otherwise be held while the consumer reads: their channels
+	// are fully buffered, so they can be abandoned at any time.
+	BoltCRUD struct {
+		db      *bolt.DB
+		ownsDB  bool
+		bucket  []byte
+		fields  []string
+		index   map[string]int
+		codec   RowCodec
+		hydrate BoltHydrateFunc
+
+		indexLock sync.RWMutex
+		indexed   map[int]bool
+
+		exprLock    sync.RWMutex
+		expressions map[string]*expression
+	}
+
+	BoltHydrateFunc = MemoryHydrateFunc
+
+	// Index keys of a field, between low and high (inclusive)
+	boltRange struct {
+		field     string
+		low, high []byte
+	}
+
+	// Outcome of a row of a batch: its id, and an error reported for
+	// that row only
+	boltBatchRow struct {
+		id  catrina.Value
+		err error
+	}
+)
+
+const (
+	// Kinds of values in index keys, in sort order
+	boltNumberKind byte = 1 + iota
+	boltStringKind
+	boltTimeKind
+)
+
+// Opens (or creates) the database file at path and stores rows in the
+// given bucket. Rows are encoded with codec, GobRowCodec if nil.
+func NewBoltCRUD(path string, bucket string, fields []string, codec RowCodec, hydrate BoltHydrateFunc) (*BoltCRUD, error) {
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := NewBoltCRUDWithDB(db, bucket, fields, codec, hydrate)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	b.ownsDB = true
+
+	return b, nil
+}
+
+// Same as NewBoltCRUD, for an already open database, so that several
+// resources can share one file (each in its own bucket).
+func NewBoltCRUDWithDB(db *bolt.DB, bucket string, fields []string, codec RowCodec, hydrate BoltHydrateFunc) (*BoltCRUD, error) {
+
+	if len(fields) == 0 {
+		return nil, errors.New("At least one field must be defined")
+	}
+
+	if codec == nil {
+		codec = GobRowCodec{}
+	}
+
+	b := BoltCRUD{
+		db:          db,
+		bucket:      []byte(bucket),
+		fields:      fields,
+		index:       make(map[string]int, len(fields)),
+		codec:       codec,
+		hydrate:     hydrate,
+		indexed:     make(map[int]bool, 0),
+		expressions: make(map[string]*expression, 0),
+	}
+
+	for i, f := range fields {
+		b.index[f] = i
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(b.bucket)
+		if err != nil {
+			return err
+		}
+		// indexes created by a previous AddIndex are kept up to date
+		for i, f := range fields {
+			if tx.Bucket(b.indexBucket(f)) != nil {
+				b.indexed[i] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &b, nil
+}
+
+// Helper methods
+
+// Transactions on a closed database fail with catrina.UnavailableErr
+func (r *BoltCRUD) update(f func(tx *bolt.Tx) error) error {
+	return unavailable(r.db.Update(f))
+}
+
+func (r *BoltCRUD) view(f func(tx *bolt.Tx) error) error {
+	return unavailable(r.db.View(f))
+}
+
+func (r *BoltCRUD) indexBucket(field string) []byte {
+	return []byte(string(r.bucket) + ".idx." + field)
+}
+
+func boltKey(id int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+	return key
+}
+
+// Order-preserving encoding of a float: flip the sign bit of positive
+// numbers and every bit of negative ones.
+func boltFloatKey(f float64) []byte {
+	bits := math.Float64bits(f)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, bits)
+	return key
+}
+
+// Escapes zero bytes and terminates the string, so that a shorter
+// string sorts before a longer one starting with it, whatever follows.
+func boltEscapeString(s string) []byte {
+	key := make([]byte, 0, len(s)+2)
+	for i := 0; i < len(s); i++ {
+		key = append(key, s[i])
+		if s[i] == 0 {
+			key = append(key, 0xff)
+		}
+	}
+	return append(key, 0, 0)
+}
+
+func boltTimeKey(t time.Time) []byte {
+	key := make([]byte, 9)
+	key[0] = boltTimeKind
+	binary.BigEndian.PutUint64(key[1:], uint64(t.UnixNano())^(1<<63))
+	return key
+}
+
+// Index keys (kind followed by the encoded value) for a value. As
+// numeric strings compare by value with numbers, and byte by byte with
+// other strings, they are indexed as both (and likewise for RFC 3339
+// strings and times). NULL is never indexed.
+func boltValueKeys(v catrina.Value) [][]byte {
+
+	if v == nil {
+		return nil
+	}
+
+	if t, isTime := v.(time.Time); isTime {
+		return [][]byte{boltTimeKey(t)}
+	}
+
+	keys := make([][]byte, 0, 2)
+	if !isNumber(v) {
+		s, _ := toString(v)
+		keys = append(keys, append([]byte{boltStringKind}, boltEscapeString(s)...))
+		if t, ok := toTime(s); ok {
+			keys = append(keys, boltTimeKey(t))
+		}
+	}
+	if f, ok := toFloat64(v); ok {
+		keys = append(keys, append([]byte{boltNumberKind}, boltFloatKey(f)...))
+	}
+
+	return keys
+}
+
+func (r *BoltCRUD) isIndexed(index int) bool {
+	r.indexLock.RLock()
+	defer r.indexLock.RUnlock()
+	return r.indexed[index]
+}
+
+func (r *BoltCRUD) indexedFields() []int {
+	r.indexLock.RLock()
+	defer r.indexLock.RUnlock()
+	indexes := make([]int, 0, len(r.indexed))
+	for i := range r.indexed {
+		indexes = append(indexes, i)
+	}
+	return indexes
+}
+
+func (r *BoltCRUD) addIndexEntries(tx *bolt.Tx, id int64, row []catrina.Value) error {
+	for _, i := range r.indexedFields() {
+		idx := tx.Bucket(r.indexBucket(r.fields[i]))
+		if idx == nil {
+			// AddIndex failed and is being rolled back
+			continue
+		}
+		for _, key := range boltValueKeys(row[i]) {
+			err := idx.Put(append(key, boltKey(id)...), []byte{})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *BoltCRUD) removeIndexEntries(tx *bolt.Tx, id int64, row []catrina.Value) error {
+	for _, i := range r.indexedFields() {
+		idx := tx.Bucket(r.indexBucket(r.fields[i]))
+		if idx == nil {
+			// AddIndex failed and is being rolled back
+			continue
+		}
+		for _, key := range boltValueKeys(row[i]) {
+			err := idx.Delete(append(key, boltKey(id)...))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Stores a row and returns it as it will be read back, so that index
+// entries are computed from the decoded values (e.g. the JSON codec
+// turns times into strings).
+func (r *BoltCRUD) putRow(tx *bolt.Tx, id int64, row []catrina.Value) ([]catrina.Value, error) {
+
+	data, err := r.codec.Encode(row)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := r.codec.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return stored, tx.Bucket(r.bucket).Put(boltKey(id), data)
+}
+
+func (r *BoltCRUD) insertRow(tx *bolt.Tx, values []catrina.Value) (int64, error) {
+
+	seq, err := tx.Bucket(r.bucket).NextSequence()
+	if err != nil {
+		return 0, err
+	}
+	id := int64(seq)
+
+	row := make([]catrina.Value, len(r.fields))
+	row[0] = id
+	copy(row[1:], values)
+
+	stored, err := r.putRow(tx, id, row)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, r.addIndexEntries(tx, id, stored)
+}
+
+func (r *BoltCRUD) updateRow(tx *bolt.Tx, id catrina.Value, values []catrina.Value) error {
+
+	// like UPDATE, changing a missing row is not an error
+	key, ok := toInt64(id)
+	if !ok {
+		return nil
+	}
+
+	row, err := r.getRow(tx, key)
+	if err != nil || row == nil {
+		return err
+	}
+
+	err = r.removeIndexEntries(tx, key, row)
+	if err != nil {
+		return err
+	}
+
+	updated := make([]catrina.Value, len(row))
+	updated[0] = row[0]
+	copy(updated[1:], values)
+
+	stored, err := r.putRow(tx, key, updated)
+	if err != nil {
+		return err
+	}
+
+	return r.addIndexEntries(tx, key, stored)
+}
+
+func (r *BoltCRUD) deleteRow(tx *bolt.Tx, id catrina.Value) error {
+
+	key, ok := toInt64(id)
+	if !ok {
+		return nil
+	}
+
+	row, err := r.getRow(tx, key)
+	if err != nil || row == nil {
+		return err
+	}
+
+	err = r.removeIndexEntries(tx, key, row)
+	if err != nil {
+		return err
+	}
+
+	return tx.Bucket(r.bucket).Delete(boltKey(key))
+}
+
+// Runs a batch in one transaction. run returns the outcome of the ith
+// row, or an error that aborts the batch; in that case every row
+// reports it, as nothing was written.
+func (r *BoltCRUD) batch(ctx context.Context, count int, run func(tx *bolt.Tx, i int) (boltBatchRow, error)) ([]catrina.BatchResult, error) {
+
+	results := make([]catrina.BatchResult, count)
+
+	err := r.update(func(tx *bolt.Tx) error {
+		for i := range results {
+			err := ctx.Err()
+			if err != nil {
+				return err
+			}
+			row, err := run(tx, i)
+			if err != nil {
+				return err
+			}
+			results[i] = catrina.BatchResult{Id: row.id, Error: row.err}
+		}
+		return nil
+	})
+	if err != nil {
+		failRows(results, err)
+		return results, err
+	}
+
+	return results, nil
+}
+
+func (r *BoltCRUD) getRow(tx *bolt.Tx, id int64) ([]catrina.Value, error) {
+
+	data := tx.Bucket(r.bucket).Get(boltKey(id))
+	if data == nil {
+		return nil, nil
+	}
+
+	row, err := r.codec.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(row) != len(r.fields) {
+		return nil, errors.New("Stored row does not match field count")
+	}
+
+	return row, nil
+}
+
+func (r *BoltCRUD) getObject(row []catrina.Value) (catrina.Object, error) {
+
+	if r.hydrate != nil {
+		return r.hydrate(row)
+	}
+
+	object := make(map[string]interface{}, len(r.fields))
+	for i, f := range r.fields {
+		object[f] = row[i]
+	}
+
+	return object, nil
+}
+
+func (r *BoltCRUD) getExpression(where string) (*expression, error) {
+
+	r.exprLock.RLock()
+	expr, parsed := r.expressions[where]
+	r.exprLock.RUnlock()
+
+	if parsed {
+		return expr, nil
+	}
+
+	r.exprLock.Lock()
+	defer r.exprLock.Unlock()
+
+	expr, parsed = r.expressions[where]
+	if !parsed {
+		var err error
+		expr, err = parseExpression(where, r.index)
+		if err != nil {
+			return nil, err
+		}
+		r.expressions[where] = expr
+	}
+
+	return expr, nil
+}
+
+func (r *BoltCRUD) fieldIndex(field string) (int, error) {
+	i, exists := r.index[field]
+	if !exists {
+		return 0, unknownFieldErr(field)
+	}
+	return i, nil
+}
+
+// Ids of the rows whose indexed value may lie between min and max
+// (both given as index keys of the same kind), in id order.
+func (r *BoltCRUD) indexCandidates(tx *bolt.Tx, field string, min, max []byte, found map[int64]bool) {
+
+	c := tx.Bucket(r.indexBucket(field)).Cursor()
+	for k, _ := c.Seek(min); k != nil && len(k) > 8; k, _ = c.Next() {
+		if bytes.Compare(k[:len(k)-8], max) > 0 {
+			break
+		}
+		found[int64(binary.BigEndian.Uint64(k[len(k)-8:]))] = true
+	}
+}
+
+// Index keys to scan for values between min and max, or ok false if the
+// index cannot narrow the search (e.g. a number and a string bound).
+func boltRangeKeys(min, max catrina.Value) (lows, highs [][]byte, ok bool) {
+
+	if min == nil || max == nil {
+		// NULL bounds match nothing
+		return nil, nil, true
+	}
+
+	_, minTime := min.(time.Time)
+	_, maxTime := max.(time.Time)
+	if minTime != maxTime || isNumber(min) != isNumber(max) {
+		return nil, nil, false
+	}
+
+	minKeys := boltValueKeys(min)
+	maxKeys := boltValueKeys(max)
+	for _, low := range minKeys {
+		for _, high := range maxKeys {
+			if low[0] == high[0] {
+				lows = append(lows, low)
+				highs = append(highs, high)
+			}
+		}
+	}
+
+	return lows, highs, true
+}
+
+// Returns the matching rows, ordered by id. Rows are read from the
+// candidate ids if given, or from the whole bucket otherwise.
+func (r *BoltCRUD) matchRows(ctx context.Context, candidates func(tx *bolt.Tx) (map[int64]bool, bool), match func(row []catrina.Value) bool) ([][]catrina.Value, error) {
+
+	found := make([][]catrina.Value, 0)
+
+	err := r.view(func(tx *bolt.Tx) error {
+
+		ids, indexed := candidates(tx)
+
+		if !indexed {
+			c := tx.Bucket(r.bucket).Cursor()
+			for k, data := c.First(); k != nil; k, data = c.Next() {
+				err := ctx.Err()
+				if err != nil {
+					return err
+				}
+				row, err := r.codec.Decode(data)
+				if err != nil {
+					return err
+				}
+				if match(row) {
+					found = append(found, row)
+				}
+			}
+			return nil
+		}
+
+		sorted := make([]int64, 0, len(ids))
+		for id := range ids {
+			sorted = append(sorted, id)
+		}
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+		for _, id := range sorted {
+			err := ctx.Err()
+			if err != nil {
+				return err
+			}
+			row, err := r.getRow(tx, id)
+			if err != nil {
+				return err
+			}
+			if row != nil && match(row) {
+				found = append(found, row)
+			}
+		}
+		return nil
+	})
+
+	return found, err
+}
+
+// Returns the matching rows, ordered by id unless page sorts them,
+// through a channel that is already filled and closed.
+func (r *BoltCRUD) selectMany(ctx context.Context, candidates func(tx *bolt.Tx) (map[int64]bool, bool), match func(row []catrina.Value) bool, page func(rows [][]catrina.Value) [][]catrina.Value) (<-chan catrina.Row, error) {
+
+	rows, err := r.matchRows(ctx, candidates, match)
+	if err != nil {
+		return nil, err
+	}
+
+	if page != nil {
+		rows = page(rows)
+	}
+
+	result := make(chan catrina.Row, len(rows))
+	for _, row := range rows {
+		obj, err := r.getObject(row)
+		if err != nil {
+			result <- catrina.Row{Error: err}
+		} else {
+			result <- catrina.Row{Result: obj}
+		}
+	}
+	close(result)
+
+	return result, nil
+}
+
+// Reads the candidates of a checked filter from the indexes, see
+// indexRanges
+func (r *BoltCRUD) filterCandidates(filter catrina.Filter) func(tx *bolt.Tx) (map[int64]bool, bool) {
+
+	ranges := r.indexRanges(filter)
+	if ranges == nil {
+		return fullScan
+	}
+
+	return func(tx *bolt.Tx) (map[int64]bool, bool) {
+		found := make(map[int64]bool, 0)
+		for _, rg := range ranges {
+			r.indexCandidates(tx, rg.field, rg.low, rg.high, found)
+		}
+		return found, true
+	}
+}
+
+// Index ranges holding every row that may match a checked filter, or
+// nil if the indexes cannot narrow the search
+func (r *BoltCRUD) indexRanges(f catrina.Filter) []boltRange {
+
+	switch f.Op {
+	case "":
+		return nil
+	case catrina.AndFilter:
+		for _, child := range f.Filters {
+			ranges := r.indexRanges(child)
+			if ranges != nil {
+				return ranges
+			}
+		}
+		return nil
+	case catrina.OrFilter:
+		all := make([]boltRange, 0)
+		for _, child := range f.Filters {
+			ranges := r.indexRanges(child)
+			if ranges == nil {
+				return nil
+			}
+			all = append(all, ranges...)
+		}
+		return all
+	}
+
+	if !r.isIndexed(r.index[f.Field]) {
+		return nil
+	}
+
+	ranges := make([]boltRange, 0)
+
+	switch f.Op {
+	case catrina.EqFilter, catrina.InFilter:
+		for _, v := range f.Values {
+			for _, key := range boltValueKeys(v) {
+				ranges = append(ranges, boltRange{f.Field, key, key})
+			}
+		}
+	case catrina.RangeFilter:
+		lows, highs, narrowed := boltRangeKeys(f.Values[0], f.Values[1])
+		if !narrowed {
+			return nil
+		}
+		for i := range lows {
+			ranges = append(ranges, boltRange{f.Field, lows[i], highs[i]})
+		}
+	default:
+		return nil
+	}
+
+	return ranges
+}
+
+func fullScan(tx *bolt.Tx) (map[int64]bool, bool) {
+	return nil, false
+}
+
+// Public interface
+
+// Creates (or rebuilds) the secondary index of a field
+func (r *BoltCRUD) AddIndex(field string) error {
+
+	index, err := r.fieldIndex(field)
+	if err != nil {
+		return err
+	}
+
+	err = r.update(func(tx *bolt.Tx) error {
+
+		name := r.indexBucket(field)
+		if tx.Bucket(name) != nil {
+			err := tx.DeleteBucket(name)
+			if err != nil {
+				return err
+			}
+		}
+
+		idx, err := tx.CreateBucket(name)
+		if err != nil {
+			return err
+		}
+
+		c := tx.Bucket(r.bucket).Cursor()
+		for k, data := c.First(); k != nil; k, data = c.Next() {
+			row, err := r.codec.Decode(data)
+			if err != nil {
+				return err
+			}
+			for _, key := range boltValueKeys(row[index]) {
+				err = idx.Put(append(key, k...), []byte{})
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		// writes are serialized, so no row can be missed from here on
+		r.indexLock.Lock()
+		r.indexed[index] = true
+		r.indexLock.Unlock()
+		return nil
+	})
+	if err != nil {
+		r.indexLock.Lock()
+		delete(r.indexed, index)
+		r.indexLock.Unlock()
+	}
+
+	return err
+}
+
+// Closes the database if it was opened by NewBoltCRUD
+func (r *BoltCRUD) Close() error {
+	if !r.ownsDB {
+		return nil
+	}
+	return r.db.Close()
+}
+
+func (r *BoltCRUD) Insert(values []catrina.Value) (id catrina.Value, e error) {
+	return r.InsertContext(context.Background(), values)
+}
+
+func (r *BoltCRUD) Select(id catrina.Value) (catrina.Object, error) {
+	return r.SelectContext(context.Background(), id)
+}
+
+func (r *BoltCRUD) SelectWhereFields(fields []string, values []catrina.Value) (<-chan catrina.Row, error) {
+	return r.SelectWhereFieldsContext(context.Background(), fields, values)
+}
+
+func (r *BoltCRUD) SelectWhereRange(field string, min, max catrina.Value) (<-chan catrina.Row, error) {
+	return r.SelectWhereRangeContext(context.Background(), field, min, max)
+}
+
+func (r *BoltCRUD) SelectWhere(filter catrina.Filter) (<-chan catrina.Row, error) {
+	return r.SelectWhereContext(context.Background(), filter)
+}
+
+func (r *BoltCRUD) SelectQuery(query catrina.Query) (<-chan catrina.Row, error) {
+	return r.SelectQueryContext(context.Background(), query)
+}
+
+func (r *BoltCRUD) Count(filter catrina.Filter) (int64, error) {
+	return r.CountContext(context.Background(), filter)
+}
+
+// Supports a subset of SQL, see parseExpression
+func (r *BoltCRUD) SelectWhereExpression(where string, values []catrina.Value) (<-chan catrina.Row, error) {
+	return r.SelectWhereExpressionContext(context.Background(), where, values)
+}
+
+func (r *BoltCRUD) Update(id catrina.Value, values []catrina.Value) error {
+	return r.UpdateContext(context.Background(), id, values)
+}
+
+func (r *BoltCRUD) Upsert(id catrina.Value, values []catrina.Value) (catrina.Value, error) {
+	return r.UpsertContext(context.Background(), id, values)
+}
+
+func (r *BoltCRUD) UpdateIfVersion(id catrina.Value, field string, version catrina.Value, values []catrina.Value) error {
+	return r.UpdateIfVersionContext(context.Background(), id, field, version, values)
+}
+
+func (r *BoltCRUD) Delete(id catrina.Value) error {
+	return r.DeleteContext(context.Background(), id)
+}
+
+func (r *BoltCRUD) DeleteIfVersion(id catrina.Value, field string, version catrina.Value) error {
+	return r.DeleteIfVersionContext(context.Background(), id, field, version)
+}
+
+func (r *BoltCRUD) InsertMany(rows [][]catrina.Value) ([]catrina.BatchResult, error) {
+	return r.InsertManyContext(context.Background(), rows)
+}
+
+func (r *BoltCRUD) UpdateMany(ids []catrina.Value, rows [][]catrina.Value) ([]catrina.BatchResult, error) {
+	return r.UpdateManyContext(context.Background(), ids, rows)
+}
+
+func (r *BoltCRUD) DeleteMany(ids []catrina.Value) ([]catrina.BatchResult, error) {
+	return r.DeleteManyContext(context.Background(), ids)
+}
+
+// Context-aware public interface
+
+func (r *BoltCRUD) InsertContext(ctx context.Context, values []catrina.Value) (id catrina.Value, e error) {
+
+	if len(r.fields)-1 != len(values) {
+		return nil, valueCountErr
+	}
+
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	var lastId int64
+	err = r.update(func(tx *bolt.Tx) (err error) {
+		lastId, err = r.insertRow(tx, values)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return lastId, nil
+}
+
+func (r *BoltCRUD) SelectContext(ctx context.Context, id catrina.Value) (catrina.Object, error) {
+
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := toInt64(id)
+	if !ok {
+		return nil, catrina.NotFoundErr
+	}
+
+	var row []catrina.Value
+	err = r.view(func(tx *bolt.Tx) (err error) {
+		row, err = r.getRow(tx, key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if row == nil {
+		return nil, catrina.NotFoundErr
+	}
+
+	return r.getObject(row)
+}
+
+func (r *BoltCRUD) SelectWhereFieldsContext(ctx context.Context, fields []string, values []catrina.Value) (<-chan catrina.Row, error) {
+
+	if len(fields) != len(values) {
+		return nil, catrina.NewValidationError("Fields and values do not match")
+	}
+
+	if len(fields) == 0 {
+		return nil, catrina.NewValidationError("At least one field must be given")
+	}
+
+	filters := make([]catrina.Filter, len(fields))
+	for i, f := range fields {
+		filters[i] = catrina.Eq(f, values[i])
+	}
+
+	return r.SelectWhereContext(ctx, catrina.And(filters...))
+}
+
+func (r *BoltCRUD) SelectWhereRangeContext(ctx context.Context, field string, min, max catrina.Value) (<-chan catrina.Row, error) {
+	return r.SelectWhereContext(ctx, catrina.Range(field, min, max))
+}
+
+// Uses the indexes when the filter is an equality, IN or range on an
+// indexed field, or an AND of filters one of which is, or an OR of
+// such filters.
+func (r *BoltCRUD) SelectWhereContext(ctx context.Context, filter catrina.Filter) (<-chan catrina.Row, error) {
+
+	err := checkFilter(filter, r.index)
+	if err != nil {
+		return nil, err
+	}
+
+	match := compileFilter(filter, r.index)
+
+	return r.selectMany(ctx, r.filterCandidates(filter), func(row []catrina.Value) bool {
+		return match(row) == trueTruth
+	}, nil)
+}
+
+// Uses the indexes like SelectWhereContext, but sorts in memory
+func (r *BoltCRUD) SelectQueryContext(ctx context.Context, query catrina.Query) (<-chan catrina.Row, error) {
+
+	fields := querySort(query, r.fields[0])
+
+	err := checkQuery(query, fields, r.index)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := queryFilter(query, fields)
+	match := compileFilter(filter, r.index)
+
+	return r.selectMany(ctx, r.filterCandidates(filter), func(row []catrina.Value) bool {
+		return match(row) == trueTruth
+	}, queryPage(query, fields, r.index))
+}
+
+func (r *BoltCRUD) CountContext(ctx context.Context, filter catrina.Filter) (int64, error) {
+
+	err := checkFilter(filter, r.index)
+	if err != nil {
+		return 0, err
+	}
+
+	match := compileFilter(filter, r.index)
+
+	rows, err := r.matchRows(ctx, r.filterCandidates(filter), func(row []catrina.Value) bool {
+		return match(row) == trueTruth
+	})
+
+	return int64(len(rows)), err
+}
+
+func (r *BoltCRUD) SelectWhereExpressionContext(ctx context.Context, where string, values []catrina.Value) (<-chan catrina.Row, error) {
+
+	expr, err := r.getExpression(where)
+	if err != nil {
+		return nil, err
+	}
+
+	if expr.placeholders != len(values) {
+		return nil, catrina.NewValidationError("Placeholders and values do not match")
+	}
+
+	return r.selectMany(ctx, fullScan, func(row []catrina.Value) bool {
+		return expr.match(row, values)
+	}, nil)
+}
+
+func (r *BoltCRUD) UpdateContext(ctx context.Context, id catrina.Value, values []catrina.Value) error {
+
+	if len(r.fields)-1 != len(values) {
+		return valueCountErr
+	}
+
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	return r.update(func(tx *bolt.Tx) error {
+		return r.updateRow(tx, id, values)
+	})
+}
+
+// Ids are positive int64 values, so other ids are rejected. Ids given
+// above the bucket sequence move it past them.
+func (r *BoltCRUD) UpsertContext(ctx context.Context, id catrina.Value, values []catrina.Value) (catrina.Value, error) {
+
+	if id == nil {
+		return r.InsertContext(ctx, values)
+	}
+
+	if len(r.fields)-1 != len(values) {
+		return nil, valueCountErr
+	}
+
+	key, ok := toInt64(id)
+	if !ok || key < 1 {
+		return nil, invalidIdErr
+	}
+
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.update(func(tx *bolt.Tx) error {
+
+		row, err := r.getRow(tx, key)
+		if err != nil {
+			return err
+		}
+		if row != nil {
+			return r.updateRow(tx, key, values)
+		}
+
+		b := tx.Bucket(r.bucket)
+		if b.Sequence() < uint64(key) {
+			err = b.SetSequence(uint64(key))
+			if err != nil {
+				return err
+			}
+		}
+
+		row = make([]catrina.Value, len(r.fields))
+		row[0] = key
+		copy(row[1:], values)
+
+		stored, err := r.putRow(tx, key, row)
+		if err != nil {
+			return err
+		}
+
+		return r.addIndexEntries(tx, key, stored)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func (r *BoltCRUD) UpdateIfVersionContext(ctx context.Context, id catrina.Value, field string, version catrina.Value, values []catrina.Value) error {
+
+	if len(r.fields)-1 != len(values) {
+		return valueCountErr
+	}
+
+	i, err := r.fieldIndex(field)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	key, ok := toInt64(id)
+	if !ok {
+		return catrina.NotFoundErr
+	}
+
+	return r.update(func(tx *bolt.Tx) error {
+
+		row, err := r.getRow(tx, key)
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			return catrina.NotFoundErr
+		}
+
+		if c, ok := compareValues(row[i], version); !ok || c != 0 {
+			return catrina.ConflictErr
+		}
+
+		return r.updateRow(tx, key, values)
+	})
+}
+
+func (r *BoltCRUD) DeleteContext(ctx context.Context, id catrina.Value) error {
+
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	return r.update(func(tx *bolt.Tx) error {
+		return r.deleteRow(tx, id)
+	})
+}
+
+func (r *BoltCRUD) DeleteIfVersionContext(ctx context.Context, id catrina.Value, field string, version catrina.Value) error {
+
+	i, err := r.fieldIndex(field)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	key, ok := toInt64(id)
+	if !ok {
+		return catrina.NotFoundErr
+	}
+
+	return r.update(func(tx *bolt.Tx) error {
+
+		row, err := r.getRow(tx, key)
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			return catrina.NotFoundErr
+		}
+
+		if c, ok := compareValues(row[i], version); !ok || c != 0 {
+			return catrina.ConflictErr
+		}
+
+		return r.deleteRow(tx, key)
+	})
+}
+
+// Batches run in a single transaction, so they are much faster than
+// one call per row. Rows with the wrong number of values are reported
+// and skipped; any other error rolls the whole batch back.
+func (r *BoltCRUD) InsertManyContext(ctx context.Context, rows [][]catrina.Value) ([]catrina.BatchResult, error) {
+	return r.batch(ctx, len(rows), func(tx *bolt.Tx, i int) (boltBatchRow, error) {
+		if len(r.fields)-1 != len(rows[i]) {
+			return boltBatchRow{err: valueCountErr}, nil
+		}
+		id, err := r.insertRow(tx, rows[i])
+		return boltBatchRow{id: id}, err
+	})
+}
+
+func (r *BoltCRUD) UpdateManyContext(ctx context.Context, ids []catrina.Value, rows [][]catrina.Value) ([]catrina.BatchResult, error) {
+
+	if len(ids) != len(rows) {
+		return nil, idsAndRowsErr
+	}
+
+	return r.batch(ctx, len(rows), func(tx *bolt.Tx, i int) (boltBatchRow, error) {
+		if len(r.fields)-1 != len(rows[i]) {
+			return boltBatchRow{ids[i], valueCountErr}, nil
+		}
+		return boltBatchRow{id: ids[i]}, r.updateRow(tx, ids[i], rows[i])
+	})
+}
+
+func (r *BoltCRUD) DeleteManyContext(ctx context.Context, ids []catrina.Value) ([]catrina.BatchResult, error) {
+	return r.batch(ctx, len(ids), func(tx *bolt.Tx, i int) (boltBatchRow, error) {
+		return boltBatchRow{id: ids[i]}, r.deleteRow(tx, ids[i])
+	})
+}
@@ -0,0 +1,366 @@
+package crud
+
+import (
+	"time"
+	"bytes"
+	"errors"
+	"context"
+	"reflect"
+	"testing"
+	"encoding/json"
+	"encoding/binary"
+	"path/filepath"
+
+	"github.com/buduchail/catrina"
+	bolt "go.etcd.io/bbolt"
+)
+
+type (
+	// Fails to encode rows holding "fail", to abort batches
+	failingRowCodec struct {
+		GobRowCodec
+	}
+)
+
+var (
+	failingRowErr = errors.New("Cannot encode")
+
+	noon = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	boltItems = [][]catrina.Value{
+		{"pen", int64(3)},
+		{"ink", nil},
+		{"10", int64(5)},
+		{"9", int64(-2)},
+		{"cup", 2.5},
+		{"", int64(3)},
+		{"pen\x00x", int64(7)},
+		{"pens", -0.5},
+		{noon, int64(1)},
+		{"2024-05-01T18:00:00Z", "3"},
+		{"9.0", 1e10},
+	}
+)
+
+func (c failingRowCodec) Encode(values []catrina.Value) ([]byte, error) {
+	for _, v := range values {
+		if v == "fail" {
+			return nil, failingRowErr
+		}
+	}
+	return c.GobRowCodec.Encode(values)
+}
+
+func openBolt(t *testing.T, codec RowCodec) *BoltCRUD {
+
+	items, err := NewBoltCRUD(filepath.Join(t.TempDir(), "items.db"), "items", []string{"id", "name", "price"}, codec, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { items.Close() })
+
+	return items
+}
+
+// Ids of the entries of the index of a field, by encoded value kind
+func boltIndexIds(t *testing.T, items *BoltCRUD, field string) map[byte][]int64 {
+
+	ids := map[byte][]int64{}
+
+	err := items.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(items.indexBucket(field)).ForEach(func(k, v []byte) error {
+			ids[k[0]] = append(ids[k[0]], int64(binary.BigEndian.Uint64(k[len(k)-8:])))
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ids
+}
+
+// Indexed selects return the same rows as scans, and as MemoryCRUD
+func TestBoltIndexes(t *testing.T) {
+
+	memory := newMemoryItems(t, boltItems...)
+	scanned := openBolt(t, nil)
+	insertItems(t, scanned, boltItems...)
+	indexed := openBolt(t, nil)
+	insertItems(t, indexed, boltItems...)
+
+	for _, field := range []string{"name", "price"} {
+		err := indexed.AddIndex(field)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	filters := []catrina.Filter{
+		catrina.Eq("name", "pen"),
+		catrina.Eq("name", ""),
+		catrina.Eq("name", "pen\x00x"),
+		// numeric strings compare with numbers by value
+		catrina.Eq("name", 10),
+		catrina.Eq("name", 9.0),
+		catrina.Eq("name", "9"),
+		catrina.Eq("price", 3),
+		catrina.Eq("price", "3"),
+		catrina.Eq("price", "3.0"),
+		catrina.Eq("price", nil),
+		catrina.In("price", 5, "2.5", -2),
+		catrina.Range("price", -1, 3),
+		catrina.Range("price", -2.5, 1e11),
+		catrina.Range("price", "-1", "3"),
+		catrina.Range("price", nil, 3),
+		catrina.Range("name", "pen", "pens"),
+		catrina.Range("name", "1", "9"),
+		catrina.Range("name", 1, 10),
+		// times compare with RFC 3339 strings
+		catrina.Eq("name", "2024-05-01T12:00:00Z"),
+		catrina.Range("name", noon, noon.Add(6*time.Hour)),
+		catrina.Range("name", "2024-05-01T00:00:00Z", "2024-05-02T00:00:00Z"),
+		catrina.And(catrina.Eq("name", "pen"), catrina.Gt("price", 1)),
+		catrina.And(catrina.Gt("price", 1), catrina.Range("name", "a", "z")),
+		catrina.Or(catrina.Eq("name", "ink"), catrina.Eq("price", 5)),
+		// not narrowed by the indexes
+		catrina.Or(catrina.Eq("name", "ink"), catrina.Gt("price", 5)),
+		catrina.Range("price", 1, "z"),
+	}
+
+	for _, filter := range filters {
+
+		rows, err := memory.SelectWhere(filter)
+		expected := memoryIds(t, rows, err)
+
+		for _, items := range []*BoltCRUD{scanned, indexed} {
+			rows, err := items.SelectWhere(filter)
+			ids := memoryIds(t, rows, err)
+			if !reflect.DeepEqual(ids, expected) {
+				t.Errorf("%v (indexed %v): expected %v, got %v", filter, items.isIndexed(1), expected, ids)
+			}
+
+			count, err := items.Count(filter)
+			if err != nil || count != int64(len(expected)) {
+				t.Errorf("%v: expected %d rows, counted %d (%v)", filter, len(expected), count, err)
+			}
+		}
+	}
+
+	// indexes are found again when the file is reopened
+	reopened, err := NewBoltCRUDWithDB(indexed.db, "items", []string{"id", "name", "price"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reopened.isIndexed(1) || !reopened.isIndexed(2) || reopened.isIndexed(0) {
+		t.Errorf("unexpected indexes %v", reopened.indexed)
+	}
+}
+
+func TestBoltIndexMaintenance(t *testing.T) {
+
+	items := openBolt(t, nil)
+	err := items.AddIndex("name")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	insertItems(t, items, []catrina.Value{"10", 1}, []catrina.Value{"pen", 2}, []catrina.Value{nil, 3})
+
+	// numeric strings have a string and a number entry, NULL none
+	expected := map[byte][]int64{boltNumberKind: {1}, boltStringKind: {1, 2}}
+	if ids := boltIndexIds(t, items, "name"); !reflect.DeepEqual(ids, expected) {
+		t.Errorf("after insert: expected %v, got %v", expected, ids)
+	}
+
+	err = items.Update(int64(1), []catrina.Value{"cup", 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = items.Update(int64(3), []catrina.Value{"2024-05-01T12:00:00Z", 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = map[byte][]int64{boltStringKind: {3, 1, 2}, boltTimeKind: {3}}
+	if ids := boltIndexIds(t, items, "name"); !reflect.DeepEqual(ids, expected) {
+		t.Errorf("after update: expected %v, got %v", expected, ids)
+	}
+
+	rows, err := items.SelectWhereFields([]string{"name"}, []catrina.Value{10})
+	if ids := memoryIds(t, rows, err); len(ids) != 0 {
+		t.Errorf("old value still selects %v", ids)
+	}
+
+	_, err = items.Upsert(int64(2), []catrina.Value{"ink", 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = items.Delete(int64(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = map[byte][]int64{boltStringKind: {1, 2}}
+	if ids := boltIndexIds(t, items, "name"); !reflect.DeepEqual(ids, expected) {
+		t.Errorf("after delete: expected %v, got %v", expected, ids)
+	}
+
+	rows, err = items.SelectWhereFields([]string{"name"}, []catrina.Value{"ink"})
+	if ids := memoryIds(t, rows, err); !reflect.DeepEqual(ids, []int64{2}) {
+		t.Errorf("expected the upserted row, got %v", ids)
+	}
+
+	err = items.DeleteIfVersion(int64(1), "price", 2)
+	if err != catrina.ConflictErr {
+		t.Errorf("expected %v, got %v", catrina.ConflictErr, err)
+	}
+	err = items.DeleteIfVersion(int64(1), "price", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = map[byte][]int64{boltStringKind: {2}}
+	if ids := boltIndexIds(t, items, "name"); !reflect.DeepEqual(ids, expected) {
+		t.Errorf("after a versioned delete: expected %v, got %v", expected, ids)
+	}
+}
+
+func TestBoltCodecs(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		codec    RowCodec
+		row      []catrina.Value
+		expected map[string]interface{}
+	}{
+		{"gob", GobRowCodec{}, []catrina.Value{noon, 2.5}, map[string]interface{}{"id": int64(1), "name": noon, "price": 2.5}},
+		{"gob null", GobRowCodec{}, []catrina.Value{"pen", nil}, map[string]interface{}{"id": int64(1), "name": "pen", "price": nil}},
+		// numbers come back as json.Number, times as strings
+		{"json", JSONRowCodec{}, []catrina.Value{noon, 2.5}, map[string]interface{}{"id": json.Number("1"), "name": "2024-05-01T12:00:00Z", "price": json.Number("2.5")}},
+		{"json null", JSONRowCodec{}, []catrina.Value{"pen", nil}, map[string]interface{}{"id": json.Number("1"), "name": "pen", "price": nil}},
+	}
+
+	for _, test := range tests {
+
+		items := openBolt(t, test.codec)
+		err := items.AddIndex("name")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		id, err := items.Insert(test.row)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		object, err := items.Select(id)
+		if err != nil || !reflect.DeepEqual(object, test.expected) {
+			t.Errorf("%s: expected %v, got %v (%v)", test.name, test.expected, object, err)
+		}
+
+		// the index holds the values as they are read back, which still
+		// compare equal to the ones written
+		rows, err := items.SelectWhere(catrina.Eq("name", test.row[0]))
+		found := collectRows(t, rows, err)
+		if len(found) != 1 || !reflect.DeepEqual(found[0], test.expected) {
+			t.Errorf("%s: expected the row by %v, got %v", test.name, test.row[0], found)
+		}
+	}
+}
+
+// Batches are written in one transaction, rows and index entries alike
+func TestBoltBatchRollback(t *testing.T) {
+
+	ctx := context.Background()
+	items := openBolt(t, failingRowCodec{})
+	err := items.AddIndex("name")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := items.InsertManyContext(ctx, [][]catrina.Value{{"pen", 1}, {"ink"}, {"cup", 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []catrina.BatchResult{{Id: int64(1)}, {Error: valueCountErr}, {Id: int64(2)}}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("expected %v, got %v", expected, results)
+	}
+
+	before := boltIndexIds(t, items, "name")
+
+	batches := []struct {
+		name string
+		run  func() ([]catrina.BatchResult, error)
+	}{
+		{"insert", func() ([]catrina.BatchResult, error) {
+			return items.InsertManyContext(ctx, [][]catrina.Value{{"mug", 4}, {"fail", 5}})
+		}},
+		{"update", func() ([]catrina.BatchResult, error) {
+			return items.UpdateManyContext(ctx, []catrina.Value{int64(1), int64(2)}, [][]catrina.Value{{"mug", 4}, {"fail", 5}})
+		}},
+		{"cancelled", func() ([]catrina.BatchResult, error) {
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+			return items.DeleteManyContext(cancelled, []catrina.Value{int64(1), int64(2)})
+		}},
+	}
+
+	for _, batch := range batches {
+
+		results, err := batch.run()
+		if err == nil {
+			t.Fatalf("%s: expected an error", batch.name)
+		}
+		for _, result := range results {
+			if result.Error != err {
+				t.Errorf("%s: expected %v on every row, got %v", batch.name, err, result.Error)
+			}
+		}
+
+		rows, err := items.SelectWhere(catrina.Filter{})
+		if ids := memoryIds(t, rows, err); !reflect.DeepEqual(ids, []int64{1, 2}) {
+			t.Errorf("%s: expected rows 1 and 2, got %v", batch.name, ids)
+		}
+		object, err := items.Select(int64(1))
+		if err != nil || object.(map[string]interface{})["name"] != "pen" {
+			t.Errorf("%s: row 1 changed to %v (%v)", batch.name, object, err)
+		}
+		if ids := boltIndexIds(t, items, "name"); !reflect.DeepEqual(ids, before) {
+			t.Errorf("%s: index changed from %v to %v", batch.name, before, ids)
+		}
+	}
+
+	// the id sequence is rolled back too
+	id, err := items.Insert([]catrina.Value{"mug", 4})
+	if err != nil || id != int64(3) {
+		t.Errorf("expected id 3, got %v (%v)", id, err)
+	}
+
+	// updating and deleting missing rows is not an error
+	results, err = items.DeleteManyContext(ctx, []catrina.Value{int64(3), int64(9), "x"})
+	if err != nil || len(results) != 3 || results[1].Error != nil {
+		t.Errorf("unexpected %v (%v)", results, err)
+	}
+}
+
+// Index keys sort as the values they encode
+func TestBoltKeyOrder(t *testing.T) {
+
+	ordered := [][]catrina.Value{
+		{-1e10, -2.5, -1, -0.5, 0, 0.5, 1, 2, 10, 1e10},
+		{"", "\x00", "\x00\x00", "a", "a\x00", "a\x00b", "a\x01", "ab", "b"},
+		{noon.Add(-time.Hour), noon, noon.Add(time.Nanosecond)},
+	}
+
+	for _, values := range ordered {
+		for i := 1; i < len(values); i++ {
+			a := boltValueKeys(values[i-1])
+			b := boltValueKeys(values[i])
+			if bytes.Compare(a[0], b[0]) >= 0 {
+				t.Errorf("%#v sorts after %#v", values[i-1], values[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package crud
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"github.com/buduchail/catrina"
+)
+
+type (
+	// Encodes rows (values in field order, id first) for backends that
+	// store them as bytes, like BoltCRUD.
+	RowCodec interface {
+		Encode(values []catrina.Value) ([]byte, error)
+		Decode(data []byte) ([]catrina.Value, error)
+	}
+
+	// Keeps value types. Types other than the basic ones and time.Time
+	// must be registered with gob.Register.
+	GobRowCodec struct {
+	}
+
+	// Readable, but numbers come back as json.Number and times as
+	// strings.
+	JSONRowCodec struct {
+	}
+)
+
+func (c GobRowCodec) Encode(values []catrina.Value) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(values)
+	return buf.Bytes(), err
+}
+
+func (c GobRowCodec) Decode(data []byte) (values []catrina.Value, err error) {
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&values)
+	return values, err
+}
+
+func (c JSONRowCodec) Encode(values []catrina.Value) ([]byte, error) {
+	return json.Marshal(values)
+}
+
+func (c JSONRowCodec) Decode(data []byte) (values []catrina.Value, err error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	err = d.Decode(&values)
+	return values, err
+}
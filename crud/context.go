@@ -0,0 +1,87 @@
+package crud
+
+import (
+	"context"
+	"github.com/buduchail/catrina"
+)
+
+type (
+	// Adapter that lets a plain CRUD implementation be used where a
+	// ContextCRUD is expected. The context is ignored.
+	contextAdapter struct {
+		crud catrina.CRUD
+	}
+)
+
+// Returns a ContextCRUD for the given CRUD. Implementations that are
+// already context-aware (e.g. MySqlCRUD) are returned unchanged.
+func NewContextAdapter(crud catrina.CRUD) catrina.ContextCRUD {
+	if c, ok := crud.(catrina.ContextCRUD); ok {
+		return c
+	}
+	return contextAdapter{crud}
+}
+
+func (a contextAdapter) InsertContext(ctx context.Context, values []catrina.Value) (id catrina.Value, e error) {
+	return a.crud.Insert(values)
+}
+
+func (a contextAdapter) SelectContext(ctx context.Context, id catrina.Value) (catrina.Object, error) {
+	return a.crud.Select(id)
+}
+
+func (a contextAdapter) SelectWhereFieldsContext(ctx context.Context, fields []string, values []catrina.Value) (<-chan catrina.Row, error) {
+	return a.crud.SelectWhereFields(fields, values)
+}
+
+func (a contextAdapter) SelectWhereRangeContext(ctx context.Context, field string, min, max catrina.Value) (<-chan catrina.Row, error) {
+	return a.crud.SelectWhereRange(field, min, max)
+}
+
+func (a contextAdapter) SelectWhereContext(ctx context.Context, filter catrina.Filter) (<-chan catrina.Row, error) {
+	return a.crud.SelectWhere(filter)
+}
+
+func (a contextAdapter) SelectQueryContext(ctx context.Context, query catrina.Query) (<-chan catrina.Row, error) {
+	return a.crud.SelectQuery(query)
+}
+
+func (a contextAdapter) CountContext(ctx context.Context, filter catrina.Filter) (int64, error) {
+	return a.crud.Count(filter)
+}
+
+func (a contextAdapter) SelectWhereExpressionContext(ctx context.Context, expr string, values []catrina.Value) (<-chan catrina.Row, error) {
+	return a.crud.SelectWhereExpression(expr, values)
+}
+
+func (a contextAdapter) UpdateContext(ctx context.Context, id catrina.Value, values []catrina.Value) error {
+	return a.crud.Update(id, values)
+}
+
+func (a contextAdapter) UpsertContext(ctx context.Context, id catrina.Value, values []catrina.Value) (catrina.Value, error) {
+	return a.crud.Upsert(id, values)
+}
+
+func (a contextAdapter) UpdateIfVersionContext(ctx context.Context, id catrina.Value, field string, version catrina.Value, values []catrina.Value) error {
+	return a.crud.UpdateIfVersion(id, field, version, values)
+}
+
+func (a contextAdapter) DeleteContext(ctx context.Context, id catrina.Value) error {
+	return a.crud.Delete(id)
+}
+
+func (a contextAdapter) DeleteIfVersionContext(ctx context.Context, id catrina.Value, field string, version catrina.Value) error {
+	return a.crud.DeleteIfVersion(id, field, version)
+}
+
+func (a contextAdapter) InsertManyContext(ctx context.Context, rows [][]catrina.Value) ([]catrina.BatchResult, error) {
+	return a.crud.InsertMany(rows)
+}
+
+func (a contextAdapter) UpdateManyContext(ctx context.Context, ids []catrina.Value, rows [][]catrina.Value) ([]catrina.BatchResult, error) {
+	return a.crud.UpdateMany(ids, rows)
+}
+
+func (a contextAdapter) DeleteManyContext(ctx context.Context, ids []catrina.Value) ([]catrina.BatchResult, error) {
+	return a.crud.DeleteMany(ids)
+}
@@ -0,0 +1,40 @@
+package crud
+
+import (
+	"fmt"
+	"net"
+	"errors"
+	"database/sql"
+	"database/sql/driver"
+	"github.com/buduchail/catrina"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Validation errors shared by the backends
+var (
+	valueCountErr = catrina.NewValidationError("Value count does not match field count")
+	idsAndRowsErr = catrina.NewValidationError("Ids and rows do not match")
+	invalidIdErr  = catrina.NewValidationError("Invalid id")
+)
+
+func invalid(format string, args ...interface{}) error {
+	return catrina.NewValidationError(fmt.Sprintf(format, args...))
+}
+
+func unknownFieldErr(field string) error {
+	return catrina.NewValidationError("Unknown field "+field).WithField(field, "Unknown field")
+}
+
+// Marks the errors of a lost or closed database as
+// catrina.UnavailableErr, keeping the original error
+func unavailable(err error) error {
+
+	var netErr net.Error
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, bolt.ErrDatabaseNotOpen) || errors.Is(err, bolt.ErrTimeout) ||
+		errors.As(err, &netErr) {
+		return fmt.Errorf("%w: %w", catrina.UnavailableErr, err)
+	}
+
+	return err
+}
@@ -0,0 +1,682 @@
+package crud
+
+import (
+	"fmt"
+	"time"
+	"sync"
+	"regexp"
+	"strings"
+	"strconv"
+	"encoding/json"
+	"github.com/buduchail/catrina"
+)
+
+type (
+	// A WHERE expression compiled for MemoryCRUD
+	expression struct {
+		eval         func(row []catrina.Value, values []catrina.Value) truth
+		placeholders int
+	}
+
+	// SQL three-valued logic: comparisons with NULL are unknown
+	truth int8
+
+	operand func(row []catrina.Value, values []catrina.Value) catrina.Value
+
+	token struct {
+		kind string
+		text string
+	}
+
+	// The regexp of the last pattern a LIKE was evaluated with. Its
+	// pattern is a constant or a value bound for a whole select, so it
+	// is rarely compiled more than once.
+	likeCache struct {
+		lock    sync.Mutex
+		pattern string
+		like    *regexp.Regexp
+	}
+
+	expressionParser struct {
+		tokens       []token
+		pos          int
+		index        map[string]int
+		placeholders int
+	}
+)
+
+const (
+	falseTruth truth = iota
+	trueTruth
+	unknownTruth
+)
+
+var (
+	tokenPattern = regexp.MustCompile(`^(?:` +
+		`(?P<space>\s+)|` +
+		`(?P<string>'(?:[^']|'')*')|` +
+		`(?P<number>-?[0-9]+(?:\.[0-9]+)?)|` +
+		`(?P<word>[A-Za-z_][A-Za-z0-9_.]*)|` +
+		`(?P<op><=|>=|<>|!=|=|<|>)|` +
+		`(?P<punct>[(),?])` +
+		`)`)
+
+	keywords = map[string]bool{
+		"AND": true, "OR": true, "NOT": true, "IS": true, "NULL": true,
+		"IN": true, "LIKE": true, "BETWEEN": true, "TRUE": true, "FALSE": true,
+	}
+)
+
+// Parses the subset of SQL understood by MemoryCRUD.SelectWhereExpression:
+//
+//	expr       = and { OR and }
+//	and        = not { AND not }
+//	not        = NOT not | "(" expr ")" | predicate
+//	predicate  = operand ( "=" | "!=" | "<>" | "<" | "<=" | ">" | ">=" ) operand
+//	           | operand IS [ NOT ] NULL
+//	           | operand [ NOT ] IN "(" operand { "," operand } ")"
+//	           | operand [ NOT ] LIKE operand
+//	           | operand [ NOT ] BETWEEN operand AND operand
+//	operand    = field | "?" | number | 'string' | NULL | TRUE | FALSE
+//
+// Keywords are case insensitive, fields are not. LIKE supports the %
+// and _ wildcards. Values are bound to "?" placeholders in order.
+func parseExpression(where string, index map[string]int) (*expression, error) {
+
+	tokens, err := tokenize(where)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &expressionParser{tokens: tokens, index: index}
+
+	eval, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.tokens) {
+		return nil, invalid("Unexpected %s in expression", p.tokens[p.pos].text)
+	}
+
+	return &expression{eval: eval, placeholders: p.placeholders}, nil
+}
+
+func (e *expression) match(row []catrina.Value, values []catrina.Value) bool {
+	return e.eval(row, values) == trueTruth
+}
+
+func tokenize(where string) ([]token, error) {
+
+	tokens := make([]token, 0)
+	names := tokenPattern.SubexpNames()
+
+	for len(where) > 0 {
+		m := tokenPattern.FindStringSubmatchIndex(where)
+		if m == nil {
+			return nil, invalid("Unexpected %q in expression", where)
+		}
+		for i := 1; i < len(names); i++ {
+			if m[2*i] < 0 {
+				continue
+			}
+			text := where[m[2*i]:m[2*i+1]]
+			kind := names[i]
+			if kind == "word" && keywords[strings.ToUpper(text)] {
+				kind = "keyword"
+				text = strings.ToUpper(text)
+			}
+			if kind != "space" {
+				tokens = append(tokens, token{kind, text})
+			}
+			break
+		}
+		where = where[m[1]:]
+	}
+
+	return tokens, nil
+}
+
+// Parser
+
+func (p *expressionParser) peek() token {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return token{}
+}
+
+func (p *expressionParser) accept(text string) bool {
+	t := p.peek()
+	if (t.kind == "keyword" || t.kind == "op" || t.kind == "punct") && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *expressionParser) expect(text string) error {
+	if !p.accept(text) {
+		return invalid("Expected %s in expression", text)
+	}
+	return nil
+}
+
+func (p *expressionParser) parseOr() (func([]catrina.Value, []catrina.Value) truth, error) {
+
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l, r := left, right
+		left = func(row []catrina.Value, values []catrina.Value) truth {
+			a := l(row, values)
+			if a == trueTruth {
+				return trueTruth
+			}
+			b := r(row, values)
+			if b == trueTruth {
+				return trueTruth
+			}
+			if a == unknownTruth || b == unknownTruth {
+				return unknownTruth
+			}
+			return falseTruth
+		}
+	}
+
+	return left, nil
+}
+
+func (p *expressionParser) parseAnd() (func([]catrina.Value, []catrina.Value) truth, error) {
+
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l, r := left, right
+		left = func(row []catrina.Value, values []catrina.Value) truth {
+			a := l(row, values)
+			if a == falseTruth {
+				return falseTruth
+			}
+			b := r(row, values)
+			if b == falseTruth {
+				return falseTruth
+			}
+			if a == unknownTruth || b == unknownTruth {
+				return unknownTruth
+			}
+			return trueTruth
+		}
+	}
+
+	return left, nil
+}
+
+func (p *expressionParser) parseNot() (func([]catrina.Value, []catrina.Value) truth, error) {
+
+	if p.accept("NOT") {
+		inner, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return negate(inner), nil
+	}
+
+	if p.accept("(") {
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(")")
+	}
+
+	return p.parsePredicate()
+}
+
+func (p *expressionParser) parsePredicate() (func([]catrina.Value, []catrina.Value) truth, error) {
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.accept("IS") {
+		not := p.accept("NOT")
+		err = p.expect("NULL")
+		if err != nil {
+			return nil, err
+		}
+		return func(row []catrina.Value, values []catrina.Value) truth {
+			isNull := left(row, values) == nil
+			if isNull != not {
+				return trueTruth
+			}
+			return falseTruth
+		}, nil
+	}
+
+	not := p.accept("NOT")
+
+	var predicate func([]catrina.Value, []catrina.Value) truth
+
+	switch {
+	case p.accept("IN"):
+		predicate, err = p.parseIn(left)
+	case p.accept("LIKE"):
+		predicate, err = p.parseLike(left)
+	case p.accept("BETWEEN"):
+		predicate, err = p.parseBetween(left)
+	case !not && p.peek().kind == "op":
+		predicate, err = p.parseComparison(left)
+	default:
+		return nil, invalid("Expected a comparison in expression")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if not {
+		return negate(predicate), nil
+	}
+
+	return predicate, nil
+}
+
+func (p *expressionParser) parseComparison(left operand) (func([]catrina.Value, []catrina.Value) truth, error) {
+
+	op := p.peek().text
+	p.pos++
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(row []catrina.Value, values []catrina.Value) truth {
+		c, ok := compareValues(left(row, values), right(row, values))
+		if !ok {
+			return unknownTruth
+		}
+		var result bool
+		switch op {
+		case "=":
+			result = c == 0
+		case "!=", "<>":
+			result = c != 0
+		case "<":
+			result = c < 0
+		case "<=":
+			result = c <= 0
+		case ">":
+			result = c > 0
+		case ">=":
+			result = c >= 0
+		}
+		return toTruth(result)
+	}, nil
+}
+
+func (p *expressionParser) parseIn(left operand) (func([]catrina.Value, []catrina.Value) truth, error) {
+
+	err := p.expect("(")
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]operand, 0)
+	for {
+		o, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, o)
+		if !p.accept(",") {
+			break
+		}
+	}
+
+	err = p.expect(")")
+	if err != nil {
+		return nil, err
+	}
+
+	return func(row []catrina.Value, values []catrina.Value) truth {
+		result := falseTruth
+		v := left(row, values)
+		for _, o := range list {
+			c, ok := compareValues(v, o(row, values))
+			if !ok {
+				result = unknownTruth
+			} else if c == 0 {
+				return trueTruth
+			}
+		}
+		return result
+	}, nil
+}
+
+func (p *expressionParser) parseLike(left operand) (func([]catrina.Value, []catrina.Value) truth, error) {
+
+	pattern, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	cache := &likeCache{}
+
+	return func(row []catrina.Value, values []catrina.Value) truth {
+		v, ok := toString(left(row, values))
+		if !ok {
+			return unknownTruth
+		}
+		like, ok := toString(pattern(row, values))
+		if !ok {
+			return unknownTruth
+		}
+		return toTruth(cache.regexp(like).MatchString(v))
+	}, nil
+}
+
+func (p *expressionParser) parseBetween(left operand) (func([]catrina.Value, []catrina.Value) truth, error) {
+
+	min, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.expect("AND")
+	if err != nil {
+		return nil, err
+	}
+
+	max, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(row []catrina.Value, values []catrina.Value) truth {
+		v := left(row, values)
+		low, ok1 := compareValues(v, min(row, values))
+		high, ok2 := compareValues(v, max(row, values))
+		if !ok1 || !ok2 {
+			return unknownTruth
+		}
+		return toTruth(low >= 0 && high <= 0)
+	}, nil
+}
+
+func (p *expressionParser) parseOperand() (operand, error) {
+
+	t := p.peek()
+	p.pos++
+
+	switch t.kind {
+	case "word":
+		i, exists := p.index[t.text]
+		if !exists {
+			return nil, unknownFieldErr(t.text)
+		}
+		return func(row []catrina.Value, values []catrina.Value) catrina.Value {
+			return row[i]
+		}, nil
+	case "number":
+		var v catrina.Value
+		if i, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			v = i
+		} else {
+			f, err := strconv.ParseFloat(t.text, 64)
+			if err != nil {
+				return nil, err
+			}
+			v = f
+		}
+		return constant(v), nil
+	case "string":
+		s := strings.Replace(t.text[1:len(t.text)-1], "''", "'", -1)
+		return constant(s), nil
+	case "keyword":
+		switch t.text {
+		case "NULL":
+			return constant(nil), nil
+		case "TRUE":
+			return constant(int64(1)), nil
+		case "FALSE":
+			return constant(int64(0)), nil
+		}
+	case "punct":
+		if t.text == "?" {
+			n := p.placeholders
+			p.placeholders++
+			return func(row []catrina.Value, values []catrina.Value) catrina.Value {
+				return values[n]
+			}, nil
+		}
+	}
+
+	p.pos--
+	if t.kind == "" {
+		return nil, invalid("Unexpected end of expression")
+	}
+	return nil, invalid("Unexpected %s in expression", t.text)
+}
+
+// Evaluation helpers
+
+func constant(v catrina.Value) operand {
+	return func(row []catrina.Value, values []catrina.Value) catrina.Value {
+		return v
+	}
+}
+
+func negate(f func([]catrina.Value, []catrina.Value) truth) func([]catrina.Value, []catrina.Value) truth {
+	return func(row []catrina.Value, values []catrina.Value) truth {
+		switch f(row, values) {
+		case trueTruth:
+			return falseTruth
+		case falseTruth:
+			return trueTruth
+		}
+		return unknownTruth
+	}
+}
+
+func toTruth(b bool) truth {
+	if b {
+		return trueTruth
+	}
+	return falseTruth
+}
+
+func likeRegexp(pattern string) *regexp.Regexp {
+	quoted := regexp.QuoteMeta(pattern)
+	quoted = strings.Replace(quoted, "%", ".*", -1)
+	quoted = strings.Replace(quoted, "_", ".", -1)
+	return regexp.MustCompile("^(?s:" + quoted + ")$")
+}
+
+func (c *likeCache) regexp(pattern string) *regexp.Regexp {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.like == nil || c.pattern != pattern {
+		c.pattern, c.like = pattern, likeRegexp(pattern)
+	}
+
+	return c.like
+}
+
+func between(v, min, max catrina.Value) bool {
+	low, ok1 := compareValues(v, min)
+	high, ok2 := compareValues(v, max)
+	return ok1 && ok2 && low >= 0 && high <= 0
+}
+
+func toString(v catrina.Value) (string, bool) {
+	switch s := v.(type) {
+	case string:
+		return s, true
+	case []byte:
+		return string(s), true
+	case json.Number:
+		return string(s), true
+	case nil:
+		return "", false
+	}
+	return fmt.Sprint(v), true
+}
+
+func isNumber(v catrina.Value) bool {
+	switch v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, json.Number, bool:
+		return true
+	}
+	return false
+}
+
+// Exact integer value, for ids and integer comparisons
+func toInt64(v catrina.Value) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int8:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint:
+		return int64(n), n <= 1<<63-1
+	case uint8:
+		return int64(n), true
+	case uint16:
+		return int64(n), true
+	case uint32:
+		return int64(n), true
+	case uint64:
+		return int64(n), n <= 1<<63-1
+	case float32:
+		return int64(n), float32(int64(n)) == n
+	case float64:
+		return int64(n), float64(int64(n)) == n
+	case bool:
+		if n {
+			return 1, true
+		}
+		return 0, true
+	case json.Number, string, []byte:
+		s, _ := toString(n)
+		i, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		return i, err == nil
+	}
+	return 0, false
+}
+
+func toFloat64(v catrina.Value) (float64, bool) {
+	if i, ok := toInt64(v); ok {
+		return float64(i), true
+	}
+	switch n := v.(type) {
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	case json.Number, string, []byte:
+		s, _ := toString(n)
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// Compares two values the way MySQL would: numbers, or a number and a
+// numeric string, by value; strings byte by byte; times, or a time and
+// an RFC 3339 string (e.g. from a JSON cursor), by instant.
+// ok is false when either value is NULL or they cannot be compared.
+func compareValues(a, b catrina.Value) (c int, ok bool) {
+
+	if a == nil || b == nil {
+		return 0, false
+	}
+
+	if _, isTime := b.(time.Time); isTime {
+		if _, isTime := a.(time.Time); !isTime {
+			c, ok := compareValues(b, a)
+			return -c, ok
+		}
+	}
+
+	if ta, isTime := a.(time.Time); isTime {
+		tb, isTime := toTime(b)
+		if !isTime {
+			return 0, false
+		}
+		switch {
+		case ta.Before(tb):
+			return -1, true
+		case ta.After(tb):
+			return 1, true
+		}
+		return 0, true
+	}
+
+	if isNumber(a) || isNumber(b) {
+		ia, okA := toInt64(a)
+		ib, okB := toInt64(b)
+		if okA && okB {
+			return compareOrdered(ia, ib), true
+		}
+		fa, okA := toFloat64(a)
+		fb, okB := toFloat64(b)
+		if okA && okB {
+			return compareOrdered(fa, fb), true
+		}
+		return 0, false
+	}
+
+	sa, okA := toString(a)
+	sb, okB := toString(b)
+	if !okA || !okB {
+		return 0, false
+	}
+
+	return strings.Compare(sa, sb), true
+}
+
+func toTime(v catrina.Value) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case string, []byte:
+		s, _ := toString(t)
+		parsed, err := time.Parse(time.RFC3339Nano, s)
+		return parsed, err == nil
+	}
+	return time.Time{}, false
+}
+
+func compareOrdered[T int64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
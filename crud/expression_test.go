@@ -0,0 +1,187 @@
+package crud
+
+import (
+	"time"
+	"testing"
+	"encoding/json"
+
+	"github.com/buduchail/catrina"
+)
+
+func TestParseExpression(t *testing.T) {
+
+	index := map[string]int{"id": 0, "name": 1, "price": 2}
+	pen := []catrina.Value{int64(1), "pen", int64(3)}
+	ink := []catrina.Value{int64(2), "ink", nil}
+
+	tests := []struct {
+		where    string
+		values   []catrina.Value
+		row      []catrina.Value
+		expected truth
+	}{
+		// comparisons
+		{"price = 3", nil, pen, trueTruth},
+		{"price != 3", nil, pen, falseTruth},
+		{"price <> 4", nil, pen, trueTruth},
+		{"price < 3.5", nil, pen, trueTruth},
+		{"price <= 3", nil, pen, trueTruth},
+		{"price > ?", []catrina.Value{2}, pen, trueTruth},
+		{"price >= '4'", nil, pen, falseTruth},
+		{"name = 'pen'", nil, pen, trueTruth},
+		{"name = 'it''s'", nil, []catrina.Value{int64(3), "it's", nil}, trueTruth},
+		{"name < 'pin'", nil, pen, trueTruth},
+		{"3 = price", nil, pen, trueTruth},
+		{"id = TRUE", nil, pen, trueTruth},
+		{"price = -3", nil, pen, falseTruth},
+		{"price = ?", []catrina.Value{json.Number("3")}, pen, trueTruth},
+
+		// IN, LIKE and BETWEEN, with and without NOT
+		{"name IN ('cup', ?)", []catrina.Value{"pen"}, pen, trueTruth},
+		{"name NOT IN ('cup')", nil, pen, trueTruth},
+		{"name LIKE 'p%'", nil, pen, trueTruth},
+		{"name LIKE '_e_'", nil, pen, trueTruth},
+		{"name LIKE 'p.n'", nil, pen, falseTruth},
+		{"name NOT LIKE ?", []catrina.Value{"%n"}, pen, falseTruth},
+		{"price BETWEEN 3 AND 4", nil, pen, trueTruth},
+		{"price NOT BETWEEN 1 AND 2", nil, pen, trueTruth},
+
+		// keywords are case insensitive
+		{"name like 'P%' or price between 1 and 3", nil, pen, trueTruth},
+		{"not (price is null)", nil, pen, trueTruth},
+
+		// precedence: NOT, then AND, then OR
+		{"price = 1 AND price = 2 OR name = 'pen'", nil, pen, trueTruth},
+		{"price = 1 AND (price = 2 OR name = 'pen')", nil, pen, falseTruth},
+		{"NOT price = 1 AND name = 'pen'", nil, pen, trueTruth},
+
+		// NULL is unknown in comparisons, and unknown is not negated
+		{"price IS NULL", nil, ink, trueTruth},
+		{"price IS NOT NULL", nil, ink, falseTruth},
+		{"price = 3", nil, ink, unknownTruth},
+		{"NOT price = 3", nil, ink, unknownTruth},
+		{"price = NULL", nil, ink, unknownTruth},
+		{"price = ?", []catrina.Value{nil}, pen, unknownTruth},
+		{"price IN (1, NULL)", nil, pen, unknownTruth},
+		{"price IN (3, NULL)", nil, pen, trueTruth},
+		{"price NOT IN (1, NULL)", nil, pen, unknownTruth},
+		{"price LIKE '%'", nil, ink, unknownTruth},
+		{"price BETWEEN 1 AND NULL", nil, pen, unknownTruth},
+
+		// three-valued AND and OR
+		{"price = 3 AND name = 'pen'", nil, ink, falseTruth},
+		{"price = 3 AND name = 'ink'", nil, ink, unknownTruth},
+		{"price = 3 OR name = 'ink'", nil, ink, trueTruth},
+		{"price = 3 OR name = 'pen'", nil, ink, unknownTruth},
+	}
+
+	for _, test := range tests {
+		expr, err := parseExpression(test.where, index)
+		if err != nil {
+			t.Errorf("%s: %v", test.where, err)
+			continue
+		}
+		if expr.placeholders != len(test.values) {
+			t.Errorf("%s: expected %d placeholders, got %d", test.where, len(test.values), expr.placeholders)
+			continue
+		}
+		if actual := expr.eval(test.row, test.values); actual != test.expected {
+			t.Errorf("%s: expected %d, got %d", test.where, test.expected, actual)
+		}
+	}
+}
+
+func TestParseExpressionErrors(t *testing.T) {
+
+	index := map[string]int{"id": 0, "name": 1}
+
+	tests := []string{
+		"",
+		"name",
+		"name = ",
+		"Name = 'a'",
+		"cost = 1",
+		"name = 'a' AND",
+		"(name = 'a'",
+		"name = 'a')",
+		"name IS 'a'",
+		"name NOT = 'a'",
+		"name IN ()",
+		"name IN ('a'",
+		"id BETWEEN 1 OR 2",
+		"name = \"a\"",
+		"name = 'a'; DROP TABLE users",
+	}
+
+	for _, where := range tests {
+		if _, err := parseExpression(where, index); err == nil {
+			t.Errorf("%q: expected an error", where)
+		}
+	}
+}
+
+// Patterns are compiled once for all the rows, and again when a
+// placeholder is bound to another one
+func TestLikeCache(t *testing.T) {
+
+	expr, err := parseExpression("name LIKE ?", map[string]int{"id": 0, "name": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		pattern  string
+		expected truth
+	}{
+		{"pen", "p%", trueTruth},
+		{"pin", "p%", trueTruth},
+		{"pin", "%n", trueTruth},
+		{"cup", "%n", falseTruth},
+		{"a\nb", "a_b", trueTruth},
+		{"a+b", "a+b", trueTruth},
+		{"aab", "a+b", falseTruth},
+	}
+
+	for _, test := range tests {
+		row := []catrina.Value{int64(1), test.name}
+		if actual := expr.eval(row, []catrina.Value{test.pattern}); actual != test.expected {
+			t.Errorf("%q LIKE %q: expected %d, got %d", test.name, test.pattern, test.expected, actual)
+		}
+	}
+}
+
+func TestCompareValues(t *testing.T) {
+
+	noon := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		a, b     catrina.Value
+		expected int
+		ok       bool
+	}{
+		{int64(1), 2, -1, true},
+		{int8(2), uint64(2), 0, true},
+		{1.5, int64(1), 1, true},
+		{"10", int64(9), 1, true},
+		{json.Number("2.5"), 2.5, 0, true},
+		{"10", "9", -1, true},
+		{[]byte("b"), "a", 1, true},
+		{true, int64(1), 0, true},
+		{noon, "2024-05-01T12:00:00Z", 0, true},
+		{"2024-05-01T11:00:00Z", noon, -1, true},
+		{noon, noon.Add(time.Second), -1, true},
+		{noon, "noon", 0, false},
+		{"a", int64(1), 0, false},
+		{nil, int64(1), 0, false},
+		{int64(1), nil, 0, false},
+		{nil, nil, 0, false},
+	}
+
+	for _, test := range tests {
+		c, ok := compareValues(test.a, test.b)
+		if ok != test.ok || (ok && c != test.expected) {
+			t.Errorf("compareValues(%#v, %#v): expected %d, %v, got %d, %v", test.a, test.b, test.expected, test.ok, c, ok)
+		}
+	}
+}
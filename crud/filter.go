@@ -0,0 +1,171 @@
+package crud
+
+import (
+	"github.com/buduchail/catrina"
+)
+
+// Checks the shape of a filter, and that it only uses known fields, so
+// that backends can translate it without further checks.
+func checkFilter(f catrina.Filter, index map[string]int) error {
+
+	switch f.Op {
+	case "":
+		return nil
+	case catrina.AndFilter, catrina.OrFilter:
+		for _, child := range f.Filters {
+			err := checkFilter(child, index)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case catrina.NotFilter:
+		if len(f.Filters) != 1 {
+			return invalid("Filter %s takes one filter", f.Op)
+		}
+		return checkFilter(f.Filters[0], index)
+	}
+
+	count := -1
+	switch f.Op {
+	case catrina.EqFilter, catrina.NeqFilter, catrina.LikeFilter,
+		catrina.LtFilter, catrina.LteFilter, catrina.GtFilter, catrina.GteFilter:
+		count = 1
+	case catrina.RangeFilter:
+		count = 2
+	case catrina.IsNullFilter:
+		count = 0
+	case catrina.InFilter:
+	default:
+		return invalid("Unknown filter %s", f.Op)
+	}
+
+	if count >= 0 && len(f.Values) != count {
+		return invalid("Filter %s takes %d values", f.Op, count)
+	}
+
+	if f.Op == catrina.LikeFilter {
+		if _, isString := f.Values[0].(string); !isString {
+			return invalid("Filter %s takes a string pattern", f.Op)
+		}
+	}
+
+	if _, exists := index[f.Field]; !exists {
+		return unknownFieldErr(f.Field)
+	}
+
+	return nil
+}
+
+// Compiles a checked filter for the backends that evaluate rows
+// themselves, with the same semantics as the SQL backends.
+func compileFilter(f catrina.Filter, index map[string]int) func(row []catrina.Value) truth {
+
+	switch f.Op {
+	case "":
+		return func(row []catrina.Value) truth {
+			return trueTruth
+		}
+	case catrina.AndFilter, catrina.OrFilter:
+		and := f.Op == catrina.AndFilter
+		children := make([]func([]catrina.Value) truth, len(f.Filters))
+		for i, child := range f.Filters {
+			children[i] = compileFilter(child, index)
+		}
+		// AND stops at the first false, OR at the first true
+		stop, result := falseTruth, trueTruth
+		if !and {
+			stop, result = trueTruth, falseTruth
+		}
+		return func(row []catrina.Value) truth {
+			r := result
+			for _, child := range children {
+				switch child(row) {
+				case stop:
+					return stop
+				case unknownTruth:
+					r = unknownTruth
+				}
+			}
+			return r
+		}
+	case catrina.NotFilter:
+		child := compileFilter(f.Filters[0], index)
+		return func(row []catrina.Value) truth {
+			switch child(row) {
+			case trueTruth:
+				return falseTruth
+			case falseTruth:
+				return trueTruth
+			}
+			return unknownTruth
+		}
+	}
+
+	i := index[f.Field]
+	values := f.Values
+
+	switch f.Op {
+	case catrina.EqFilter, catrina.NeqFilter,
+		catrina.LtFilter, catrina.LteFilter, catrina.GtFilter, catrina.GteFilter:
+		op := f.Op
+		return func(row []catrina.Value) truth {
+			c, ok := compareValues(row[i], values[0])
+			if !ok {
+				return unknownTruth
+			}
+			var result bool
+			switch op {
+			case catrina.EqFilter:
+				result = c == 0
+			case catrina.NeqFilter:
+				result = c != 0
+			case catrina.LtFilter:
+				result = c < 0
+			case catrina.LteFilter:
+				result = c <= 0
+			case catrina.GtFilter:
+				result = c > 0
+			case catrina.GteFilter:
+				result = c >= 0
+			}
+			return toTruth(result)
+		}
+	case catrina.InFilter:
+		return func(row []catrina.Value) truth {
+			result := falseTruth
+			for _, v := range values {
+				c, ok := compareValues(row[i], v)
+				if !ok {
+					result = unknownTruth
+				} else if c == 0 {
+					return trueTruth
+				}
+			}
+			return result
+		}
+	case catrina.LikeFilter:
+		like := likeRegexp(values[0].(string))
+		return func(row []catrina.Value) truth {
+			v, ok := toString(row[i])
+			if !ok {
+				return unknownTruth
+			}
+			return toTruth(like.MatchString(v))
+		}
+	case catrina.RangeFilter:
+		return func(row []catrina.Value) truth {
+			low, ok1 := compareValues(row[i], values[0])
+			high, ok2 := compareValues(row[i], values[1])
+			if !ok1 || !ok2 {
+				return unknownTruth
+			}
+			return toTruth(low >= 0 && high <= 0)
+		}
+	}
+
+	// IS NULL
+	return func(row []catrina.Value) truth {
+		return toTruth(row[i] == nil)
+	}
+}
@@ -0,0 +1,385 @@
+package crud
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"reflect"
+	"strings"
+	"unicode"
+	"database/sql"
+	"database/sql/driver"
+	"github.com/buduchail/catrina"
+)
+
+type (
+	// Maps a struct type to a table, from the db tags of its fields:
+	//
+	//	type User struct {
+	//		Id      int64     `db:"user_id,id"`
+	//		Name    string    // column "name"
+	//		Created time.Time `db:"created_at"`
+	//		Cache   []byte    `db:"-"`
+	//	}
+	//
+	// Exported fields are columns, named after the tag or else the
+	// snake_cased field name. The id is the field tagged with the "id"
+	// option, or else the "id" column. Embedded structs are flattened,
+	// nil pointers are NULL, and sql.Scanner / driver.Valuer fields
+	// convert themselves. The table is given by a TableName() string
+	// method, or else is the snake_cased type name.
+	//
+	// Mappings are read once per type and cached.
+	StructMapping[T any] struct {
+		meta *structMeta
+	}
+
+	structMeta struct {
+		typ    reflect.Type
+		table  string
+		// columns and the index paths of their struct fields, id first
+		fields []string
+		paths  [][]int
+	}
+)
+
+var (
+	structMetas sync.Map
+
+	timeType = reflect.TypeOf(time.Time{})
+)
+
+func MapStruct[T any]() (StructMapping[T], error) {
+
+	var zero T
+	meta, err := structMetadata(reflect.TypeOf(zero))
+	if err != nil {
+		return StructMapping[T]{}, err
+	}
+
+	return StructMapping[T]{meta}, nil
+}
+
+// Opens a typed SQL CRUD for the struct type T, like NewSqlCRUD with
+// the table and fields of the mapping. As with NewSqliteCRUD, an
+// SQLite SqliteMemoryDSN is private to the CRUD, so its table cannot be
+// created: open the database and use NewStructCRUDWithDB instead.
+func NewStructCRUD[T any, ID any](driver string, dsn string) (catrina.TypedCRUD[T, ID], error) {
+
+	var db *sql.DB
+	var err error
+
+	if driver == "sqlite3" {
+		db, err = openSqliteDB(dsn)
+	} else {
+		db, err = sql.Open(driver, dsn)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return NewStructCRUDWithDB[T, ID](driver, db)
+}
+
+// Same as NewStructCRUD, for an already open database
+func NewStructCRUDWithDB[T any, ID any](driver string, db *sql.DB) (catrina.TypedCRUD[T, ID], error) {
+
+	m, err := MapStruct[T]()
+	if err != nil {
+		return nil, err
+	}
+
+	crud, err := NewSqlCRUDWithScan(driver, db, m.Table(), m.Fields(), m.Scan)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTypedCRUD[T, ID](crud, m.Values), nil
+}
+
+// Typed MemoryCRUD for the struct type T
+func NewStructMemoryCRUD[T any, ID any]() (catrina.TypedCRUD[T, ID], error) {
+
+	m, err := MapStruct[T]()
+	if err != nil {
+		return nil, err
+	}
+
+	memory, err := NewMemoryCRUD(m.Fields(), m.Hydrate)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTypedCRUD[T, ID](memory, m.Values), nil
+}
+
+// Helper functions
+
+func structMetadata(t reflect.Type) (*structMeta, error) {
+
+	if cached, ok := structMetas.Load(t); ok {
+		return cached.(*structMeta), nil
+	}
+
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("Cannot map %v, it is not a struct", t)
+	}
+
+	meta := &structMeta{typ: t, table: snakeCase(t.Name())}
+	if named, ok := reflect.New(t).Interface().(interface{ TableName() string }); ok {
+		meta.table = named.TableName()
+	}
+
+	id := -1
+	err := meta.collect(t, nil, &id)
+	if err != nil {
+		return nil, err
+	}
+
+	if id < 0 {
+		for i, f := range meta.fields {
+			if f == "id" {
+				id = i
+			}
+		}
+	}
+	if id < 0 {
+		return nil, fmt.Errorf("%s has no id field, tag one with db:\",id\"", t)
+	}
+
+	// the id goes first, as in every CRUD
+	fields := append([]string{meta.fields[id]}, meta.fields[:id]...)
+	meta.fields = append(fields, meta.fields[id+1:]...)
+	paths := append([][]int{meta.paths[id]}, meta.paths[:id]...)
+	meta.paths = append(paths, meta.paths[id+1:]...)
+
+	cached, _ := structMetas.LoadOrStore(t, meta)
+	return cached.(*structMeta), nil
+}
+
+func (m *structMeta) collect(t reflect.Type, path []int, id *int) error {
+
+	for i := 0; i < t.NumField(); i++ {
+
+		f := t.Field(i)
+		tag := f.Tag.Get("db")
+		if tag == "-" || !f.IsExported() && !f.Anonymous {
+			continue
+		}
+
+		name, options, _ := strings.Cut(tag, ",")
+		fieldPath := append(append([]int{}, path...), i)
+
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct && f.Type != timeType {
+			err := m.collect(f.Type, fieldPath, id)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = snakeCase(f.Name)
+		}
+		for _, existing := range m.fields {
+			if existing == name {
+				return fmt.Errorf("%s maps column %s twice", m.typ, name)
+			}
+		}
+
+		for _, option := range strings.Split(options, ",") {
+			if option != "id" {
+				continue
+			}
+			if *id >= 0 {
+				return fmt.Errorf("%s has more than one id field", m.typ)
+			}
+			*id = len(m.fields)
+		}
+
+		m.fields = append(m.fields, name)
+		m.paths = append(m.paths, fieldPath)
+	}
+
+	return nil
+}
+
+// CreatedAt -> created_at, UserID -> user_id
+func snakeCase(name string) string {
+
+	runes := []rune(name)
+	b := strings.Builder{}
+
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			previous := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if !unicode.IsUpper(previous) || nextLower {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+
+	return b.String()
+}
+
+// Sets a struct field from a value read by a CRUD, converting between
+// the types the backends return (e.g. int64 or json.Number for an int
+// field, RFC 3339 strings for times).
+func assignValue(target reflect.Value, value catrina.Value) error {
+
+	if value == nil {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+
+	if scanner, ok := target.Addr().Interface().(sql.Scanner); ok {
+		return scanner.Scan(value)
+	}
+
+	source := reflect.ValueOf(value)
+	if source.Type().AssignableTo(target.Type()) {
+		target.Set(source)
+		return nil
+	}
+
+	switch target.Kind() {
+	case reflect.Ptr:
+		elem := reflect.New(target.Type().Elem())
+		err := assignValue(elem.Elem(), value)
+		if err != nil {
+			return err
+		}
+		target.Set(elem)
+		return nil
+	case reflect.String:
+		s, _ := toString(value)
+		target.SetString(s)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := toInt64(value); ok && !target.OverflowInt(i) {
+			target.SetInt(i)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if i, ok := toInt64(value); ok && i >= 0 && !target.OverflowUint(uint64(i)) {
+			target.SetUint(uint64(i))
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		if f, ok := toFloat64(value); ok {
+			target.SetFloat(f)
+			return nil
+		}
+	case reflect.Bool:
+		if i, ok := toInt64(value); ok {
+			target.SetBool(i != 0)
+			return nil
+		}
+	case reflect.Slice:
+		if s, isString := value.(string); isString && target.Type().Elem().Kind() == reflect.Uint8 {
+			target.SetBytes([]byte(s))
+			return nil
+		}
+	case reflect.Struct:
+		if t, ok := toTime(value); ok && target.Type() == timeType {
+			target.Set(reflect.ValueOf(t))
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Cannot assign %T to %s", value, target.Type())
+}
+
+// Public interface
+
+func (m StructMapping[T]) Table() string {
+	return m.meta.table
+}
+
+// Columns in CRUD order, id first
+func (m StructMapping[T]) Fields() []string {
+	return append([]string{}, m.meta.fields...)
+}
+
+func (m StructMapping[T]) Id(object T) catrina.Value {
+	return m.value(reflect.ValueOf(object).FieldByIndex(m.meta.paths[0]))
+}
+
+// Values of the fields but the id, as taken by Insert and Update (and
+// by NewTypedCRUD)
+func (m StructMapping[T]) Values(object T) []catrina.Value {
+
+	v := reflect.ValueOf(object)
+
+	values := make([]catrina.Value, len(m.meta.paths)-1)
+	for i, path := range m.meta.paths[1:] {
+		values[i] = m.value(v.FieldByIndex(path))
+	}
+
+	return values
+}
+
+func (m StructMapping[T]) value(field reflect.Value) catrina.Value {
+
+	if field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			return nil
+		}
+		if _, isValuer := field.Interface().(driver.Valuer); !isValuer {
+			field = field.Elem()
+		}
+	}
+
+	if valuer, ok := field.Interface().(driver.Valuer); ok {
+		if value, err := valuer.Value(); err == nil {
+			return value
+		}
+	}
+
+	return field.Interface()
+}
+
+// Builds a T from values in field order, id first. It is a
+// MemoryHydrateFunc and a BoltHydrateFunc.
+func (m StructMapping[T]) Hydrate(values []catrina.Value) (interface{}, error) {
+
+	if len(values) != len(m.meta.paths) {
+		return nil, valueCountErr
+	}
+
+	var object T
+	v := reflect.ValueOf(&object).Elem()
+
+	for i, path := range m.meta.paths {
+		err := assignValue(v.FieldByIndex(path), values[i])
+		if err != nil {
+			return nil, fmt.Errorf("Field %s: %s", m.meta.fields[i], err)
+		}
+	}
+
+	return object, nil
+}
+
+// Scans the current row, selected with the mapping fields, into a T
+func (m StructMapping[T]) Scan(rows *sql.Rows) (interface{}, error) {
+
+	var object T
+	v := reflect.ValueOf(&object).Elem()
+
+	dest := make([]interface{}, len(m.meta.paths))
+	for i, path := range m.meta.paths {
+		dest[i] = v.FieldByIndex(path).Addr().Interface()
+	}
+
+	err := rows.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	return object, nil
+}
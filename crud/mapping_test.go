@@ -0,0 +1,268 @@
+package crud
+
+import (
+	"context"
+	"time"
+	"reflect"
+	"testing"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/buduchail/catrina"
+)
+
+type (
+	mappedAudit struct {
+		CreatedAt time.Time
+		UpdatedBy string `db:"updated_by_user"`
+	}
+
+	mappedUser struct {
+		Name    string
+		UserID  int64 `db:"user_id,id"`
+		Email   *string
+		Cache   []byte `db:"-"`
+		secret  string
+		mappedAudit
+	}
+
+	mappedOrder struct {
+		Id    int64
+		Total float64
+	}
+
+	mappedNoId struct {
+		Name string
+	}
+
+	mappedTwoIds struct {
+		A int64 `db:",id"`
+		B int64 `db:",id"`
+	}
+
+	mappedDuplicate struct {
+		Name  string
+		Other string `db:"name"`
+	}
+
+	mappedScanner struct {
+		Valid bool
+		Value string
+	}
+)
+
+func (mappedOrder) TableName() string {
+	return "orders"
+}
+
+func (s *mappedScanner) Scan(v interface{}) error {
+	s.Valid = v != nil
+	s.Value, _ = toString(v)
+	return nil
+}
+
+func TestSnakeCase(t *testing.T) {
+
+	tests := []struct {
+		name, expected string
+	}{
+		{"Name", "name"},
+		{"CreatedAt", "created_at"},
+		{"UserID", "user_id"},
+		{"HTTPServer", "http_server"},
+		{"mappedUser", "mapped_user"},
+		{"ID", "id"},
+		{"A1B", "a1_b"},
+	}
+
+	for _, test := range tests {
+		if actual := snakeCase(test.name); actual != test.expected {
+			t.Errorf("snakeCase(%q): expected %q, got %q", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestStructMetadata(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		typ    reflect.Type
+		table  string
+		fields []string
+		paths  [][]int
+		fails  bool
+	}{
+		{
+			name:   "tags, embedded structs and ignored fields",
+			typ:    reflect.TypeOf(mappedUser{}),
+			table:  "mapped_user",
+			fields: []string{"user_id", "name", "email", "created_at", "updated_by_user"},
+			paths:  [][]int{{1}, {0}, {2}, {5, 0}, {5, 1}},
+		},
+		{
+			name:   "id column and TableName",
+			typ:    reflect.TypeOf(mappedOrder{}),
+			table:  "orders",
+			fields: []string{"id", "total"},
+			paths:  [][]int{{0}, {1}},
+		},
+		{name: "no id", typ: reflect.TypeOf(mappedNoId{}), fails: true},
+		{name: "two ids", typ: reflect.TypeOf(mappedTwoIds{}), fails: true},
+		{name: "duplicate column", typ: reflect.TypeOf(mappedDuplicate{}), fails: true},
+		{name: "not a struct", typ: reflect.TypeOf(1), fails: true},
+		{name: "nil type", typ: nil, fails: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			meta, err := structMetadata(test.typ)
+			if test.fails {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", meta.fields)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if meta.table != test.table {
+				t.Errorf("expected table %q, got %q", test.table, meta.table)
+			}
+			if !reflect.DeepEqual(meta.fields, test.fields) {
+				t.Errorf("expected fields %v, got %v", test.fields, meta.fields)
+			}
+			if !reflect.DeepEqual(meta.paths, test.paths) {
+				t.Errorf("expected paths %v, got %v", test.paths, meta.paths)
+			}
+		})
+	}
+}
+
+func TestAssignValue(t *testing.T) {
+
+	created := time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)
+	s := "x"
+
+	tests := []struct {
+		name     string
+		target   interface{}
+		value    catrina.Value
+		expected interface{}
+		fails    bool
+	}{
+		{"assignable", new(string), "a", "a", false},
+		{"nil is zero", new(int64), nil, int64(0), false},
+		{"int64 to int", new(int), int64(7), 7, false},
+		{"json number to int32", new(int32), json.Number("12"), int32(12), false},
+		{"float to int", new(int64), 3.0, int64(3), false},
+		{"int overflow", new(int8), int64(300), nil, true},
+		{"negative uint", new(uint), int64(-1), nil, true},
+		{"string to uint", new(uint16), "65", uint16(65), false},
+		{"int to float", new(float64), int64(2), 2.0, false},
+		{"int to bool", new(bool), int64(1), true, false},
+		{"bytes to string", new(string), []byte("b"), "b", false},
+		{"string to bytes", new([]byte), "c", []byte("c"), false},
+		{"string to pointer", new(*string), "x", &s, false},
+		{"nil pointer", new(*string), nil, (*string)(nil), false},
+		{"rfc 3339 time", new(time.Time), "2024-05-01T10:30:00Z", created, false},
+		{"scanner", new(mappedScanner), "v", mappedScanner{true, "v"}, false},
+		{"scanner nil", new(mappedScanner), nil, mappedScanner{}, false},
+		{"not a time", new(time.Time), "yesterday", nil, true},
+		{"unsupported", new(map[string]int), int64(1), nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			target := reflect.ValueOf(test.target).Elem()
+			err := assignValue(target, test.value)
+			if test.fails {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", target.Interface())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(target.Interface(), test.expected) {
+				t.Errorf("expected %#v, got %#v", test.expected, target.Interface())
+			}
+		})
+	}
+}
+
+func TestStructMappingRoundTrip(t *testing.T) {
+
+	m, err := MapStruct[mappedUser]()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	email := "a@b.c"
+	user := mappedUser{Name: "a", UserID: 3, Email: &email}
+	user.UpdatedBy = "admin"
+
+	if m.Id(user) != int64(3) {
+		t.Errorf("expected id 3, got %v", m.Id(user))
+	}
+
+	values := m.Values(user)
+	expected := []catrina.Value{"a", "a@b.c", time.Time{}, "admin"}
+	if !reflect.DeepEqual(values, expected) {
+		t.Fatalf("expected values %v, got %v", expected, values)
+	}
+
+	object, err := m.Hydrate(append([]catrina.Value{int64(3)}, values...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(object, user) {
+		t.Errorf("expected %+v, got %+v", user, object)
+	}
+
+	_, err = m.Hydrate(values)
+	if err != valueCountErr {
+		t.Errorf("expected %v, got %v", valueCountErr, err)
+	}
+}
+
+// The struct mapping scans rows through NewSqlCRUDWithScan
+func TestStructCRUD(t *testing.T) {
+
+	db, err := sql.Open("sqlite3", "file:mapping?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec("CREATE TABLE orders (id INTEGER PRIMARY KEY AUTOINCREMENT, total REAL)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orders, err := NewStructCRUDWithDB[mappedOrder, int64]("sqlite3", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	id, err := orders.Insert(ctx, mappedOrder{Total: 9.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order, err := orders.Select(ctx, id)
+	if err != nil || order.Total != 9.5 || order.Id != id {
+		t.Fatalf("expected order %d, got %+v (%v)", id, order, err)
+	}
+
+	_, err = NewStructCRUDWithDB[mappedOrder, int64]("oracle", db)
+	if err == nil {
+		t.Error("expected an unknown driver error")
+	}
+}
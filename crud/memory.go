@@ -0,0 +1,687 @@
+package crud
+
+import (
+	"os"
+	"io"
+	"sort"
+	"sync"
+	"time"
+	"errors"
+	"context"
+	"encoding/gob"
+	"path/filepath"
+	"github.com/buduchail/catrina"
+)
+
+type (
+	// Thread-safe in-memory CRUD, meant as a test double, a prototype
+	// backend and a reference for the CRUD contract. It behaves like
+	// MySqlCRUD: ids are auto-incremented int64 values, the first field
+	// is the id field, Insert and Update take the other fields in
+	// order, and missing rows are reported with the same errors.
+	//
+	// Values are compared like MySQL does: numbers (and numeric
+	// strings) by value, strings byte by byte. NULL (nil) never matches.
+	// Select channels are fully buffered, so they can be abandoned at
+	// any time.
+	MemoryCRUD struct {
+		lock    sync.RWMutex
+		fields  []string
+		index   map[string]int
+		hydrate MemoryHydrateFunc
+		nextId  int64
+		rows    map[int64][]catrina.Value
+
+		exprLock    sync.RWMutex
+		expressions map[string]*expression
+	}
+
+	// Builds an object from the values of a row, in field order (id
+	// first). Without a hydrate function objects are returned as
+	// map[string]interface{}.
+	MemoryHydrateFunc func(values []catrina.Value) (interface{}, error)
+
+	memorySnapshot struct {
+		Fields []string
+		NextId int64
+		Rows   map[int64][]catrina.Value
+	}
+)
+
+func init() {
+	// so that snapshots keep time values
+	gob.Register(time.Time{})
+}
+
+func NewMemoryCRUD(fields []string, hydrate MemoryHydrateFunc) (*MemoryCRUD, error) {
+
+	if len(fields) == 0 {
+		return nil, errors.New("At least one field must be defined")
+	}
+
+	memory := MemoryCRUD{
+		fields:      fields,
+		index:       make(map[string]int, len(fields)),
+		hydrate:     hydrate,
+		nextId:      1,
+		rows:        make(map[int64][]catrina.Value, 0),
+		expressions: make(map[string]*expression, 0),
+	}
+
+	for i, f := range fields {
+		memory.index[f] = i
+	}
+
+	return &memory, nil
+}
+
+// Helper methods
+
+func (r *MemoryCRUD) getObject(row []catrina.Value) (catrina.Object, error) {
+
+	values := make([]catrina.Value, len(row))
+	copy(values, row)
+
+	if r.hydrate != nil {
+		return r.hydrate(values)
+	}
+
+	object := make(map[string]interface{}, len(r.fields))
+	for i, f := range r.fields {
+		object[f] = values[i]
+	}
+
+	return object, nil
+}
+
+func (r *MemoryCRUD) getExpression(where string) (*expression, error) {
+
+	r.exprLock.RLock()
+	expr, parsed := r.expressions[where]
+	r.exprLock.RUnlock()
+
+	if parsed {
+		return expr, nil
+	}
+
+	r.exprLock.Lock()
+	defer r.exprLock.Unlock()
+
+	expr, parsed = r.expressions[where]
+	if !parsed {
+		var err error
+		expr, err = parseExpression(where, r.index)
+		if err != nil {
+			return nil, err
+		}
+		r.expressions[where] = expr
+	}
+
+	return expr, nil
+}
+
+// Returns the rows matching the filter, ordered by id. Rows are never
+// changed in place, so they can be read after the lock is released.
+func (r *MemoryCRUD) matchRows(ctx context.Context, match func(row []catrina.Value) (bool, error)) ([][]catrina.Value, error) {
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	ids := make([]int64, 0, len(r.rows))
+	for id := range r.rows {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	found := make([][]catrina.Value, 0)
+	for _, id := range ids {
+
+		err := ctx.Err()
+		if err != nil {
+			return nil, err
+		}
+
+		row := r.rows[id]
+		matches, err := match(row)
+		if err != nil {
+			return nil, err
+		}
+		if matches {
+			found = append(found, row)
+		}
+	}
+
+	return found, nil
+}
+
+// Returns the matching rows, ordered by id unless page sorts them,
+// through a channel that is already filled and closed, so nothing
+// leaks if the caller stops reading.
+func (r *MemoryCRUD) selectMany(ctx context.Context, match func(row []catrina.Value) (bool, error), page func(rows [][]catrina.Value) [][]catrina.Value) (<-chan catrina.Row, error) {
+
+	rows, err := r.matchRows(ctx, match)
+	if err != nil {
+		return nil, err
+	}
+
+	if page != nil {
+		rows = page(rows)
+	}
+
+	result := make(chan catrina.Row, len(rows))
+	for _, row := range rows {
+		obj, err := r.getObject(row)
+		if err != nil {
+			result <- catrina.Row{Error: err}
+		} else {
+			result <- catrina.Row{Result: obj}
+		}
+	}
+	close(result)
+
+	return result, nil
+}
+
+func (r *MemoryCRUD) fieldIndex(field string) (int, error) {
+	i, exists := r.index[field]
+	if !exists {
+		return 0, unknownFieldErr(field)
+	}
+	return i, nil
+}
+
+// Public interface
+
+func (r *MemoryCRUD) Insert(values []catrina.Value) (id catrina.Value, e error) {
+	return r.InsertContext(context.Background(), values)
+}
+
+func (r *MemoryCRUD) Select(id catrina.Value) (catrina.Object, error) {
+	return r.SelectContext(context.Background(), id)
+}
+
+func (r *MemoryCRUD) SelectWhereFields(fields []string, values []catrina.Value) (<-chan catrina.Row, error) {
+	return r.SelectWhereFieldsContext(context.Background(), fields, values)
+}
+
+func (r *MemoryCRUD) SelectWhereRange(field string, min, max catrina.Value) (<-chan catrina.Row, error) {
+	return r.SelectWhereRangeContext(context.Background(), field, min, max)
+}
+
+func (r *MemoryCRUD) SelectWhere(filter catrina.Filter) (<-chan catrina.Row, error) {
+	return r.SelectWhereContext(context.Background(), filter)
+}
+
+func (r *MemoryCRUD) SelectQuery(query catrina.Query) (<-chan catrina.Row, error) {
+	return r.SelectQueryContext(context.Background(), query)
+}
+
+func (r *MemoryCRUD) Count(filter catrina.Filter) (int64, error) {
+	return r.CountContext(context.Background(), filter)
+}
+
+// Supports a subset of SQL, see parseExpression
+func (r *MemoryCRUD) SelectWhereExpression(where string, values []catrina.Value) (<-chan catrina.Row, error) {
+	return r.SelectWhereExpressionContext(context.Background(), where, values)
+}
+
+func (r *MemoryCRUD) Update(id catrina.Value, values []catrina.Value) error {
+	return r.UpdateContext(context.Background(), id, values)
+}
+
+func (r *MemoryCRUD) Upsert(id catrina.Value, values []catrina.Value) (catrina.Value, error) {
+	return r.UpsertContext(context.Background(), id, values)
+}
+
+func (r *MemoryCRUD) UpdateIfVersion(id catrina.Value, field string, version catrina.Value, values []catrina.Value) error {
+	return r.UpdateIfVersionContext(context.Background(), id, field, version, values)
+}
+
+func (r *MemoryCRUD) Delete(id catrina.Value) error {
+	return r.DeleteContext(context.Background(), id)
+}
+
+func (r *MemoryCRUD) DeleteIfVersion(id catrina.Value, field string, version catrina.Value) error {
+	return r.DeleteIfVersionContext(context.Background(), id, field, version)
+}
+
+func (r *MemoryCRUD) InsertMany(rows [][]catrina.Value) ([]catrina.BatchResult, error) {
+	return r.InsertManyContext(context.Background(), rows)
+}
+
+func (r *MemoryCRUD) UpdateMany(ids []catrina.Value, rows [][]catrina.Value) ([]catrina.BatchResult, error) {
+	return r.UpdateManyContext(context.Background(), ids, rows)
+}
+
+func (r *MemoryCRUD) DeleteMany(ids []catrina.Value) ([]catrina.BatchResult, error) {
+	return r.DeleteManyContext(context.Background(), ids)
+}
+
+// Context-aware public interface
+
+func (r *MemoryCRUD) InsertContext(ctx context.Context, values []catrina.Value) (id catrina.Value, e error) {
+
+	if len(r.fields)-1 != len(values) {
+		return nil, valueCountErr
+	}
+
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	lastId := r.nextId
+	r.nextId++
+
+	row := make([]catrina.Value, len(r.fields))
+	row[0] = lastId
+	copy(row[1:], values)
+	r.rows[lastId] = row
+
+	return lastId, nil
+}
+
+func (r *MemoryCRUD) SelectContext(ctx context.Context, id catrina.Value) (catrina.Object, error) {
+
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	key, ok := toInt64(id)
+	if !ok {
+		return nil, catrina.NotFoundErr
+	}
+
+	row, exists := r.rows[key]
+	if !exists {
+		return nil, catrina.NotFoundErr
+	}
+
+	return r.getObject(row)
+}
+
+func (r *MemoryCRUD) SelectWhereFieldsContext(ctx context.Context, fields []string, values []catrina.Value) (<-chan catrina.Row, error) {
+
+	if len(fields) != len(values) {
+		return nil, catrina.NewValidationError("Fields and values do not match")
+	}
+
+	if len(fields) == 0 {
+		return nil, catrina.NewValidationError("At least one field must be given")
+	}
+
+	indexes := make([]int, len(fields))
+	for i, f := range fields {
+		index, err := r.fieldIndex(f)
+		if err != nil {
+			return nil, err
+		}
+		indexes[i] = index
+	}
+
+	return r.selectMany(ctx, func(row []catrina.Value) (bool, error) {
+		for i, index := range indexes {
+			c, ok := compareValues(row[index], values[i])
+			if !ok || c != 0 {
+				return false, nil
+			}
+		}
+		return true, nil
+	}, nil)
+}
+
+func (r *MemoryCRUD) SelectWhereRangeContext(ctx context.Context, field string, min, max catrina.Value) (<-chan catrina.Row, error) {
+
+	index, err := r.fieldIndex(field)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.selectMany(ctx, func(row []catrina.Value) (bool, error) {
+		return between(row[index], min, max), nil
+	}, nil)
+}
+
+func (r *MemoryCRUD) SelectWhereContext(ctx context.Context, filter catrina.Filter) (<-chan catrina.Row, error) {
+
+	err := checkFilter(filter, r.index)
+	if err != nil {
+		return nil, err
+	}
+
+	match := compileFilter(filter, r.index)
+
+	return r.selectMany(ctx, func(row []catrina.Value) (bool, error) {
+		return match(row) == trueTruth, nil
+	}, nil)
+}
+
+func (r *MemoryCRUD) SelectQueryContext(ctx context.Context, query catrina.Query) (<-chan catrina.Row, error) {
+
+	fields := querySort(query, r.fields[0])
+
+	err := checkQuery(query, fields, r.index)
+	if err != nil {
+		return nil, err
+	}
+
+	match := compileFilter(queryFilter(query, fields), r.index)
+
+	return r.selectMany(ctx, func(row []catrina.Value) (bool, error) {
+		return match(row) == trueTruth, nil
+	}, queryPage(query, fields, r.index))
+}
+
+func (r *MemoryCRUD) CountContext(ctx context.Context, filter catrina.Filter) (int64, error) {
+
+	err := checkFilter(filter, r.index)
+	if err != nil {
+		return 0, err
+	}
+
+	match := compileFilter(filter, r.index)
+
+	rows, err := r.matchRows(ctx, func(row []catrina.Value) (bool, error) {
+		return match(row) == trueTruth, nil
+	})
+
+	return int64(len(rows)), err
+}
+
+func (r *MemoryCRUD) SelectWhereExpressionContext(ctx context.Context, where string, values []catrina.Value) (<-chan catrina.Row, error) {
+
+	expr, err := r.getExpression(where)
+	if err != nil {
+		return nil, err
+	}
+
+	if expr.placeholders != len(values) {
+		return nil, catrina.NewValidationError("Placeholders and values do not match")
+	}
+
+	return r.selectMany(ctx, func(row []catrina.Value) (bool, error) {
+		return expr.match(row, values), nil
+	}, nil)
+}
+
+func (r *MemoryCRUD) UpdateContext(ctx context.Context, id catrina.Value, values []catrina.Value) error {
+
+	if len(r.fields)-1 != len(values) {
+		return valueCountErr
+	}
+
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// like UPDATE, changing a missing row is not an error
+	key, ok := toInt64(id)
+	if !ok {
+		return nil
+	}
+
+	row, exists := r.rows[key]
+	if !exists {
+		return nil
+	}
+
+	updated := make([]catrina.Value, len(row))
+	updated[0] = row[0]
+	copy(updated[1:], values)
+	r.rows[key] = updated
+
+	return nil
+}
+
+// Ids are int64 values, so other ids are rejected. Ids given above the
+// last generated one move the sequence past them.
+func (r *MemoryCRUD) UpsertContext(ctx context.Context, id catrina.Value, values []catrina.Value) (catrina.Value, error) {
+
+	if id == nil {
+		return r.InsertContext(ctx, values)
+	}
+
+	if len(r.fields)-1 != len(values) {
+		return nil, valueCountErr
+	}
+
+	key, ok := toInt64(id)
+	if !ok {
+		return nil, invalidIdErr
+	}
+
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if key >= r.nextId {
+		r.nextId = key + 1
+	}
+
+	row := make([]catrina.Value, len(r.fields))
+	row[0] = key
+	copy(row[1:], values)
+	r.rows[key] = row
+
+	return key, nil
+}
+
+func (r *MemoryCRUD) UpdateIfVersionContext(ctx context.Context, id catrina.Value, field string, version catrina.Value, values []catrina.Value) error {
+
+	if len(r.fields)-1 != len(values) {
+		return valueCountErr
+	}
+
+	i, err := r.fieldIndex(field)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	key, ok := toInt64(id)
+	if !ok {
+		return catrina.NotFoundErr
+	}
+
+	row, exists := r.rows[key]
+	if !exists {
+		return catrina.NotFoundErr
+	}
+
+	if c, ok := compareValues(row[i], version); !ok || c != 0 {
+		return catrina.ConflictErr
+	}
+
+	updated := make([]catrina.Value, len(row))
+	updated[0] = row[0]
+	copy(updated[1:], values)
+	r.rows[key] = updated
+
+	return nil
+}
+
+func (r *MemoryCRUD) DeleteContext(ctx context.Context, id catrina.Value) error {
+
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	key, ok := toInt64(id)
+	if ok {
+		delete(r.rows, key)
+	}
+
+	return nil
+}
+
+func (r *MemoryCRUD) DeleteIfVersionContext(ctx context.Context, id catrina.Value, field string, version catrina.Value) error {
+
+	i, err := r.fieldIndex(field)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	key, ok := toInt64(id)
+	if !ok {
+		return catrina.NotFoundErr
+	}
+
+	row, exists := r.rows[key]
+	if !exists {
+		return catrina.NotFoundErr
+	}
+
+	if c, ok := compareValues(row[i], version); !ok || c != 0 {
+		return catrina.ConflictErr
+	}
+
+	delete(r.rows, key)
+
+	return nil
+}
+
+func (r *MemoryCRUD) InsertManyContext(ctx context.Context, rows [][]catrina.Value) ([]catrina.BatchResult, error) {
+	return eachRow(ctx, len(rows), func(i int) (catrina.Value, error) {
+		return r.InsertContext(ctx, rows[i])
+	})
+}
+
+func (r *MemoryCRUD) UpdateManyContext(ctx context.Context, ids []catrina.Value, rows [][]catrina.Value) ([]catrina.BatchResult, error) {
+
+	if len(ids) != len(rows) {
+		return nil, idsAndRowsErr
+	}
+
+	return eachRow(ctx, len(rows), func(i int) (catrina.Value, error) {
+		return ids[i], r.UpdateContext(ctx, ids[i], rows[i])
+	})
+}
+
+func (r *MemoryCRUD) DeleteManyContext(ctx context.Context, ids []catrina.Value) ([]catrina.BatchResult, error) {
+	return eachRow(ctx, len(ids), func(i int) (catrina.Value, error) {
+		return ids[i], r.DeleteContext(ctx, ids[i])
+	})
+}
+
+// Snapshots
+
+// Writes every row, and the next id, to w. Values are gob-encoded:
+// types other than the basic ones and time.Time must be registered
+// with gob.Register.
+func (r *MemoryCRUD) WriteSnapshot(w io.Writer) error {
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return gob.NewEncoder(w).Encode(memorySnapshot{
+		Fields: r.fields,
+		NextId: r.nextId,
+		Rows:   r.rows,
+	})
+}
+
+// Replaces the contents of the CRUD with a snapshot written by
+// WriteSnapshot. The snapshot must have the same fields.
+func (r *MemoryCRUD) ReadSnapshot(rd io.Reader) error {
+
+	snapshot := memorySnapshot{}
+	err := gob.NewDecoder(rd).Decode(&snapshot)
+	if err != nil {
+		return err
+	}
+
+	if len(snapshot.Fields) != len(r.fields) {
+		return errors.New("Snapshot fields do not match")
+	}
+	for i, f := range snapshot.Fields {
+		if r.fields[i] != f {
+			return errors.New("Snapshot fields do not match")
+		}
+	}
+
+	if snapshot.Rows == nil {
+		snapshot.Rows = make(map[int64][]catrina.Value, 0)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.nextId = snapshot.NextId
+	r.rows = snapshot.Rows
+
+	return nil
+}
+
+// Saves a snapshot to a file. The file is replaced atomically, so a
+// crash never leaves a half-written snapshot behind.
+func (r *MemoryCRUD) SaveSnapshot(path string) error {
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = r.WriteSnapshot(tmp)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Loads a snapshot saved with SaveSnapshot. A missing file is not an
+// error, the CRUD is simply left empty.
+func (r *MemoryCRUD) LoadSnapshot(path string) error {
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return r.ReadSnapshot(f)
+}
@@ -0,0 +1,360 @@
+package crud
+
+import (
+	"time"
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+	"path/filepath"
+
+	"github.com/buduchail/catrina"
+)
+
+func newMemoryItems(t *testing.T, rows ...[]catrina.Value) *MemoryCRUD {
+
+	items, err := NewMemoryCRUD([]string{"id", "name", "price"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	insertItems(t, items, rows...)
+
+	return items
+}
+
+func memoryIds(t *testing.T, rows <-chan catrina.Row, err error) []int64 {
+
+	ids := []int64{}
+	for _, object := range collectRows(t, rows, err) {
+		ids = append(ids, object.(map[string]interface{})["id"].(int64))
+	}
+
+	return ids
+}
+
+func TestMemory(t *testing.T) {
+
+	items := newMemoryItems(t)
+	ctx := context.Background()
+
+	_, err := NewMemoryCRUD(nil, nil)
+	if err == nil {
+		t.Error("expected an error without fields")
+	}
+
+	id, err := items.InsertContext(ctx, []catrina.Value{"pen", int64(3)})
+	if err != nil || id != int64(1) {
+		t.Fatalf("expected id 1, got %v (%v)", id, err)
+	}
+
+	_, err = items.InsertContext(ctx, []catrina.Value{"pen"})
+	if err != valueCountErr {
+		t.Errorf("expected %v, got %v", valueCountErr, err)
+	}
+
+	object, err := items.SelectContext(ctx, "1")
+	expected := map[string]interface{}{"id": int64(1), "name": "pen", "price": int64(3)}
+	if err != nil || !reflect.DeepEqual(object, expected) {
+		t.Errorf("expected %v, got %v (%v)", expected, object, err)
+	}
+
+	for _, missing := range []catrina.Value{int64(9), "pen", nil} {
+		_, err = items.SelectContext(ctx, missing)
+		if err != catrina.NotFoundErr {
+			t.Errorf("select %v: expected %v, got %v", missing, catrina.NotFoundErr, err)
+		}
+	}
+
+	// like UPDATE and DELETE, missing rows are not an error
+	err = items.UpdateContext(ctx, int64(9), []catrina.Value{"ink", nil})
+	if err != nil {
+		t.Error(err)
+	}
+	err = items.DeleteContext(ctx, int64(9))
+	if err != nil {
+		t.Error(err)
+	}
+
+	id, err = items.UpsertContext(ctx, int64(5), []catrina.Value{"cup", int64(5)})
+	if err != nil || id != int64(5) {
+		t.Errorf("upsert: expected id 5, got %v (%v)", id, err)
+	}
+	id, _ = items.InsertContext(ctx, []catrina.Value{"mug", int64(6)})
+	if id != int64(6) {
+		t.Errorf("expected upserts to move the sequence, got id %v", id)
+	}
+	_, err = items.UpsertContext(ctx, "five", []catrina.Value{"cup", int64(5)})
+	if err != invalidIdErr {
+		t.Errorf("expected %v, got %v", invalidIdErr, err)
+	}
+
+	versions := []struct {
+		id      catrina.Value
+		field   string
+		version catrina.Value
+		err     error
+	}{
+		{int64(5), "price", int64(4), catrina.ConflictErr},
+		{int64(5), "price", nil, catrina.ConflictErr},
+		{int64(9), "price", int64(5), catrina.NotFoundErr},
+		{int64(5), "price", "5", nil},
+	}
+	for _, v := range versions {
+		err = items.UpdateIfVersionContext(ctx, v.id, v.field, v.version, []catrina.Value{"cup", int64(6)})
+		if err != v.err {
+			t.Errorf("update %v if %v: expected %v, got %v", v.id, v.version, v.err, err)
+		}
+	}
+	err = items.UpdateIfVersionContext(ctx, int64(5), "cost", int64(6), []catrina.Value{"cup", int64(7)})
+	if err == nil {
+		t.Error("expected an unknown field error")
+	}
+
+	err = items.DeleteIfVersionContext(ctx, int64(5), "price", int64(5))
+	if err != catrina.ConflictErr {
+		t.Errorf("expected %v, got %v", catrina.ConflictErr, err)
+	}
+	err = items.DeleteIfVersionContext(ctx, int64(9), "price", int64(6))
+	if err != catrina.NotFoundErr {
+		t.Errorf("expected %v, got %v", catrina.NotFoundErr, err)
+	}
+	err = items.DeleteIfVersionContext(ctx, int64(5), "price", int64(6))
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = items.SelectContext(ctx, int64(5))
+	if err != catrina.NotFoundErr {
+		t.Errorf("expected the row to be deleted, got %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = items.InsertContext(cancelled, []catrina.Value{"pad", int64(8)})
+	if err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+	_, err = items.SelectWhereContext(cancelled, catrina.Filter{})
+	if err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+// Filters follow SQL: comparisons with NULL are unknown, and NOT of
+// unknown is unknown, so rows with NULLs only match IS NULL
+func TestMemoryFilters(t *testing.T) {
+
+	items := newMemoryItems(t,
+		[]catrina.Value{"pen", int64(3)},
+		[]catrina.Value{"ink", nil},
+		[]catrina.Value{"cup", "5"},
+		[]catrina.Value{"pad", 8.0},
+		[]catrina.Value{"pin", int64(3)},
+	)
+	ctx := context.Background()
+
+	filters := []struct {
+		name     string
+		filter   catrina.Filter
+		expected []int64
+	}{
+		{"all", catrina.Filter{}, []int64{1, 2, 3, 4, 5}},
+		{"eq", catrina.Eq("price", 3), []int64{1, 5}},
+		{"numeric string", catrina.Eq("price", 5), []int64{3}},
+		{"neq", catrina.Neq("price", 3), []int64{3, 4}},
+		{"not eq", catrina.Not(catrina.Eq("price", 3)), []int64{3, 4}},
+		{"eq null", catrina.Eq("price", nil), []int64{}},
+		{"in", catrina.In("name", "cup", "pad", "mop"), []int64{3, 4}},
+		{"in with null", catrina.Not(catrina.In("price", 3, nil)), []int64{}},
+		{"empty in", catrina.In("name"), []int64{}},
+		{"not empty in", catrina.Not(catrina.In("name")), []int64{1, 2, 3, 4, 5}},
+		{"like", catrina.Like("name", "p_n"), []int64{1, 5}},
+		{"not like", catrina.Not(catrina.Like("price", "%")), []int64{}},
+		{"range", catrina.Range("price", 4, 8), []int64{3, 4}},
+		{"lt", catrina.Lt("price", 5), []int64{1, 5}},
+		{"gte", catrina.Gte("price", 5), []int64{3, 4}},
+		{"is null", catrina.IsNull("price"), []int64{2}},
+		{"not null", catrina.Not(catrina.IsNull("price")), []int64{1, 3, 4, 5}},
+		{"or unknown", catrina.Or(catrina.Eq("price", 8), catrina.Eq("name", "ink")), []int64{2, 4}},
+		{"not and unknown", catrina.Not(catrina.And(catrina.Eq("price", 3), catrina.Eq("name", "ink"))), []int64{1, 3, 4, 5}},
+		{"not or unknown", catrina.Not(catrina.Or(catrina.Eq("price", 3), catrina.Eq("name", "pen"))), []int64{3, 4}},
+		{"empty and", catrina.And(), []int64{1, 2, 3, 4, 5}},
+		{"empty or", catrina.Or(), []int64{}},
+	}
+
+	for _, f := range filters {
+		t.Run(f.name, func(t *testing.T) {
+
+			rows, err := items.SelectWhereContext(ctx, f.filter)
+			if ids := memoryIds(t, rows, err); !reflect.DeepEqual(ids, f.expected) {
+				t.Errorf("expected %v, got %v", f.expected, ids)
+			}
+
+			count, err := items.CountContext(ctx, f.filter)
+			if err != nil || count != int64(len(f.expected)) {
+				t.Errorf("expected a count of %d, got %d (%v)", len(f.expected), count, err)
+			}
+		})
+	}
+
+	invalid := []catrina.Filter{
+		catrina.Eq("cost", 1),
+		catrina.And(catrina.Eq("name", "a"), catrina.Lt("cost", 1)),
+		{Op: "xor"},
+	}
+	for _, f := range invalid {
+		_, err := items.SelectWhereContext(ctx, f)
+		if err == nil {
+			t.Errorf("%v: expected an error", f)
+		}
+	}
+
+	rows, err := items.SelectWhereFieldsContext(ctx, []string{"name", "price"}, []catrina.Value{"pen", "3"})
+	if ids := memoryIds(t, rows, err); !reflect.DeepEqual(ids, []int64{1}) {
+		t.Errorf("fields: unexpected %v", ids)
+	}
+
+	rows, err = items.SelectWhereRangeContext(ctx, "price", 3, 5)
+	if ids := memoryIds(t, rows, err); !reflect.DeepEqual(ids, []int64{1, 3, 5}) {
+		t.Errorf("range: unexpected %v", ids)
+	}
+
+	rows, err = items.SelectWhereExpressionContext(ctx, "name LIKE ? AND NOT price = ?", []catrina.Value{"p%", 3})
+	if ids := memoryIds(t, rows, err); !reflect.DeepEqual(ids, []int64{4}) {
+		t.Errorf("expression: unexpected %v", ids)
+	}
+
+	_, err = items.SelectWhereExpressionContext(ctx, "name = ?", nil)
+	if err == nil {
+		t.Error("expected a placeholder count error")
+	}
+}
+
+// Selects see the rows as they were when they ran, and neither the
+// values given nor the objects returned share memory with the CRUD
+func TestMemoryIsolation(t *testing.T) {
+
+	items := newMemoryItems(t)
+	ctx := context.Background()
+
+	values := []catrina.Value{"pen", int64(3)}
+	id, _ := items.InsertContext(ctx, values)
+	values[0] = "ink"
+
+	rows, err := items.SelectWhereContext(ctx, catrina.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items.UpdateContext(ctx, id, []catrina.Value{"cup", int64(5)})
+	items.InsertContext(ctx, []catrina.Value{"pad", int64(8)})
+
+	found := collectRows(t, rows, nil)
+	expected := []catrina.Object{map[string]interface{}{"id": int64(1), "name": "pen", "price": int64(3)}}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("expected %v, got %v", expected, found)
+	}
+
+	object, _ := items.SelectContext(ctx, id)
+	object.(map[string]interface{})["name"] = "mug"
+
+	object, _ = items.SelectContext(ctx, id)
+	if name := object.(map[string]interface{})["name"]; name != "cup" {
+		t.Errorf("expected cup, got %v", name)
+	}
+
+	// an abandoned select leaks nothing, its channel is already closed
+	rows, _ = items.SelectWhereContext(ctx, catrina.Filter{})
+	if cap(rows) != 2 || len(rows) != 2 {
+		t.Errorf("expected a full channel of 2 rows, got %d of %d", len(rows), cap(rows))
+	}
+}
+
+func TestMemoryHydrate(t *testing.T) {
+
+	items, err := NewMemoryCRUD([]string{"id", "name", "price"}, func(values []catrina.Value) (interface{}, error) {
+		values[1] = "changed"
+		return sqliteItem{Id: values[0].(int64)}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	id, _ := items.InsertContext(ctx, []catrina.Value{"pen", int64(3)})
+
+	for i := 0; i < 2; i++ {
+		object, err := items.SelectContext(ctx, id)
+		if err != nil || object != (sqliteItem{Id: 1}) {
+			t.Errorf("unexpected %v (%v)", object, err)
+		}
+	}
+
+	rows, err := items.SelectWhereExpressionContext(ctx, "name = 'pen'", nil)
+	if found := collectRows(t, rows, err); len(found) != 1 {
+		t.Errorf("expected the hydrate function not to change the row, got %v", found)
+	}
+}
+
+func TestMemorySnapshot(t *testing.T) {
+
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	items, _ := NewMemoryCRUD([]string{"id", "name", "created"}, nil)
+	insertItems(t, items,
+		[]catrina.Value{"pen", created},
+		[]catrina.Value{"ink", nil},
+	)
+	items.Delete(int64(2))
+
+	buffer := &bytes.Buffer{}
+	err := items.WriteSnapshot(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, _ := NewMemoryCRUD([]string{"id", "name", "created"}, nil)
+	err = restored.ReadSnapshot(bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	object, err := restored.Select(int64(1))
+	expected := map[string]interface{}{"id": int64(1), "name": "pen", "created": created}
+	if err != nil || !reflect.DeepEqual(object, expected) {
+		t.Errorf("expected %v, got %v (%v)", expected, object, err)
+	}
+
+	// ids of deleted rows are not reused
+	id, _ := restored.Insert([]catrina.Value{"cup", nil})
+	if id != int64(3) {
+		t.Errorf("expected id 3, got %v", id)
+	}
+
+	other, _ := NewMemoryCRUD([]string{"id", "name", "updated"}, nil)
+	err = other.ReadSnapshot(bytes.NewReader(buffer.Bytes()))
+	if err == nil {
+		t.Error("expected a field mismatch error")
+	}
+
+	path := filepath.Join(t.TempDir(), "items.snapshot")
+
+	err = other.LoadSnapshot(path)
+	if err != nil {
+		t.Errorf("expected a missing snapshot to be ignored, got %v", err)
+	}
+
+	err = items.SaveSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, _ := NewMemoryCRUD([]string{"id", "name", "created"}, nil)
+	err = loaded.LoadSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, _ := loaded.Count(catrina.Filter{})
+	if count != 1 {
+		t.Errorf("expected 1 row, got %d", count)
+	}
+}
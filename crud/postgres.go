@@ -0,0 +1,44 @@
+package crud
+
+import (
+	"database/sql"
+	_ "github.com/lib/pq"
+)
+
+type (
+	// Same as MySqlCRUD, for PostgreSQL: statements use $n placeholders
+	// (also in SelectWhereExpression, e.g. "name LIKE $1") and ids
+	// generated on insert are read with RETURNING, as the driver does
+	// not support LastInsertId.
+	PostgresCRUD struct {
+		sqlCRUD
+	}
+
+	// Deprecated: use SqlScanFunc
+	PostgresHydrateFunc = SqlScanFunc
+)
+
+func NewPostgresCRUD(dsn string, table string, fields []string, scan SqlScanFunc) (*PostgresCRUD, error) {
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPostgresCRUDWithDB(db, table, fields, scan)
+}
+
+// Same as NewPostgresCRUD, for an already open database. Useful to
+// share a connection pool, or to run against any database/sql driver
+// that understands PostgreSQL syntax (e.g. an embedded server in tests).
+func NewPostgresCRUDWithDB(db *sql.DB, table string, fields []string, scan SqlScanFunc) (*PostgresCRUD, error) {
+
+	postgres := &PostgresCRUD{}
+
+	err := postgres.init(db, table, fields, scan, dollarNumbers)
+	if err != nil {
+		return nil, err
+	}
+
+	return postgres, nil
+}
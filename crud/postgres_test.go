@@ -0,0 +1,260 @@
+package crud
+
+import (
+	"os"
+	"context"
+	"reflect"
+	"testing"
+	"database/sql"
+
+	"github.com/buduchail/catrina"
+)
+
+// DSN of a PostgreSQL database the tests may create tables in, e.g.
+// "postgres://postgres@localhost/test?sslmode=disable". The tests that
+// need it are skipped when it is not set.
+const postgresDSNVariable = "CATRINA_POSTGRES_DSN"
+
+type pgUser struct {
+	Id      int64
+	Name    string
+	Age     int64
+	Version int64
+}
+
+func scanPgUser(rows *sql.Rows) (interface{}, error) {
+	u := pgUser{}
+	err := rows.Scan(&u.Id, &u.Name, &u.Age, &u.Version)
+	return u, err
+}
+
+// Statements are generated with $n placeholders, numbered across the
+// clauses of a statement. No database is needed.
+func TestPostgresSQL(t *testing.T) {
+
+	r := &sqlCRUD{}
+	err := r.init(nil, "users", []string{"id", "name", "age", "version"}, nil, dollarNumbers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tables with only an id
+	tickets := &sqlCRUD{}
+	tickets.init(nil, "tickets", []string{"id"}, nil, dollarNumbers)
+
+	statements := []struct {
+		name, actual, expected string
+	}{
+		{"insert", r.insertSQL(), "INSERT INTO users (name,age,version) VALUES ($1,$2,$3) RETURNING id"},
+		{"insert rows", r.insertRowsSQL(2), "INSERT INTO users (name,age,version) VALUES ($1,$2,$3),($4,$5,$6) RETURNING id"},
+		{"update", r.updateSQL(), "UPDATE users SET name = $1, age = $2, version = $3 WHERE id = $4"},
+		{"delete", r.deleteSQL(), "DELETE FROM users WHERE id = $1"},
+		{"upsert", r.upsertSQL(), "INSERT INTO users (id,name,age,version) VALUES ($1,$2,$3,$4) ON CONFLICT (id) DO UPDATE SET name = excluded.name, age = excluded.age, version = excluded.version RETURNING id"},
+		{"where fields", r.whereFieldsSQL([]string{"name", "age"}), "name = $1 AND age = $2"},
+		{"where range", r.whereRangeSQL("age"), "age BETWEEN $1 AND $2"},
+		{"insert defaults", tickets.insertSQL(), "INSERT INTO tickets DEFAULT VALUES RETURNING id"},
+	}
+
+	for _, s := range statements {
+		if s.actual != s.expected {
+			t.Errorf("%s: expected %q, got %q", s.name, s.expected, s.actual)
+		}
+	}
+
+	filters := []struct {
+		name     string
+		filter   catrina.Filter
+		args     []interface{}
+		expected string
+		values   []interface{}
+	}{
+		{
+			name:     "nested filters",
+			filter:   catrina.And(catrina.Eq("name", "a"), catrina.Or(catrina.In("age", 1, 2), catrina.Range("version", 3, 4)), catrina.Not(catrina.IsNull("age"))),
+			expected: "(name = $1) AND ((age IN ($2,$3)) OR (version BETWEEN $4 AND $5)) AND (NOT (age IS NULL))",
+			values:   []interface{}{"a", 1, 2, 3, 4},
+		},
+		{
+			name:     "numbered after existing values",
+			filter:   catrina.And(catrina.Gt("age", 18), catrina.Like("name", "a%")),
+			args:     []interface{}{"x", "y"},
+			expected: "(age > $3) AND (name LIKE $4)",
+			values:   []interface{}{"x", "y", 18, "a%"},
+		},
+		{
+			name:     "empty in",
+			filter:   catrina.And(catrina.In("age"), catrina.Eq("name", "b")),
+			expected: "(1 = 0) AND (name = $1)",
+			values:   []interface{}{"b"},
+		},
+	}
+
+	for _, f := range filters {
+		args := append([]interface{}{}, f.args...)
+		where := r.filterSQL(f.filter, &args)
+		if where != f.expected {
+			t.Errorf("%s: expected %q, got %q", f.name, f.expected, where)
+		}
+		if !reflect.DeepEqual(args, f.values) {
+			t.Errorf("%s: expected values %v, got %v", f.name, f.values, args)
+		}
+	}
+
+	q := catrina.Query{Filter: catrina.Eq("name", "a"), Sort: catrina.ParseSort("-age"), Limit: 2, After: []catrina.Value{30, 5}}
+	sort := querySort(q, r.id)
+	args := []interface{}{}
+	query := r.selectSQL(r.filterSQL(queryFilter(q, sort), &args)) + r.pageSQL(q, sort)
+	expected := "SELECT id,name,age,version FROM users WHERE (name = $1) AND ((((age < $2) OR (age IS NULL))) OR ((age = $3) AND (id > $4))) ORDER BY age DESC NULLS LAST, id ASC NULLS FIRST LIMIT 2"
+	if query != expected {
+		t.Errorf("query: expected %q, got %q", expected, query)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"a", 30, 30, 5}) {
+		t.Errorf("query: unexpected values %v", args)
+	}
+}
+
+func openPostgres(t *testing.T) *sql.DB {
+
+	dsn := os.Getenv(postgresDSNVariable)
+	if dsn == "" {
+		t.Skip(postgresDSNVariable + " is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	for _, statement := range []string{
+		"DROP TABLE IF EXISTS catrina_users",
+		"CREATE TABLE catrina_users (id BIGSERIAL PRIMARY KEY, name TEXT NOT NULL, age BIGINT NOT NULL, version BIGINT NOT NULL)",
+	} {
+		_, err = db.Exec(statement)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { db.Exec("DROP TABLE IF EXISTS catrina_users") })
+
+	return db
+}
+
+func collectRows(t *testing.T, rows <-chan catrina.Row, err error) []catrina.Object {
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objects := []catrina.Object{}
+	for row := range rows {
+		if row.Error != nil {
+			t.Fatal(row.Error)
+		}
+		objects = append(objects, row.Result)
+	}
+
+	return objects
+}
+
+func TestPostgres(t *testing.T) {
+
+	db := openPostgres(t)
+	ctx := context.Background()
+
+	users, err := NewSqlCRUDWithScan("postgres", db, "catrina_users", []string{"id", "name", "age", "version"}, scanPgUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ids generated by the database are read with RETURNING
+	id, err := users.InsertContext(ctx, []catrina.Value{"ann", 30, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != int64(1) {
+		t.Fatalf("expected id 1, got %#v", id)
+	}
+
+	results, err := users.InsertManyContext(ctx, [][]catrina.Value{
+		{"bob", 40, 1},
+		{"cid"},
+		{"dan", 50, 1},
+		{"eve", 40, 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []catrina.BatchResult{
+		{Id: int64(2)},
+		{Error: valueCountErr},
+		{Id: int64(3)},
+		{Id: int64(4)},
+	}
+	if !reflect.DeepEqual(results, expectedResults) {
+		t.Fatalf("expected %v, got %v", expectedResults, results)
+	}
+
+	object, err := users.SelectContext(ctx, int64(3))
+	if err != nil || object != (pgUser{3, "dan", 50, 1}) {
+		t.Fatalf("unexpected %v (%v)", object, err)
+	}
+
+	// placeholders of the expression are the caller's
+	rows, err := users.SelectWhereExpressionContext(ctx, "name LIKE $1 AND age > $2", []catrina.Value{"%e%", 35})
+	found := collectRows(t, rows, err)
+	if len(found) != 1 || found[0].(pgUser).Name != "eve" {
+		t.Errorf("expression: unexpected %v", found)
+	}
+
+	// filter and cursor values share the numbering
+	query := catrina.Query{
+		Filter: catrina.Gte("age", 30),
+		Sort:   catrina.ParseSort("-age"),
+		Limit:  2,
+		After:  []catrina.Value{40, 2},
+	}
+	rows, err = users.SelectQueryContext(ctx, query)
+	found = collectRows(t, rows, err)
+	if len(found) != 2 || found[0].(pgUser).Id != 4 || found[1].(pgUser).Id != 1 {
+		t.Errorf("query: unexpected %v", found)
+	}
+
+	count, err := users.CountContext(ctx, catrina.Or(catrina.Eq("age", 40), catrina.Eq("name", "ann")))
+	if err != nil || count != 3 {
+		t.Errorf("count: expected 3, got %d (%v)", count, err)
+	}
+
+	err = users.UpdateIfVersionContext(ctx, int64(1), "version", 2, []catrina.Value{"ann", 31, 3})
+	if err != catrina.ConflictErr {
+		t.Errorf("expected %v, got %v", catrina.ConflictErr, err)
+	}
+	err = users.UpdateIfVersionContext(ctx, int64(1), "version", 1, []catrina.Value{"ann", 31, 2})
+	if err != nil {
+		t.Error(err)
+	}
+	err = users.DeleteIfVersionContext(ctx, int64(1), "version", 1)
+	if err != catrina.ConflictErr {
+		t.Errorf("expected %v, got %v", catrina.ConflictErr, err)
+	}
+
+	// an upsert without id inserts, and its id is returned
+	id, err = users.UpsertContext(ctx, nil, []catrina.Value{"fay", 20, 1})
+	if err != nil || id != int64(5) {
+		t.Errorf("upsert: expected id 5, got %v (%v)", id, err)
+	}
+	id, err = users.UpsertContext(ctx, int64(5), []catrina.Value{"fay", 21, 2})
+	if err != nil || id != int64(5) {
+		t.Errorf("upsert: expected id 5, got %v (%v)", id, err)
+	}
+
+	deleted, err := users.DeleteManyContext(ctx, []catrina.Value{int64(2), int64(3)})
+	if err != nil || deleted[0].Error != nil || deleted[1].Error != nil {
+		t.Errorf("delete: unexpected %v (%v)", deleted, err)
+	}
+
+	_, err = users.SelectContext(ctx, int64(2))
+	if err != catrina.NotFoundErr {
+		t.Errorf("expected %v, got %v", catrina.NotFoundErr, err)
+	}
+}
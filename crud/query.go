@@ -0,0 +1,157 @@
+package crud
+
+import (
+	"sort"
+	"github.com/buduchail/catrina"
+)
+
+// Sort fields of a query, ending with the id field so that the order
+// is total and pages never overlap
+func querySort(q catrina.Query, id string) []catrina.SortField {
+
+	fields := append([]catrina.SortField{}, q.Sort...)
+	for _, s := range q.Sort {
+		if s.Field == id {
+			return fields
+		}
+	}
+
+	return append(fields, catrina.SortField{Field: id})
+}
+
+func checkQuery(q catrina.Query, sort []catrina.SortField, index map[string]int) error {
+
+	err := checkFilter(q.Filter, index)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range sort {
+		if _, exists := index[s.Field]; !exists {
+			return unknownFieldErr(s.Field)
+		}
+	}
+
+	if q.Limit < 0 || q.Offset < 0 {
+		return catrina.NewValidationError("Limit and offset must not be negative")
+	}
+
+	if len(q.After) > 0 && len(q.After) != len(sort) {
+		return catrina.NewValidationError("Cursor does not match sort fields")
+	}
+
+	return nil
+}
+
+// Filter of a checked query, narrowed to the rows after the cursor.
+// For sort fields a, -b, id and cursor x, y, z that is
+//
+//	a > x OR (a = x AND b < y) OR (a = x AND b = y AND id > z)
+//
+// NULLs sort first, so when x is NULL "a > x" becomes a IS NOT NULL
+// and "a = x" a IS NULL, and when y is not NULL "b < y" includes the
+// rows where b IS NULL. Nothing comes after a NULL y.
+//
+// Cursors usually come from encoded objects, where times have become
+// RFC 3339 strings: those are turned back into times, which the SQL
+// drivers format as the database stores them.
+func queryFilter(q catrina.Query, sort []catrina.SortField) catrina.Filter {
+
+	if len(q.After) == 0 {
+		return q.Filter
+	}
+
+	after := make([]catrina.Value, len(q.After))
+	for i, v := range q.After {
+		after[i] = v
+		if s, isString := v.(string); isString {
+			if t, ok := toTime(s); ok {
+				after[i] = t
+			}
+		}
+	}
+
+	keyset := make([]catrina.Filter, 0, len(sort))
+	for i, s := range sort {
+		next, exists := keysetNext(s, after[i])
+		if !exists {
+			continue
+		}
+		and := make([]catrina.Filter, 0, i+1)
+		for j := 0; j < i; j++ {
+			and = append(and, keysetEq(sort[j].Field, after[j]))
+		}
+		keyset = append(keyset, catrina.And(append(and, next)...))
+	}
+
+	return catrina.And(q.Filter, catrina.Or(keyset...))
+}
+
+func keysetEq(field string, v catrina.Value) catrina.Filter {
+	if v == nil {
+		return catrina.IsNull(field)
+	}
+	return catrina.Eq(field, v)
+}
+
+// Filter of the values that sort after v, if any
+func keysetNext(s catrina.SortField, v catrina.Value) (catrina.Filter, bool) {
+
+	switch {
+	case v == nil && s.Descending:
+		return catrina.Filter{}, false
+	case v == nil:
+		return catrina.Not(catrina.IsNull(s.Field)), true
+	case s.Descending:
+		return catrina.Or(catrina.Lt(s.Field, v), catrina.IsNull(s.Field)), true
+	}
+
+	return catrina.Gt(s.Field, v), true
+}
+
+// Sorts and pages rows for the backends that evaluate queries
+// themselves. NULLs sort first, as in MySQL.
+func queryPage(q catrina.Query, fields []catrina.SortField, index map[string]int) func(rows [][]catrina.Value) [][]catrina.Value {
+
+	return func(rows [][]catrina.Value) [][]catrina.Value {
+
+		sort.SliceStable(rows, func(i, j int) bool {
+			for _, s := range fields {
+				c := compareSort(rows[i][index[s.Field]], rows[j][index[s.Field]])
+				if s.Descending {
+					c = -c
+				}
+				if c != 0 {
+					return c < 0
+				}
+			}
+			return false
+		})
+
+		if q.Offset >= len(rows) {
+			return rows[:0]
+		}
+		rows = rows[q.Offset:]
+
+		if q.Limit > 0 && q.Limit < len(rows) {
+			rows = rows[:q.Limit]
+		}
+
+		return rows
+	}
+}
+
+func compareSort(a, b catrina.Value) int {
+
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+
+	c, _ := compareValues(a, b)
+	return c
+}
@@ -0,0 +1,145 @@
+package crud
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/buduchail/catrina"
+)
+
+var pagedItems = [][]catrina.Value{
+	{"pen", int64(3)},
+	{"ink", nil},
+	{"cup", int64(5)},
+	{"pad", nil},
+	{"pin", int64(3)},
+	{"mug", nil},
+	{"box", int64(8)},
+}
+
+// Reads every page of a query with cursors made from the last row of
+// the previous page, as CRUDHandler does. A limit of 0 reads one page.
+func readPages(t *testing.T, crud catrina.ContextCRUD, sort string, limit int, values func(catrina.Object) map[string]catrina.Value) []int64 {
+
+	ctx := context.Background()
+	query := catrina.Query{Sort: catrina.ParseSort(sort), Limit: limit}
+	fields := querySort(query, "id")
+
+	ids := []int64{}
+	for pages := 0; pages <= len(pagedItems); pages++ {
+
+		rows, err := crud.SelectQueryContext(ctx, query)
+		found := collectRows(t, rows, err)
+		if len(found) == 0 {
+			return ids
+		}
+
+		last := values(found[len(found)-1])
+		query.After = make([]catrina.Value, len(fields))
+		for i, f := range fields {
+			query.After[i] = last[f.Field]
+		}
+
+		for _, object := range found {
+			ids = append(ids, values(object)["id"].(int64))
+		}
+	}
+
+	t.Fatalf("%s: pages do not end, read %v", sort, ids)
+	return nil
+}
+
+func TestKeysetPaging(t *testing.T) {
+
+	memory := newMemoryItems(t, pagedItems...)
+	sqlite := openSqlite(t)
+	insertItems(t, sqlite, pagedItems...)
+
+	backends := []struct {
+		name   string
+		crud   catrina.ContextCRUD
+		values func(catrina.Object) map[string]catrina.Value
+	}{
+		{"memory", memory, func(o catrina.Object) map[string]catrina.Value {
+			values := map[string]catrina.Value{}
+			for field, v := range o.(map[string]interface{}) {
+				values[field] = v
+			}
+			return values
+		}},
+		{"sqlite", sqlite, func(o catrina.Object) map[string]catrina.Value {
+			item := o.(sqliteItem)
+			values := map[string]catrina.Value{"id": item.Id, "name": item.Name, "price": nil}
+			if item.Price.Valid {
+				values["price"] = item.Price.Int64
+			}
+			return values
+		}},
+	}
+
+	// NULLs sort first, and ties are broken by id
+	tests := []struct {
+		sort     string
+		expected []int64
+	}{
+		{"", []int64{1, 2, 3, 4, 5, 6, 7}},
+		{"-id", []int64{7, 6, 5, 4, 3, 2, 1}},
+		{"name", []int64{7, 3, 2, 6, 4, 1, 5}},
+		{"price", []int64{2, 4, 6, 1, 5, 3, 7}},
+		{"-price", []int64{7, 3, 1, 5, 2, 4, 6}},
+		{"price,-id", []int64{6, 4, 2, 5, 1, 3, 7}},
+		{"-price,-id", []int64{7, 3, 5, 1, 6, 4, 2}},
+		{"price,name", []int64{2, 6, 4, 1, 5, 3, 7}},
+		{"-price,name", []int64{7, 3, 1, 5, 2, 6, 4}},
+	}
+
+	for _, backend := range backends {
+		for _, test := range tests {
+
+			for limit := 0; limit <= 3; limit++ {
+				ids := readPages(t, backend.crud, test.sort, limit, backend.values)
+				if !reflect.DeepEqual(ids, test.expected) {
+					t.Errorf("%s, sort %q, pages of %d: expected %v, got %v", backend.name, test.sort, limit, test.expected, ids)
+				}
+			}
+		}
+	}
+}
+
+func TestQueryFilter(t *testing.T) {
+
+	sort := catrina.ParseSort("a,-b,id")
+
+	tests := []struct {
+		name     string
+		after    []catrina.Value
+		expected catrina.Filter
+	}{
+		{
+			name:  "values",
+			after: []catrina.Value{1, 2, 3},
+			expected: catrina.Or(
+				catrina.And(catrina.Gt("a", 1)),
+				catrina.And(catrina.Eq("a", 1), catrina.Or(catrina.Lt("b", 2), catrina.IsNull("b"))),
+				catrina.And(catrina.Eq("a", 1), catrina.Eq("b", 2), catrina.Gt("id", 3)),
+			),
+		},
+		{
+			name:  "nulls",
+			after: []catrina.Value{nil, nil, 3},
+			expected: catrina.Or(
+				catrina.And(catrina.Not(catrina.IsNull("a"))),
+				catrina.And(catrina.IsNull("a"), catrina.IsNull("b"), catrina.Gt("id", 3)),
+			),
+		},
+	}
+
+	for _, test := range tests {
+		filter := queryFilter(catrina.Query{Filter: catrina.Eq("c", 4), After: test.after}, sort)
+		expected := catrina.And(catrina.Eq("c", 4), test.expected)
+		if !reflect.DeepEqual(filter, expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, expected, filter)
+		}
+	}
+}
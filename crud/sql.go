@@ -0,0 +1,1204 @@
+package crud
+
+import (
+	"fmt"
+	"context"
+	"sync"
+	"errors"
+	"strconv"
+	"strings"
+	"database/sql"
+	"github.com/buduchail/catrina"
+)
+
+type (
+	// Builds an object from the current row, which it can only scan,
+	// e.g. StructMapping.Scan. It replaces the hydrate functions that
+	// took the rows by value, copying the lock they hold.
+	SqlScanFunc func(rows *sql.Rows) (interface{}, error)
+
+	// What changes from one SQL backend to another
+	sqlDialect struct {
+		// placeholder for the nth value of a statement, starting at 1
+		placeholder func(n int) string
+		// generated ids are read with RETURNING instead of LastInsertId
+		returning bool
+		// NULLs sort as the largest values, unlike in MySQL
+		nullsLast bool
+		// most placeholders a statement can have
+		maxParams int
+		// LastInsertId of a multi-row insert is the id of the last row,
+		// not the first one as in MySQL
+		lastIdIsLast bool
+		// upserts use MySQL's ON DUPLICATE KEY UPDATE instead of
+		// ON CONFLICT (id) DO UPDATE
+		onDuplicateKey bool
+		// rows without fields besides the id are inserted one at a time
+		// with DEFAULT VALUES, as there is no "VALUES ()"
+		defaultValues bool
+	}
+
+	// Statement generation and caching shared by the database/sql
+	// backends, which embed it. The first field is the ID field.
+	sqlCRUD struct {
+		db      *sql.DB
+		table   string
+		id      string
+		fields  []string
+		index   map[string]int
+		scan    SqlScanFunc
+		dialect sqlDialect
+		// batches are split in statements of at most this many bytes
+		maxPacketSize int
+		// capacity of the channels returned by selects
+		bufferSize int
+
+		stmt    *sqlStatements
+		// set in the views returned by SqlTx.CRUD
+		tx *sql.Tx
+	}
+
+	// Statements prepared on the database, shared by a CRUD and its
+	// transactional views
+	sqlStatements struct {
+		lock             sync.RWMutex
+		insertStatement  *sql.Stmt
+		upsertStatement  *sql.Stmt
+		selectStatements map[string]*sql.Stmt
+		updateStatement  *sql.Stmt
+		deleteStatement  *sql.Stmt
+	}
+)
+
+const (
+	sqlNoLimit = "9223372036854775807"
+
+	// Batch statements are kept below this size unless changed with
+	// SetMaxPacketSize. It is the max_allowed_packet default of older
+	// MySQL servers.
+	DefaultMaxPacketSize = 4 << 20
+
+	// rough size of a value in a statement, besides its data: the
+	// placeholder, separators and the type sent by the driver
+	sqlValueOverhead = 8
+)
+
+var (
+	questionMarks = sqlDialect{
+		placeholder: func(n int) string {
+			return "?"
+		},
+		maxParams:      65535,
+		onDuplicateKey: true,
+	}
+
+	dollarNumbers = sqlDialect{
+		placeholder: func(n int) string {
+			return "$" + strconv.Itoa(n)
+		},
+		returning:     true,
+		nullsLast:     true,
+		maxParams:     65535,
+		defaultValues: true,
+	}
+
+	sqliteQuestionMarks = sqlDialect{
+		placeholder:  questionMarks.placeholder,
+		maxParams:     32766,
+		lastIdIsLast:  true,
+		defaultValues: true,
+	}
+)
+
+// Opens a CRUD for the given database/sql driver name ("mysql",
+// "postgres" or "sqlite3"), so that backends can be chosen by
+// configuration. Expressions given to SelectWhereExpression use the
+// placeholders of the backend.
+func NewSqlCRUD(driver string, dsn string, table string, fields []string, scan SqlScanFunc) (catrina.ContextCRUD, error) {
+
+	switch driver {
+	case "mysql":
+		return NewMySqlCRUD(dsn, table, fields, scan)
+	case "postgres":
+		return NewPostgresCRUD(dsn, table, fields, scan)
+	case "sqlite3":
+		return NewSqliteCRUD(dsn, table, fields, scan)
+	}
+
+	return nil, fmt.Errorf("Unknown SQL driver %s", driver)
+}
+
+// Same as NewSqlCRUD, for an already open database, e.g. one shared by
+// several CRUDs.
+func NewSqlCRUDWithScan(driver string, db *sql.DB, table string, fields []string, scan SqlScanFunc) (catrina.ContextCRUD, error) {
+
+	var crud interface {
+		catrina.ContextCRUD
+		init(db *sql.DB, table string, fields []string, scan SqlScanFunc, dialect sqlDialect) error
+	}
+	var dialect sqlDialect
+
+	switch driver {
+	case "mysql":
+		crud, dialect = &MySqlCRUD{}, questionMarks
+	case "postgres":
+		crud, dialect = &PostgresCRUD{}, dollarNumbers
+	case "sqlite3":
+		crud, dialect = &SqliteCRUD{}, sqliteQuestionMarks
+	default:
+		return nil, fmt.Errorf("Unknown SQL driver %s", driver)
+	}
+
+	err := crud.init(db, table, fields, scan, dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	return crud, nil
+}
+
+func (r *sqlCRUD) init(db *sql.DB, table string, fields []string, scan SqlScanFunc, dialect sqlDialect) error {
+
+	if len(fields) == 0 {
+		return errors.New("At least one field must be defined")
+	}
+
+	r.db = db
+	r.table = table
+	r.id = fields[0]
+	r.fields = fields
+	r.index = make(map[string]int, len(fields))
+	r.scan = scan
+	r.dialect = dialect
+	r.maxPacketSize = DefaultMaxPacketSize
+	r.stmt = &sqlStatements{selectStatements: make(map[string]*sql.Stmt, 0)}
+
+	for i, f := range fields {
+		r.index[f] = i
+	}
+
+	return nil
+}
+
+// Helper methods
+
+func (r *sqlCRUD) sqlBackend() *sqlCRUD {
+	return r
+}
+
+// Returns the placeholders for count values, starting at from
+func (r *sqlCRUD) placeholders(from, count int) []string {
+	p := make([]string, count)
+	for i := range p {
+		p[i] = r.dialect.placeholder(from + i)
+	}
+	return p
+}
+
+func (r *sqlCRUD) insertSQL() string {
+	return r.insertRowsSQL(1)
+}
+
+func (r *sqlCRUD) insertRowsSQL(count int) string {
+
+	// first field is ID field
+	fields := len(r.fields) - 1
+	values := make([]string, count)
+	for i := range values {
+		values[i] = "(" + strings.Join(r.placeholders(1+i*fields, fields), ",") + ")"
+	}
+
+	query := fmt.Sprintf(
+		"INSERT INTO %s (%s) VALUES %s",
+		r.table,
+		strings.Join(r.fields[1:], ","),
+		strings.Join(values, ","),
+	)
+
+	if fields == 0 && r.dialect.defaultValues {
+		query = "INSERT INTO " + r.table + " DEFAULT VALUES"
+	}
+
+	if r.dialect.returning {
+		query += " RETURNING " + r.id
+	}
+
+	return query
+}
+
+func (r *sqlCRUD) upsertSQL() string {
+
+	query := fmt.Sprintf(
+		"INSERT INTO %s (%s) VALUES (%s)",
+		r.table,
+		strings.Join(r.fields, ","),
+		strings.Join(r.placeholders(1, len(r.fields)), ","),
+	)
+
+	set := make([]string, 0, len(r.fields))
+
+	if r.dialect.onDuplicateKey {
+		// makes LastInsertId return the id of an updated row
+		set = append(set, r.id+" = LAST_INSERT_ID("+r.id+")")
+		for _, f := range r.fields[1:] {
+			set = append(set, f+" = VALUES("+f+")")
+		}
+		query += " ON DUPLICATE KEY UPDATE " + strings.Join(set, ", ")
+	} else {
+		for _, f := range r.fields[1:] {
+			set = append(set, f+" = excluded."+f)
+		}
+		if len(set) == 0 {
+			set = append(set, r.id+" = excluded."+r.id)
+		}
+		query += " ON CONFLICT (" + r.id + ") DO UPDATE SET " + strings.Join(set, ", ")
+	}
+
+	if r.dialect.returning {
+		query += " RETURNING " + r.id
+	}
+
+	return query
+}
+
+func (r *sqlCRUD) selectSQL(where string) string {
+	return fmt.Sprintf(
+		"SELECT %s FROM %s WHERE %s",
+		strings.Join(r.fields, ","),
+		r.table,
+		where,
+	)
+}
+
+func (r *sqlCRUD) updateSQL() string {
+
+	// first field is ID field
+	fields := make([]string, len(r.fields)-1)
+	for i, f := range r.fields[1:] {
+		fields[i] = f + " = " + r.dialect.placeholder(i+1)
+	}
+
+	return fmt.Sprintf(
+		"UPDATE %s SET %s WHERE %s = %s",
+		r.table,
+		strings.Join(fields, ", "),
+		r.id,
+		r.dialect.placeholder(len(r.fields)),
+	)
+}
+
+func (r *sqlCRUD) deleteSQL() string {
+	return fmt.Sprintf(
+		"DELETE FROM %s WHERE %s = %s",
+		r.table,
+		r.id,
+		r.dialect.placeholder(1),
+	)
+}
+
+func (r *sqlCRUD) whereFieldsSQL(fields []string) string {
+	where := make([]string, len(fields))
+	for i, f := range fields {
+		where[i] = f + " = " + r.dialect.placeholder(i+1)
+	}
+	return strings.Join(where, " AND ")
+}
+
+func (r *sqlCRUD) whereRangeSQL(field string) string {
+	return field + " BETWEEN " + r.dialect.placeholder(1) + " AND " + r.dialect.placeholder(2)
+}
+
+// Translates a checked filter, appending its values to args so that
+// placeholders are numbered from the values already there
+func (r *sqlCRUD) filterSQL(f catrina.Filter, args *[]interface{}) string {
+
+	switch f.Op {
+	case "":
+		return "1 = 1"
+	case catrina.AndFilter, catrina.OrFilter:
+		if len(f.Filters) == 0 {
+			if f.Op == catrina.AndFilter {
+				return "1 = 1"
+			}
+			return "1 = 0"
+		}
+		where := make([]string, len(f.Filters))
+		for i, child := range f.Filters {
+			where[i] = "(" + r.filterSQL(child, args) + ")"
+		}
+		return strings.Join(where, " "+strings.ToUpper(string(f.Op))+" ")
+	case catrina.NotFilter:
+		return "NOT (" + r.filterSQL(f.Filters[0], args) + ")"
+	case catrina.IsNullFilter:
+		return f.Field + " IS NULL"
+	case catrina.InFilter:
+		if len(f.Values) == 0 {
+			return "1 = 0"
+		}
+	}
+
+	p := make([]string, len(f.Values))
+	for i, v := range f.Values {
+		*args = append(*args, v)
+		p[i] = r.dialect.placeholder(len(*args))
+	}
+
+	switch f.Op {
+	case catrina.EqFilter:
+		return f.Field + " = " + p[0]
+	case catrina.NeqFilter:
+		return f.Field + " <> " + p[0]
+	case catrina.LtFilter:
+		return f.Field + " < " + p[0]
+	case catrina.LteFilter:
+		return f.Field + " <= " + p[0]
+	case catrina.GtFilter:
+		return f.Field + " > " + p[0]
+	case catrina.GteFilter:
+		return f.Field + " >= " + p[0]
+	case catrina.LikeFilter:
+		return f.Field + " LIKE " + p[0]
+	case catrina.RangeFilter:
+		return f.Field + " BETWEEN " + p[0] + " AND " + p[1]
+	}
+
+	// IN
+	return f.Field + " IN (" + strings.Join(p, ",") + ")"
+}
+
+// ORDER BY and LIMIT clauses of a checked query
+func (r *sqlCRUD) pageSQL(q catrina.Query, sort []catrina.SortField) string {
+
+	order := make([]string, len(sort))
+	for i, s := range sort {
+		order[i] = s.Field + " ASC"
+		if s.Descending {
+			order[i] = s.Field + " DESC"
+		}
+		// sort NULLs like MySQL, so pages are the same on every backend
+		if r.dialect.nullsLast {
+			if s.Descending {
+				order[i] += " NULLS LAST"
+			} else {
+				order[i] += " NULLS FIRST"
+			}
+		}
+	}
+
+	query := " ORDER BY " + strings.Join(order, ", ")
+
+	if q.Limit > 0 {
+		query += " LIMIT " + strconv.Itoa(q.Limit)
+	}
+
+	if q.Offset > 0 {
+		if q.Limit == 0 {
+			// MySQL and SQLite need a limit to take an offset
+			query += " LIMIT " + sqlNoLimit
+		}
+		query += " OFFSET " + strconv.Itoa(q.Offset)
+	}
+
+	return query
+}
+
+// Field names are pasted into queries, so only configured ones are
+// accepted
+func (r *sqlCRUD) checkFields(fields ...string) error {
+	for _, f := range fields {
+		if _, exists := r.index[f]; !exists {
+			return unknownFieldErr(f)
+		}
+	}
+	return nil
+}
+
+// Rebinds a cached statement to the transaction, if any. The result
+// is closed with the transaction.
+func (r *sqlCRUD) bind(ctx context.Context, stmt *sql.Stmt) *sql.Stmt {
+	if r.tx != nil {
+		return r.tx.StmtContext(ctx, stmt)
+	}
+	return stmt
+}
+
+func (r *sqlCRUD) query(ctx context.Context, query string, args ...interface{}) (rows *sql.Rows, err error) {
+	if r.tx != nil {
+		rows, err = r.tx.QueryContext(ctx, query, args...)
+	} else {
+		rows, err = r.db.QueryContext(ctx, query, args...)
+	}
+	return rows, unavailable(err)
+}
+
+func (r *sqlCRUD) exec(ctx context.Context, query string, args ...interface{}) (res sql.Result, err error) {
+	if r.tx != nil {
+		res, err = r.tx.ExecContext(ctx, query, args...)
+	} else {
+		res, err = r.db.ExecContext(ctx, query, args...)
+	}
+	return res, unavailable(err)
+}
+
+func (r *sqlCRUD) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	if r.tx != nil {
+		return r.tx.QueryRowContext(ctx, query, args...)
+	}
+	return r.db.QueryRowContext(ctx, query, args...)
+}
+
+// Statements missing from the cache are prepared in the transaction,
+// and not cached: preparing them on the database could wait forever
+// for the connection held by the transaction (e.g. with SQLite and a
+// single connection). They are closed with the transaction.
+func (r *sqlCRUD) prepareInTx(ctx context.Context, query string) (*sql.Stmt, error) {
+	stmt, err := r.tx.PrepareContext(ctx, query)
+	return stmt, unavailable(err)
+}
+
+// Prepares the statement once and caches it in *stmt
+func (r *sqlCRUD) getStatement(ctx context.Context, stmt **sql.Stmt, query func() string) (*sql.Stmt, error) {
+
+	r.stmt.lock.Lock()
+	defer r.stmt.lock.Unlock()
+
+	if *stmt == nil && r.tx != nil {
+		return r.prepareInTx(ctx, query())
+	}
+
+	if *stmt == nil {
+		prepared, err := r.db.PrepareContext(ctx, query())
+		if err != nil {
+			return nil, unavailable(err)
+		}
+		*stmt = prepared
+	}
+
+	return r.bind(ctx, *stmt), nil
+}
+
+func (r *sqlCRUD) getInsertStatement(ctx context.Context) (*sql.Stmt, error) {
+	return r.getStatement(ctx, &r.stmt.insertStatement, r.insertSQL)
+}
+
+func (r *sqlCRUD) getUpsertStatement(ctx context.Context) (*sql.Stmt, error) {
+	return r.getStatement(ctx, &r.stmt.upsertStatement, r.upsertSQL)
+}
+
+func (r *sqlCRUD) getUpdateStatement(ctx context.Context) (*sql.Stmt, error) {
+	return r.getStatement(ctx, &r.stmt.updateStatement, r.updateSQL)
+}
+
+func (r *sqlCRUD) getDeleteStatement(ctx context.Context) (*sql.Stmt, error) {
+	return r.getStatement(ctx, &r.stmt.deleteStatement, r.deleteSQL)
+}
+
+func (r *sqlCRUD) getSelectStatement(ctx context.Context, where string) (*sql.Stmt, error) {
+
+	r.stmt.lock.RLock()
+	stmt, prepared := r.stmt.selectStatements[where]
+	r.stmt.lock.RUnlock()
+
+	if prepared {
+		return r.bind(ctx, stmt), nil
+	}
+
+	if r.tx != nil {
+		return r.prepareInTx(ctx, r.selectSQL(where))
+	}
+
+	r.stmt.lock.Lock()
+	defer r.stmt.lock.Unlock()
+
+	stmt, prepared = r.stmt.selectStatements[where]
+	if !prepared {
+		var err error
+		stmt, err = r.db.PrepareContext(ctx, r.selectSQL(where))
+		if err != nil {
+			return nil, unavailable(err)
+		}
+		r.stmt.selectStatements[where] = stmt
+	}
+
+	return r.bind(ctx, stmt), nil
+}
+
+func (r *sqlCRUD) selectMany(ctx context.Context, where string, values []interface{}) (<-chan catrina.Row, error) {
+
+	stmt, err := r.getSelectStatement(ctx, where)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.QueryContext(ctx, values...)
+	if err != nil {
+		return nil, unavailable(err)
+	}
+
+	return r.streamRows(ctx, rows), nil
+}
+
+// Streams rows through a channel of the configured buffer size. Once
+// ctx is cancelled the rows are closed and the channel with them, even
+// if nobody reads it anymore. The selects without a context cannot be
+// cancelled: their channels must be read to the end.
+func (r *sqlCRUD) streamRows(ctx context.Context, rows *sql.Rows) <-chan catrina.Row {
+
+	result := make(chan catrina.Row, r.bufferSize)
+
+	go func() {
+		defer close(result)
+		defer rows.Close()
+
+		for rows.Next() {
+			row := catrina.Row{}
+			obj, err := r.scan(rows)
+			if err != nil {
+				row.Error = err
+			} else {
+				row.Result = obj
+			}
+			if !sendRow(ctx, result, row) {
+				return
+			}
+		}
+
+		// e.g. a lost connection: the rows read so far are incomplete
+		err := rows.Err()
+		if err != nil {
+			sendRow(ctx, result, catrina.Row{Error: unavailable(err)})
+		}
+	}()
+
+	return result
+}
+
+// Splits the rows of a batch (indexes into the batch) in chunks that
+// fit in one statement: below the placeholder limit of the database
+// and, roughly, the packet size. size estimates the bytes of a row.
+func (r *sqlCRUD) chunks(rows []int, params int, size func(i int) int) [][]int {
+
+	maxRows := len(rows)
+	if params > 0 {
+		maxRows = r.dialect.maxParams / params
+	} else if r.dialect.defaultValues {
+		maxRows = 1
+	}
+
+	chunks := make([][]int, 0)
+	start, bytes := 0, 0
+	for n, i := range rows {
+		s := size(i)
+		if n > start && (n-start >= maxRows || bytes+s > r.maxPacketSize) {
+			chunks = append(chunks, rows[start:n])
+			start, bytes = n, 0
+		}
+		bytes += s
+	}
+
+	if start < len(rows) {
+		chunks = append(chunks, rows[start:])
+	}
+
+	return chunks
+}
+
+func valueSize(v catrina.Value) int {
+	switch v := v.(type) {
+	case string:
+		return len(v) + sqlValueOverhead
+	case []byte:
+		return len(v) + sqlValueOverhead
+	}
+	return 8 + sqlValueOverhead
+}
+
+// Inserts a chunk of rows with one statement, setting their ids
+func (r *sqlCRUD) insertChunk(ctx context.Context, chunk []int, rows [][]catrina.Value, results []catrina.BatchResult) error {
+
+	args := make([]interface{}, 0, len(chunk)*(len(r.fields)-1))
+	for _, i := range chunk {
+		args = append(args, r.castValues(rows[i])...)
+	}
+
+	query := r.insertRowsSQL(len(chunk))
+
+	if r.dialect.returning {
+		ids, err := r.query(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer ids.Close()
+		// ids come back in the order of the VALUES list
+		for _, i := range chunk {
+			if !ids.Next() {
+				break
+			}
+			var id catrina.Value
+			err = ids.Scan(&id)
+			if err != nil {
+				return unavailable(err)
+			}
+			if b, ok := id.([]byte); ok {
+				id = string(b)
+			}
+			results[i].Id = id
+		}
+		return unavailable(ids.Err())
+	}
+
+	res, err := r.exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	lastID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	// the ids of a multi-row insert are consecutive
+	first := lastID
+	if r.dialect.lastIdIsLast {
+		first = lastID - int64(len(chunk)) + 1
+	}
+	for n, i := range chunk {
+		results[i].Id = first + int64(n)
+	}
+
+	return nil
+}
+
+func (r *sqlCRUD) castValues(values []catrina.Value) []interface{} {
+
+	interfaces := make([]interface{}, len(values))
+	for i, v := range values {
+		interfaces[i] = v
+	}
+
+	return interfaces
+}
+
+// Public interface
+
+// The database the CRUD runs on, e.g. to start a transaction with
+// BeginSqlTx
+func (r *sqlCRUD) DB() *sql.DB {
+	return r.db
+}
+
+// Sets how many rows a select reads ahead of its consumer. With the
+// default of 0, rows are read from the database as they are consumed.
+func (r *sqlCRUD) SetBufferSize(size int) {
+	r.bufferSize = size
+}
+
+// Sets the size batch statements are kept below. It should not be
+// larger than the max_allowed_packet setting of a MySQL server.
+func (r *sqlCRUD) SetMaxPacketSize(size int) {
+	r.maxPacketSize = size
+}
+
+func (r *sqlCRUD) Insert(values []catrina.Value) (id catrina.Value, e error) {
+	return r.InsertContext(context.Background(), values)
+}
+
+func (r *sqlCRUD) Select(id catrina.Value) (catrina.Object, error) {
+	return r.SelectContext(context.Background(), id)
+}
+
+func (r *sqlCRUD) SelectWhereFields(fields []string, values []catrina.Value) (<-chan catrina.Row, error) {
+	return r.SelectWhereFieldsContext(context.Background(), fields, values)
+}
+
+func (r *sqlCRUD) SelectWhereRange(field string, min, max catrina.Value) (<-chan catrina.Row, error) {
+	return r.SelectWhereRangeContext(context.Background(), field, min, max)
+}
+
+func (r *sqlCRUD) SelectWhere(filter catrina.Filter) (<-chan catrina.Row, error) {
+	return r.SelectWhereContext(context.Background(), filter)
+}
+
+func (r *sqlCRUD) SelectQuery(query catrina.Query) (<-chan catrina.Row, error) {
+	return r.SelectQueryContext(context.Background(), query)
+}
+
+func (r *sqlCRUD) Count(filter catrina.Filter) (int64, error) {
+	return r.CountContext(context.Background(), filter)
+}
+
+func (r *sqlCRUD) SelectWhereExpression(where string, values []catrina.Value) (<-chan catrina.Row, error) {
+	return r.SelectWhereExpressionContext(context.Background(), where, values)
+}
+
+func (r *sqlCRUD) Update(id catrina.Value, values []catrina.Value) error {
+	return r.UpdateContext(context.Background(), id, values)
+}
+
+func (r *sqlCRUD) Upsert(id catrina.Value, values []catrina.Value) (catrina.Value, error) {
+	return r.UpsertContext(context.Background(), id, values)
+}
+
+func (r *sqlCRUD) UpdateIfVersion(id catrina.Value, field string, version catrina.Value, values []catrina.Value) error {
+	return r.UpdateIfVersionContext(context.Background(), id, field, version, values)
+}
+
+func (r *sqlCRUD) Delete(id catrina.Value) error {
+	return r.DeleteContext(context.Background(), id)
+}
+
+func (r *sqlCRUD) DeleteIfVersion(id catrina.Value, field string, version catrina.Value) error {
+	return r.DeleteIfVersionContext(context.Background(), id, field, version)
+}
+
+func (r *sqlCRUD) InsertMany(rows [][]catrina.Value) ([]catrina.BatchResult, error) {
+	return r.InsertManyContext(context.Background(), rows)
+}
+
+func (r *sqlCRUD) UpdateMany(ids []catrina.Value, rows [][]catrina.Value) ([]catrina.BatchResult, error) {
+	return r.UpdateManyContext(context.Background(), ids, rows)
+}
+
+func (r *sqlCRUD) DeleteMany(ids []catrina.Value) ([]catrina.BatchResult, error) {
+	return r.DeleteManyContext(context.Background(), ids)
+}
+
+// Context-aware public interface
+
+func (r *sqlCRUD) InsertContext(ctx context.Context, values []catrina.Value) (id catrina.Value, e error) {
+
+	if len(r.fields)-1 != len(values) {
+		return nil, valueCountErr
+	}
+
+	stmt, err := r.getInsertStatement(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.dialect.returning {
+		err = stmt.QueryRowContext(ctx, r.castValues(values)...).Scan(&id)
+		if err != nil {
+			return nil, unavailable(err)
+		}
+		// text and uuid ids are returned as bytes
+		if b, ok := id.([]byte); ok {
+			return string(b), nil
+		}
+		return id, nil
+	}
+
+	res, err := stmt.ExecContext(ctx, r.castValues(values)...)
+	if err != nil {
+		return nil, unavailable(err)
+	}
+
+	lastID, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return lastID, nil
+}
+
+func (r *sqlCRUD) SelectContext(ctx context.Context, id catrina.Value) (catrina.Object, error) {
+
+	stmt, err := r.getSelectStatement(ctx, r.id+" = "+r.dialect.placeholder(1))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.QueryContext(ctx, id)
+	if err != nil {
+		return nil, unavailable(err)
+	}
+
+	defer rows.Close()
+
+	if !rows.Next() {
+		err = rows.Err()
+		if err != nil {
+			return nil, unavailable(err)
+		}
+		return nil, catrina.NotFoundErr
+	}
+
+	return r.scan(rows)
+}
+
+func (r *sqlCRUD) SelectWhereFieldsContext(ctx context.Context, fields []string, values []catrina.Value) (<-chan catrina.Row, error) {
+
+	if len(fields) != len(values) {
+		return nil, catrina.NewValidationError("Fields and values do not match")
+	}
+
+	if len(fields) == 0 {
+		return nil, catrina.NewValidationError("At least one field must be given")
+	}
+
+	err := r.checkFields(fields...)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.selectMany(ctx, r.whereFieldsSQL(fields), r.castValues(values))
+}
+
+func (r *sqlCRUD) SelectWhereRangeContext(ctx context.Context, field string, min, max catrina.Value) (<-chan catrina.Row, error) {
+
+	err := r.checkFields(field)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.selectMany(
+		ctx,
+		r.whereRangeSQL(field),
+		r.castValues([]catrina.Value{min, max}),
+	)
+}
+
+// Filter queries are not prepared: their shape comes from the caller
+// (e.g. the length of IN lists), so caching them could grow without
+// bounds.
+func (r *sqlCRUD) SelectWhereContext(ctx context.Context, filter catrina.Filter) (<-chan catrina.Row, error) {
+
+	err := checkFilter(filter, r.index)
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]interface{}, 0)
+	where := r.filterSQL(filter, &args)
+
+	rows, err := r.query(ctx, r.selectSQL(where), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.streamRows(ctx, rows), nil
+}
+
+func (r *sqlCRUD) SelectQueryContext(ctx context.Context, query catrina.Query) (<-chan catrina.Row, error) {
+
+	sort := querySort(query, r.id)
+
+	err := checkQuery(query, sort, r.index)
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]interface{}, 0)
+	where := r.filterSQL(queryFilter(query, sort), &args)
+
+	rows, err := r.query(ctx, r.selectSQL(where)+r.pageSQL(query, sort), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.streamRows(ctx, rows), nil
+}
+
+func (r *sqlCRUD) CountContext(ctx context.Context, filter catrina.Filter) (count int64, e error) {
+
+	err := checkFilter(filter, r.index)
+	if err != nil {
+		return 0, err
+	}
+
+	args := make([]interface{}, 0)
+	where := r.filterSQL(filter, &args)
+
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", r.table, where)
+
+	err = r.queryRow(ctx, query, args...).Scan(&count)
+	if err != nil {
+		return 0, unavailable(err)
+	}
+
+	return count, nil
+}
+
+func (r *sqlCRUD) SelectWhereExpressionContext(ctx context.Context, where string, values []catrina.Value) (<-chan catrina.Row, error) {
+
+	return r.selectMany(ctx, where, r.castValues(values))
+}
+
+func (r *sqlCRUD) UpdateContext(ctx context.Context, id catrina.Value, values []catrina.Value) error {
+
+	if len(r.fields)-1 != len(values) {
+		return valueCountErr
+	}
+
+	stmt, err := r.getUpdateStatement(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.ExecContext(ctx, r.castValues(append(values, id))...)
+	if err != nil {
+		// TODO: don't treat warning as errors (e.g. trimmed data)
+		return unavailable(err)
+	}
+
+	return nil
+}
+
+// MySQL runs INSERT ... ON DUPLICATE KEY UPDATE, so other unique keys
+// than the id also turn the insert into an update (of the row whose id
+// is returned). PostgreSQL and SQLite only update on a matching id,
+// and PostgreSQL sequences are not moved past the ids given.
+func (r *sqlCRUD) UpsertContext(ctx context.Context, id catrina.Value, values []catrina.Value) (catrina.Value, error) {
+
+	if id == nil && r.dialect.returning {
+		// a NULL id would not be generated
+		return r.InsertContext(ctx, values)
+	}
+
+	if len(r.fields)-1 != len(values) {
+		return nil, valueCountErr
+	}
+
+	stmt, err := r.getUpsertStatement(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	args := r.castValues(append([]catrina.Value{id}, values...))
+
+	if r.dialect.returning {
+		var upserted catrina.Value
+		err = stmt.QueryRowContext(ctx, args...).Scan(&upserted)
+		if err != nil {
+			return nil, unavailable(err)
+		}
+		if b, ok := upserted.([]byte); ok {
+			return string(b), nil
+		}
+		return upserted, nil
+	}
+
+	res, err := stmt.ExecContext(ctx, args...)
+	if err != nil {
+		return nil, unavailable(err)
+	}
+
+	// SQLite only sets LastInsertId for inserted rows
+	if id != nil && !r.dialect.onDuplicateKey {
+		return id, nil
+	}
+
+	lastID, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return lastID, nil
+}
+
+func (r *sqlCRUD) UpdateIfVersionContext(ctx context.Context, id catrina.Value, field string, version catrina.Value, values []catrina.Value) error {
+
+	if len(r.fields)-1 != len(values) {
+		return valueCountErr
+	}
+
+	err := r.checkFields(field)
+	if err != nil {
+		return err
+	}
+
+	query := r.updateSQL() + " AND " + field + " = " + r.dialect.placeholder(len(r.fields)+1)
+	args := r.castValues(append(append(append([]catrina.Value{}, values...), id), version))
+
+	res, err := r.exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated > 0 {
+		return nil
+	}
+
+	// MySQL does not count rows that were left as they were, so the
+	// row may still hold the version
+	var found, matched sql.NullInt64
+	err = r.queryRow(
+		ctx,
+		fmt.Sprintf(
+			"SELECT COUNT(*), SUM(CASE WHEN %s = %s THEN 1 ELSE 0 END) FROM %s WHERE %s = %s",
+			field, r.dialect.placeholder(1), r.table, r.id, r.dialect.placeholder(2),
+		),
+		version, id,
+	).Scan(&found, &matched)
+	if err != nil {
+		return unavailable(err)
+	}
+
+	switch {
+	case found.Int64 == 0:
+		return catrina.NotFoundErr
+	case matched.Int64 == 0:
+		return catrina.ConflictErr
+	}
+
+	return nil
+}
+
+func (r *sqlCRUD) DeleteContext(ctx context.Context, id catrina.Value) error {
+
+	stmt, err := r.getDeleteStatement(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.ExecContext(ctx, id)
+	if err != nil {
+		// TODO: don't treat warning as errors (e.g. trimmed data)
+		return unavailable(err)
+	}
+
+	return nil
+}
+
+func (r *sqlCRUD) DeleteIfVersionContext(ctx context.Context, id catrina.Value, field string, version catrina.Value) error {
+
+	err := r.checkFields(field)
+	if err != nil {
+		return err
+	}
+
+	query := r.deleteSQL() + " AND " + field + " = " + r.dialect.placeholder(2)
+
+	res, err := r.exec(ctx, query, r.castValues([]catrina.Value{id, version})...)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		return nil
+	}
+
+	// the row is either missing or holds another version
+	var found int64
+	err = r.queryRow(
+		ctx,
+		fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s = %s", r.table, r.id, r.dialect.placeholder(1)),
+		id,
+	).Scan(&found)
+	if err != nil {
+		return unavailable(err)
+	}
+
+	if found == 0 {
+		return catrina.NotFoundErr
+	}
+
+	return catrina.ConflictErr
+}
+
+// Rows are inserted with multi-row INSERT statements, split to fit the
+// packet size. Generated ids are read with RETURNING on PostgreSQL, and
+// are otherwise computed from LastInsertId, which assumes that they are
+// consecutive (in MySQL, innodb_autoinc_lock_mode must not be 2 when
+// other clients insert concurrently). If a statement fails, its rows
+// are inserted one by one to find the failing ones.
+func (r *sqlCRUD) InsertManyContext(ctx context.Context, rows [][]catrina.Value) ([]catrina.BatchResult, error) {
+
+	results := make([]catrina.BatchResult, len(rows))
+
+	valid := make([]int, 0, len(rows))
+	for i, values := range rows {
+		if len(r.fields)-1 != len(values) {
+			results[i].Error = valueCountErr
+		} else {
+			valid = append(valid, i)
+		}
+	}
+
+	size := func(i int) int {
+		s := 0
+		for _, v := range rows[i] {
+			s += valueSize(v)
+		}
+		return s
+	}
+
+	done := 0
+	for _, chunk := range r.chunks(valid, len(r.fields)-1, size) {
+		err := r.insertChunk(ctx, chunk, rows, results)
+		if err != nil && ctx.Err() != nil {
+			for _, i := range valid[done:] {
+				results[i] = catrina.BatchResult{Error: ctx.Err()}
+			}
+			return results, ctx.Err()
+		}
+		if err != nil {
+			for _, i := range chunk {
+				results[i].Id, results[i].Error = r.InsertContext(ctx, rows[i])
+			}
+		}
+		done += len(chunk)
+	}
+
+	return results, nil
+}
+
+func (r *sqlCRUD) UpdateManyContext(ctx context.Context, ids []catrina.Value, rows [][]catrina.Value) ([]catrina.BatchResult, error) {
+
+	if len(ids) != len(rows) {
+		return nil, idsAndRowsErr
+	}
+
+	return eachRow(ctx, len(ids), func(i int) (catrina.Value, error) {
+		return ids[i], r.UpdateContext(ctx, ids[i], rows[i])
+	})
+}
+
+// Rows are deleted with DELETE ... WHERE id IN (...) statements, or
+// one by one if a statement fails.
+func (r *sqlCRUD) DeleteManyContext(ctx context.Context, ids []catrina.Value) ([]catrina.BatchResult, error) {
+
+	results := make([]catrina.BatchResult, len(ids))
+
+	all := make([]int, len(ids))
+	for i, id := range ids {
+		all[i] = i
+		results[i].Id = id
+	}
+
+	size := func(i int) int {
+		return valueSize(ids[i])
+	}
+
+	for _, chunk := range r.chunks(all, 1, size) {
+
+		in := make([]catrina.Value, len(chunk))
+		for n, i := range chunk {
+			in[n] = ids[i]
+		}
+
+		args := make([]interface{}, 0, len(in))
+		where := r.filterSQL(catrina.In(r.id, in...), &args)
+
+		_, err := r.exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE %s", r.table, where), args...)
+		if err != nil && ctx.Err() != nil {
+			failRows(results[chunk[0]:], ctx.Err())
+			return results, ctx.Err()
+		}
+		if err != nil {
+			for _, i := range chunk {
+				results[i].Error = r.DeleteContext(ctx, ids[i])
+			}
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,66 @@
+package crud
+
+import (
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	SqliteMemoryDSN = ":memory:"
+)
+
+type (
+	// Same as MySqlCRUD, for SQLite. Handy for local development and
+	// tests, as it needs no server.
+	SqliteCRUD struct {
+		sqlCRUD
+	}
+
+	// Deprecated: use SqlScanFunc
+	SqliteHydrateFunc = SqlScanFunc
+)
+
+// dsn is a file name or SqliteMemoryDSN, a private in-memory database.
+// The latter runs on a single connection, so the rows of a select must
+// be read to the end before the next call. To share an in-memory database between CRUDs, open them with
+// NewSqliteCRUDWithDB on the same *sql.DB, or with a named shared DSN
+// such as "file:app?mode=memory&cache=shared".
+func NewSqliteCRUD(dsn string, table string, fields []string, scan SqlScanFunc) (*SqliteCRUD, error) {
+
+	db, err := openSqliteDB(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSqliteCRUDWithDB(db, table, fields, scan)
+}
+
+// Same as NewSqliteCRUD, for an already open database
+func NewSqliteCRUDWithDB(db *sql.DB, table string, fields []string, scan SqlScanFunc) (*SqliteCRUD, error) {
+
+	sqlite := &SqliteCRUD{}
+
+	err := sqlite.init(db, table, fields, scan, sqliteQuestionMarks)
+	if err != nil {
+		return nil, err
+	}
+
+	return sqlite, nil
+}
+
+// Every connection to ":memory:" gets its own empty database, so the
+// pool is limited to one connection, which keeps it for the lifetime
+// of the *sql.DB
+func openSqliteDB(dsn string) (*sql.DB, error) {
+
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if dsn == SqliteMemoryDSN {
+		db.SetMaxOpenConns(1)
+	}
+
+	return db, nil
+}
@@ -0,0 +1,453 @@
+package crud
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"database/sql"
+
+	"github.com/buduchail/catrina"
+)
+
+type sqliteItem struct {
+	Id    int64
+	Name  string
+	Price sql.NullInt64
+}
+
+func scanSqliteItem(rows *sql.Rows) (interface{}, error) {
+	i := sqliteItem{}
+	err := rows.Scan(&i.Id, &i.Name, &i.Price)
+	return i, err
+}
+
+// Opens an in-memory database of its own for the test, with an items
+// table, and a CRUD for it
+func openSqlite(t *testing.T) *SqliteCRUD {
+
+	db, err := sql.Open("sqlite3", "file:"+t.Name()+"?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL, price INTEGER)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crud, err := NewSqlCRUDWithScan("sqlite3", db, "items", []string{"id", "name", "price"}, scanSqliteItem)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return crud.(*SqliteCRUD)
+}
+
+func insertItems(t *testing.T, crud catrina.ContextCRUD, rows ...[]catrina.Value) {
+
+	results, err := crud.InsertManyContext(context.Background(), rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if r.Error != nil {
+			t.Fatal(r.Error)
+		}
+	}
+}
+
+func itemIds(t *testing.T, rows <-chan catrina.Row, err error) []int64 {
+
+	ids := []int64{}
+	for _, object := range collectRows(t, rows, err) {
+		ids = append(ids, object.(sqliteItem).Id)
+	}
+
+	return ids
+}
+
+func price(p int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: p, Valid: true}
+}
+
+// Each CRUD opened with SqliteMemoryDSN has a database of its own,
+// which CRUDs opened on the same *sql.DB share
+func TestSqliteMemoryDSN(t *testing.T) {
+
+	open := func() *SqliteCRUD {
+		items, err := NewSqliteCRUD(SqliteMemoryDSN, "items", []string{"id", "name"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { items.DB().Close() })
+
+		_, err = items.DB().Exec("CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return items
+	}
+
+	a := open()
+	b := open()
+
+	_, err := a.InsertMany([][]catrina.Value{{"a"}, {"b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the database outlives the statements and transactions run on it
+	count, err := a.Count(catrina.Filter{})
+	if err != nil || count != 2 {
+		t.Errorf("expected 2 rows, got %d (%v)", count, err)
+	}
+
+	count, err = b.Count(catrina.Filter{})
+	if err != nil || count != 0 {
+		t.Errorf("expected a private database, got %d rows (%v)", count, err)
+	}
+
+	shared, err := NewSqliteCRUDWithDB(a.DB(), "items", []string{"id", "name"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err = shared.Count(catrina.Filter{})
+	if err != nil || count != 2 {
+		t.Errorf("expected the shared database, got %d rows (%v)", count, err)
+	}
+}
+
+func TestSqlite(t *testing.T) {
+
+	items := openSqlite(t)
+	ctx := context.Background()
+
+	id, err := items.InsertContext(ctx, []catrina.Value{"pen", 3})
+	if err != nil || id != int64(1) {
+		t.Fatalf("expected id 1, got %v (%v)", id, err)
+	}
+
+	_, err = items.InsertContext(ctx, []catrina.Value{"pen"})
+	if err != valueCountErr {
+		t.Errorf("expected %v, got %v", valueCountErr, err)
+	}
+
+	object, err := items.SelectContext(ctx, id)
+	if err != nil || object != (sqliteItem{1, "pen", price(3)}) {
+		t.Errorf("unexpected %v (%v)", object, err)
+	}
+
+	_, err = items.SelectContext(ctx, int64(9))
+	if err != catrina.NotFoundErr {
+		t.Errorf("expected %v, got %v", catrina.NotFoundErr, err)
+	}
+
+	err = items.UpdateContext(ctx, id, []catrina.Value{"ink", nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	object, _ = items.SelectContext(ctx, id)
+	if object != (sqliteItem{1, "ink", sql.NullInt64{}}) {
+		t.Errorf("update: unexpected %v", object)
+	}
+
+	// upserts insert without id or with a new one, and update otherwise
+	upserts := []struct {
+		id       catrina.Value
+		values   []catrina.Value
+		expected catrina.Value
+	}{
+		{nil, []catrina.Value{"cup", 5}, int64(2)},
+		{int64(7), []catrina.Value{"mug", 6}, int64(7)},
+		{int64(2), []catrina.Value{"cup", 4}, int64(2)},
+	}
+	for _, u := range upserts {
+		upserted, err := items.UpsertContext(ctx, u.id, u.values)
+		if err != nil || upserted != u.expected {
+			t.Errorf("upsert %v: expected id %v, got %v (%v)", u.id, u.expected, upserted, err)
+		}
+	}
+	object, _ = items.SelectContext(ctx, int64(2))
+	if object != (sqliteItem{2, "cup", price(4)}) {
+		t.Errorf("upsert: unexpected %v", object)
+	}
+
+	err = items.UpdateIfVersionContext(ctx, int64(7), "price", 5, []catrina.Value{"mug", 7})
+	if err != catrina.ConflictErr {
+		t.Errorf("expected %v, got %v", catrina.ConflictErr, err)
+	}
+	err = items.UpdateIfVersionContext(ctx, int64(8), "price", 6, []catrina.Value{"mug", 7})
+	if err != catrina.NotFoundErr {
+		t.Errorf("expected %v, got %v", catrina.NotFoundErr, err)
+	}
+	err = items.UpdateIfVersionContext(ctx, int64(7), "price", 6, []catrina.Value{"mug", 7})
+	if err != nil {
+		t.Error(err)
+	}
+	err = items.UpdateIfVersionContext(ctx, int64(7), "cost", 6, []catrina.Value{"mug", 7})
+	if err == nil {
+		t.Error("expected an unknown field error")
+	}
+
+	err = items.DeleteContext(ctx, int64(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = items.SelectContext(ctx, int64(7))
+	if err != catrina.NotFoundErr {
+		t.Errorf("expected %v, got %v", catrina.NotFoundErr, err)
+	}
+
+	deletes := []struct {
+		id      int64
+		version catrina.Value
+		err     error
+	}{
+		{2, 3, catrina.ConflictErr},
+		{2, nil, catrina.ConflictErr},
+		{8, 4, catrina.NotFoundErr},
+		{2, 4, nil},
+		{2, 4, catrina.NotFoundErr},
+	}
+	for _, d := range deletes {
+		err = items.DeleteIfVersionContext(ctx, d.id, "price", d.version)
+		if err != d.err {
+			t.Errorf("delete %d if %v: expected %v, got %v", d.id, d.version, d.err, err)
+		}
+	}
+	err = items.DeleteIfVersionContext(ctx, int64(1), "cost", 7)
+	if err == nil {
+		t.Error("expected an unknown field error")
+	}
+}
+
+func TestSqliteSelect(t *testing.T) {
+
+	items := openSqlite(t)
+	ctx := context.Background()
+
+	insertItems(t, items,
+		[]catrina.Value{"pen", 3},
+		[]catrina.Value{"ink", nil},
+		[]catrina.Value{"cup", 5},
+		[]catrina.Value{"pad", 8},
+		[]catrina.Value{"pin", 3},
+	)
+
+	rows, err := items.SelectWhereFieldsContext(ctx, []string{"name", "price"}, []catrina.Value{"pen", 3})
+	if ids := itemIds(t, rows, err); !reflect.DeepEqual(ids, []int64{1}) {
+		t.Errorf("fields: unexpected %v", ids)
+	}
+
+	_, err = items.SelectWhereFieldsContext(ctx, []string{"cost"}, []catrina.Value{3})
+	if err == nil {
+		t.Error("fields: expected an unknown field error")
+	}
+
+	rows, err = items.SelectWhereRangeContext(ctx, "price", 3, 5)
+	if ids := itemIds(t, rows, err); !reflect.DeepEqual(ids, []int64{1, 3, 5}) {
+		t.Errorf("range: unexpected %v", ids)
+	}
+
+	rows, err = items.SelectWhereExpressionContext(ctx, "name LIKE ? AND price > ?", []catrina.Value{"p%", 3})
+	if ids := itemIds(t, rows, err); !reflect.DeepEqual(ids, []int64{4}) {
+		t.Errorf("expression: unexpected %v", ids)
+	}
+
+	filters := []struct {
+		name     string
+		filter   catrina.Filter
+		expected []int64
+	}{
+		{"all", catrina.Filter{}, []int64{1, 2, 3, 4, 5}},
+		{"eq", catrina.Eq("price", 3), []int64{1, 5}},
+		{"neq", catrina.Neq("price", 3), []int64{3, 4}},
+		{"in", catrina.In("name", "cup", "pad", "mop"), []int64{3, 4}},
+		{"empty in", catrina.In("name"), []int64{}},
+		{"like", catrina.Like("name", "p_n"), []int64{1, 5}},
+		{"range", catrina.Range("price", 4, 8), []int64{3, 4}},
+		{"lt", catrina.Lt("price", 5), []int64{1, 5}},
+		{"lte", catrina.Lte("price", 5), []int64{1, 3, 5}},
+		{"gt", catrina.Gt("price", 5), []int64{4}},
+		{"gte", catrina.Gte("price", 5), []int64{3, 4}},
+		{"is null", catrina.IsNull("price"), []int64{2}},
+		{"not null", catrina.Not(catrina.IsNull("price")), []int64{1, 3, 4, 5}},
+		{"and", catrina.And(catrina.Like("name", "p%"), catrina.Gt("price", 3)), []int64{4}},
+		{"or", catrina.Or(catrina.Eq("name", "ink"), catrina.Gte("price", 8)), []int64{2, 4}},
+		{"nested", catrina.And(catrina.Or(catrina.Eq("price", 3), catrina.IsNull("price")), catrina.Not(catrina.Eq("name", "pin"))), []int64{1, 2}},
+	}
+
+	for _, f := range filters {
+		t.Run(f.name, func(t *testing.T) {
+
+			rows, err := items.SelectWhereContext(ctx, f.filter)
+			if ids := itemIds(t, rows, err); !reflect.DeepEqual(ids, f.expected) {
+				t.Errorf("expected %v, got %v", f.expected, ids)
+			}
+
+			count, err := items.CountContext(ctx, f.filter)
+			if err != nil || count != int64(len(f.expected)) {
+				t.Errorf("expected a count of %d, got %d (%v)", len(f.expected), count, err)
+			}
+		})
+	}
+
+	_, err = items.SelectWhereContext(ctx, catrina.Eq("cost", 3))
+	if err == nil {
+		t.Error("expected an unknown field error")
+	}
+}
+
+// Batches are split in several statements, whose ids are computed from
+// LastInsertId
+func TestSqliteBatches(t *testing.T) {
+
+	items := openSqlite(t)
+	ctx := context.Background()
+
+	// two rows of 3 characters and a number per statement
+	items.SetMaxPacketSize(2 * (3 + 8 + 2*sqlValueOverhead))
+
+	results, err := items.InsertManyContext(ctx, [][]catrina.Value{
+		{"pen", 1},
+		{"ink", 2},
+		{"bad"},
+		{"cup", 3},
+		{"pad", 4},
+		{"pin", 5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []catrina.BatchResult{
+		{Id: int64(1)},
+		{Id: int64(2)},
+		{Error: valueCountErr},
+		{Id: int64(3)},
+		{Id: int64(4)},
+		{Id: int64(5)},
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Fatalf("expected %v, got %v", expected, results)
+	}
+
+	for _, r := range expected[3:] {
+		object, err := items.SelectContext(ctx, r.Id)
+		if err != nil || object.(sqliteItem).Price != price(r.Id.(int64)) {
+			t.Errorf("unexpected %v for id %v (%v)", object, r.Id, err)
+		}
+	}
+
+	updated, err := items.UpdateManyContext(ctx, []catrina.Value{int64(1), int64(2)}, [][]catrina.Value{{"pen", 9}, {"ink"}})
+	if err != nil || updated[0].Error != nil || updated[1].Error != valueCountErr {
+		t.Errorf("update: unexpected %v (%v)", updated, err)
+	}
+
+	deleted, err := items.DeleteManyContext(ctx, []catrina.Value{int64(1), int64(3), int64(4), int64(9)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range deleted {
+		if r.Error != nil {
+			t.Errorf("delete %v: %v", r.Id, r.Error)
+		}
+	}
+
+	count, err := items.CountContext(ctx, catrina.Filter{})
+	if err != nil || count != 2 {
+		t.Errorf("expected 2 rows left, got %d (%v)", count, err)
+	}
+}
+
+func TestSqlChunks(t *testing.T) {
+
+	rows := []int{0, 1, 2, 3, 4}
+	size := func(i int) int { return 10 }
+
+	tests := []struct {
+		name      string
+		dialect   sqlDialect
+		maxParams int
+		maxPacket int
+		params    int
+		expected  [][]int
+	}{
+		{"one statement", sqliteQuestionMarks, 100, 100, 2, [][]int{{0, 1, 2, 3, 4}}},
+		{"placeholder limit", sqliteQuestionMarks, 5, 100, 2, [][]int{{0, 1}, {2, 3}, {4}}},
+		{"packet size", sqliteQuestionMarks, 100, 25, 2, [][]int{{0, 1}, {2, 3}, {4}}},
+		{"rows larger than a packet", sqliteQuestionMarks, 100, 5, 2, [][]int{{0}, {1}, {2}, {3}, {4}}},
+		{"no values", questionMarks, 100, 100, 0, [][]int{{0, 1, 2, 3, 4}}},
+		{"no values and a packet size", questionMarks, 100, 25, 0, [][]int{{0, 1}, {2, 3}, {4}}},
+		{"default values", sqliteQuestionMarks, 100, 100, 0, [][]int{{0}, {1}, {2}, {3}, {4}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			r := &sqlCRUD{}
+			r.init(nil, "items", []string{"id", "name", "price"}, nil, test.dialect)
+			r.dialect.maxParams = test.maxParams
+			r.maxPacketSize = test.maxPacket
+
+			chunks := r.chunks(rows, test.params, size)
+			if !reflect.DeepEqual(chunks, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, chunks)
+			}
+
+			if chunks := r.chunks([]int{}, test.params, size); len(chunks) != 0 {
+				t.Errorf("expected no chunks, got %v", chunks)
+			}
+		})
+	}
+}
+
+// Tables with only an id take rows without values
+func TestSqliteIdOnly(t *testing.T) {
+
+	db, err := sql.Open("sqlite3", "file:"+t.Name()+"?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec("CREATE TABLE tickets (id INTEGER PRIMARY KEY AUTOINCREMENT)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tickets, err := NewSqlCRUDWithScan("sqlite3", db, "tickets", []string{"id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	id, err := tickets.InsertContext(ctx, []catrina.Value{})
+	if err != nil || id != int64(1) {
+		t.Fatalf("expected id 1, got %v (%v)", id, err)
+	}
+
+	results, err := tickets.InsertManyContext(ctx, [][]catrina.Value{{}, {"a"}, {}, {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []catrina.BatchResult{
+		{Id: int64(2)},
+		{Error: valueCountErr},
+		{Id: int64(3)},
+		{Id: int64(4)},
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("expected %v, got %v", expected, results)
+	}
+
+	count, err := tickets.CountContext(ctx, catrina.Filter{})
+	if err != nil || count != 4 {
+		t.Errorf("expected 4 rows, got %d (%v)", count, err)
+	}
+}
@@ -0,0 +1,23 @@
+package crud
+
+import (
+	"context"
+)
+
+// Sends a row unless ctx is cancelled first, so that producers stop
+// when their consumer is gone. Returns whether the row was sent.
+func sendRow[R any](ctx context.Context, result chan<- R, row R) bool {
+	select {
+	case result <- row:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Reads the rest of a source, for producers that do not stop on the
+// context of their consumer
+func drainRows[R any](rows <-chan R) {
+	for range rows {
+	}
+}
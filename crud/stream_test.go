@@ -0,0 +1,118 @@
+package crud
+
+import (
+	"time"
+	"context"
+	"testing"
+
+	"github.com/buduchail/catrina"
+)
+
+// Waits for cond, which goroutines left behind may take a while to meet
+func eventually(t *testing.T, what string, cond func() bool) {
+	for deadline := time.Now().Add(2 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// A select cancelled mid-stream releases its connection even though
+// its channel is not read anymore
+func TestSqlStreamCancel(t *testing.T) {
+
+	items := openSqlite(t)
+	items.DB().SetMaxOpenConns(1)
+
+	rows := make([][]catrina.Value, 100)
+	for i := range rows {
+		rows[i] = []catrina.Value{"pen", i}
+	}
+	insertItems(t, items, rows...)
+
+	typed := NewTypedCRUD[sqliteItem, int64](items, func(i sqliteItem) []catrina.Value {
+		return []catrina.Value{i.Name, i.Price}
+	})
+
+	// each select reads a single row
+	selects := []struct {
+		name   string
+		selekt func(ctx context.Context) error
+	}{
+		{"untyped", func(ctx context.Context) error {
+			rows, err := items.SelectWhereContext(ctx, catrina.Filter{})
+			if err == nil {
+				<-rows
+			}
+			return err
+		}},
+		{"typed", func(ctx context.Context) error {
+			rows, err := typed.SelectWhere(ctx, catrina.Filter{})
+			if err == nil {
+				<-rows
+			}
+			return err
+		}},
+	}
+
+	for _, s := range selects {
+		t.Run(s.name, func(t *testing.T) {
+
+			ctx, cancel := context.WithCancel(context.Background())
+
+			err := s.selekt(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cancel()
+
+			eventually(t, "the connection to be released", func() bool {
+				return items.DB().Stats().InUse == 0
+			})
+
+			count, err := items.CountContext(context.Background(), catrina.Filter{})
+			if err != nil || count != 100 {
+				t.Errorf("expected 100 rows, got %d (%v)", count, err)
+			}
+		})
+	}
+}
+
+// Typed rows stop on cancel, and drain sources that do not
+func TestTypedRowsCancel(t *testing.T) {
+
+	source := make(chan catrina.Row)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer close(source)
+		for i := int64(1); i <= 10; i++ {
+			source <- catrina.Row{Result: sqliteItem{Id: i}}
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rows := typedRows[sqliteItem](ctx, source)
+
+	first := <-rows
+	if first.Error != nil || first.Result.Id != 1 {
+		t.Fatalf("unexpected %v", first)
+	}
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the source was left blocked")
+	}
+
+	eventually(t, "the rows to be closed", func() bool {
+		select {
+		case _, open := <-rows:
+			return !open
+		default:
+			return false
+		}
+	})
+}
@@ -0,0 +1,99 @@
+package crud
+
+import (
+	"context"
+	"errors"
+	"database/sql"
+	"github.com/buduchail/catrina"
+)
+
+type (
+	// Unit of work over a database/sql transaction. CRUD returns views
+	// of MySqlCRUD, PostgresCRUD or SqliteCRUD repositories that run
+	// every call in the transaction, so that e.g. an order and its line
+	// items are created atomically:
+	//
+	//	err := crud.RunSqlTx(ctx, orders.DB(), nil, func(tx *crud.SqlTx) error {
+	//		o, _ := tx.CRUD(orders)
+	//		i, _ := tx.CRUD(items)
+	//		...
+	//	})
+	//
+	// A transaction runs on a single connection: rows returned by a
+	// select must be read to the end before the next call.
+	SqlTx struct {
+		db *sql.DB
+		tx *sql.Tx
+	}
+
+	// Implemented by the database/sql backends (MySqlCRUD, PostgresCRUD,
+	// SqliteCRUD)
+	SqlBackend interface {
+		DB() *sql.DB
+		sqlBackend() *sqlCRUD
+	}
+)
+
+func BeginSqlTx(ctx context.Context, db *sql.DB, opts *sql.TxOptions) (*SqlTx, error) {
+
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SqlTx{db: db, tx: tx}, nil
+}
+
+// Runs f in a transaction, which is committed if f returns nil and
+// rolled back if it returns an error or panics.
+func RunSqlTx(ctx context.Context, db *sql.DB, opts *sql.TxOptions, f func(tx *SqlTx) error) (err error) {
+
+	tx, err := BeginSqlTx(ctx, db, opts)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	err = f(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Returns a view of the repository bound to the transaction. The
+// repository must use the database the transaction was started on.
+func (t *SqlTx) CRUD(backend SqlBackend) (catrina.ContextCRUD, error) {
+
+	base := backend.sqlBackend()
+	if base.db != t.db {
+		return nil, errors.New("Repository does not share the transaction database")
+	}
+
+	// prepared statements are shared, and rebound with tx.Stmt
+	view := *base
+	view.tx = t.tx
+
+	return &view, nil
+}
+
+// The underlying transaction, to run other statements in it
+func (t *SqlTx) Tx() *sql.Tx {
+	return t.tx
+}
+
+func (t *SqlTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *SqlTx) Rollback() error {
+	return t.tx.Rollback()
+}
@@ -0,0 +1,196 @@
+package crud
+
+import (
+	"errors"
+	"context"
+	"testing"
+	"database/sql"
+
+	"github.com/buduchail/catrina"
+)
+
+type sqliteOrder struct {
+	Id   int64
+	Item int64
+}
+
+var txFailedErr = errors.New("Failed")
+
+func scanSqliteOrder(rows *sql.Rows) (interface{}, error) {
+	o := sqliteOrder{}
+	err := rows.Scan(&o.Id, &o.Item)
+	return o, err
+}
+
+// An items CRUD and an orders CRUD on the same database
+func openSqliteOrders(t *testing.T) (*SqliteCRUD, *SqliteCRUD) {
+
+	items := openSqlite(t)
+
+	_, err := items.DB().Exec("CREATE TABLE orders (id INTEGER PRIMARY KEY AUTOINCREMENT, item INTEGER NOT NULL)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orders, err := NewSqlCRUDWithScan("sqlite3", items.DB(), "orders", []string{"id", "item"}, scanSqliteOrder)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return items, orders.(*SqliteCRUD)
+}
+
+// Writes an item and an order for it through views of the transaction
+func placeOrder(tx *SqlTx, items, orders SqlBackend) error {
+
+	ctx := context.Background()
+
+	i, err := tx.CRUD(items)
+	if err != nil {
+		return err
+	}
+	o, err := tx.CRUD(orders)
+	if err != nil {
+		return err
+	}
+
+	id, err := i.InsertContext(ctx, []catrina.Value{"pen", 3})
+	if err != nil {
+		return err
+	}
+	_, err = o.InsertContext(ctx, []catrina.Value{id})
+	if err != nil {
+		return err
+	}
+
+	// both rows are visible in the transaction
+	for _, view := range []catrina.ContextCRUD{i, o} {
+		count, err := view.CountContext(ctx, catrina.Filter{})
+		if err != nil {
+			return err
+		}
+		if count != 1 {
+			return errors.New("Rows not visible in the transaction")
+		}
+	}
+
+	return nil
+}
+
+func countRows(t *testing.T, crud catrina.CRUD) int64 {
+
+	count, err := crud.Count(catrina.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return count
+}
+
+func TestSqlTxCommit(t *testing.T) {
+
+	items, orders := openSqliteOrders(t)
+
+	err := RunSqlTx(context.Background(), items.DB(), nil, func(tx *SqlTx) error {
+		return placeOrder(tx, items, orders)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if countRows(t, items) != 1 || countRows(t, orders) != 1 {
+		t.Errorf("expected both rows after commit")
+	}
+
+	order, err := orders.Select(int64(1))
+	if err != nil || order != (sqliteOrder{1, 1}) {
+		t.Errorf("unexpected order %v (%v)", order, err)
+	}
+}
+
+func TestSqlTxRollback(t *testing.T) {
+
+	items, orders := openSqliteOrders(t)
+
+	err := RunSqlTx(context.Background(), items.DB(), nil, func(tx *SqlTx) error {
+		err := placeOrder(tx, items, orders)
+		if err != nil {
+			return err
+		}
+		return txFailedErr
+	})
+	if err != txFailedErr {
+		t.Errorf("expected %v, got %v", txFailedErr, err)
+	}
+
+	if countRows(t, items) != 0 || countRows(t, orders) != 0 {
+		t.Errorf("expected no rows after rollback")
+	}
+
+	// explicit transactions are rolled back the same way
+	tx, err := BeginSqlTx(context.Background(), items.DB(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = placeOrder(tx, items, orders)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if countRows(t, items) != 0 || countRows(t, orders) != 0 {
+		t.Errorf("expected no rows after an explicit rollback")
+	}
+}
+
+func TestSqlTxPanic(t *testing.T) {
+
+	items, orders := openSqliteOrders(t)
+
+	func() {
+		defer func() {
+			if p := recover(); p != txFailedErr {
+				t.Errorf("expected the panic to be raised again, got %v", p)
+			}
+		}()
+
+		RunSqlTx(context.Background(), items.DB(), nil, func(tx *SqlTx) error {
+			err := placeOrder(tx, items, orders)
+			if err != nil {
+				t.Fatal(err)
+			}
+			panic(txFailedErr)
+		})
+	}()
+
+	if countRows(t, items) != 0 || countRows(t, orders) != 0 {
+		t.Errorf("expected no rows after a panic")
+	}
+}
+
+func TestSqlTxOtherDatabase(t *testing.T) {
+
+	items, _ := openSqliteOrders(t)
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	other, err := NewSqlCRUDWithScan("sqlite3", db, "items", []string{"id", "name", "price"}, scanSqliteItem)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = RunSqlTx(context.Background(), items.DB(), nil, func(tx *SqlTx) error {
+		_, err := tx.CRUD(other.(*SqliteCRUD))
+		return err
+	})
+	if err == nil || err.Error() != "Repository does not share the transaction database" {
+		t.Errorf("expected a different database error, got %v", err)
+	}
+}
@@ -0,0 +1,523 @@
+package crud
+
+import (
+	"fmt"
+	"context"
+	"reflect"
+	"strconv"
+	"github.com/buduchail/catrina"
+)
+
+type (
+	// Adapter that exposes a ContextCRUD as a TypedCRUD. Objects returned
+	// by the CRUD (e.g. by a SqlScanFunc) must be T or *T values.
+	typedAdapter[T any, ID any] struct {
+		crud   catrina.ContextCRUD
+		values func(object T) []catrina.Value
+	}
+
+	// Adapter that exposes a TypedCRUD as a ContextCRUD, e.g. to use it
+	// with rest.CRUDHandler.
+	untypedAdapter[T any, ID any] struct {
+		crud   catrina.TypedCRUD[T, ID]
+		object func(values []catrina.Value) (T, error)
+	}
+)
+
+// Returns a TypedCRUD for the given CRUD. values returns the values of
+// an object in field order, without the id field, as expected by
+// Insert and Update.
+func NewTypedCRUD[T any, ID any](crud catrina.ContextCRUD, values func(object T) []catrina.Value) catrina.TypedCRUD[T, ID] {
+	return typedAdapter[T, ID]{crud, values}
+}
+
+// Returns a ContextCRUD for the given TypedCRUD. object builds a T
+// from values in field order, without the id field.
+func NewUntypedCRUD[T any, ID any](crud catrina.TypedCRUD[T, ID], object func(values []catrina.Value) (T, error)) catrina.ContextCRUD {
+	return untypedAdapter[T, ID]{crud, object}
+}
+
+// Helper functions
+
+func typedResults[ID any](results []catrina.BatchResult) []catrina.TypedBatchResult[ID] {
+
+	typed := make([]catrina.TypedBatchResult[ID], len(results))
+	for i, r := range results {
+		typed[i].Error = r.Error
+		if r.Error == nil {
+			typed[i].Id, typed[i].Error = toId[ID](r.Id)
+		}
+	}
+
+	return typed
+}
+
+// Fills the results of the rows given to a typed batch (index maps them
+// to the input rows) and leaves the others, which failed to convert.
+func mergeResults[ID any](results []catrina.BatchResult, index []int, typed []catrina.TypedBatchResult[ID], err error) {
+	for j, i := range index {
+		if j < len(typed) {
+			results[i] = catrina.BatchResult{Id: typed[j].Id, Error: typed[j].Error}
+		} else {
+			results[i].Error = err
+		}
+	}
+}
+
+func toObject[T any](object catrina.Object) (T, error) {
+
+	switch o := object.(type) {
+	case T:
+		return o, nil
+	case *T:
+		if o != nil {
+			return *o, nil
+		}
+	}
+
+	var zero T
+	return zero, fmt.Errorf("Unexpected object type %T", object)
+}
+
+// Converts ids between CRUD values and ID, e.g. the int64 returned by
+// MySqlCRUD.Insert or the string ids taken from request paths.
+func toId[ID any](value catrina.Value) (id ID, err error) {
+
+	if v, ok := value.(ID); ok {
+		return v, nil
+	}
+
+	source := reflect.ValueOf(value)
+	target := reflect.ValueOf(&id).Elem()
+
+	if !source.IsValid() {
+		return id, invalid("Invalid id %v", value)
+	}
+
+	switch target.Kind() {
+	case reflect.String:
+		target.SetString(fmt.Sprint(value))
+		return id, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if source.Kind() == reflect.String {
+			i, err := strconv.ParseInt(source.String(), 10, 64)
+			if err != nil {
+				return id, err
+			}
+			target.SetInt(i)
+			return id, nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if source.Kind() == reflect.String {
+			u, err := strconv.ParseUint(source.String(), 10, 64)
+			if err != nil {
+				return id, err
+			}
+			target.SetUint(u)
+			return id, nil
+		}
+	}
+
+	if source.Type().ConvertibleTo(target.Type()) {
+		target.Set(source.Convert(target.Type()))
+		return id, nil
+	}
+
+	return id, invalid("Cannot convert id %v to %s", value, target.Type())
+}
+
+// Converts rows until they end or ctx is cancelled. The source is
+// then drained, in case it ignores ctx.
+func typedRows[T any](ctx context.Context, rows <-chan catrina.Row) <-chan catrina.TypedRow[T] {
+
+	result := make(chan catrina.TypedRow[T])
+
+	go func() {
+		defer drainRows(rows)
+		defer close(result)
+		for row := range rows {
+			typed := catrina.TypedRow[T]{Error: row.Error}
+			if row.Error == nil {
+				typed.Result, typed.Error = toObject[T](row.Result)
+			}
+			if !sendRow(ctx, result, typed) {
+				return
+			}
+		}
+	}()
+
+	return result
+}
+
+// Same as typedRows, the other way around
+func untypedRows[T any](ctx context.Context, rows <-chan catrina.TypedRow[T]) <-chan catrina.Row {
+
+	result := make(chan catrina.Row)
+
+	go func() {
+		defer drainRows(rows)
+		defer close(result)
+		for row := range rows {
+			untyped := catrina.Row{Result: nil, Error: row.Error}
+			if row.Error == nil {
+				untyped.Result = row.Result
+			}
+			if !sendRow(ctx, result, untyped) {
+				return
+			}
+		}
+	}()
+
+	return result
+}
+
+// TypedCRUD interface
+
+func (a typedAdapter[T, ID]) Insert(ctx context.Context, object T) (id ID, e error) {
+
+	value, err := a.crud.InsertContext(ctx, a.values(object))
+	if err != nil {
+		return id, err
+	}
+
+	return toId[ID](value)
+}
+
+func (a typedAdapter[T, ID]) Select(ctx context.Context, id ID) (T, error) {
+
+	object, err := a.crud.SelectContext(ctx, id)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return toObject[T](object)
+}
+
+func (a typedAdapter[T, ID]) SelectWhereFields(ctx context.Context, fields []string, values []catrina.Value) (<-chan catrina.TypedRow[T], error) {
+	rows, err := a.crud.SelectWhereFieldsContext(ctx, fields, values)
+	if err != nil {
+		return nil, err
+	}
+	return typedRows[T](ctx, rows), nil
+}
+
+func (a typedAdapter[T, ID]) SelectWhereRange(ctx context.Context, field string, min, max catrina.Value) (<-chan catrina.TypedRow[T], error) {
+	rows, err := a.crud.SelectWhereRangeContext(ctx, field, min, max)
+	if err != nil {
+		return nil, err
+	}
+	return typedRows[T](ctx, rows), nil
+}
+
+func (a typedAdapter[T, ID]) SelectWhere(ctx context.Context, filter catrina.Filter) (<-chan catrina.TypedRow[T], error) {
+	rows, err := a.crud.SelectWhereContext(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return typedRows[T](ctx, rows), nil
+}
+
+func (a typedAdapter[T, ID]) SelectQuery(ctx context.Context, query catrina.Query) (<-chan catrina.TypedRow[T], error) {
+	rows, err := a.crud.SelectQueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return typedRows[T](ctx, rows), nil
+}
+
+func (a typedAdapter[T, ID]) Count(ctx context.Context, filter catrina.Filter) (int64, error) {
+	return a.crud.CountContext(ctx, filter)
+}
+
+func (a typedAdapter[T, ID]) SelectWhereExpression(ctx context.Context, expr string, values []catrina.Value) (<-chan catrina.TypedRow[T], error) {
+	rows, err := a.crud.SelectWhereExpressionContext(ctx, expr, values)
+	if err != nil {
+		return nil, err
+	}
+	return typedRows[T](ctx, rows), nil
+}
+
+func (a typedAdapter[T, ID]) Update(ctx context.Context, id ID, object T) error {
+	return a.crud.UpdateContext(ctx, id, a.values(object))
+}
+
+func (a typedAdapter[T, ID]) Upsert(ctx context.Context, id ID, object T) (ID, error) {
+
+	var value catrina.Value = id
+	if reflect.ValueOf(&id).Elem().IsZero() {
+		value = nil
+	}
+
+	value, err := a.crud.UpsertContext(ctx, value, a.values(object))
+	if err != nil {
+		var zero ID
+		return zero, err
+	}
+
+	return toId[ID](value)
+}
+
+func (a typedAdapter[T, ID]) UpdateIfVersion(ctx context.Context, id ID, field string, version catrina.Value, object T) error {
+	return a.crud.UpdateIfVersionContext(ctx, id, field, version, a.values(object))
+}
+
+func (a typedAdapter[T, ID]) Delete(ctx context.Context, id ID) error {
+	return a.crud.DeleteContext(ctx, id)
+}
+
+func (a typedAdapter[T, ID]) DeleteIfVersion(ctx context.Context, id ID, field string, version catrina.Value) error {
+	return a.crud.DeleteIfVersionContext(ctx, id, field, version)
+}
+
+func (a typedAdapter[T, ID]) InsertMany(ctx context.Context, objects []T) ([]catrina.TypedBatchResult[ID], error) {
+
+	rows := make([][]catrina.Value, len(objects))
+	for i, o := range objects {
+		rows[i] = a.values(o)
+	}
+
+	results, err := a.crud.InsertManyContext(ctx, rows)
+	return typedResults[ID](results), err
+}
+
+func (a typedAdapter[T, ID]) UpdateMany(ctx context.Context, ids []ID, objects []T) ([]catrina.TypedBatchResult[ID], error) {
+
+	values := make([]catrina.Value, len(ids))
+	for i, id := range ids {
+		values[i] = id
+	}
+
+	rows := make([][]catrina.Value, len(objects))
+	for i, o := range objects {
+		rows[i] = a.values(o)
+	}
+
+	results, err := a.crud.UpdateManyContext(ctx, values, rows)
+	return typedResults[ID](results), err
+}
+
+func (a typedAdapter[T, ID]) DeleteMany(ctx context.Context, ids []ID) ([]catrina.TypedBatchResult[ID], error) {
+
+	values := make([]catrina.Value, len(ids))
+	for i, id := range ids {
+		values[i] = id
+	}
+
+	results, err := a.crud.DeleteManyContext(ctx, values)
+	return typedResults[ID](results), err
+}
+
+// ContextCRUD interface
+
+func (a untypedAdapter[T, ID]) InsertContext(ctx context.Context, values []catrina.Value) (id catrina.Value, e error) {
+
+	object, err := a.object(values)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.crud.Insert(ctx, object)
+}
+
+func (a untypedAdapter[T, ID]) SelectContext(ctx context.Context, id catrina.Value) (catrina.Object, error) {
+
+	typedId, err := toId[ID](id)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.crud.Select(ctx, typedId)
+}
+
+func (a untypedAdapter[T, ID]) SelectWhereFieldsContext(ctx context.Context, fields []string, values []catrina.Value) (<-chan catrina.Row, error) {
+	rows, err := a.crud.SelectWhereFields(ctx, fields, values)
+	if err != nil {
+		return nil, err
+	}
+	return untypedRows(ctx, rows), nil
+}
+
+func (a untypedAdapter[T, ID]) SelectWhereRangeContext(ctx context.Context, field string, min, max catrina.Value) (<-chan catrina.Row, error) {
+	rows, err := a.crud.SelectWhereRange(ctx, field, min, max)
+	if err != nil {
+		return nil, err
+	}
+	return untypedRows(ctx, rows), nil
+}
+
+func (a untypedAdapter[T, ID]) SelectWhereContext(ctx context.Context, filter catrina.Filter) (<-chan catrina.Row, error) {
+	rows, err := a.crud.SelectWhere(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return untypedRows(ctx, rows), nil
+}
+
+func (a untypedAdapter[T, ID]) SelectQueryContext(ctx context.Context, query catrina.Query) (<-chan catrina.Row, error) {
+	rows, err := a.crud.SelectQuery(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return untypedRows(ctx, rows), nil
+}
+
+func (a untypedAdapter[T, ID]) CountContext(ctx context.Context, filter catrina.Filter) (int64, error) {
+	return a.crud.Count(ctx, filter)
+}
+
+func (a untypedAdapter[T, ID]) SelectWhereExpressionContext(ctx context.Context, expr string, values []catrina.Value) (<-chan catrina.Row, error) {
+	rows, err := a.crud.SelectWhereExpression(ctx, expr, values)
+	if err != nil {
+		return nil, err
+	}
+	return untypedRows(ctx, rows), nil
+}
+
+func (a untypedAdapter[T, ID]) UpdateContext(ctx context.Context, id catrina.Value, values []catrina.Value) error {
+
+	typedId, err := toId[ID](id)
+	if err != nil {
+		return err
+	}
+
+	object, err := a.object(values)
+	if err != nil {
+		return err
+	}
+
+	return a.crud.Update(ctx, typedId, object)
+}
+
+func (a untypedAdapter[T, ID]) UpsertContext(ctx context.Context, id catrina.Value, values []catrina.Value) (catrina.Value, error) {
+
+	var typedId ID
+	if id != nil {
+		var err error
+		typedId, err = toId[ID](id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	object, err := a.object(values)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.crud.Upsert(ctx, typedId, object)
+}
+
+func (a untypedAdapter[T, ID]) UpdateIfVersionContext(ctx context.Context, id catrina.Value, field string, version catrina.Value, values []catrina.Value) error {
+
+	typedId, err := toId[ID](id)
+	if err != nil {
+		return err
+	}
+
+	object, err := a.object(values)
+	if err != nil {
+		return err
+	}
+
+	return a.crud.UpdateIfVersion(ctx, typedId, field, version, object)
+}
+
+func (a untypedAdapter[T, ID]) DeleteContext(ctx context.Context, id catrina.Value) error {
+
+	typedId, err := toId[ID](id)
+	if err != nil {
+		return err
+	}
+
+	return a.crud.Delete(ctx, typedId)
+}
+
+func (a untypedAdapter[T, ID]) DeleteIfVersionContext(ctx context.Context, id catrina.Value, field string, version catrina.Value) error {
+
+	typedId, err := toId[ID](id)
+	if err != nil {
+		return err
+	}
+
+	return a.crud.DeleteIfVersion(ctx, typedId, field, version)
+}
+
+func (a untypedAdapter[T, ID]) InsertManyContext(ctx context.Context, rows [][]catrina.Value) ([]catrina.BatchResult, error) {
+
+	results := make([]catrina.BatchResult, len(rows))
+	objects := make([]T, 0, len(rows))
+	index := make([]int, 0, len(rows))
+
+	for i, values := range rows {
+		object, err := a.object(values)
+		if err != nil {
+			results[i].Error = err
+			continue
+		}
+		objects = append(objects, object)
+		index = append(index, i)
+	}
+
+	typed, err := a.crud.InsertMany(ctx, objects)
+	mergeResults(results, index, typed, err)
+
+	return results, err
+}
+
+func (a untypedAdapter[T, ID]) UpdateManyContext(ctx context.Context, ids []catrina.Value, rows [][]catrina.Value) ([]catrina.BatchResult, error) {
+
+	if len(ids) != len(rows) {
+		return nil, idsAndRowsErr
+	}
+
+	results := make([]catrina.BatchResult, len(rows))
+	typedIds := make([]ID, 0, len(rows))
+	objects := make([]T, 0, len(rows))
+	index := make([]int, 0, len(rows))
+
+	for i, values := range rows {
+		results[i].Id = ids[i]
+		typedId, err := toId[ID](ids[i])
+		if err != nil {
+			results[i].Error = err
+			continue
+		}
+		object, err := a.object(values)
+		if err != nil {
+			results[i].Error = err
+			continue
+		}
+		typedIds = append(typedIds, typedId)
+		objects = append(objects, object)
+		index = append(index, i)
+	}
+
+	typed, err := a.crud.UpdateMany(ctx, typedIds, objects)
+	mergeResults(results, index, typed, err)
+
+	return results, err
+}
+
+func (a untypedAdapter[T, ID]) DeleteManyContext(ctx context.Context, ids []catrina.Value) ([]catrina.BatchResult, error) {
+
+	results := make([]catrina.BatchResult, len(ids))
+	typedIds := make([]ID, 0, len(ids))
+	index := make([]int, 0, len(ids))
+
+	for i, id := range ids {
+		results[i].Id = id
+		typedId, err := toId[ID](id)
+		if err != nil {
+			results[i].Error = err
+			continue
+		}
+		typedIds = append(typedIds, typedId)
+		index = append(index, i)
+	}
+
+	typed, err := a.crud.DeleteMany(ctx, typedIds)
+	mergeResults(results, index, typed, err)
+
+	return results, err
+}
@@ -0,0 +1,65 @@
+package catrina
+
+import (
+	"errors"
+	"strings"
+)
+
+type (
+	// Invalid input, e.g. a row with the wrong number of values or a
+	// filter on an unknown field. Fields, when known, tell what is
+	// wrong with each field. It matches ValidationErr with errors.Is.
+	ValidationError struct {
+		Message string
+		Fields  []FieldError
+	}
+
+	FieldError struct {
+		Field   string `json:"field"`
+		Message string `json:"message"`
+	}
+)
+
+// Kinds of errors returned by CRUD implementations and handlers, which
+// the rest package maps to HTTP statuses. Match them with errors.Is,
+// as they may be wrapped.
+var (
+	// A missing row; the text is the one MySqlCRUD always returned
+	NotFoundErr = errors.New("No rows found")
+	// The row changed since it was read, see also UpdateIfVersion
+	ConflictErr = errors.New("Version conflict")
+	// Any ValidationError
+	ValidationErr = errors.New("Validation failed")
+	// The backend cannot be reached; retrying later may work
+	UnavailableErr = errors.New("Service unavailable")
+	ForbiddenErr   = errors.New("Forbidden")
+)
+
+func NewValidationError(message string) *ValidationError {
+	return &ValidationError{Message: message}
+}
+
+// Adds a problem with a field
+func (e *ValidationError) WithField(field, message string) *ValidationError {
+	e.Fields = append(e.Fields, FieldError{field, message})
+	return e
+}
+
+// The message, or the problems with the fields if there is none
+func (e *ValidationError) Error() string {
+
+	if e.Message != "" || len(e.Fields) == 0 {
+		return e.Message
+	}
+
+	fields := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		fields[i] = f.Field + ": " + f.Message
+	}
+
+	return strings.Join(fields, ", ")
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ValidationErr
+}
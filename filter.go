@@ -0,0 +1,93 @@
+package catrina
+
+type (
+	// Backend-neutral WHERE clause for SelectWhere. Filters are built
+	// with Eq, In, And, etc. Fields are checked against the fields the
+	// CRUD was configured with, and values are always bound as
+	// parameters, so filters can safely be built from user input.
+	//
+	// Comparisons follow SQL: a NULL (nil) value never matches, use
+	// IsNull to select missing values. The zero Filter matches every
+	// row.
+	Filter struct {
+		Op      FilterOp
+		Field   string
+		Values  []Value
+		Filters []Filter
+	}
+
+	FilterOp string
+)
+
+const (
+	EqFilter     FilterOp = "eq"
+	NeqFilter    FilterOp = "neq"
+	InFilter     FilterOp = "in"
+	LikeFilter   FilterOp = "like"
+	RangeFilter  FilterOp = "range"
+	LtFilter     FilterOp = "lt"
+	LteFilter    FilterOp = "lte"
+	GtFilter     FilterOp = "gt"
+	GteFilter    FilterOp = "gte"
+	IsNullFilter FilterOp = "null"
+	AndFilter    FilterOp = "and"
+	OrFilter     FilterOp = "or"
+	NotFilter    FilterOp = "not"
+)
+
+func Eq(field string, value Value) Filter {
+	return Filter{Op: EqFilter, Field: field, Values: []Value{value}}
+}
+
+func Neq(field string, value Value) Filter {
+	return Filter{Op: NeqFilter, Field: field, Values: []Value{value}}
+}
+
+// Matches any of the values; no values match nothing
+func In(field string, values ...Value) Filter {
+	return Filter{Op: InFilter, Field: field, Values: values}
+}
+
+// SQL LIKE pattern, with the % and _ wildcards
+func Like(field string, pattern string) Filter {
+	return Filter{Op: LikeFilter, Field: field, Values: []Value{pattern}}
+}
+
+// Inclusive range, like SelectWhereRange
+func Range(field string, min, max Value) Filter {
+	return Filter{Op: RangeFilter, Field: field, Values: []Value{min, max}}
+}
+
+func Lt(field string, value Value) Filter {
+	return Filter{Op: LtFilter, Field: field, Values: []Value{value}}
+}
+
+func Lte(field string, value Value) Filter {
+	return Filter{Op: LteFilter, Field: field, Values: []Value{value}}
+}
+
+func Gt(field string, value Value) Filter {
+	return Filter{Op: GtFilter, Field: field, Values: []Value{value}}
+}
+
+func Gte(field string, value Value) Filter {
+	return Filter{Op: GteFilter, Field: field, Values: []Value{value}}
+}
+
+func IsNull(field string) Filter {
+	return Filter{Op: IsNullFilter, Field: field}
+}
+
+// No filters match every row
+func And(filters ...Filter) Filter {
+	return Filter{Op: AndFilter, Filters: filters}
+}
+
+// No filters match nothing
+func Or(filters ...Filter) Filter {
+	return Filter{Op: OrFilter, Filters: filters}
+}
+
+func Not(filter Filter) Filter {
+	return Filter{Op: NotFilter, Filters: []Filter{filter}}
+}
@@ -0,0 +1,139 @@
+package catrina
+
+import (
+	"iter"
+	"context"
+)
+
+type (
+	// Iterator over the results of a select, as an alternative to
+	// ranging over its channel:
+	//
+	//	it, err := catrina.Iterate(ctx, func(ctx context.Context) (<-chan catrina.Row, error) {
+	//		return crud.SelectQueryContext(ctx, query)
+	//	})
+	//	if err != nil {
+	//		...
+	//	}
+	//	defer it.Close()
+	//	for it.Next() {
+	//		use(it.Value())
+	//	}
+	//	err = it.Err()
+	//
+	// The select runs with a context derived from ctx that Close
+	// cancels, so the backend stops reading rows and releases its
+	// connection even if the results are not read to the end. Unlike
+	// the channel, iteration stops at the first error.
+	Iterator[T any] struct {
+		ctx    context.Context
+		cancel context.CancelFunc
+		next   func() (value T, err error, ok bool)
+		drain  func()
+		value  T
+		err    error
+		done   bool
+	}
+)
+
+func Iterate(ctx context.Context, query func(ctx context.Context) (<-chan Row, error)) (*Iterator[Object], error) {
+	return newIterator(ctx, query, func(row Row) (Object, error) {
+		return row.Result, row.Error
+	})
+}
+
+// Same as Iterate, for the selects of a TypedCRUD
+func IterateTyped[T any](ctx context.Context, query func(ctx context.Context) (<-chan TypedRow[T], error)) (*Iterator[T], error) {
+	return newIterator(ctx, query, func(row TypedRow[T]) (T, error) {
+		return row.Result, row.Error
+	})
+}
+
+func newIterator[T any, R any](ctx context.Context, query func(ctx context.Context) (<-chan R, error), unpack func(row R) (T, error)) (*Iterator[T], error) {
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	rows, err := query(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &Iterator[T]{
+		ctx:    ctx,
+		cancel: cancel,
+		next: func() (value T, err error, ok bool) {
+			row, ok := <-rows
+			if ok {
+				value, err = unpack(row)
+			}
+			return value, err, ok
+		},
+		drain: func() {
+			for range rows {
+			}
+		},
+	}, nil
+}
+
+// Advances to the next value, returning false at the end of the
+// results, on error, or once closed
+func (it *Iterator[T]) Next() bool {
+
+	if it.done {
+		return false
+	}
+
+	value, err, ok := it.next()
+	switch {
+	case !ok:
+		// a cancelled context ends the results early
+		it.err = it.ctx.Err()
+	case err != nil:
+		it.err = err
+	default:
+		it.value = value
+		return true
+	}
+
+	it.Close()
+	return false
+}
+
+func (it *Iterator[T]) Value() T {
+	return it.value
+}
+
+// The error that ended the iteration, if any
+func (it *Iterator[T]) Err() error {
+	return it.err
+}
+
+// Stops the select, waiting for the backend to let go of the results.
+// It can be called more than once.
+func (it *Iterator[T]) Close() error {
+
+	if !it.done {
+		it.done = true
+		it.cancel()
+		it.drain()
+	}
+
+	var zero T
+	it.value = zero
+
+	return nil
+}
+
+// Ranges over the remaining values, closing the iterator when done or
+// when the loop breaks. Check Err afterwards.
+func (it *Iterator[T]) All() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		defer it.Close()
+		for it.Next() {
+			if !yield(it.value) {
+				return
+			}
+		}
+	}
+}
@@ -3,6 +3,7 @@ package middleware
 import (
 	"net/http"
 	"github.com/satori/go.uuid"
+	"github.com/buduchail/catrina"
 )
 
 type (
@@ -17,10 +18,15 @@ func NewCorrelationID(headerName string) *CorrelationID {
 
 func (m CorrelationID) Handle(w http.ResponseWriter, r *http.Request) (err *error) {
 
-	_, exists := r.Header[m.headerName]
-	if !exists {
-		r.Header[m.headerName] = []string{uuid.NewV4().String()}
+	// Get and Set canonicalize the name, as the error renderer does
+	id := r.Header.Get(m.headerName)
+	if id == "" {
+		id = uuid.NewV4().String()
+		r.Header.Set(m.headerName, id)
 	}
 
+	// handlers can also read it with Request.Value(headerName)
+	catrina.SetRequestValue(r, m.headerName, id)
+
 	return
 }
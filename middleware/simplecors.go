@@ -16,7 +16,9 @@ func NewSimpleCORS() *SimpleCORS {
 func (m SimpleCORS) Handle(w http.ResponseWriter, r *http.Request) (err *error) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	// pre-flight
-	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, HEAD, OPTIONS, PUT, PATCH, DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, If-Match")
+	// paging and version headers set by rest.CRUDHandler
+	w.Header().Set("Access-Control-Expose-Headers", "Link, X-Next-Cursor, X-Total-Count, ETag")
 	return
 }
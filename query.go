@@ -0,0 +1,57 @@
+package catrina
+
+import "strings"
+
+type (
+	// Filter, order and page of SelectQuery. Rows are sorted by the
+	// Sort fields and then by id, so that pages never overlap.
+	//
+	// Pages can be read with Limit and Offset, or with a cursor (keyset
+	// pagination): After holds the values of the Sort fields of the
+	// last row of the previous page, followed by its id (unless id is
+	// one of the Sort fields). Keyset pagination does not slow down on
+	// later pages. NULLs sort first, as in MySQL, on every backend.
+	// RFC 3339 strings in After are taken as times.
+	Query struct {
+		Filter Filter
+		Sort   []SortField
+		// zero means no limit
+		Limit  int
+		Offset int
+		After  []Value
+	}
+
+	SortField struct {
+		Field      string
+		Descending bool
+	}
+)
+
+// Parses a comma separated list of fields, descending ones prefixed
+// with "-", e.g. "-created,name"
+func ParseSort(sort string) []SortField {
+
+	fields := []SortField{}
+
+	for _, f := range strings.Split(sort, ",") {
+		f = strings.TrimSpace(f)
+		switch {
+		case f == "" || f == "-" || f == "+":
+		case f[0] == '-':
+			fields = append(fields, SortField{Field: f[1:], Descending: true})
+		case f[0] == '+':
+			fields = append(fields, SortField{Field: f[1:]})
+		default:
+			fields = append(fields, SortField{Field: f})
+		}
+	}
+
+	return fields
+}
+
+func (s SortField) String() string {
+	if s.Descending {
+		return "-" + s.Field
+	}
+	return s.Field
+}
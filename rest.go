@@ -1,6 +1,11 @@
 package catrina
 
-import "net/http"
+import (
+	"io"
+	"context"
+	"io/ioutil"
+	"net/http"
+)
 
 var (
 	EmptyBody = Payload([]byte(""))
@@ -9,8 +14,33 @@ var (
 type (
 	RestAPI interface {
 		AddResource(name string, handler ResourceHandler)
+		AddContextResource(name string, handler ContextResourceHandler)
+		AddResponseResource(name string, handler ResponseResourceHandler)
 		AddMiddleware(m Middleware)
+		// Returns an OpenAPI 3 document (JSON) describing the
+		// registered resources.
+		OpenAPI(title, version string) (Payload, error)
+		// Serves the OpenAPI document as a resource of the API, e.g.
+		// ServeOpenAPI("openapi.json", ...) serves it at
+		// /prefix/openapi.json. The document itself is not described.
+		ServeOpenAPI(path, title, version string)
+		// Deprecated: Run discards any error, use Start instead.
 		Run(port int)
+		// Start listens on the given port and serves requests until
+		// the server fails or is shut down. A graceful shutdown
+		// returns nil.
+		Start(port int) error
+		// Shutdown stops accepting connections and waits for in-flight
+		// requests to finish, or for the context to expire, and then
+		// runs the OnShutdown hooks.
+		Shutdown(ctx context.Context) error
+		// Ready returns a channel that is closed once the server is
+		// listening.
+		Ready() <-chan struct{}
+		OnShutdown(f func())
+		// Replaces the renderer of error responses, which by default
+		// answers with application/problem+json documents.
+		SetErrorRenderer(renderer ErrorRenderer)
 	}
 
 	ResourceHandler interface {
@@ -29,11 +59,92 @@ type (
 		Put(id string, parentIds []string, payload Payload) (
 			code int, body Payload, err error,
 		)
+		Patch(id string, parentIds []string, payload Payload) (
+			code int, body Payload, err error,
+		)
 		Delete(id string, parentIds []string) (
 			code int, body Payload, err error,
 		)
 	}
 
+	// Context-aware variant of ResourceHandler. Every method receives
+	// the context of the incoming request, so handlers can honour client
+	// disconnects and deadlines and read request-scoped values.
+	ContextResourceHandler interface {
+		Options(ctx context.Context) (
+			code int, body Payload, err error,
+		)
+		Post(ctx context.Context, parentIds []string, payload Payload) (
+			code int, body Payload, err error,
+		)
+		Get(ctx context.Context, id string, parentIds []string) (
+			code int, body Payload, err error,
+		)
+		GetMany(ctx context.Context, parentIds []string, query QueryParameters) (
+			code int, body Payload, err error,
+		)
+		Put(ctx context.Context, id string, parentIds []string, payload Payload) (
+			code int, body Payload, err error,
+		)
+		Patch(ctx context.Context, id string, parentIds []string, payload Payload) (
+			code int, body Payload, err error,
+		)
+		Delete(ctx context.Context, id string, parentIds []string) (
+			code int, body Payload, err error,
+		)
+	}
+
+	// Richest handler interface: methods receive the whole Request
+	// (ids, query, headers, body, middleware values...) and return a
+	// full Response, so handlers can set headers (Location, ETag,
+	// cookies...) or stream the body.
+	ResponseResourceHandler interface {
+		Options(r *Request) *Response
+		Post(r *Request) *Response
+		Get(r *Request) *Response
+		GetMany(r *Request) *Response
+		Put(r *Request) *Response
+		Patch(r *Request) *Response
+		Delete(r *Request) *Response
+	}
+
+	// Framework-neutral view of an incoming request. Id is empty for
+	// collection requests (POST, GetMany). ParentIds follow the same
+	// order as in ResourceHandler (closest parent first), while PathIds
+	// maps each resource in the path to its id, e.g. users/1/orders/2
+	// gives {"users": "1", "orders": "2"}.
+	Request struct {
+		Method     string
+		Id         string
+		ParentIds  []string
+		PathIds    map[string]string
+		Query      QueryParameters
+		Headers    http.Header
+		RemoteAddr string
+		Body       io.Reader
+
+		ctx     context.Context
+		payload Payload
+		read    bool
+		readErr error
+	}
+
+	// Values stored in the request context by middleware
+	requestValues map[string]interface{}
+	requestValuesKey struct{}
+
+	// Response returned by a ResponseResourceHandler. When Stream is set
+	// it is copied to the client instead of Body, and closed afterwards
+	// if it is an io.Closer. Err plays the same role as the error in the
+	// (code, body, err) tuple.
+	Response struct {
+		Code    int
+		Headers http.Header
+		Body    Payload
+		Stream  io.Reader
+		Err     error
+	}
+
 	// Some syntactic sugar
 	Payload []byte
 	QueryParameters map[string][]string
@@ -41,4 +152,104 @@ type (
 	Middleware interface {
 		Handle(w http.ResponseWriter, r *http.Request) *error
 	}
+
+	// Renders responses with an error status (4xx, 5xx) and an empty
+	// body, returning the response to send instead. Bodies set by
+	// handlers are never replaced. r is the incoming request, after
+	// middleware has run.
+	ErrorRenderer interface {
+		RenderError(r *http.Request, rs *Response) *Response
+	}
 )
+
+func NewRequest(ctx context.Context, method string) *Request {
+	return &Request{
+		Method:    method,
+		ParentIds: []string{},
+		PathIds:   map[string]string{},
+		Query:     QueryParameters{},
+		Headers:   http.Header{},
+		Body:      http.NoBody,
+		ctx:       ctx,
+	}
+}
+
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// Reads the whole body. The result is cached, so it can be called
+// more than once.
+func (r *Request) Payload() (Payload, error) {
+	if !r.read {
+		r.read = true
+		r.payload, r.readErr = ioutil.ReadAll(r.Body)
+	}
+	return r.payload, r.readErr
+}
+
+// Returns a value set by middleware with SetRequestValue, falling back
+// to the request context.
+func (r *Request) Value(key string) interface{} {
+	values, ok := r.Context().Value(requestValuesKey{}).(requestValues)
+	if ok {
+		if v, exists := values[key]; exists {
+			return v
+		}
+	}
+	return r.Context().Value(key)
+}
+
+// Prepares a context to carry values set by middleware. Called by the
+// RestAPI implementations before running the middleware chain.
+func WithRequestValues(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(requestValuesKey{}).(requestValues); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, requestValuesKey{}, requestValues{})
+}
+
+// Lets middleware pass values on to handlers, which read them with
+// Request.Value. Returns false if the request was not prepared with
+// WithRequestValues.
+func SetRequestValue(r *http.Request, key string, value interface{}) bool {
+	values, ok := r.Context().Value(requestValuesKey{}).(requestValues)
+	if ok {
+		values[key] = value
+	}
+	return ok
+}
+
+func NewResponse(code int, body Payload, err error) *Response {
+	return &Response{
+		Code:    code,
+		Headers: http.Header{},
+		Body:    body,
+		Err:     err,
+	}
+}
+
+func (r *Response) WithHeader(key, value string) *Response {
+	if r.Headers == nil {
+		r.Headers = http.Header{}
+	}
+	r.Headers.Add(key, value)
+	return r
+}
+
+func (r *Response) WithStream(stream io.Reader) *Response {
+	r.Stream = stream
+	return r
+}
+
+// Returns the first value for the given key, or "" if there is none
+func (q QueryParameters) Get(key string) string {
+	values := q[key]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
@@ -0,0 +1,74 @@
+package rest
+
+import (
+	"reflect"
+
+	"github.com/buduchail/catrina"
+	ugorji "github.com/ugorji/go/codec"
+)
+
+type (
+	// MessagePack bodies. Struct fields are named by their codec or
+	// json tags, and maps decoded into interface{} values have string
+	// keys.
+	MessagePackCodec struct {
+	}
+
+	// CBOR (RFC 8949) bodies, with the same naming as MessagePackCodec
+	CBORCodec struct {
+	}
+)
+
+var (
+	msgpackHandle = newMsgpackHandle()
+	cborHandle    = newCborHandle()
+)
+
+func newMsgpackHandle() *ugorji.MsgpackHandle {
+	h := &ugorji.MsgpackHandle{}
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	// strings as str, not raw bytes, and times as timestamps
+	h.RawToString = true
+	h.WriteExt = true
+	return h
+}
+
+func newCborHandle() *ugorji.CborHandle {
+	h := &ugorji.CborHandle{}
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	return h
+}
+
+func encodeBinary(h ugorji.Handle, v interface{}) (catrina.Payload, error) {
+	var b []byte
+	err := ugorji.NewEncoderBytes(&b, h).Encode(v)
+	return b, err
+}
+
+func decodeBinary(h ugorji.Handle, payload catrina.Payload, v interface{}) error {
+	return ugorji.NewDecoderBytes(payload, h).Decode(v)
+}
+
+func (c MessagePackCodec) Encode(v interface{}) (catrina.Payload, error) {
+	return encodeBinary(msgpackHandle, v)
+}
+
+func (c MessagePackCodec) Decode(payload catrina.Payload, v interface{}) error {
+	return decodeBinary(msgpackHandle, payload, v)
+}
+
+func (c MessagePackCodec) MediaType() string {
+	return "application/msgpack"
+}
+
+func (c CBORCodec) Encode(v interface{}) (catrina.Payload, error) {
+	return encodeBinary(cborHandle, v)
+}
+
+func (c CBORCodec) Decode(payload catrina.Payload, v interface{}) error {
+	return decodeBinary(cborHandle, payload, v)
+}
+
+func (c CBORCodec) MediaType() string {
+	return "application/cbor"
+}
@@ -0,0 +1,357 @@
+package rest
+
+import (
+	"fmt"
+	"errors"
+	"context"
+	"net/http"
+
+	"github.com/buduchail/catrina"
+)
+
+type (
+	// Bulk endpoint of a CRUDHandler, to insert, update and delete many
+	// objects with one request. It is added as a resource of its own
+	// next to the CRUDHandler, so that it gets the same parent ids:
+	//
+	//	api.AddResponseResource("users/orders", orders)
+	//	api.AddResponseResource("users/orders-bulk", rest.NewBulkHandler(orders))
+	//
+	// POST takes a BulkRequest, and answers 200 with a BulkResponse
+	// holding the outcome of every item in request order: inserts,
+	// then updates, then deletes. If a step fails as a whole (e.g. the
+	// database is unavailable), its items and those of the later steps
+	// report the error and are not run, while the items of the earlier
+	// steps report what was written.
+	BulkHandler struct {
+		ResponseResourceHandler
+		handler  *CRUDHandler
+		maxItems int
+	}
+
+	// Updated objects must include their id
+	BulkRequest struct {
+		Insert []map[string]interface{} `json:"insert,omitempty"`
+		Update []map[string]interface{} `json:"update,omitempty"`
+		Delete []catrina.Value          `json:"delete,omitempty"`
+	}
+
+	BulkResponse struct {
+		Insert []BulkResult `json:"insert"`
+		Update []BulkResult `json:"update"`
+		Delete []BulkResult `json:"delete"`
+	}
+
+	// Status is the one a single request would have answered (e.g. 201
+	// for an insert, 404 for a missing object)
+	BulkResult struct {
+		Status int           `json:"status"`
+		Id     catrina.Value `json:"id,omitempty"`
+		Error  string        `json:"error,omitempty"`
+	}
+)
+
+var (
+	TooManyItemsErr = errors.New("Too many items")
+)
+
+func NewBulkHandler(handler *CRUDHandler) *BulkHandler {
+	return &BulkHandler{handler: handler}
+}
+
+// Rejects requests with more than max items in total with 413
+func (h *BulkHandler) WithMaxItems(max int) *BulkHandler {
+	h.maxItems = max
+	return h
+}
+
+// Helper methods
+
+func bulkResult(status int, id catrina.Value) BulkResult {
+	result := BulkResult{Status: status, Id: id}
+	if status >= 400 {
+		result.Error = http.StatusText(status)
+	}
+	return result
+}
+
+func (h *BulkHandler) batchResults(results []catrina.BatchResult, status int) []BulkResult {
+
+	bulk := make([]BulkResult, len(results))
+	for i, r := range results {
+		if r.Error != nil {
+			bulk[i] = bulkResult(h.handler.errorResponse(r.Error).Code, r.Id)
+		} else {
+			bulk[i] = bulkResult(status, r.Id)
+		}
+	}
+
+	return bulk
+}
+
+// Results of the items of a step that failed as a whole, or was not
+// run after an earlier step failed
+func (h *BulkHandler) failedResults(ids []catrina.Value, err error) []BulkResult {
+
+	status := h.handler.errorResponse(err).Code
+
+	failed := make([]BulkResult, len(ids))
+	for i, id := range ids {
+		failed[i] = bulkResult(status, id)
+	}
+
+	return failed
+}
+
+func (h *BulkHandler) objectIds(objects []map[string]interface{}) []catrina.Value {
+	ids := make([]catrina.Value, len(objects))
+	for i, object := range objects {
+		ids[i] = object[h.handler.id]
+	}
+	return ids
+}
+
+// Ids given in a request are compared by their text, as they may be
+// numbers or strings depending on the codec
+func bulkKey(id catrina.Value) string {
+	return fmt.Sprint(id)
+}
+
+// Returns the fields of the given ids that exist under the parent ids,
+// so that objects of other parents cannot be changed.
+func (h *BulkHandler) existing(ctx context.Context, ids []catrina.Value, parentIds []string) (map[string]map[string]interface{}, error) {
+
+	found := map[string]map[string]interface{}{}
+	if len(ids) == 0 {
+		return found, nil
+	}
+
+	filters := append([]catrina.Filter{catrina.In(h.handler.id, ids...)}, h.handler.parentFilter(parentIds)...)
+	rows, err := h.handler.crud.SelectWhereContext(ctx, catrina.And(filters...))
+	if err != nil {
+		return nil, err
+	}
+
+	objects, err := h.handler.collect(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, object := range objects {
+		fields, err := h.handler.objectFields(object)
+		if err != nil {
+			return nil, err
+		}
+		found[bulkKey(fields[h.handler.id])] = fields
+	}
+
+	return found, nil
+}
+
+func (h *BulkHandler) insert(ctx context.Context, objects []map[string]interface{}, parentIds []string) ([]BulkResult, error) {
+
+	rows := make([][]catrina.Value, len(objects))
+	for i, object := range objects {
+		values, err := h.handler.values(object, parentIds)
+		if err != nil {
+			return nil, err
+		}
+		if h.handler.versionField != "" {
+			h.handler.setVersion(values, 1)
+		}
+		rows[i] = values
+	}
+
+	results, err := h.handler.crud.InsertManyContext(ctx, rows)
+	if len(results) != len(rows) {
+		return nil, err
+	}
+
+	return h.batchResults(results, http.StatusCreated), nil
+}
+
+func (h *BulkHandler) update(ctx context.Context, objects []map[string]interface{}, parentIds []string) ([]BulkResult, error) {
+
+	results := make([]BulkResult, len(objects))
+
+	ids := make([]catrina.Value, 0, len(objects))
+	for _, object := range objects {
+		if id, ok := object[h.handler.id]; ok && id != nil {
+			ids = append(ids, id)
+		}
+	}
+
+	found, err := h.existing(ctx, ids, parentIds)
+	if err != nil {
+		return nil, err
+	}
+
+	// only existing objects are updated, the others are reported
+	index := []int{}
+	ids = []catrina.Value{}
+	rows := [][]catrina.Value{}
+	for i, object := range objects {
+		id, ok := object[h.handler.id]
+		switch {
+		case !ok || id == nil:
+			results[i] = bulkResult(http.StatusBadRequest, nil)
+		case found[bulkKey(id)] == nil:
+			results[i] = bulkResult(http.StatusNotFound, id)
+		default:
+			values, err := h.handler.values(object, parentIds)
+			if err != nil {
+				return nil, err
+			}
+			// bulk updates are not conditional, but still count as a
+			// change for ETags
+			if h.handler.versionField != "" {
+				version, err := h.handler.fieldsVersion(found[bulkKey(id)])
+				if err != nil {
+					return nil, err
+				}
+				h.handler.setVersion(values, version+1)
+			}
+			index = append(index, i)
+			ids = append(ids, id)
+			rows = append(rows, values)
+		}
+	}
+
+	if len(rows) == 0 {
+		return results, nil
+	}
+
+	updated, err := h.handler.crud.UpdateManyContext(ctx, ids, rows)
+	if len(updated) != len(rows) {
+		return nil, err
+	}
+
+	for n, r := range h.batchResults(updated, http.StatusOK) {
+		results[index[n]] = r
+	}
+
+	return results, nil
+}
+
+func (h *BulkHandler) delete(ctx context.Context, ids []catrina.Value, parentIds []string) ([]BulkResult, error) {
+
+	results := make([]BulkResult, len(ids))
+
+	found, err := h.existing(ctx, ids, parentIds)
+	if err != nil {
+		return nil, err
+	}
+
+	index := []int{}
+	existing := []catrina.Value{}
+	for i, id := range ids {
+		if found[bulkKey(id)] != nil {
+			index = append(index, i)
+			existing = append(existing, id)
+		} else {
+			results[i] = bulkResult(http.StatusNotFound, id)
+		}
+	}
+
+	if len(existing) == 0 {
+		return results, nil
+	}
+
+	deleted, err := h.handler.crud.DeleteManyContext(ctx, existing)
+	if len(deleted) != len(existing) {
+		return nil, err
+	}
+
+	for n, r := range h.batchResults(deleted, http.StatusNoContent) {
+		results[index[n]] = r
+	}
+
+	return results, nil
+}
+
+// Public interface
+
+func (h *BulkHandler) OpenAPI() OpenAPIResource {
+
+	objects := OpenAPISchema{"type": "array", "items": OpenAPISchema{"type": "object"}}
+
+	return OpenAPIResource{
+		Summary: "Bulk insert, update and delete",
+		Methods: []string{"Options", "Post"},
+		Schema: OpenAPISchema{
+			"type": "object",
+			"properties": OpenAPISchema{
+				"insert": objects,
+				"update": objects,
+				"delete": OpenAPISchema{"type": "array", "items": OpenAPISchema{}},
+			},
+		},
+	}
+}
+
+func (h *BulkHandler) Options(r *catrina.Request) *catrina.Response {
+	return catrina.NewResponse(http.StatusOK, catrina.EmptyBody, nil).
+		WithHeader("Allow", "OPTIONS, POST")
+}
+
+// Items are not processed atomically: a failed item does not undo the
+// others, as reported in the response.
+func (h *BulkHandler) Post(r *catrina.Request) *catrina.Response {
+
+	handler, rs := h.handler.negotiate(r, true)
+	if rs != nil {
+		return rs
+	}
+
+	payload, err := r.Payload()
+	if err != nil {
+		return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, err)
+	}
+
+	request := BulkRequest{}
+	err = handler.in.Decode(payload, &request)
+	if err != nil {
+		return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, err)
+	}
+
+	items := len(request.Insert) + len(request.Update) + len(request.Delete)
+	if h.maxItems > 0 && items > h.maxItems {
+		return catrina.NewResponse(http.StatusRequestEntityTooLarge, catrina.EmptyBody, TooManyItemsErr)
+	}
+
+	if len(r.ParentIds) < len(h.handler.parentFields) {
+		return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, errors.New("Missing parent ids"))
+	}
+
+	response := BulkResponse{Insert: []BulkResult{}, Update: []BulkResult{}, Delete: []BulkResult{}}
+
+	// once a step fails, the remaining items report its error
+	var failed error
+
+	if len(request.Insert) > 0 {
+		response.Insert, failed = h.insert(r.Context(), request.Insert, r.ParentIds)
+		if failed != nil {
+			response.Insert = h.failedResults(make([]catrina.Value, len(request.Insert)), failed)
+		}
+	}
+
+	if len(request.Update) > 0 {
+		if failed == nil {
+			response.Update, failed = h.update(r.Context(), request.Update, r.ParentIds)
+		}
+		if failed != nil {
+			response.Update = h.failedResults(h.objectIds(request.Update), failed)
+		}
+	}
+
+	if len(request.Delete) > 0 {
+		if failed == nil {
+			response.Delete, failed = h.delete(r.Context(), request.Delete, r.ParentIds)
+		}
+		if failed != nil {
+			response.Delete = h.failedResults(request.Delete, failed)
+		}
+	}
+
+	return handler.encode(http.StatusOK, response)
+}
@@ -0,0 +1,151 @@
+package rest_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"net/http"
+
+	"github.com/buduchail/catrina"
+	"github.com/buduchail/catrina/crud"
+	"github.com/buduchail/catrina/rest"
+)
+
+type (
+	// Fails every select, so that bulk updates and deletes cannot find
+	// their objects
+	unavailableSelects struct {
+		*crud.MemoryCRUD
+	}
+)
+
+func (c unavailableSelects) SelectWhereContext(ctx context.Context, filter catrina.Filter) (<-chan catrina.Row, error) {
+	return nil, catrina.UnavailableErr
+}
+
+func insertOrders(t *testing.T, orders *crud.MemoryCRUD, rows ...[]catrina.Value) {
+	for _, row := range rows {
+		_, err := orders.Insert(row)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func postBulk(t *testing.T, h *rest.BulkHandler, userId, body string) (int, rest.BulkResponse) {
+
+	rs := h.Post(newRequest(http.MethodPost, userId, "", body))
+
+	response := rest.BulkResponse{}
+	if rs.Code == http.StatusOK {
+		decodeBody(t, rs, &response)
+	}
+
+	return rs.Code, response
+}
+
+func TestBulkHandler(t *testing.T) {
+
+	orders, h := newOrders(t)
+	bulk := rest.NewBulkHandler(h).WithMaxItems(5)
+
+	insertOrders(t, orders, []catrina.Value{"1", "pen", 1}, []catrina.Value{"1", "ink", 4}, []catrina.Value{"2", "cup", 1})
+
+	code, response := postBulk(t, bulk, "1", `{
+		"insert": [{"item": "mug"}],
+		"update": [{"id": 1, "item": "nib"}, {"id": 3, "item": "nib"}, {"item": "nib"}],
+		"delete": [2]
+	}`)
+
+	expected := rest.BulkResponse{
+		Insert: []rest.BulkResult{{Status: http.StatusCreated, Id: float64(4)}},
+		Update: []rest.BulkResult{
+			{Status: http.StatusOK, Id: float64(1)},
+			// objects of other parents are not found
+			{Status: http.StatusNotFound, Id: float64(3), Error: "Not Found"},
+			{Status: http.StatusBadRequest, Error: "Bad Request"},
+		},
+		Delete: []rest.BulkResult{{Status: http.StatusNoContent, Id: float64(2)}},
+	}
+	if code != http.StatusOK || !reflect.DeepEqual(response, expected) {
+		t.Errorf("expected %v, got %d %v", expected, code, response)
+	}
+
+	// bulk updates still count as changes
+	rs := h.Get(newRequest(http.MethodGet, "1", "1", ""))
+	object := map[string]interface{}{}
+	decodeBody(t, rs, &object)
+	if !reflect.DeepEqual(object, order(1, "1", "nib", 2)) {
+		t.Errorf("unexpected updated object %v", object)
+	}
+
+	rs = h.Get(newRequest(http.MethodGet, "1", "4", ""))
+	decodeBody(t, rs, &object)
+	if !reflect.DeepEqual(object, order(4, "1", "mug", 1)) {
+		t.Errorf("unexpected inserted object %v", object)
+	}
+
+	if rs = h.Get(newRequest(http.MethodGet, "1", "2", "")); rs.Code != http.StatusNotFound {
+		t.Errorf("expected the deleted object to be gone, got %d", rs.Code)
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		expected int
+	}{
+		{"too many items", `{"insert": [{}, {}, {}], "delete": [1, 2, 3]}`, http.StatusRequestEntityTooLarge},
+		{"undecodable", `{"insert": {}}`, http.StatusBadRequest},
+		{"empty", `{}`, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		code, _ := postBulk(t, bulk, "1", test.body)
+		if code != test.expected {
+			t.Errorf("%s: expected %d, got %d", test.name, test.expected, code)
+		}
+	}
+}
+
+// The items written before a step fails are reported, and the
+// remaining ones fail with its error
+func TestBulkHandlerFailedStep(t *testing.T) {
+
+	orders, _ := newOrders(t)
+	insertOrders(t, orders, []catrina.Value{"1", "pen", 1})
+
+	h := rest.NewCRUDHandler(unavailableSelects{orders}, orderFields, rest.JSONCodec{}).WithParentFields("user_id")
+	bulk := rest.NewBulkHandler(h)
+
+	code, response := postBulk(t, bulk, "1", `{
+		"insert": [{"item": "mug"}, {"item": "cup"}],
+		"update": [{"id": 1, "item": "nib"}, {"item": "nib"}],
+		"delete": [1]
+	}`)
+
+	unavailable := http.StatusText(http.StatusServiceUnavailable)
+	expected := rest.BulkResponse{
+		Insert: []rest.BulkResult{{Status: http.StatusCreated, Id: float64(2)}, {Status: http.StatusCreated, Id: float64(3)}},
+		Update: []rest.BulkResult{
+			{Status: http.StatusServiceUnavailable, Id: float64(1), Error: unavailable},
+			{Status: http.StatusServiceUnavailable, Error: unavailable},
+		},
+		Delete: []rest.BulkResult{{Status: http.StatusServiceUnavailable, Id: float64(1), Error: unavailable}},
+	}
+	if code != http.StatusOK || !reflect.DeepEqual(response, expected) {
+		t.Errorf("expected %v, got %d %v", expected, code, response)
+	}
+
+	// the inserts were written, and nothing else
+	rows, err := orders.SelectWhere(catrina.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := []catrina.Value{}
+	for row := range rows {
+		items = append(items, row.Result.(map[string]interface{})["item"])
+	}
+	if !reflect.DeepEqual(items, []catrina.Value{"pen", "mug", "cup"}) {
+		t.Errorf("unexpected items %v", items)
+	}
+}
@@ -0,0 +1,48 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/buduchail/catrina"
+)
+
+type (
+	// Converts between request/response bodies and Go values
+	Codec interface {
+		Encode(v interface{}) (catrina.Payload, error)
+		Decode(payload catrina.Payload, v interface{}) error
+	}
+
+	JSONCodec struct {
+	}
+)
+
+func (c JSONCodec) Encode(v interface{}) (catrina.Payload, error) {
+	return json.Marshal(v)
+}
+
+// Numbers decoded into interface{} values are kept as json.Number,
+// so that ids and other big integers are not turned into floats.
+func (c JSONCodec) Decode(payload catrina.Payload, v interface{}) error {
+	return decodeJSON(bytes.TrimSpace(payload), v)
+}
+
+// Values as JSON decodes them into interface{}, so that other codecs
+// can mirror the JSON encoding
+func toGeneric(v interface{}) (interface{}, error) {
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var generic interface{}
+	err = decodeJSON(b, &generic)
+
+	return generic, err
+}
+
+func (c JSONCodec) MediaType() string {
+	return "application/json"
+}
@@ -1,6 +1,7 @@
 package rest
 
 import (
+	"io"
 	"fmt"
 	"errors"
 	"strings"
@@ -11,7 +12,8 @@ import (
 )
 
 var (
-	unknownErr = errors.New("Unknown error")
+	unknownErr     = errors.New("Unknown error")
+	nilResponseErr = errors.New("Handler returned no response")
 
 	routers = map[string]string{
 		"n": "nethttp",
@@ -56,6 +58,115 @@ func getHttpError(code int) error {
 	return unknownErr
 }
 
+// Plain text body of an error response: the body returned by the
+// handler if any, else the error message, or the status text if there
+// is no error. See TextErrorRenderer.
+func getResponseBody(code int, body catrina.Payload, err error) catrina.Payload {
+
+	if len(body) > 0 || code < http.StatusBadRequest {
+		return body
+	}
+
+	if err == nil {
+		err = getHttpError(code)
+	}
+
+	return catrina.Payload(err.Error())
+}
+
+// Writes a handler response to any net/http compatible writer: headers
+// first, then status and either the stream or the body. Errors without
+// a body are rendered for the request r.
+func writeResponse(w http.ResponseWriter, r *http.Request, rs *catrina.Response, rendering *errorRendering) (err error) {
+
+	if rs == nil {
+		rs = catrina.NewResponse(http.StatusInternalServerError, catrina.EmptyBody, nilResponseErr)
+	}
+
+	resolveStatus(rs)
+	rs = rendering.render(r, rs)
+
+	for k, values := range rs.Headers {
+		w.Header().Del(k)
+		for _, v := range values {
+			w.Header().Add(k, v)
+		}
+	}
+
+	w.WriteHeader(rs.Code)
+
+	if rs.Stream != nil {
+		if closer, ok := rs.Stream.(io.Closer); ok {
+			defer closer.Close()
+		}
+		_, err = io.Copy(w, rs.Stream)
+		return err
+	}
+
+	_, err = w.Write(rs.Body)
+
+	return err
+}
+
+// Maps each resource in a (possibly nested) resource name to its id:
+// users/*/orders, "2", [1] -> {"users": "1", "orders": "2"}
+func getPathIds(name string, id string, parentIds []string) map[string]string {
+
+	parts := strings.Split(name, "/*/")
+	ids := make([]string, 0, len(parentIds)+1)
+	// parentIds are closest first
+	for i := len(parentIds) - 1; i >= 0; i-- {
+		ids = append(ids, parentIds[i])
+	}
+	if id != "" {
+		ids = append(ids, id)
+	}
+
+	pathIds := make(map[string]string, len(ids))
+	for i, v := range ids {
+		if i < len(parts) {
+			pathIds[parts[i]] = v
+		}
+	}
+
+	return pathIds
+}
+
+// Builds the request passed to handlers from a net/http request
+func newRequest(r *http.Request, name string, id string, parentIds []string) *catrina.Request {
+	rq := catrina.NewRequest(r.Context(), r.Method)
+	rq.Id = id
+	rq.ParentIds = parentIds
+	rq.PathIds = getPathIds(name, id, parentIds)
+	rq.Query = catrina.QueryParameters(r.URL.Query())
+	rq.Headers = r.Header
+	rq.RemoteAddr = r.RemoteAddr
+	if r.Body != nil {
+		rq.Body = r.Body
+	}
+	return rq
+}
+
+// HEAD requests are answered by Get or GetMany: status and headers are
+// kept, the body is dropped.
+func headResponse(rs *catrina.Response) *catrina.Response {
+
+	if rs == nil {
+		return nil
+	}
+
+	if closer, ok := rs.Stream.(io.Closer); ok {
+		closer.Close()
+	}
+	// the status still tells the error
+	resolveStatus(rs)
+	rs.Stream = nil
+	rs.Body = catrina.EmptyBody
+	rs.Err = nil
+
+	return rs
+}
+
 func normalizePrefix(prefix string) string {
 	normalized := strings.TrimLeft(strings.TrimRight(prefix, "/"), "/")
 	switch normalized {
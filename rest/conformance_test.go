@@ -0,0 +1,32 @@
+package rest_test
+
+import (
+	"testing"
+
+	"github.com/buduchail/catrina"
+	"github.com/buduchail/catrina/rest"
+	"github.com/buduchail/catrina/rest/resttest"
+)
+
+func TestConformance(t *testing.T) {
+
+	routers := []struct {
+		name   string
+		newApi resttest.Constructor
+	}{
+		{"nethttp", func(prefix string) catrina.RestAPI { return rest.NewNetHTTP(prefix) }},
+		{"httprouter", func(prefix string) catrina.RestAPI { return rest.NewHttpRouter(prefix) }},
+		{"gin", func(prefix string) catrina.RestAPI { return rest.NewGin(prefix) }},
+		{"echo", func(prefix string) catrina.RestAPI { return rest.NewEcho(prefix) }},
+		{"iris", func(prefix string) catrina.RestAPI { return rest.NewIris(prefix) }},
+		{"fasthttp", func(prefix string) catrina.RestAPI { return rest.NewFast(prefix) }},
+		{"gorestful", func(prefix string) catrina.RestAPI { return rest.NewGoRestful(prefix) }},
+	}
+
+	for _, router := range routers {
+		router := router
+		t.Run(router.name, func(t *testing.T) {
+			resttest.Run(t, router.newApi)
+		})
+	}
+}
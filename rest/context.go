@@ -0,0 +1,116 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"github.com/buduchail/catrina"
+)
+
+type (
+	// Adapter that lets a plain ResourceHandler be used where a
+	// ContextResourceHandler is expected. The request context is
+	// simply dropped.
+	contextAdapter struct {
+		handler catrina.ResourceHandler
+	}
+)
+
+func NewContextAdapter(handler catrina.ResourceHandler) catrina.ContextResourceHandler {
+	return contextAdapter{handler}
+}
+
+func (a contextAdapter) Options(ctx context.Context) (code int, body catrina.Payload, err error) {
+	return a.handler.Options()
+}
+
+func (a contextAdapter) Post(ctx context.Context, parentIds []string, payload catrina.Payload) (code int, body catrina.Payload, err error) {
+	return a.handler.Post(parentIds, payload)
+}
+
+func (a contextAdapter) Get(ctx context.Context, id string, parentIds []string) (code int, body catrina.Payload, err error) {
+	return a.handler.Get(id, parentIds)
+}
+
+func (a contextAdapter) GetMany(ctx context.Context, parentIds []string, params catrina.QueryParameters) (code int, body catrina.Payload, err error) {
+	return a.handler.GetMany(parentIds, params)
+}
+
+func (a contextAdapter) Put(ctx context.Context, id string, parentIds []string, payload catrina.Payload) (code int, body catrina.Payload, err error) {
+	return a.handler.Put(id, parentIds, payload)
+}
+
+func (a contextAdapter) Patch(ctx context.Context, id string, parentIds []string, payload catrina.Payload) (code int, body catrina.Payload, err error) {
+	return a.handler.Patch(id, parentIds, payload)
+}
+
+func (a contextAdapter) Delete(ctx context.Context, id string, parentIds []string) (code int, body catrina.Payload, err error) {
+	return a.handler.Delete(id, parentIds)
+}
+
+// Handlers wrapped by the adapters keep describing themselves
+func describe(handler interface{}) OpenAPIResource {
+	if d, ok := handler.(OpenAPIDescriber); ok {
+		return d.OpenAPI()
+	}
+	return OpenAPIResource{}
+}
+
+func (a contextAdapter) OpenAPI() OpenAPIResource {
+	return describe(a.handler)
+}
+
+type (
+	// Adapter that unpacks the catrina.Request for a ContextResourceHandler
+	// and wraps the (code, body, err) tuples it returns into a Response.
+	responseAdapter struct {
+		handler catrina.ContextResourceHandler
+	}
+)
+
+func NewResponseAdapter(handler catrina.ContextResourceHandler) catrina.ResponseResourceHandler {
+	return responseAdapter{handler}
+}
+
+func (a responseAdapter) Options(r *catrina.Request) *catrina.Response {
+	return catrina.NewResponse(a.handler.Options(r.Context()))
+}
+
+func (a responseAdapter) Post(r *catrina.Request) *catrina.Response {
+	payload, err := r.Payload()
+	if err != nil {
+		return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, err)
+	}
+	return catrina.NewResponse(a.handler.Post(r.Context(), r.ParentIds, payload))
+}
+
+func (a responseAdapter) Get(r *catrina.Request) *catrina.Response {
+	return catrina.NewResponse(a.handler.Get(r.Context(), r.Id, r.ParentIds))
+}
+
+func (a responseAdapter) GetMany(r *catrina.Request) *catrina.Response {
+	return catrina.NewResponse(a.handler.GetMany(r.Context(), r.ParentIds, r.Query))
+}
+
+func (a responseAdapter) Put(r *catrina.Request) *catrina.Response {
+	payload, err := r.Payload()
+	if err != nil {
+		return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, err)
+	}
+	return catrina.NewResponse(a.handler.Put(r.Context(), r.Id, r.ParentIds, payload))
+}
+
+func (a responseAdapter) Patch(r *catrina.Request) *catrina.Response {
+	payload, err := r.Payload()
+	if err != nil {
+		return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, err)
+	}
+	return catrina.NewResponse(a.handler.Patch(r.Context(), r.Id, r.ParentIds, payload))
+}
+
+func (a responseAdapter) Delete(r *catrina.Request) *catrina.Response {
+	return catrina.NewResponse(a.handler.Delete(r.Context(), r.Id, r.ParentIds))
+}
+
+func (a responseAdapter) OpenAPI() OpenAPIResource {
+	return describe(a.handler)
+}
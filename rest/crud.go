@@ -0,0 +1,642 @@
+package rest
+
+import (
+	"fmt"
+	"sort"
+	"errors"
+	"strconv"
+	"strings"
+	"net/http"
+
+	"github.com/buduchail/catrina"
+)
+
+type (
+	// ResponseResourceHandler backed by a catrina.ContextCRUD. Plain CRUD
+	// implementations can be wrapped with crud.NewContextAdapter.
+	//
+	// fields are the CRUD fields, id first, as given to the CRUD
+	// implementation (e.g. NewMySqlCRUD). Bodies are decoded into
+	// objects whose keys are field names, and their values passed to
+	// Insert and Update in field order; objects returned by Select are
+	// encoded as they are. With a Codecs registry as codec, request and
+	// response bodies are negotiated, and the default codec is used for
+	// everything else (e.g. ETags, cursors and patches).
+	CRUDHandler struct {
+		crud         catrina.ContextCRUD
+		codec        Codec
+		id           string
+		fields       []string
+		parentFields []string
+		queryFields  map[string]string
+		defaultLimit int
+		maxLimit     int
+		totalCount   bool
+		versionField string
+		// set per request by negotiate
+		negotiated
+	}
+)
+
+var (
+	PreconditionFailedErr = errors.New("Precondition failed")
+	InvalidVersionErr     = errors.New("Invalid version")
+)
+
+func NewCRUDHandler(crud catrina.ContextCRUD, fields []string, codec Codec) *CRUDHandler {
+	return &CRUDHandler{
+		crud:         crud,
+		codec:        codec,
+		id:           fields[0],
+		fields:       fields[1:],
+		parentFields: []string{},
+		queryFields:  map[string]string{},
+	}
+}
+
+// Sets the foreign key fields holding the ids of the parent resources,
+// in the same order as parentIds (closest parent first). For
+// users/*/orders/*/items that would be "order_id", "user_id".
+func (h *CRUDHandler) WithParentFields(fields ...string) *CRUDHandler {
+	h.parentFields = fields
+	return h
+}
+
+// Lets GetMany filter on the given fields with query parameters of
+// the same name, e.g. ?status=paid, or ?status=paid&status=sent to
+// match either value. Only listed fields can be used.
+func (h *CRUDHandler) WithQueryFields(fields ...string) *CRUDHandler {
+	for _, f := range fields {
+		h.queryFields[f] = f
+	}
+	return h
+}
+
+// Same as WithQueryFields, for query parameters that do not match the
+// field name.
+func (h *CRUDHandler) WithQueryField(param, field string) *CRUDHandler {
+	h.queryFields[param] = field
+	return h
+}
+
+// Limits the number of objects returned by GetMany (see ParseQuery).
+// Without limits every matching object is returned.
+func (h *CRUDHandler) WithPageLimits(defaultLimit, maxLimit int) *CRUDHandler {
+	h.defaultLimit = defaultLimit
+	h.maxLimit = maxLimit
+	return h
+}
+
+// Makes GetMany count every matching object in the X-Total-Count
+// header, at the cost of a second query.
+func (h *CRUDHandler) WithTotalCount() *CRUDHandler {
+	h.totalCount = true
+	return h
+}
+
+// Enables optimistic concurrency on the given integer field, which
+// counts the changes of an object: it is set to 1 on POST, and
+// incremented by every PUT and PATCH, overriding what the body says.
+// Objects are returned with their version in the ETag header, and a
+// PUT, PATCH or DELETE with an If-Match header that does not match
+// the current version fails with 412. Without If-Match, updates and
+// deletes still fail with 409 when the object changes while they run.
+func (h *CRUDHandler) WithVersionField(field string) *CRUDHandler {
+	h.versionField = field
+	return h
+}
+
+// Helper methods
+
+// Copy of the handler with the codecs of the request. decoding tells
+// whether the request body is decoded.
+func (h *CRUDHandler) negotiate(r *catrina.Request, decoding bool) (*CRUDHandler, *catrina.Response) {
+
+	n, rs := negotiate(h.codec, r, decoding)
+	if rs != nil {
+		return nil, rs
+	}
+
+	view := *h
+	view.negotiated = n
+
+	return &view, nil
+}
+
+// Missing objects are answered with an empty 404, other errors with
+// the status of their kind (see ErrorStatus)
+func (h *CRUDHandler) errorResponse(err error) *catrina.Response {
+	code := ErrorStatus(err)
+	if code == http.StatusNotFound {
+		return catrina.NewResponse(code, catrina.EmptyBody, nil)
+	}
+	return catrina.NewResponse(code, catrina.EmptyBody, err)
+}
+
+// Same as errorResponse for updates and deletes, where a conflict
+// fails the If-Match precondition if there is one
+func (h *CRUDHandler) writeErrorResponse(r *catrina.Request, err error) *catrina.Response {
+	if errors.Is(err, catrina.ConflictErr) && r.Headers.Get("If-Match") != "" {
+		return catrina.NewResponse(http.StatusPreconditionFailed, catrina.EmptyBody, PreconditionFailedErr)
+	}
+	return h.errorResponse(err)
+}
+
+func (h *CRUDHandler) encode(code int, v interface{}) *catrina.Response {
+	return h.negotiated.encode(code, v)
+}
+
+// Encodes a single object, with its version in the ETag header
+func (h *CRUDHandler) encodeObject(code int, object catrina.Object) *catrina.Response {
+
+	rs := h.encode(code, object)
+	if rs.Err != nil || h.versionField == "" {
+		return rs
+	}
+
+	version, err := h.version(object)
+	if err != nil {
+		return catrina.NewResponse(http.StatusInternalServerError, catrina.EmptyBody, err)
+	}
+
+	return rs.WithHeader("ETag", etag(version))
+}
+
+// Fields of an object as the client sees them: the encoded object,
+// decoded into a map
+func (h *CRUDHandler) objectFields(object catrina.Object) (map[string]interface{}, error) {
+
+	payload, err := h.codec.Encode(object)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]interface{}{}
+	err = h.codec.Decode(payload, &fields)
+	if err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+func (h *CRUDHandler) version(object catrina.Object) (int64, error) {
+
+	fields, err := h.objectFields(object)
+	if err != nil {
+		return 0, err
+	}
+
+	return h.fieldsVersion(fields)
+}
+
+func (h *CRUDHandler) fieldsVersion(fields map[string]interface{}) (int64, error) {
+
+	version, err := strconv.ParseInt(fmt.Sprint(fields[h.versionField]), 10, 64)
+	if err != nil {
+		return 0, InvalidVersionErr
+	}
+
+	return version, nil
+}
+
+func (h *CRUDHandler) setVersion(values []catrina.Value, version int64) {
+	for i, f := range h.fields {
+		if f == h.versionField {
+			values[i] = version
+		}
+	}
+}
+
+func etag(version int64) string {
+	return "\"" + strconv.FormatInt(version, 10) + "\""
+}
+
+// Whether an If-Match header matches the version: "*", or one of a
+// list of strong entity tags
+func ifMatch(header string, version int64) bool {
+
+	tag := etag(version)
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Checks the If-Match header of the request against the version of
+// the object, returning the version
+func (h *CRUDHandler) checkVersion(r *catrina.Request, object catrina.Object) (int64, *catrina.Response) {
+
+	version, err := h.version(object)
+	if err != nil {
+		return 0, catrina.NewResponse(http.StatusInternalServerError, catrina.EmptyBody, err)
+	}
+
+	if match := r.Headers.Get("If-Match"); match != "" && !ifMatch(match, version) {
+		return 0, catrina.NewResponse(http.StatusPreconditionFailed, catrina.EmptyBody, PreconditionFailedErr)
+	}
+
+	return version, nil
+}
+
+// Decodes a body into CRUD values, in field order. Parent ids override
+// whatever the body says about foreign keys, so that objects cannot be
+// created or moved under another parent.
+func (h *CRUDHandler) decode(codec Codec, payload catrina.Payload, parentIds []string) ([]catrina.Value, error) {
+
+	object := map[string]interface{}{}
+	err := codec.Decode(payload, &object)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.values(object, parentIds)
+}
+
+func (h *CRUDHandler) values(object map[string]interface{}, parentIds []string) ([]catrina.Value, error) {
+
+	if len(parentIds) < len(h.parentFields) {
+		return nil, errors.New("Missing parent ids")
+	}
+
+	for i, f := range h.parentFields {
+		object[f] = parentIds[i]
+	}
+
+	values := make([]catrina.Value, len(h.fields))
+	for i, f := range h.fields {
+		values[i] = object[f]
+	}
+
+	return values, nil
+}
+
+func (h *CRUDHandler) parentFilter(parentIds []string) []catrina.Filter {
+
+	filters := []catrina.Filter{}
+
+	for i, f := range h.parentFields {
+		if i < len(parentIds) {
+			filters = append(filters, catrina.Eq(f, parentIds[i]))
+		}
+	}
+
+	return filters
+}
+
+// Drains the channel, so that the CRUD implementation can release
+// its resources, and returns the first error found.
+func (h *CRUDHandler) collect(rows <-chan catrina.Row) ([]catrina.Object, error) {
+
+	var err error
+	objects := []catrina.Object{}
+
+	for row := range rows {
+		if row.Error != nil {
+			if err == nil {
+				err = row.Error
+			}
+			continue
+		}
+		objects = append(objects, row.Result)
+	}
+
+	return objects, err
+}
+
+func (h *CRUDHandler) isField(field string) bool {
+	if field == h.id {
+		return true
+	}
+	for _, f := range h.fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Cursor pointing after the given object: the values of the sort
+// fields and the id, read from the encoded object.
+func (h *CRUDHandler) cursor(sort []catrina.SortField, object catrina.Object) (string, error) {
+
+	fields, err := h.objectFields(object)
+	if err != nil {
+		return "", err
+	}
+
+	values := []catrina.Value{}
+	sortedById := false
+	for _, s := range sort {
+		values = append(values, fields[s.Field])
+		sortedById = sortedById || s.Field == h.id
+	}
+	if !sortedById {
+		values = append(values, fields[h.id])
+	}
+
+	return EncodeCursor(values)
+}
+
+// Selects an object by id, checking that it belongs to its parents
+func (h *CRUDHandler) find(r *catrina.Request) (catrina.Object, error) {
+
+	if len(h.parentFields) == 0 {
+		return h.crud.SelectContext(r.Context(), r.Id)
+	}
+
+	filters := append([]catrina.Filter{catrina.Eq(h.id, r.Id)}, h.parentFilter(r.ParentIds)...)
+	rows, err := h.crud.SelectWhereContext(r.Context(), catrina.And(filters...))
+	if err != nil {
+		return nil, err
+	}
+
+	objects, err := h.collect(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(objects) == 0 {
+		return nil, catrina.NotFoundErr
+	}
+
+	return objects[0], nil
+}
+
+// Public interface
+
+// Field types are unknown, so the schema only lists field names
+func (h *CRUDHandler) OpenAPI() OpenAPIResource {
+
+	properties := OpenAPISchema{h.id: OpenAPISchema{}}
+	for _, f := range h.fields {
+		properties[f] = OpenAPISchema{}
+	}
+
+	return OpenAPIResource{
+		Methods: []string{"Options", "Post", "Get", "GetMany", "Put", "Patch", "Delete"},
+		Schema:  OpenAPISchema{"type": "object", "properties": properties},
+	}
+}
+
+func (h *CRUDHandler) Options(r *catrina.Request) *catrina.Response {
+	return catrina.NewResponse(http.StatusOK, catrina.EmptyBody, nil).
+		WithHeader("Allow", "OPTIONS, POST, GET, HEAD, PUT, PATCH, DELETE")
+}
+
+func (h *CRUDHandler) Post(r *catrina.Request) *catrina.Response {
+
+	h, rs := h.negotiate(r, true)
+	if rs != nil {
+		return rs
+	}
+
+	payload, err := r.Payload()
+	if err != nil {
+		return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, err)
+	}
+
+	values, err := h.decode(h.in, payload, r.ParentIds)
+	if err != nil {
+		return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, err)
+	}
+
+	if h.versionField != "" {
+		h.setVersion(values, 1)
+	}
+
+	id, err := h.crud.InsertContext(r.Context(), values)
+	if err != nil {
+		return h.errorResponse(err)
+	}
+
+	object, err := h.crud.SelectContext(r.Context(), id)
+	if err != nil {
+		return h.errorResponse(err)
+	}
+
+	return h.encodeObject(http.StatusCreated, object)
+}
+
+func (h *CRUDHandler) Get(r *catrina.Request) *catrina.Response {
+
+	h, rs := h.negotiate(r, false)
+	if rs != nil {
+		return rs
+	}
+
+	object, err := h.find(r)
+	if err != nil {
+		return h.errorResponse(err)
+	}
+
+	return h.encodeObject(http.StatusOK, object)
+}
+
+func (h *CRUDHandler) GetMany(r *catrina.Request) *catrina.Response {
+
+	h, rs := h.negotiate(r, false)
+	if rs != nil {
+		return rs
+	}
+
+	filters := h.parentFilter(r.ParentIds)
+
+	// sorted, so that the same filters always give the same query
+	params := make([]string, 0, len(h.queryFields))
+	for param := range h.queryFields {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+
+	// a repeated parameter matches any of its values
+	for _, param := range params {
+		values := r.Query[param]
+		switch len(values) {
+		case 0:
+		case 1:
+			filters = append(filters, catrina.Eq(h.queryFields[param], values[0]))
+		default:
+			in := make([]catrina.Value, len(values))
+			for i, v := range values {
+				in[i] = v
+			}
+			filters = append(filters, catrina.In(h.queryFields[param], in...))
+		}
+	}
+
+	query, err := ParseQuery(r.Query, h.defaultLimit, h.maxLimit)
+	if err != nil {
+		return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, err)
+	}
+
+	for _, s := range query.Sort {
+		if !h.isField(s.Field) {
+			return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, errors.New("Unknown sort field "+s.Field))
+		}
+	}
+
+	// no filters list the whole collection
+	query.Filter = catrina.And(filters...)
+
+	rows, err := h.crud.SelectQueryContext(r.Context(), query)
+	if err != nil {
+		return h.errorResponse(err)
+	}
+
+	objects, err := h.collect(rows)
+	if err != nil {
+		return h.errorResponse(err)
+	}
+
+	rs = h.encode(http.StatusOK, objects)
+	if rs.Err != nil {
+		return rs
+	}
+
+	// a full page may be followed by another one
+	if query.Limit > 0 && len(objects) == query.Limit {
+		cursor, err := h.cursor(query.Sort, objects[len(objects)-1])
+		if err != nil {
+			return h.errorResponse(err)
+		}
+		rs.WithHeader(NextCursorHeader, cursor).WithHeader("Link", NextPageLink(r.Query, cursor))
+	}
+
+	if h.totalCount {
+		count, err := h.crud.CountContext(r.Context(), query.Filter)
+		if err != nil {
+			return h.errorResponse(err)
+		}
+		rs.WithHeader(TotalCountHeader, strconv.FormatInt(count, 10))
+	}
+
+	return rs
+}
+
+func (h *CRUDHandler) Put(r *catrina.Request) *catrina.Response {
+
+	h, rs := h.negotiate(r, true)
+	if rs != nil {
+		return rs
+	}
+
+	payload, err := r.Payload()
+	if err != nil {
+		return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, err)
+	}
+
+	values, err := h.decode(h.in, payload, r.ParentIds)
+	if err != nil {
+		return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, err)
+	}
+
+	// Update does not tell whether the object exists
+	object, err := h.find(r)
+	if err != nil {
+		return h.errorResponse(err)
+	}
+
+	return h.update(r, object, values)
+}
+
+// Applies a JSON Merge Patch or JSON Patch (see ApplyPatch) to the
+// encoded object. Only works with codecs producing JSON, and objects
+// encoded with field names as keys. The Content-Type tells the patch
+// format, and is not negotiated.
+func (h *CRUDHandler) Patch(r *catrina.Request) *catrina.Response {
+
+	h, rs := h.negotiate(r, false)
+	if rs != nil {
+		return rs
+	}
+
+	payload, err := r.Payload()
+	if err != nil {
+		return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, err)
+	}
+
+	object, err := h.find(r)
+	if err != nil {
+		return h.errorResponse(err)
+	}
+
+	doc, err := h.codec.Encode(object)
+	if err != nil {
+		return catrina.NewResponse(http.StatusInternalServerError, catrina.EmptyBody, err)
+	}
+
+	doc, err = ApplyPatch(r.Headers.Get("Content-Type"), doc, payload)
+	if err == PatchTestFailedErr {
+		return catrina.NewResponse(http.StatusConflict, catrina.EmptyBody, err)
+	}
+	if err != nil {
+		return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, err)
+	}
+
+	values, err := h.decode(h.codec, doc, r.ParentIds)
+	if err != nil {
+		return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, err)
+	}
+
+	return h.update(r, object, values)
+}
+
+// Updates the current object with values. With a version field, the
+// update only succeeds if the object has not changed since it was read.
+func (h *CRUDHandler) update(r *catrina.Request, current catrina.Object, values []catrina.Value) *catrina.Response {
+
+	var err error
+
+	if h.versionField == "" {
+		err = h.crud.UpdateContext(r.Context(), r.Id, values)
+	} else {
+		version, rs := h.checkVersion(r, current)
+		if rs != nil {
+			return rs
+		}
+		h.setVersion(values, version+1)
+		err = h.crud.UpdateIfVersionContext(r.Context(), r.Id, h.versionField, version, values)
+	}
+
+	if err != nil {
+		return h.writeErrorResponse(r, err)
+	}
+
+	object, err := h.crud.SelectContext(r.Context(), r.Id)
+	if err != nil {
+		return h.errorResponse(err)
+	}
+
+	return h.encodeObject(http.StatusOK, object)
+}
+
+// With a version field, the object is only deleted if it has not
+// changed since it was read, as updates are.
+func (h *CRUDHandler) Delete(r *catrina.Request) *catrina.Response {
+
+	object, err := h.find(r)
+	if err != nil {
+		return h.errorResponse(err)
+	}
+
+	if h.versionField == "" {
+		err = h.crud.DeleteContext(r.Context(), r.Id)
+	} else {
+		version, rs := h.checkVersion(r, object)
+		if rs != nil {
+			return rs
+		}
+		err = h.crud.DeleteIfVersionContext(r.Context(), r.Id, h.versionField, version)
+	}
+
+	if err != nil {
+		return h.writeErrorResponse(r, err)
+	}
+
+	return catrina.NewResponse(http.StatusNoContent, catrina.EmptyBody, nil)
+}
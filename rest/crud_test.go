@@ -0,0 +1,267 @@
+package rest_test
+
+import (
+	"fmt"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"net/http"
+	"encoding/json"
+
+	"github.com/buduchail/catrina"
+	"github.com/buduchail/catrina/crud"
+	"github.com/buduchail/catrina/rest"
+)
+
+type (
+	// Changes the first order right after it is read, as a concurrent
+	// request would
+	racingOrders struct {
+		*crud.MemoryCRUD
+	}
+)
+
+var orderFields = []string{"id", "user_id", "item", "version"}
+
+func (c racingOrders) SelectWhereContext(ctx context.Context, filter catrina.Filter) (<-chan catrina.Row, error) {
+
+	rows, err := c.MemoryCRUD.SelectWhereContext(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	object, err := c.MemoryCRUD.SelectContext(ctx, int64(1))
+	if err != nil {
+		return nil, err
+	}
+	version := object.(map[string]interface{})["version"].(int64)
+
+	return rows, c.MemoryCRUD.UpdateContext(ctx, int64(1), []catrina.Value{"1", "pen", version + 1})
+}
+
+// Orders of users, with a version field
+func newOrders(t *testing.T) (*crud.MemoryCRUD, *rest.CRUDHandler) {
+
+	orders, err := crud.NewMemoryCRUD(orderFields, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := rest.NewCRUDHandler(orders, orderFields, rest.DefaultCodecs()).
+		WithParentFields("user_id").
+		WithQueryFields("item").
+		WithVersionField("version").
+		WithPageLimits(2, 10).
+		WithTotalCount()
+
+	return orders, handler
+}
+
+// Request for an object of a user, with a JSON body unless empty
+func newRequest(method, userId, id, body string) *catrina.Request {
+
+	r := catrina.NewRequest(context.Background(), method)
+	r.Id = id
+	r.ParentIds = []string{userId}
+	if body != "" {
+		r.Headers.Set("Content-Type", "application/json")
+		r.Body = strings.NewReader(body)
+	}
+
+	return r
+}
+
+func decodeBody(t *testing.T, rs *catrina.Response, v interface{}) {
+	err := json.Unmarshal(rs.Body, v)
+	if err != nil {
+		t.Fatalf("%s: %v", rs.Body, err)
+	}
+}
+
+func order(id int64, userId, item string, version int64) map[string]interface{} {
+	return map[string]interface{}{"id": float64(id), "user_id": userId, "item": item, "version": float64(version)}
+}
+
+func TestCRUDHandler(t *testing.T) {
+
+	_, h := newOrders(t)
+
+	for _, item := range []string{"pen", "ink", "pen"} {
+		rs := h.Post(newRequest(http.MethodPost, "1", "", `{"item":"`+item+`","version":7,"user_id":"2"}`))
+		if rs.Code != http.StatusCreated || rs.Headers.Get("ETag") != `"1"` {
+			t.Fatalf("post: unexpected %d %s (%v)", rs.Code, rs.Body, rs.Err)
+		}
+	}
+	h.Post(newRequest(http.MethodPost, "2", "", `{"item":"cup"}`))
+
+	// the parent id and the version are set by the handler
+	rs := h.Get(newRequest(http.MethodGet, "1", "2", ""))
+	object := map[string]interface{}{}
+	decodeBody(t, rs, &object)
+	if rs.Code != http.StatusOK || !reflect.DeepEqual(object, order(2, "1", "ink", 1)) {
+		t.Errorf("get: unexpected %d %v", rs.Code, object)
+	}
+
+	// objects of other parents are not found
+	rs = h.Get(newRequest(http.MethodGet, "2", "2", ""))
+	if rs.Code != http.StatusNotFound {
+		t.Errorf("get of another user: expected 404, got %d", rs.Code)
+	}
+
+	r := newRequest(http.MethodGet, "1", "", "")
+	r.Query = catrina.QueryParameters{"item": {"pen"}, "sort": {"-id"}}
+	rs = h.GetMany(r)
+	objects := []map[string]interface{}{}
+	decodeBody(t, rs, &objects)
+	expected := []map[string]interface{}{order(3, "1", "pen", 1), order(1, "1", "pen", 1)}
+	if !reflect.DeepEqual(objects, expected) || rs.Headers.Get(rest.TotalCountHeader) != "2" {
+		t.Errorf("get many: unexpected %v (%v)", objects, rs.Headers)
+	}
+
+	// a full page links to the next one
+	r = newRequest(http.MethodGet, "1", "", "")
+	r.Query = catrina.QueryParameters{"limit": {"2"}}
+	rs = h.GetMany(r)
+	if rs.Headers.Get(rest.NextCursorHeader) == "" || rs.Headers.Get(rest.TotalCountHeader) != "3" {
+		t.Errorf("get many: expected a next page of 3 objects, got %v", rs.Headers)
+	}
+
+	r = newRequest(http.MethodGet, "1", "", "")
+	r.Query = catrina.QueryParameters{"sort": {"price"}}
+	if rs = h.GetMany(r); rs.Code != http.StatusBadRequest {
+		t.Errorf("get many by an unknown field: expected 400, got %d", rs.Code)
+	}
+
+	tests := []struct {
+		name        string
+		request     *catrina.Request
+		contentType string
+		ifMatch     string
+		expected    int
+		object      map[string]interface{}
+	}{
+		{"put", newRequest(http.MethodPut, "1", "1", `{"item":"mug"}`), "", "", http.StatusOK, order(1, "1", "mug", 2)},
+		{"put if match", newRequest(http.MethodPut, "1", "1", `{"item":"cup"}`), "", `"2"`, http.StatusOK, order(1, "1", "cup", 3)},
+		{"put if not match", newRequest(http.MethodPut, "1", "1", `{"item":"pen"}`), "", `"2"`, http.StatusPreconditionFailed, nil},
+		{"put of another user", newRequest(http.MethodPut, "2", "1", `{"item":"pen"}`), "", "", http.StatusNotFound, nil},
+		{"undecodable put", newRequest(http.MethodPut, "1", "1", `{"item":`), "", "", http.StatusBadRequest, nil},
+		{"merge patch", newRequest(http.MethodPatch, "1", "2", `{"item":"nib"}`), "", "*", http.StatusOK, order(2, "1", "nib", 2)},
+		{"json patch", newRequest(http.MethodPatch, "1", "2", `[{"op":"test","path":"/item","value":"nib"},{"op":"replace","path":"/item","value":"ink"}]`), rest.JSONPatchContentType, "", http.StatusOK, order(2, "1", "ink", 3)},
+		{"failed patch test", newRequest(http.MethodPatch, "1", "2", `[{"op":"test","path":"/item","value":"nib"}]`), rest.JSONPatchContentType, "", http.StatusConflict, nil},
+		{"delete if not match", newRequest(http.MethodDelete, "1", "3", ""), "", `"2"`, http.StatusPreconditionFailed, nil},
+		{"delete of another user", newRequest(http.MethodDelete, "2", "3", ""), "", "", http.StatusNotFound, nil},
+		{"delete if match", newRequest(http.MethodDelete, "1", "3", ""), "", `"1"`, http.StatusNoContent, nil},
+		{"delete again", newRequest(http.MethodDelete, "1", "3", ""), "", "", http.StatusNotFound, nil},
+	}
+
+	for _, test := range tests {
+
+		r := test.request
+		if test.contentType != "" {
+			r.Headers.Set("Content-Type", test.contentType)
+		}
+		if test.ifMatch != "" {
+			r.Headers.Set("If-Match", test.ifMatch)
+		}
+
+		var rs *catrina.Response
+		switch r.Method {
+		case http.MethodPut:
+			rs = h.Put(r)
+		case http.MethodPatch:
+			rs = h.Patch(r)
+		case http.MethodDelete:
+			rs = h.Delete(r)
+		}
+
+		if rs.Code != test.expected {
+			t.Errorf("%s: expected %d, got %d (%v)", test.name, test.expected, rs.Code, rs.Err)
+			continue
+		}
+		if test.object != nil {
+			object := map[string]interface{}{}
+			decodeBody(t, rs, &object)
+			tag := fmt.Sprintf(`"%v"`, test.object["version"])
+			if !reflect.DeepEqual(object, test.object) || rs.Headers.Get("ETag") != tag {
+				t.Errorf("%s: expected %v, got %v (ETag %s)", test.name, test.object, object, rs.Headers.Get("ETag"))
+			}
+		}
+	}
+}
+
+// Objects changed after the handler reads them are neither updated nor
+// deleted
+func TestCRUDHandlerConflicts(t *testing.T) {
+
+	orders, err := crud.NewMemoryCRUD(orderFields, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	insertOrders(t, orders, []catrina.Value{"1", "pen", int64(1)})
+
+	h := rest.NewCRUDHandler(racingOrders{orders}, orderFields, rest.JSONCodec{}).
+		WithParentFields("user_id").
+		WithVersionField("version")
+
+	tests := []struct {
+		name     string
+		request  *catrina.Request
+		ifMatch  string
+		expected int
+	}{
+		{"put", newRequest(http.MethodPut, "1", "1", `{"item":"mug"}`), "", http.StatusConflict},
+		{"put if match", newRequest(http.MethodPut, "1", "1", `{"item":"mug"}`), `"3"`, http.StatusPreconditionFailed},
+		{"delete", newRequest(http.MethodDelete, "1", "1", ""), "", http.StatusConflict},
+		{"delete if match", newRequest(http.MethodDelete, "1", "1", ""), `"5"`, http.StatusPreconditionFailed},
+	}
+
+	for _, test := range tests {
+
+		r := test.request
+		if test.ifMatch != "" {
+			r.Headers.Set("If-Match", test.ifMatch)
+		}
+
+		var rs *catrina.Response
+		if r.Method == http.MethodPut {
+			rs = h.Put(r)
+		} else {
+			rs = h.Delete(r)
+		}
+
+		if rs.Code != test.expected {
+			t.Errorf("%s: expected %d, got %d (%v)", test.name, test.expected, rs.Code, rs.Err)
+		}
+	}
+
+	object, err := orders.Select(int64(1))
+	if err != nil || object.(map[string]interface{})["item"] != "pen" {
+		t.Errorf("expected the order to be left as it was, got %v (%v)", object, err)
+	}
+}
+
+func TestCRUDHandlerNegotiation(t *testing.T) {
+
+	_, h := newOrders(t)
+
+	r := newRequest(http.MethodPost, "1", "", "item=pen")
+	r.Headers.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Headers.Set("Accept", "application/xml")
+	rs := h.Post(r)
+	if rs.Code != http.StatusCreated || rs.Headers.Get("Content-Type") != "application/xml" {
+		t.Errorf("form to xml: unexpected %d %s", rs.Code, rs.Headers.Get("Content-Type"))
+	}
+
+	r = newRequest(http.MethodPost, "1", "", "item,pen")
+	r.Headers.Set("Content-Type", "text/csv")
+	if rs = h.Post(r); rs.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("csv: expected 415, got %d", rs.Code)
+	}
+
+	r = newRequest(http.MethodGet, "1", "1", "")
+	r.Headers.Set("Accept", "text/csv")
+	if rs = h.Get(r); rs.Code != http.StatusNotAcceptable {
+		t.Errorf("csv: expected 406, got %d", rs.Code)
+	}
+}
@@ -1,18 +1,20 @@
 package rest
 
 import (
-	"bytes"
-	"strconv"
+	"context"
 	"net/http"
-	"io/ioutil"
 	"github.com/labstack/echo"
 	"github.com/buduchail/catrina"
 )
 
 type (
 	EchoAPI struct {
-		e      *echo.Echo
-		prefix string
+		e          *echo.Echo
+		prefix     string
+		middleware *middlewareChain
+		lifecycle  *lifecycle
+		spec       *openAPISpec
+		rendering  *errorRendering
 	}
 )
 
@@ -20,18 +22,13 @@ func NewEcho(prefix string) (api EchoAPI) {
 	api = EchoAPI{}
 	api.e = echo.New()
 	api.prefix = normalizePrefix(prefix)
+	api.middleware = newMiddlewareChain()
+	api.lifecycle = newLifecycle()
+	api.spec = newOpenAPISpec(api.prefix)
+	api.rendering = newErrorRendering()
 	return api
 }
 
-func (api EchoAPI) getBody(c echo.Context) catrina.Payload {
-	b, _ := ioutil.ReadAll(c.Request().Body)
-	return bytes.NewBuffer(b).Bytes()
-}
-
-func (api EchoAPI) getQueryParameters(c echo.Context) catrina.QueryParameters {
-	return catrina.QueryParameters(c.QueryParams())
-}
-
 func (api EchoAPI) getParentIds(c echo.Context, idParams []string) (ids []string) {
 	ids = make([]string, 0)
 	for _, id := range idParams {
@@ -41,81 +38,140 @@ func (api EchoAPI) getParentIds(c echo.Context, idParams []string) (ids []string
 	return ids
 }
 
-func (api EchoAPI) sendResponse(c echo.Context, code int, body catrina.Payload, err error) error {
+func (api EchoAPI) getRequest(c echo.Context, name string, id string, idParams []string) *catrina.Request {
+	return newRequest(c.Request(), name, id, api.getParentIds(c, idParams))
+}
+
+func (api EchoAPI) sendResponse(c echo.Context, rs *catrina.Response) error {
+	return writeResponse(c.Response(), c.Request(), rs, api.rendering)
+}
 
-	if code != http.StatusOK || err != nil {
-		if err == nil {
-			err = getHttpError(code)
+func (api EchoAPI) withMiddleware(route echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.SetRequest(withRequestValues(c.Request()))
+		err := api.middleware.apply(c.Response(), c.Request())
+		if err != nil {
+			return api.sendResponse(c, catrina.NewResponse(http.StatusInternalServerError, catrina.EmptyBody, err))
 		}
-		return c.String(code, err.Error())
+		return route(c)
 	}
-
-	return c.String(code, string(body))
 }
 
 func (api EchoAPI) AddResource(name string, handler catrina.ResourceHandler) {
+	api.AddContextResource(name, NewContextAdapter(handler))
+}
+
+func (api EchoAPI) AddContextResource(name string, handler catrina.ContextResourceHandler) {
+	api.AddResponseResource(name, NewResponseAdapter(handler))
+}
+
+func (api EchoAPI) AddResponseResource(name string, handler catrina.ResponseResourceHandler) {
+
+	api.spec.add(name, handler)
 
 	path, parentIdParams, idParam := expandPath(name, ":%s")
 
+	optionsRoute := func(c echo.Context) error {
+		rs := handler.Options(api.getRequest(c, name, c.Param(idParam), parentIdParams))
+		return api.sendResponse(c, rs)
+	}
+
 	postRoute := func(c echo.Context) error {
-		code, body, err := handler.Post(
-			api.getParentIds(c, parentIdParams),
-			api.getBody(c),
-		)
-		return api.sendResponse(c, code, body, err)
+		rs := handler.Post(api.getRequest(c, name, c.Param(idParam), parentIdParams))
+		return api.sendResponse(c, rs)
 	}
 
 	getRoute := func(c echo.Context) error {
-		code, body, err := handler.Get(
-			c.Param(idParam),
-			api.getParentIds(c, parentIdParams),
-		)
-		return api.sendResponse(c, code, body, err)
+		rs := handler.Get(api.getRequest(c, name, c.Param(idParam), parentIdParams))
+		return api.sendResponse(c, rs)
 	}
 
 	getManyRoute := func(c echo.Context) error {
-		code, body, err := handler.GetMany(
-			api.getParentIds(c, parentIdParams),
-			api.getQueryParameters(c),
-		)
-		return api.sendResponse(c, code, body, err)
+		rs := handler.GetMany(api.getRequest(c, name, c.Param(idParam), parentIdParams))
+		return api.sendResponse(c, rs)
 	}
 
 	putRoute := func(c echo.Context) error {
-		code, body, err := handler.Put(
-			c.Param(idParam),
-			api.getParentIds(c, parentIdParams),
-			api.getBody(c),
-		)
-		return api.sendResponse(c, code, body, err)
+		rs := handler.Put(api.getRequest(c, name, c.Param(idParam), parentIdParams))
+		return api.sendResponse(c, rs)
+	}
+
+	patchRoute := func(c echo.Context) error {
+		rs := handler.Patch(api.getRequest(c, name, c.Param(idParam), parentIdParams))
+		return api.sendResponse(c, rs)
+	}
+
+	headRoute := func(c echo.Context) error {
+		rs := headResponse(handler.Get(api.getRequest(c, name, c.Param(idParam), parentIdParams)))
+		return api.sendResponse(c, rs)
+	}
+
+	headManyRoute := func(c echo.Context) error {
+		rs := headResponse(handler.GetMany(api.getRequest(c, name, c.Param(idParam), parentIdParams)))
+		return api.sendResponse(c, rs)
 	}
 
 	deleteRoute := func(c echo.Context) error {
-		code, body, err := handler.Delete(
-			c.Param(idParam),
-			api.getParentIds(c, parentIdParams),
-		)
-		return api.sendResponse(c, code, body, err)
+		rs := handler.Delete(api.getRequest(c, name, c.Param(idParam), parentIdParams))
+		return api.sendResponse(c, rs)
 	}
 
 	fullPath := api.prefix + path
 
-	api.e.POST(fullPath, postRoute)
-	api.e.POST(fullPath+"/", postRoute)
+	api.e.OPTIONS(fullPath, api.withMiddleware(optionsRoute))
+	api.e.OPTIONS(fullPath+"/", api.withMiddleware(optionsRoute))
+	api.e.OPTIONS(fullPath+"/:"+idParam, api.withMiddleware(optionsRoute))
+
+	api.e.POST(fullPath, api.withMiddleware(postRoute))
+	api.e.POST(fullPath+"/", api.withMiddleware(postRoute))
+
+	api.e.GET(fullPath+"/:"+idParam, api.withMiddleware(getRoute))
+	api.e.GET(fullPath, api.withMiddleware(getManyRoute))
+	api.e.GET(fullPath+"/", api.withMiddleware(getManyRoute))
+
+	api.e.HEAD(fullPath+"/:"+idParam, api.withMiddleware(headRoute))
+	api.e.HEAD(fullPath, api.withMiddleware(headManyRoute))
+	api.e.HEAD(fullPath+"/", api.withMiddleware(headManyRoute))
 
-	api.e.GET(fullPath+"/:"+idParam, getRoute)
-	api.e.GET(fullPath, getManyRoute)
-	api.e.GET(fullPath+"/", getManyRoute)
+	api.e.PUT(fullPath+"/:"+idParam, api.withMiddleware(putRoute))
 
-	api.e.PUT(fullPath+"/:"+idParam, putRoute)
+	api.e.PATCH(fullPath+"/:"+idParam, api.withMiddleware(patchRoute))
 
-	api.e.DELETE(fullPath+"/:"+idParam, deleteRoute)
+	api.e.DELETE(fullPath+"/:"+idParam, api.withMiddleware(deleteRoute))
 }
 
 func (api EchoAPI) AddMiddleware(m catrina.Middleware) {
-	// NOT IMPLEMENTED
+	api.middleware.add(m)
+}
+
+func (api EchoAPI) OpenAPI(title, version string) (catrina.Payload, error) {
+	return api.spec.json(title, version)
+}
+
+func (api EchoAPI) ServeOpenAPI(path, title, version string) {
+	api.AddResponseResource(path, api.spec.handler(title, version))
 }
 
 func (api EchoAPI) Run(port int) {
-	api.e.Start(":" + strconv.Itoa(port))
+	api.Start(port)
+}
+
+func (api EchoAPI) Start(port int) error {
+	return api.lifecycle.serveHTTP(port, api.e)
+}
+
+func (api EchoAPI) Shutdown(ctx context.Context) error {
+	return api.lifecycle.stop(ctx)
+}
+
+func (api EchoAPI) Ready() <-chan struct{} {
+	return api.lifecycle.ready
+}
+
+func (api EchoAPI) OnShutdown(f func()) {
+	api.lifecycle.onShutdown(f)
+}
+
+func (api EchoAPI) SetErrorRenderer(renderer catrina.ErrorRenderer) {
+	api.rendering.set(renderer)
 }
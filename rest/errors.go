@@ -0,0 +1,52 @@
+package rest
+
+import (
+	"errors"
+	"context"
+	"net/http"
+
+	"github.com/buduchail/catrina"
+)
+
+// The errors ErrorStatus knows, whose messages are safe to send
+var errorKinds = []error{
+	catrina.NotFoundErr,
+	catrina.ConflictErr,
+	catrina.ValidationErr,
+	catrina.ForbiddenErr,
+	catrina.UnavailableErr,
+	context.DeadlineExceeded,
+}
+
+// Maps the error kinds of the catrina package to an HTTP status, and
+// any other error to 500.
+func ErrorStatus(err error) int {
+
+	switch {
+	case err == nil:
+		return http.StatusOK
+	// CRUDs written before NotFoundErr existed may still return their
+	// own error with the same text
+	case errors.Is(err, catrina.NotFoundErr) || err.Error() == catrina.NotFoundErr.Error():
+		return http.StatusNotFound
+	case errors.Is(err, catrina.ConflictErr):
+		return http.StatusConflict
+	case errors.Is(err, catrina.ValidationErr):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, catrina.ForbiddenErr):
+		return http.StatusForbidden
+	case errors.Is(err, catrina.UnavailableErr) || errors.Is(err, context.DeadlineExceeded):
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusInternalServerError
+}
+
+// Responses with an error and no status, or a plain 500, get the
+// status of the error, so that handlers can pass CRUD errors through.
+// A status chosen by the handler is kept.
+func resolveStatus(rs *catrina.Response) {
+	if rs.Err != nil && (rs.Code == 0 || rs.Code == http.StatusInternalServerError) {
+		rs.Code = ErrorStatus(rs.Err)
+	}
+}
@@ -0,0 +1,52 @@
+package rest_test
+
+import (
+	"fmt"
+	"errors"
+	"context"
+	"testing"
+	"net/http"
+
+	"github.com/buduchail/catrina"
+	"github.com/buduchail/catrina/rest"
+)
+
+func TestErrorStatus(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{"no error", nil, http.StatusOK},
+		{"not found", catrina.NotFoundErr, http.StatusNotFound},
+		{"conflict", catrina.ConflictErr, http.StatusConflict},
+		{"validation", catrina.ValidationErr, http.StatusUnprocessableEntity},
+		{"validation error", catrina.NewValidationError("Name is required"), http.StatusUnprocessableEntity},
+		{"forbidden", catrina.ForbiddenErr, http.StatusForbidden},
+		{"unavailable", catrina.UnavailableErr, http.StatusServiceUnavailable},
+		{"deadline", context.DeadlineExceeded, http.StatusServiceUnavailable},
+		{"other", errors.New("Table users doesn't exist"), http.StatusInternalServerError},
+		{"cancelled", context.Canceled, http.StatusInternalServerError},
+
+		// kinds are matched with errors.Is
+		{"wrapped not found", fmt.Errorf("user 1: %w", catrina.NotFoundErr), http.StatusNotFound},
+		{"wrapped twice", fmt.Errorf("load: %w", fmt.Errorf("user 1: %w", catrina.ConflictErr)), http.StatusConflict},
+		{"wrapped validation error", fmt.Errorf("decode: %w", catrina.NewValidationError("").WithField("name", "Is required")), http.StatusUnprocessableEntity},
+		{"wrapped unavailable", fmt.Errorf("%w: connection refused", catrina.UnavailableErr), http.StatusServiceUnavailable},
+		{"wrapped deadline", fmt.Errorf("query: %w", context.DeadlineExceeded), http.StatusServiceUnavailable},
+		{"joined", errors.Join(errors.New("rollback failed"), catrina.ForbiddenErr), http.StatusForbidden},
+
+		// CRUDs written before NotFoundErr return their own error with
+		// its text, which is only recognised unwrapped
+		{"legacy not found", errors.New("No rows found"), http.StatusNotFound},
+		{"wrapped legacy not found", fmt.Errorf("user 1: %w", errors.New("No rows found")), http.StatusInternalServerError},
+		{"legacy conflict", errors.New("Version conflict"), http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		if status := rest.ErrorStatus(test.err); status != test.expected {
+			t.Errorf("%s: expected %d, got %d", test.name, test.expected, status)
+		}
+	}
+}
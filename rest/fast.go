@@ -1,8 +1,10 @@
 package rest
 
 import (
+	"context"
+	"bytes"
 	"errors"
-	"strconv"
+	"strings"
 	"net/http"
 	"github.com/valyala/fasthttp"
 	"github.com/buduchail/catrina"
@@ -10,9 +12,21 @@ import (
 
 type (
 	FastAPI struct {
-		root      *pathHandler
-		prefix    string
-		prefixLen int
+		root       *pathHandler
+		prefix     string
+		prefixLen  int
+		middleware *middlewareChain
+		lifecycle  *lifecycle
+		spec       *openAPISpec
+		rendering  *errorRendering
+	}
+
+	// Minimal http.ResponseWriter that buffers whatever net/http-style
+	// middleware writes, so it can be copied into the fasthttp response.
+	fastResponseWriter struct {
+		header http.Header
+		code   int
+		body   bytes.Buffer
 	}
 )
 
@@ -21,62 +35,212 @@ func NewFast(prefix string) (api FastAPI) {
 	api.prefix = normalizePrefix(prefix)
 	api.prefixLen = len(api.prefix)
 	api.root = NewPathHandler(api.prefix)
+	api.middleware = newMiddlewareChain()
+	api.lifecycle = newLifecycle()
+	api.spec = newOpenAPISpec(api.prefix)
+	api.rendering = newErrorRendering()
 	return api
 }
 
-func (api FastAPI) getBody(ctx *fasthttp.RequestCtx) catrina.Payload {
-	return ctx.Request.Body()
-}
-
 func (api FastAPI) getQueryParameters(ctx *fasthttp.RequestCtx) catrina.QueryParameters {
 	params := catrina.QueryParameters{}
 	ctx.QueryArgs().VisitAll(func(key, value []byte) {
-		params[string(key)] = []string{string(value)}
+		params[string(key)] = append(params[string(key)], string(value))
 	})
 	return params
 }
 
-func (api FastAPI) sendResponse(ctx *fasthttp.RequestCtx, code int, body catrina.Payload, err error) error {
+func (api FastAPI) getHeaders(ctx *fasthttp.RequestCtx) http.Header {
+	headers := http.Header{}
+	ctx.Request.Header.VisitAll(func(key, value []byte) {
+		headers.Add(string(key), string(value))
+	})
+	return headers
+}
 
-	if code == http.StatusOK {
-		_, err = ctx.Write(body)
-	} else {
+// Builds the request passed to handlers. values is the context
+// holding whatever middleware stored with catrina.SetRequestValue.
+func (api FastAPI) getRequest(ctx *fasthttp.RequestCtx, values context.Context, name string, id string, parentIds []string) *catrina.Request {
+	rq := catrina.NewRequest(values, string(ctx.Method()))
+	rq.Id = id
+	rq.ParentIds = parentIds
+	rq.PathIds = getPathIds(name, id, parentIds)
+	rq.Query = api.getQueryParameters(ctx)
+	rq.Headers = api.getHeaders(ctx)
+	rq.RemoteAddr = ctx.RemoteAddr().String()
+	rq.Body = bytes.NewReader(ctx.Request.Body())
+	return rq
+}
+
+func newFastResponseWriter() *fastResponseWriter {
+	return &fastResponseWriter{header: http.Header{}}
+}
+
+func (w *fastResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *fastResponseWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *fastResponseWriter) WriteHeader(code int) {
+	w.code = code
+}
+
+// Builds a net/http request mirroring the fasthttp one, so that
+// catrina.Middleware can inspect and modify it.
+func (api FastAPI) toHttpRequest(ctx *fasthttp.RequestCtx) (*http.Request, error) {
+
+	r, err := http.NewRequest(
+		string(ctx.Method()),
+		string(ctx.RequestURI()),
+		bytes.NewReader(ctx.Request.Body()),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	r.Header = api.getHeaders(ctx)
+	r.Host = string(ctx.Host())
+	r.RemoteAddr = ctx.RemoteAddr().String()
+
+	return r.WithContext(catrina.WithRequestValues(ctx)), nil
+}
+
+// Runs the middleware chain through the net/http bridge and copies
+// any changes made to the request and response headers back into
+// the fasthttp context. Returns the context holding the values set
+// by middleware.
+func (api FastAPI) applyMiddleware(ctx *fasthttp.RequestCtx) (context.Context, error) {
+
+	if len(api.middleware.middleware) == 0 {
+		return ctx, nil
+	}
+
+	r, err := api.toHttpRequest(ctx)
+	if err != nil {
+		return ctx, err
+	}
+
+	original := r.Header.Clone()
+	w := newFastResponseWriter()
+
+	err = api.middleware.apply(w, r)
+
+	// only touch request headers that middleware actually changed
+	for k := range original {
+		if _, exists := r.Header[k]; !exists {
+			ctx.Request.Header.Del(k)
+		}
+	}
+	for k, values := range r.Header {
+		if strings.Join(values, "\n") == strings.Join(original[k], "\n") {
+			continue
+		}
+		ctx.Request.Header.Del(k)
+		for _, v := range values {
+			ctx.Request.Header.Add(k, v)
+		}
+	}
+
+	for k, values := range w.header {
+		ctx.Response.Header.Del(k)
+		for _, v := range values {
+			ctx.Response.Header.Add(k, v)
+		}
+	}
+
+	if w.code != 0 {
+		ctx.SetStatusCode(w.code)
+	}
+	if w.body.Len() > 0 {
+		ctx.Write(w.body.Bytes())
+	}
+
+	return r.Context(), err
+}
+
+func (api FastAPI) sendResponse(ctx *fasthttp.RequestCtx, rs *catrina.Response) (err error) {
+
+	if rs == nil {
+		rs = catrina.NewResponse(http.StatusInternalServerError, catrina.EmptyBody, nilResponseErr)
+	}
+
+	resolveStatus(rs)
+
+	// the net/http request is only built for errors to render
+	if api.rendering.wanted(string(ctx.Method()), rs) {
+		r, err := api.toHttpRequest(ctx)
 		if err == nil {
-			err = getHttpError(code)
+			rs = api.rendering.render(r, rs)
 		}
-		ctx.Error(err.Error(), code)
 	}
 
+	for k, values := range rs.Headers {
+		ctx.Response.Header.Del(k)
+		for _, v := range values {
+			ctx.Response.Header.Add(k, v)
+		}
+	}
+
+	// not using ctx.Error(), as it would discard
+	// headers set by middleware
+	ctx.SetStatusCode(rs.Code)
+
+	if rs.Stream != nil {
+		// fasthttp closes the stream once it has been sent
+		ctx.SetBodyStream(rs.Stream, -1)
+		return nil
+	}
+
+	_, err = ctx.Write(rs.Body)
+
 	return err
 }
 
-func (api FastAPI) handleResource(method string, id string, parentIds []string, ctx *fasthttp.RequestCtx, handler catrina.ResourceHandler) (code int, body catrina.Payload, err error) {
+func (api FastAPI) handleResource(method string, rq *catrina.Request, handler catrina.ResponseResourceHandler) *catrina.Response {
+
+	id := rq.Id
 
 	switch method {
+	case "OPTIONS":
+		return handler.Options(rq)
 	case "POST":
 		if id != "" {
-			return http.StatusBadRequest, catrina.EmptyBody, errors.New("POST requests must not provide an ID")
+			return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, errors.New("POST requests must not provide an ID"))
 		}
-		return handler.Post(parentIds, api.getBody(ctx))
+		return handler.Post(rq)
 	case "GET":
 		if id != "" {
-			return handler.Get(id, parentIds)
+			return handler.Get(rq)
+		} else {
+			return handler.GetMany(rq)
+		}
+	case "HEAD":
+		if id != "" {
+			return headResponse(handler.Get(rq))
 		} else {
-			return handler.GetMany(parentIds, api.getQueryParameters(ctx))
+			return headResponse(handler.GetMany(rq))
 		}
 	case "PUT":
 		if id == "" {
-			return http.StatusBadRequest, catrina.EmptyBody, errors.New("PUT method must provide an ID")
+			return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, errors.New("PUT method must provide an ID"))
+		}
+		return handler.Put(rq)
+	case "PATCH":
+		if id == "" {
+			return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, errors.New("PATCH method must provide an ID"))
 		}
-		return handler.Put(id, parentIds, api.getBody(ctx))
+		return handler.Patch(rq)
 	case "DELETE":
 		if id == "" {
-			return http.StatusBadRequest, catrina.EmptyBody, errors.New("DELETE method must provide an ID")
+			return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, errors.New("DELETE method must provide an ID"))
 		}
-		return handler.Delete(id, parentIds)
+		return handler.Delete(rq)
 	}
 
-	return http.StatusMethodNotAllowed, catrina.EmptyBody, errors.New("Method not allowed")
+	return catrina.NewResponse(http.StatusMethodNotAllowed, catrina.EmptyBody, errors.New("Method not allowed"))
 }
 
 func (api FastAPI) handle(ctx *fasthttp.RequestCtx) {
@@ -85,29 +249,94 @@ func (api FastAPI) handle(ctx *fasthttp.RequestCtx) {
 
 	if len(path) > api.prefixLen+1 {
 
-		handler, id, parentIds := api.root.findHandler(path[api.prefixLen:])
+		handler, name, id, parentIds := api.root.findHandler(path[api.prefixLen:])
 		if handler == nil {
-			api.sendResponse(ctx, http.StatusNotFound, catrina.EmptyBody, nil)
+			api.sendResponse(ctx, catrina.NewResponse(http.StatusNotFound, catrina.EmptyBody, nil))
 			return
 		}
 
-		code, body, err := api.handleResource(string(ctx.Method()), id, parentIds, ctx, handler)
-		api.sendResponse(ctx, code, body, err)
+		// apply middleware
+		values, err := api.applyMiddleware(ctx)
+		if err != nil {
+			api.sendResponse(ctx, catrina.NewResponse(http.StatusInternalServerError, catrina.EmptyBody, err))
+			return
+		}
+
+		// fasthttp.RequestCtx implements context.Context
+		rq := api.getRequest(ctx, values, name, id, parentIds)
+		rs := api.handleResource(string(ctx.Method()), rq, handler)
+		api.sendResponse(ctx, rs)
 
 	} else {
-		api.sendResponse(ctx, http.StatusNotFound, catrina.EmptyBody, nil)
+		api.sendResponse(ctx, catrina.NewResponse(http.StatusNotFound, catrina.EmptyBody, nil))
 	}
 }
 
 func (api FastAPI) AddResource(name string, handler catrina.ResourceHandler) {
+	api.AddContextResource(name, NewContextAdapter(handler))
+}
+
+func (api FastAPI) AddContextResource(name string, handler catrina.ContextResourceHandler) {
+	api.AddResponseResource(name, NewResponseAdapter(handler))
+}
+
+func (api FastAPI) AddResponseResource(name string, handler catrina.ResponseResourceHandler) {
+	api.spec.add(name, handler)
 	api.root.addHandler(name, handler)
 }
 
 func (api FastAPI) AddMiddleware(m catrina.Middleware) {
-	// NOT IMPLEMENTED
+	api.middleware.add(m)
+}
+
+func (api FastAPI) OpenAPI(title, version string) (catrina.Payload, error) {
+	return api.spec.json(title, version)
+}
+
+func (api FastAPI) ServeOpenAPI(path, title, version string) {
+	api.AddResponseResource(path, api.spec.handler(title, version))
 }
 
 func (api FastAPI) Run(port int) {
+	api.Start(port)
+}
+
+func (api FastAPI) Start(port int) error {
+
+	server := &fasthttp.Server{Handler: api.handle}
+
+	ln, err := api.lifecycle.listen(port, func(ctx context.Context) error {
+		// fasthttp's Shutdown does not take a deadline
+		done := make(chan error, 1)
+		go func() {
+			done <- server.Shutdown()
+		}()
+		select {
+		case err := <-done:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return server.Serve(ln)
+}
+
+func (api FastAPI) Shutdown(ctx context.Context) error {
+	return api.lifecycle.stop(ctx)
+}
+
+func (api FastAPI) Ready() <-chan struct{} {
+	return api.lifecycle.ready
+}
+
+func (api FastAPI) OnShutdown(f func()) {
+	api.lifecycle.onShutdown(f)
+}
 
-	fasthttp.ListenAndServe(":"+strconv.Itoa(port), api.handle)
+func (api FastAPI) SetErrorRenderer(renderer catrina.ErrorRenderer) {
+	api.rendering.set(renderer)
 }
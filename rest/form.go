@@ -0,0 +1,225 @@
+package rest
+
+import (
+	"fmt"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"net/url"
+	"encoding"
+	"encoding/json"
+
+	"github.com/buduchail/catrina"
+)
+
+type (
+	// application/x-www-form-urlencoded bodies. They are decoded into
+	// maps, with a string per field or a list of them if it is
+	// repeated, or into structs, whose fields are named as in JSON.
+	// Only objects can be encoded, with nested values as JSON.
+	FormCodec struct {
+	}
+)
+
+var (
+	formObjectErr = errors.New("Only objects can be form encoded")
+)
+
+func (c FormCodec) MediaType() string {
+	return "application/x-www-form-urlencoded"
+}
+
+func (c FormCodec) Encode(v interface{}) (catrina.Payload, error) {
+
+	generic, err := toGeneric(v)
+	if err != nil {
+		return nil, err
+	}
+
+	object, ok := generic.(map[string]interface{})
+	if !ok {
+		return nil, formObjectErr
+	}
+
+	values := url.Values{}
+	for k, v := range object {
+		list, isList := v.([]interface{})
+		if !isList {
+			values.Set(k, formValue(v))
+			continue
+		}
+		for _, item := range list {
+			values.Add(k, formValue(item))
+		}
+	}
+
+	return catrina.Payload(values.Encode()), nil
+}
+
+func (c FormCodec) Decode(payload catrina.Payload, v interface{}) error {
+
+	values, err := url.ParseQuery(strings.TrimSpace(string(payload)))
+	if err != nil {
+		return err
+	}
+
+	switch target := v.(type) {
+	case *map[string]interface{}:
+		*target = formObject(values)
+		return nil
+	case *interface{}:
+		*target = formObject(values)
+		return nil
+	}
+
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Ptr || target.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("Cannot decode a form into %T", v)
+	}
+
+	return decodeFormStruct(values, target.Elem())
+}
+
+// Helper functions
+
+func formValue(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return ""
+	case map[string]interface{}, []interface{}:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+	return fmt.Sprint(v)
+}
+
+func formObject(values url.Values) map[string]interface{} {
+
+	object := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		if len(v) == 1 {
+			object[k] = v[0]
+			continue
+		}
+		list := make([]interface{}, len(v))
+		for i, s := range v {
+			list[i] = s
+		}
+		object[k] = list
+	}
+
+	return object
+}
+
+func decodeFormStruct(values url.Values, target reflect.Value) error {
+
+	t := target.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+
+		f := t.Field(i)
+		tag, tagged := f.Tag.Lookup("json")
+		name, _, _ := strings.Cut(tag, ",")
+
+		if f.Anonymous && !tagged && f.Type.Kind() == reflect.Struct {
+			err := decodeFormStruct(values, target.Field(i))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !f.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		strs, ok := values[name]
+		if !ok || len(strs) == 0 {
+			continue
+		}
+
+		err := setFormValue(target.Field(i), strs)
+		if err != nil {
+			return fmt.Errorf("Invalid value for %s: %s", name, err)
+		}
+	}
+
+	return nil
+}
+
+// Repeated fields fill slices, other fields take the first value
+func setFormValue(field reflect.Value, strs []string) error {
+
+	if field.Kind() != reflect.Slice || field.Type().Elem().Kind() == reflect.Uint8 {
+		return setFormString(field, strs[0])
+	}
+
+	slice := reflect.MakeSlice(field.Type(), len(strs), len(strs))
+	for i, s := range strs {
+		err := setFormString(slice.Index(i), s)
+		if err != nil {
+			return err
+		}
+	}
+	field.Set(slice)
+
+	return nil
+}
+
+func setFormString(field reflect.Value, s string) error {
+
+	if field.Kind() == reflect.Ptr {
+		elem := reflect.New(field.Type().Elem())
+		err := setFormString(elem.Elem(), s)
+		if err != nil {
+			return err
+		}
+		field.Set(elem)
+		return nil
+	}
+
+	// e.g. time.Time
+	if unmarshaler, ok := field.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return unmarshaler.UnmarshalText([]byte(s))
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, err := strconv.ParseUint(s, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(i)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Interface:
+		field.Set(reflect.ValueOf(s))
+	case reflect.Slice:
+		field.SetBytes([]byte(s))
+	default:
+		return fmt.Errorf("Unsupported type %s", field.Type())
+	}
+
+	return nil
+}
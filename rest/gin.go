@@ -1,18 +1,20 @@
 package rest
 
 import (
-	"bytes"
-	"strconv"
+	"context"
 	"net/http"
-	"io/ioutil"
 	"github.com/gin-gonic/gin"
 	"github.com/buduchail/catrina"
 )
 
 type (
 	GinAPI struct {
-		g      *gin.Engine
-		prefix string
+		g          *gin.Engine
+		prefix     string
+		middleware *middlewareChain
+		lifecycle  *lifecycle
+		spec       *openAPISpec
+		rendering  *errorRendering
 	}
 )
 
@@ -21,18 +23,13 @@ func NewGin(prefix string) (api GinAPI) {
 	api = GinAPI{}
 	api.g = gin.New()
 	api.prefix = normalizePrefix(prefix)
+	api.middleware = newMiddlewareChain()
+	api.lifecycle = newLifecycle()
+	api.spec = newOpenAPISpec(api.prefix)
+	api.rendering = newErrorRendering()
 	return api
 }
 
-func (api GinAPI) getBody(c *gin.Context) catrina.Payload {
-	b, _ := ioutil.ReadAll(c.Request.Body)
-	return bytes.NewBuffer(b).Bytes()
-}
-
-func (api GinAPI) getQueryParameters(c *gin.Context) catrina.QueryParameters {
-	return catrina.QueryParameters(c.Request.URL.Query())
-}
-
 func (api GinAPI) getParentIds(c *gin.Context, idParams []string) (ids []string) {
 	ids = make([]string, 0)
 	for _, id := range idParams {
@@ -42,81 +39,141 @@ func (api GinAPI) getParentIds(c *gin.Context, idParams []string) (ids []string)
 	return ids
 }
 
-func (api GinAPI) sendResponse(c *gin.Context, code int, body catrina.Payload, err error) {
+func (api GinAPI) getRequest(c *gin.Context, name string, id string, idParams []string) *catrina.Request {
+	return newRequest(c.Request, name, id, api.getParentIds(c, idParams))
+}
+
+func (api GinAPI) sendResponse(c *gin.Context, rs *catrina.Response) {
+	writeResponse(c.Writer, c.Request, rs, api.rendering)
+}
 
-	if code != http.StatusOK || err != nil {
-		if err == nil {
-			err = getHttpError(code)
+func (api GinAPI) withMiddleware(route gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = withRequestValues(c.Request)
+		err := api.middleware.apply(c.Writer, c.Request)
+		if err != nil {
+			api.sendResponse(c, catrina.NewResponse(http.StatusInternalServerError, catrina.EmptyBody, err))
+			return
 		}
-		c.String(code, err.Error())
-	} else {
-		c.String(code, string(body))
+		route(c)
 	}
 }
 
 func (api GinAPI) AddResource(name string, handler catrina.ResourceHandler) {
+	api.AddContextResource(name, NewContextAdapter(handler))
+}
+
+func (api GinAPI) AddContextResource(name string, handler catrina.ContextResourceHandler) {
+	api.AddResponseResource(name, NewResponseAdapter(handler))
+}
+
+func (api GinAPI) AddResponseResource(name string, handler catrina.ResponseResourceHandler) {
+
+	api.spec.add(name, handler)
 
 	path, parentIdParams, idParam := expandPath(name, ":%s")
 
+	optionsRoute := func(c *gin.Context) {
+		rs := handler.Options(api.getRequest(c, name, c.Param(idParam), parentIdParams))
+		api.sendResponse(c, rs)
+	}
+
 	postRoute := func(c *gin.Context) {
-		code, body, err := handler.Post(
-			api.getParentIds(c, parentIdParams),
-			api.getBody(c),
-		)
-		api.sendResponse(c, code, body, err)
+		rs := handler.Post(api.getRequest(c, name, c.Param(idParam), parentIdParams))
+		api.sendResponse(c, rs)
 	}
 
 	getRoute := func(c *gin.Context) {
-		code, body, err := handler.Get(
-			c.Param(idParam),
-			api.getParentIds(c, parentIdParams),
-		)
-		api.sendResponse(c, code, body, err)
+		rs := handler.Get(api.getRequest(c, name, c.Param(idParam), parentIdParams))
+		api.sendResponse(c, rs)
 	}
 
 	getManyRoute := func(c *gin.Context) {
-		code, body, err := handler.GetMany(
-			api.getParentIds(c, parentIdParams),
-			api.getQueryParameters(c),
-		)
-		api.sendResponse(c, code, body, err)
+		rs := handler.GetMany(api.getRequest(c, name, c.Param(idParam), parentIdParams))
+		api.sendResponse(c, rs)
 	}
 
 	putRoute := func(c *gin.Context) {
-		code, body, err := handler.Put(
-			c.Param(idParam),
-			api.getParentIds(c, parentIdParams),
-			api.getBody(c),
-		)
-		api.sendResponse(c, code, body, err)
+		rs := handler.Put(api.getRequest(c, name, c.Param(idParam), parentIdParams))
+		api.sendResponse(c, rs)
+	}
+
+	patchRoute := func(c *gin.Context) {
+		rs := handler.Patch(api.getRequest(c, name, c.Param(idParam), parentIdParams))
+		api.sendResponse(c, rs)
+	}
+
+	headRoute := func(c *gin.Context) {
+		rs := headResponse(handler.Get(api.getRequest(c, name, c.Param(idParam), parentIdParams)))
+		api.sendResponse(c, rs)
+	}
+
+	headManyRoute := func(c *gin.Context) {
+		rs := headResponse(handler.GetMany(api.getRequest(c, name, c.Param(idParam), parentIdParams)))
+		api.sendResponse(c, rs)
 	}
 
 	deleteRoute := func(c *gin.Context) {
-		code, body, err := handler.Delete(
-			c.Param(idParam),
-			api.getParentIds(c, parentIdParams),
-		)
-		api.sendResponse(c, code, body, err)
+		rs := handler.Delete(api.getRequest(c, name, c.Param(idParam), parentIdParams))
+		api.sendResponse(c, rs)
 	}
 
 	fullPath := api.prefix + path
 
-	api.g.POST(fullPath, postRoute)
-	api.g.POST(fullPath+"/", postRoute)
+	api.g.OPTIONS(fullPath, api.withMiddleware(optionsRoute))
+	api.g.OPTIONS(fullPath+"/", api.withMiddleware(optionsRoute))
+	api.g.OPTIONS(fullPath+"/:"+idParam, api.withMiddleware(optionsRoute))
+
+	api.g.POST(fullPath, api.withMiddleware(postRoute))
+	api.g.POST(fullPath+"/", api.withMiddleware(postRoute))
+
+	api.g.GET(fullPath+"/:"+idParam, api.withMiddleware(getRoute))
+	api.g.GET(fullPath, api.withMiddleware(getManyRoute))
+	api.g.GET(fullPath+"/", api.withMiddleware(getManyRoute))
 
-	api.g.GET(fullPath+"/:"+idParam, getRoute)
-	api.g.GET(fullPath, getManyRoute)
-	api.g.GET(fullPath+"/", getManyRoute)
+	api.g.HEAD(fullPath+"/:"+idParam, api.withMiddleware(headRoute))
+	api.g.HEAD(fullPath, api.withMiddleware(headManyRoute))
+	api.g.HEAD(fullPath+"/", api.withMiddleware(headManyRoute))
 
-	api.g.PUT(fullPath+"/:"+idParam, putRoute)
+	api.g.PUT(fullPath+"/:"+idParam, api.withMiddleware(putRoute))
 
-	api.g.DELETE(fullPath+"/:"+idParam, deleteRoute)
+	api.g.PATCH(fullPath+"/:"+idParam, api.withMiddleware(patchRoute))
+
+	api.g.DELETE(fullPath+"/:"+idParam, api.withMiddleware(deleteRoute))
 }
 
 func (api GinAPI) AddMiddleware(m catrina.Middleware) {
-	// NOT IMPLEMENTED
+	api.middleware.add(m)
+}
+
+func (api GinAPI) OpenAPI(title, version string) (catrina.Payload, error) {
+	return api.spec.json(title, version)
+}
+
+func (api GinAPI) ServeOpenAPI(path, title, version string) {
+	api.AddResponseResource(path, api.spec.handler(title, version))
 }
 
 func (api GinAPI) Run(port int) {
-	api.g.Run(":" + strconv.Itoa(port))
+	api.Start(port)
+}
+
+func (api GinAPI) Start(port int) error {
+	return api.lifecycle.serveHTTP(port, api.g)
+}
+
+func (api GinAPI) Shutdown(ctx context.Context) error {
+	return api.lifecycle.stop(ctx)
+}
+
+func (api GinAPI) Ready() <-chan struct{} {
+	return api.lifecycle.ready
+}
+
+func (api GinAPI) OnShutdown(f func()) {
+	api.lifecycle.onShutdown(f)
+}
+
+func (api GinAPI) SetErrorRenderer(renderer catrina.ErrorRenderer) {
+	api.rendering.set(renderer)
 }
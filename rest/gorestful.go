@@ -1,18 +1,22 @@
 package rest
 
 import (
-	"bytes"
-	"strconv"
+	"context"
+	"strings"
 	"net/http"
-	"io/ioutil"
 	"github.com/emicklei/go-restful"
 	"github.com/buduchail/catrina"
 )
 
 type (
 	GoRestfulAPI struct {
-		container *restful.Container
-		prefix    string
+		container  *restful.Container
+		ws         *restful.WebService
+		prefix     string
+		middleware *middlewareChain
+		lifecycle  *lifecycle
+		spec       *openAPISpec
+		rendering  *errorRendering
 	}
 )
 
@@ -20,109 +24,167 @@ func NewGoRestful(prefix string) (api GoRestfulAPI) {
 	api = GoRestfulAPI{}
 	api.container = restful.NewContainer()
 	api.prefix = normalizePrefix(prefix)
+	// a single web service for all resources: go-restful does not allow
+	// two services sharing a root path, as users and users/*/orders would.
+	// It consumes and produces any media type, e.g. the patch formats or
+	// those of Codecs: handlers decide which ones they support, and
+	// answer 415 or 406 themselves.
+	api.ws = new(restful.WebService)
+	api.ws.Path(strings.TrimRight(api.prefix, "/")).
+		Produces("*/*")
+	api.container.Add(api.ws)
+	api.middleware = newMiddlewareChain()
+	api.lifecycle = newLifecycle()
+	api.spec = newOpenAPISpec(api.prefix)
+	api.rendering = newErrorRendering()
 	return api
 }
 
-func (api GoRestfulAPI) getBody(rq *restful.Request) catrina.Payload {
-	b, _ := ioutil.ReadAll(rq.Request.Body)
-	return bytes.NewBuffer(b).Bytes()
-}
-
-func (api GoRestfulAPI) getQueryParameters(rq *restful.Request) catrina.QueryParameters {
-	return catrina.QueryParameters(rq.Request.URL.Query())
-}
-
 func (api GoRestfulAPI) getParentIds(rq *restful.Request, idParams []string) (ids []string) {
 	ids = make([]string, 0)
 	for _, id := range idParams {
 		// prepend: /grandparent/1/parent/2/child/3 -> [2,1]
-		ids = append([]string{rq.Request.URL.Query().Get(id)}, ids...)
+		ids = append([]string{rq.PathParameter(id)}, ids...)
 	}
 	return ids
 }
 
-func (api GoRestfulAPI) sendResponse(rp *restful.Response, code int, body catrina.Payload, err error) {
+func (api GoRestfulAPI) getRequest(rq *restful.Request, name string, id string, idParams []string) *catrina.Request {
+	return newRequest(rq.Request, name, id, api.getParentIds(rq, idParams))
+}
 
-	if code != http.StatusOK || err != nil {
-		if err == nil {
-			err = getHttpError(code)
+func (api GoRestfulAPI) sendResponse(rq *restful.Request, rp *restful.Response, rs *catrina.Response) {
+	writeResponse(rp, rq.Request, rs, api.rendering)
+}
+
+func (api GoRestfulAPI) withMiddleware(route restful.RouteFunction) restful.RouteFunction {
+	return func(rq *restful.Request, rp *restful.Response) {
+		rq.Request = withRequestValues(rq.Request)
+		err := api.middleware.apply(rp, rq.Request)
+		if err != nil {
+			api.sendResponse(rq, rp, catrina.NewResponse(http.StatusInternalServerError, catrina.EmptyBody, err))
+			return
 		}
-		rp.WriteErrorString(code, err.Error())
-	} else {
-		rp.Write(body)
+		route(rq, rp)
 	}
 }
 
 func (api GoRestfulAPI) AddResource(name string, handler catrina.ResourceHandler) {
+	api.AddContextResource(name, NewContextAdapter(handler))
+}
+
+func (api GoRestfulAPI) AddContextResource(name string, handler catrina.ContextResourceHandler) {
+	api.AddResponseResource(name, NewResponseAdapter(handler))
+}
+
+func (api GoRestfulAPI) AddResponseResource(name string, handler catrina.ResponseResourceHandler) {
+
+	api.spec.add(name, handler)
 
 	path, parentIdParams, idParam := expandPath(name, "{%s}")
 
+	optionsRoute := func(rq *restful.Request, rp *restful.Response) {
+		rs := handler.Options(api.getRequest(rq, name, rq.PathParameter(idParam), parentIdParams))
+		api.sendResponse(rq, rp, rs)
+	}
+
 	postRoute := func(rq *restful.Request, rp *restful.Response) {
-		code, body, err := handler.Post(
-			api.getParentIds(rq, parentIdParams),
-			api.getBody(rq),
-		)
-		api.sendResponse(rp, code, body, err)
+		rs := handler.Post(api.getRequest(rq, name, rq.PathParameter(idParam), parentIdParams))
+		api.sendResponse(rq, rp, rs)
 	}
 
 	getRoute := func(rq *restful.Request, rp *restful.Response) {
-		code, body, err := handler.Get(
-			rq.PathParameter("id"),
-			api.getParentIds(rq, parentIdParams),
-		)
-		api.sendResponse(rp, code, body, err)
+		rs := handler.Get(api.getRequest(rq, name, rq.PathParameter(idParam), parentIdParams))
+		api.sendResponse(rq, rp, rs)
 	}
 
 	getManyRoute := func(rq *restful.Request, rp *restful.Response) {
-		code, body, err := handler.GetMany(
-			api.getParentIds(rq, parentIdParams),
-			api.getQueryParameters(rq),
-		)
-		api.sendResponse(rp, code, body, err)
+		rs := handler.GetMany(api.getRequest(rq, name, rq.PathParameter(idParam), parentIdParams))
+		api.sendResponse(rq, rp, rs)
 	}
 
 	putRoute := func(rq *restful.Request, rp *restful.Response) {
-		code, body, err := handler.Put(
-			rq.PathParameter("id"),
-			api.getParentIds(rq, parentIdParams),
-			api.getBody(rq),
-		)
-		api.sendResponse(rp, code, body, err)
+		rs := handler.Put(api.getRequest(rq, name, rq.PathParameter(idParam), parentIdParams))
+		api.sendResponse(rq, rp, rs)
+	}
+
+	patchRoute := func(rq *restful.Request, rp *restful.Response) {
+		rs := handler.Patch(api.getRequest(rq, name, rq.PathParameter(idParam), parentIdParams))
+		api.sendResponse(rq, rp, rs)
+	}
+
+	headRoute := func(rq *restful.Request, rp *restful.Response) {
+		rs := headResponse(handler.Get(api.getRequest(rq, name, rq.PathParameter(idParam), parentIdParams)))
+		api.sendResponse(rq, rp, rs)
+	}
+
+	headManyRoute := func(rq *restful.Request, rp *restful.Response) {
+		rs := headResponse(handler.GetMany(api.getRequest(rq, name, rq.PathParameter(idParam), parentIdParams)))
+		api.sendResponse(rq, rp, rs)
 	}
 
 	deleteRoute := func(rq *restful.Request, rp *restful.Response) {
-		code, body, err := handler.Delete(
-			rq.PathParameter("id"),
-			api.getParentIds(rq, parentIdParams),
-		)
-		api.sendResponse(rp, code, body, err)
+		rs := handler.Delete(api.getRequest(rq, name, rq.PathParameter(idParam), parentIdParams))
+		api.sendResponse(rq, rp, rs)
 	}
 
-	ws := new(restful.WebService)
+	ws := api.ws
+	fullPath := "/" + path
+
+	ws.Route(ws.Method("OPTIONS").Path(fullPath).To(api.withMiddleware(optionsRoute)))
+	ws.Route(ws.Method("OPTIONS").Path(fullPath + "/").To(api.withMiddleware(optionsRoute)))
+	ws.Route(ws.Method("OPTIONS").Path(fullPath + "/{" + idParam + "}").To(api.withMiddleware(optionsRoute)))
 
-	ws.Path(api.prefix + path + "/").
-		Consumes(restful.MIME_JSON).
-		Produces(restful.MIME_JSON)
+	ws.Route(ws.POST(fullPath).To(api.withMiddleware(postRoute)))
+	ws.Route(ws.POST(fullPath + "/").To(api.withMiddleware(postRoute)))
 
-	ws.Route(ws.POST("").To(postRoute))
-	ws.Route(ws.POST("/").To(postRoute))
+	ws.Route(ws.GET(fullPath + "/{" + idParam + "}").To(api.withMiddleware(getRoute)))
+	ws.Route(ws.GET(fullPath).To(api.withMiddleware(getManyRoute)))
+	ws.Route(ws.GET(fullPath + "/").To(api.withMiddleware(getManyRoute)))
 
-	ws.Route(ws.GET("/{" + idParam + "}").To(getRoute))
-	ws.Route(ws.GET("").To(getManyRoute))
-	ws.Route(ws.GET("/").To(getManyRoute))
+	ws.Route(ws.HEAD(fullPath + "/{" + idParam + "}").To(api.withMiddleware(headRoute)))
+	ws.Route(ws.HEAD(fullPath).To(api.withMiddleware(headManyRoute)))
+	ws.Route(ws.HEAD(fullPath + "/").To(api.withMiddleware(headManyRoute)))
 
-	ws.Route(ws.PUT("/{" + idParam + "}").To(putRoute))
+	ws.Route(ws.PUT(fullPath + "/{" + idParam + "}").To(api.withMiddleware(putRoute)))
 
-	ws.Route(ws.DELETE("/{" + idParam + "}").To(deleteRoute))
+	ws.Route(ws.PATCH(fullPath + "/{" + idParam + "}").To(api.withMiddleware(patchRoute)))
 
-	api.container.Add(ws)
+	ws.Route(ws.DELETE(fullPath + "/{" + idParam + "}").To(api.withMiddleware(deleteRoute)))
 }
 
 func (api GoRestfulAPI) AddMiddleware(m catrina.Middleware) {
-	// NOT IMPLEMENTED
+	api.middleware.add(m)
+}
+
+func (api GoRestfulAPI) OpenAPI(title, version string) (catrina.Payload, error) {
+	return api.spec.json(title, version)
+}
+
+func (api GoRestfulAPI) ServeOpenAPI(path, title, version string) {
+	api.AddResponseResource(path, api.spec.handler(title, version))
 }
 
 func (api GoRestfulAPI) Run(port int) {
-	server := &http.Server{Addr: ":" + strconv.Itoa(port), Handler: api.container}
-	server.ListenAndServe()
+	api.Start(port)
+}
+
+func (api GoRestfulAPI) Start(port int) error {
+	return api.lifecycle.serveHTTP(port, api.container)
+}
+
+func (api GoRestfulAPI) Shutdown(ctx context.Context) error {
+	return api.lifecycle.stop(ctx)
+}
+
+func (api GoRestfulAPI) Ready() <-chan struct{} {
+	return api.lifecycle.ready
+}
+
+func (api GoRestfulAPI) OnShutdown(f func()) {
+	api.lifecycle.onShutdown(f)
+}
+
+func (api GoRestfulAPI) SetErrorRenderer(renderer catrina.ErrorRenderer) {
+	api.rendering.set(renderer)
 }
@@ -1,18 +1,20 @@
 package rest
 
 import (
-	"bytes"
-	"strconv"
+	"context"
 	"net/http"
-	"io/ioutil"
 	"github.com/julienschmidt/httprouter"
 	"github.com/buduchail/catrina"
 )
 
 type (
 	HttpRouterAPI struct {
-		r      *httprouter.Router
-		prefix string
+		r          *httprouter.Router
+		prefix     string
+		middleware *middlewareChain
+		lifecycle  *lifecycle
+		spec       *openAPISpec
+		rendering  *errorRendering
 	}
 )
 
@@ -20,18 +22,13 @@ func NewHttpRouter(prefix string) (api HttpRouterAPI) {
 	api = HttpRouterAPI{}
 	api.r = httprouter.New()
 	api.prefix = normalizePrefix(prefix)
+	api.middleware = newMiddlewareChain()
+	api.lifecycle = newLifecycle()
+	api.spec = newOpenAPISpec(api.prefix)
+	api.rendering = newErrorRendering()
 	return api
 }
 
-func (api HttpRouterAPI) getBody(r *http.Request) catrina.Payload {
-	b, _ := ioutil.ReadAll(r.Body)
-	return bytes.NewBuffer(b).Bytes()
-}
-
-func (api HttpRouterAPI) getQueryParameters(r *http.Request) catrina.QueryParameters {
-	return catrina.QueryParameters(r.URL.Query())
-}
-
 func (api HttpRouterAPI) getParentIds(ps httprouter.Params, idParams []string) (ids []string) {
 	ids = make([]string, 0)
 	for _, id := range idParams {
@@ -41,80 +38,141 @@ func (api HttpRouterAPI) getParentIds(ps httprouter.Params, idParams []string) (
 	return ids
 }
 
-func (api HttpRouterAPI) sendResponse(w http.ResponseWriter, code int, body catrina.Payload, err error) {
-	if code != http.StatusOK || err != nil {
-		if err == nil {
-			err = getHttpError(code)
+func (api HttpRouterAPI) getRequest(r *http.Request, ps httprouter.Params, name string, id string, idParams []string) *catrina.Request {
+	return newRequest(r, name, id, api.getParentIds(ps, idParams))
+}
+
+func (api HttpRouterAPI) sendResponse(w http.ResponseWriter, r *http.Request, rs *catrina.Response) {
+	writeResponse(w, r, rs, api.rendering)
+}
+
+func (api HttpRouterAPI) withMiddleware(route httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		r = withRequestValues(r)
+		err := api.middleware.apply(w, r)
+		if err != nil {
+			api.sendResponse(w, r, catrina.NewResponse(http.StatusInternalServerError, catrina.EmptyBody, err))
+			return
 		}
-		http.Error(w, err.Error(), code)
-	} else {
-		w.Write(body)
+		route(w, r, ps)
 	}
 }
 
 func (api HttpRouterAPI) AddResource(name string, handler catrina.ResourceHandler) {
+	api.AddContextResource(name, NewContextAdapter(handler))
+}
+
+func (api HttpRouterAPI) AddContextResource(name string, handler catrina.ContextResourceHandler) {
+	api.AddResponseResource(name, NewResponseAdapter(handler))
+}
+
+func (api HttpRouterAPI) AddResponseResource(name string, handler catrina.ResponseResourceHandler) {
+
+	api.spec.add(name, handler)
 
 	path, parentIdParams, idParam := expandPath(name, ":%s")
 
+	optionsRoute := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		rs := handler.Options(api.getRequest(r, ps, name, ps.ByName(idParam), parentIdParams))
+		api.sendResponse(w, r, rs)
+	}
+
 	postRoute := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		code, body, err := handler.Post(
-			api.getParentIds(ps, parentIdParams),
-			api.getBody(r),
-		)
-		api.sendResponse(w, code, body, err)
+		rs := handler.Post(api.getRequest(r, ps, name, ps.ByName(idParam), parentIdParams))
+		api.sendResponse(w, r, rs)
 	}
 
 	getRoute := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		code, body, err := handler.Get(
-			ps.ByName(idParam),
-			api.getParentIds(ps, parentIdParams),
-		)
-		api.sendResponse(w, code, body, err)
+		rs := handler.Get(api.getRequest(r, ps, name, ps.ByName(idParam), parentIdParams))
+		api.sendResponse(w, r, rs)
 	}
 
 	getManyRoute := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		code, body, err := handler.GetMany(
-			api.getParentIds(ps, parentIdParams),
-			api.getQueryParameters(r),
-		)
-		api.sendResponse(w, code, body, err)
+		rs := handler.GetMany(api.getRequest(r, ps, name, ps.ByName(idParam), parentIdParams))
+		api.sendResponse(w, r, rs)
 	}
 
 	putRoute := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		code, body, err := handler.Put(
-			ps.ByName(idParam),
-			api.getParentIds(ps, parentIdParams),
-			api.getBody(r),
-		)
-		api.sendResponse(w, code, body, err)
+		rs := handler.Put(api.getRequest(r, ps, name, ps.ByName(idParam), parentIdParams))
+		api.sendResponse(w, r, rs)
+	}
+
+	patchRoute := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		rs := handler.Patch(api.getRequest(r, ps, name, ps.ByName(idParam), parentIdParams))
+		api.sendResponse(w, r, rs)
+	}
+
+	headRoute := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		rs := headResponse(handler.Get(api.getRequest(r, ps, name, ps.ByName(idParam), parentIdParams)))
+		api.sendResponse(w, r, rs)
+	}
+
+	headManyRoute := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		rs := headResponse(handler.GetMany(api.getRequest(r, ps, name, ps.ByName(idParam), parentIdParams)))
+		api.sendResponse(w, r, rs)
 	}
 
 	deleteRoute := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		code, body, err := handler.Delete(
-			ps.ByName(idParam),
-			api.getParentIds(ps, parentIdParams),
-		)
-		api.sendResponse(w, code, body, err)
+		rs := handler.Delete(api.getRequest(r, ps, name, ps.ByName(idParam), parentIdParams))
+		api.sendResponse(w, r, rs)
 	}
 
 	fullPath := api.prefix + path
 
-	api.r.POST(fullPath, postRoute)
-	api.r.POST(fullPath+"/", postRoute)
+	api.r.OPTIONS(fullPath, api.withMiddleware(optionsRoute))
+	api.r.OPTIONS(fullPath+"/", api.withMiddleware(optionsRoute))
+	api.r.OPTIONS(fullPath+"/:"+idParam, api.withMiddleware(optionsRoute))
+
+	api.r.POST(fullPath, api.withMiddleware(postRoute))
+	api.r.POST(fullPath+"/", api.withMiddleware(postRoute))
+
+	api.r.GET(fullPath+"/:"+idParam, api.withMiddleware(getRoute))
+	api.r.GET(fullPath+"", api.withMiddleware(getManyRoute))
+	api.r.GET(fullPath+"/", api.withMiddleware(getManyRoute))
+
+	api.r.HEAD(fullPath+"/:"+idParam, api.withMiddleware(headRoute))
+	api.r.HEAD(fullPath+"", api.withMiddleware(headManyRoute))
+	api.r.HEAD(fullPath+"/", api.withMiddleware(headManyRoute))
 
-	api.r.GET(fullPath+"/:"+idParam, getRoute)
-	api.r.GET(fullPath+"", getManyRoute)
-	api.r.GET(fullPath+"/", getManyRoute)
+	api.r.PUT(fullPath+"/:"+idParam, api.withMiddleware(putRoute))
 
-	api.r.PUT(fullPath+"/:"+idParam, putRoute)
+	api.r.PATCH(fullPath+"/:"+idParam, api.withMiddleware(patchRoute))
 
-	api.r.DELETE(fullPath+"/:"+idParam, deleteRoute)
+	api.r.DELETE(fullPath+"/:"+idParam, api.withMiddleware(deleteRoute))
 }
 
 func (api HttpRouterAPI) AddMiddleware(m catrina.Middleware) {
-	// NOT IMPLEMENTED
+	api.middleware.add(m)
+}
+
+func (api HttpRouterAPI) OpenAPI(title, version string) (catrina.Payload, error) {
+	return api.spec.json(title, version)
+}
+
+func (api HttpRouterAPI) ServeOpenAPI(path, title, version string) {
+	api.AddResponseResource(path, api.spec.handler(title, version))
 }
 
 func (api HttpRouterAPI) Run(port int) {
-	http.ListenAndServe(":"+strconv.Itoa(port), api.r)
+	api.Start(port)
+}
+
+func (api HttpRouterAPI) Start(port int) error {
+	return api.lifecycle.serveHTTP(port, api.r)
+}
+
+func (api HttpRouterAPI) Shutdown(ctx context.Context) error {
+	return api.lifecycle.stop(ctx)
+}
+
+func (api HttpRouterAPI) Ready() <-chan struct{} {
+	return api.lifecycle.ready
+}
+
+func (api HttpRouterAPI) OnShutdown(f func()) {
+	api.lifecycle.onShutdown(f)
+}
+
+func (api HttpRouterAPI) SetErrorRenderer(renderer catrina.ErrorRenderer) {
+	api.rendering.set(renderer)
 }
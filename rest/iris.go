@@ -1,10 +1,8 @@
 package rest
 
 import (
-	"bytes"
-	"strconv"
+	"context"
 	"net/http"
-	"io/ioutil"
 	"gopkg.in/kataras/iris.v6"
 	"gopkg.in/kataras/iris.v6/adaptors/httprouter"
 	"github.com/buduchail/catrina"
@@ -12,8 +10,12 @@ import (
 
 type (
 	IrisAPI struct {
-		i      *iris.Framework
-		prefix string
+		i          *iris.Framework
+		prefix     string
+		middleware *middlewareChain
+		lifecycle  *lifecycle
+		spec       *openAPISpec
+		rendering  *errorRendering
 	}
 )
 
@@ -22,24 +24,13 @@ func NewIris(prefix string) (api IrisAPI) {
 	api.i = iris.New()
 	api.i.Adapt(httprouter.New())
 	api.prefix = normalizePrefix(prefix)
+	api.middleware = newMiddlewareChain()
+	api.lifecycle = newLifecycle()
+	api.spec = newOpenAPISpec(api.prefix)
+	api.rendering = newErrorRendering()
 	return api
 }
 
-func (api IrisAPI) getBody(c *iris.Context) catrina.Payload {
-	b, _ := ioutil.ReadAll(c.Request.Body)
-	return bytes.NewBuffer(b).Bytes()
-}
-
-func (api IrisAPI) getQueryParameters(c *iris.Context) catrina.QueryParameters {
-	params := catrina.QueryParameters{}
-	if nil != c.URLParamsAsMulti() {
-		for k, v := range c.URLParamsAsMulti() {
-			params[k] = v
-		}
-	}
-	return params
-}
-
 func (api IrisAPI) getParentIds(c *iris.Context, idParams []string) (ids []string) {
 	ids = make([]string, 0)
 	for _, id := range idParams {
@@ -49,77 +40,152 @@ func (api IrisAPI) getParentIds(c *iris.Context, idParams []string) (ids []strin
 	return ids
 }
 
-func (api IrisAPI) sendResponse(c *iris.Context, code int, body catrina.Payload, err error) {
-	if code != http.StatusOK || err != nil {
-		c.EmitError(code)
-	} else {
-		c.Writef(string(body))
+func (api IrisAPI) getRequest(c *iris.Context, name string, id string, idParams []string) *catrina.Request {
+	return newRequest(c.Request, name, id, api.getParentIds(c, idParams))
+}
+
+func (api IrisAPI) sendResponse(c *iris.Context, rs *catrina.Response) {
+	writeResponse(c.ResponseWriter, c.Request, rs, api.rendering)
+}
+
+func (api IrisAPI) withMiddleware(route iris.HandlerFunc) iris.HandlerFunc {
+	return func(c *iris.Context) {
+		c.Request = withRequestValues(c.Request)
+		err := api.middleware.apply(c.ResponseWriter, c.Request)
+		if err != nil {
+			api.sendResponse(c, catrina.NewResponse(http.StatusInternalServerError, catrina.EmptyBody, err))
+			return
+		}
+		route(c)
 	}
 }
 
 func (api IrisAPI) AddResource(name string, handler catrina.ResourceHandler) {
+	api.AddContextResource(name, NewContextAdapter(handler))
+}
+
+func (api IrisAPI) AddContextResource(name string, handler catrina.ContextResourceHandler) {
+	api.AddResponseResource(name, NewResponseAdapter(handler))
+}
+
+func (api IrisAPI) AddResponseResource(name string, handler catrina.ResponseResourceHandler) {
+
+	api.spec.add(name, handler)
 
 	path, parentIdParams, idParam := expandPath(name, ":%s")
 
+	optionsRoute := func(c *iris.Context) {
+		rs := handler.Options(api.getRequest(c, name, c.Param(idParam), parentIdParams))
+		api.sendResponse(c, rs)
+	}
+
 	postRoute := func(c *iris.Context) {
-		code, body, err := handler.Post(
-			[]string{},
-			api.getBody(c),
-		)
-		api.sendResponse(c, code, body, err)
+		rs := handler.Post(api.getRequest(c, name, c.Param(idParam), parentIdParams))
+		api.sendResponse(c, rs)
 	}
 
 	getRoute := func(c *iris.Context) {
-		code, body, err := handler.Get(
-			c.Param(idParam),
-			api.getParentIds(c, parentIdParams),
-		)
-		api.sendResponse(c, code, body, err)
+		rs := handler.Get(api.getRequest(c, name, c.Param(idParam), parentIdParams))
+		api.sendResponse(c, rs)
 	}
 
 	getManyRoute := func(c *iris.Context) {
-		code, body, err := handler.GetMany(
-			api.getParentIds(c, parentIdParams),
-			api.getQueryParameters(c),
-		)
-		api.sendResponse(c, code, body, err)
+		rs := handler.GetMany(api.getRequest(c, name, c.Param(idParam), parentIdParams))
+		api.sendResponse(c, rs)
 	}
 
 	putRoute := func(c *iris.Context) {
-		code, body, err := handler.Put(
-			c.Param(idParam),
-			api.getParentIds(c, parentIdParams),
-			api.getBody(c),
-		)
-		api.sendResponse(c, code, body, err)
+		rs := handler.Put(api.getRequest(c, name, c.Param(idParam), parentIdParams))
+		api.sendResponse(c, rs)
+	}
+
+	patchRoute := func(c *iris.Context) {
+		rs := handler.Patch(api.getRequest(c, name, c.Param(idParam), parentIdParams))
+		api.sendResponse(c, rs)
+	}
+
+	headRoute := func(c *iris.Context) {
+		rs := headResponse(handler.Get(api.getRequest(c, name, c.Param(idParam), parentIdParams)))
+		api.sendResponse(c, rs)
+	}
+
+	headManyRoute := func(c *iris.Context) {
+		rs := headResponse(handler.GetMany(api.getRequest(c, name, c.Param(idParam), parentIdParams)))
+		api.sendResponse(c, rs)
 	}
 
 	deleteRoute := func(c *iris.Context) {
-		code, body, err := handler.Delete(
-			c.Param(idParam),
-			api.getParentIds(c, parentIdParams),
-		)
-		api.sendResponse(c, code, body, err)
+		rs := handler.Delete(api.getRequest(c, name, c.Param(idParam), parentIdParams))
+		api.sendResponse(c, rs)
 	}
 
 	fullPath := api.prefix + path
 
-	api.i.Post(fullPath, postRoute)
-	api.i.Post(fullPath+"/", postRoute)
+	api.i.Options(fullPath, api.withMiddleware(optionsRoute))
+	api.i.Options(fullPath+"/", api.withMiddleware(optionsRoute))
+	api.i.Options(fullPath+"/:"+idParam, api.withMiddleware(optionsRoute))
+
+	api.i.Post(fullPath, api.withMiddleware(postRoute))
+	api.i.Post(fullPath+"/", api.withMiddleware(postRoute))
+
+	api.i.Get(fullPath+"/:"+idParam, api.withMiddleware(getRoute))
+	api.i.Get(fullPath+"", api.withMiddleware(getManyRoute))
+	api.i.Get(fullPath+"/", api.withMiddleware(getManyRoute))
 
-	api.i.Get(fullPath+"/:"+idParam, getRoute)
-	api.i.Get(fullPath+"", getManyRoute)
-	api.i.Get(fullPath+"/", getManyRoute)
+	api.i.Head(fullPath+"/:"+idParam, api.withMiddleware(headRoute))
+	api.i.Head(fullPath+"", api.withMiddleware(headManyRoute))
+	api.i.Head(fullPath+"/", api.withMiddleware(headManyRoute))
 
-	api.i.Put(fullPath+"/:"+idParam, putRoute)
+	api.i.Put(fullPath+"/:"+idParam, api.withMiddleware(putRoute))
 
-	api.i.Delete(fullPath+"/:"+idParam, deleteRoute)
+	api.i.Patch(fullPath+"/:"+idParam, api.withMiddleware(patchRoute))
+
+	api.i.Delete(fullPath+"/:"+idParam, api.withMiddleware(deleteRoute))
 }
 
 func (api IrisAPI) AddMiddleware(m catrina.Middleware) {
-	// NOT IMPLEMENTED
+	api.middleware.add(m)
+}
+
+func (api IrisAPI) OpenAPI(title, version string) (catrina.Payload, error) {
+	return api.spec.json(title, version)
+}
+
+func (api IrisAPI) ServeOpenAPI(path, title, version string) {
+	api.AddResponseResource(path, api.spec.handler(title, version))
 }
 
 func (api IrisAPI) Run(port int) {
-	api.i.Listen(":" + strconv.Itoa(port))
+	api.Start(port)
+}
+
+func (api IrisAPI) Start(port int) error {
+
+	ln, err := api.lifecycle.listen(port, api.i.Shutdown)
+	if err != nil {
+		return err
+	}
+
+	err = api.i.Serve(ln)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+
+	return err
+}
+
+func (api IrisAPI) Shutdown(ctx context.Context) error {
+	return api.lifecycle.stop(ctx)
+}
+
+func (api IrisAPI) Ready() <-chan struct{} {
+	return api.lifecycle.ready
+}
+
+func (api IrisAPI) OnShutdown(f func()) {
+	api.lifecycle.onShutdown(f)
+}
+
+func (api IrisAPI) SetErrorRenderer(renderer catrina.ErrorRenderer) {
+	api.rendering.set(renderer)
 }
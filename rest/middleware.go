@@ -0,0 +1,44 @@
+package rest
+
+import (
+	"net/http"
+	"github.com/buduchail/catrina"
+)
+
+type (
+	// Ordered list of middleware shared by all routes of a RestAPI.
+	// It is kept behind a pointer so that middleware added after a
+	// resource has been registered still applies to it, and so that
+	// value-receiver adapters see every addition.
+	middlewareChain struct {
+		middleware []catrina.Middleware
+	}
+)
+
+func newMiddlewareChain() *middlewareChain {
+	return &middlewareChain{make([]catrina.Middleware, 0)}
+}
+
+func (mc *middlewareChain) add(m catrina.Middleware) {
+	mc.middleware = append(mc.middleware, m)
+}
+
+// Runs every middleware in order, stopping at the first one that fails.
+func (mc *middlewareChain) apply(w http.ResponseWriter, r *http.Request) error {
+	for _, m := range mc.middleware {
+		err := m.Handle(w, r)
+		if err != nil {
+			if *err == nil {
+				return unknownErr
+			}
+			return *err
+		}
+	}
+	return nil
+}
+
+// Returns a request whose context can carry values set by middleware
+// with catrina.SetRequestValue.
+func withRequestValues(r *http.Request) *http.Request {
+	return r.WithContext(catrina.WithRequestValues(r.Context()))
+}
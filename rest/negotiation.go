@@ -0,0 +1,304 @@
+package rest
+
+import (
+	"sort"
+	"mime"
+	"errors"
+	"strconv"
+	"strings"
+	"net/http"
+
+	"github.com/buduchail/catrina"
+)
+
+type (
+	// Registry of codecs by media type, which is itself a Codec (the
+	// first registered one, used by default). Handlers given a Codecs
+	// pick the codec of each request: the body is decoded by its
+	// Content-Type (415 if unsupported) and the response encoded for
+	// the Accept header (406 if nothing acceptable is supported).
+	//
+	//	users := rest.NewCRUDHandler(crud, fields, rest.DefaultCodecs())
+	Codecs struct {
+		types  []string
+		codecs map[string]Codec
+	}
+
+	// Codecs that know their media type, sent as Content-Type
+	MediaTyper interface {
+		MediaType() string
+	}
+
+	// Codecs picked for a request
+	negotiated struct {
+		// decodes the request body
+		in Codec
+		// encodes the response, as contentType (if known)
+		out         Codec
+		contentType string
+	}
+
+	acceptRange struct {
+		mediaType string
+		q         float64
+	}
+)
+
+var (
+	UnsupportedMediaTypeErr = errors.New("Unsupported media type")
+	NotAcceptableErr        = errors.New("Not acceptable")
+	noCodecsErr             = errors.New("No codecs registered")
+)
+
+func NewCodecs() *Codecs {
+	return &Codecs{types: []string{}, codecs: map[string]Codec{}}
+}
+
+// JSON (the default), XML, MessagePack, CBOR and form-encoded bodies
+func DefaultCodecs() *Codecs {
+	return NewCodecs().
+		Register("application/json", JSONCodec{}).
+		Register("application/xml", XMLCodec{}).
+		Register("text/xml", XMLCodec{}).
+		Register("application/msgpack", MessagePackCodec{}).
+		Register("application/x-msgpack", MessagePackCodec{}).
+		Register("application/cbor", CBORCodec{}).
+		Register("application/x-www-form-urlencoded", FormCodec{})
+}
+
+// Adds or replaces the codec of a media type
+func (c *Codecs) Register(mediaType string, codec Codec) *Codecs {
+
+	mediaType = strings.ToLower(mediaType)
+	if _, exists := c.codecs[mediaType]; !exists {
+		c.types = append(c.types, mediaType)
+	}
+	c.codecs[mediaType] = codec
+
+	return c
+}
+
+// Registered media types, the default first
+func (c *Codecs) MediaTypes() []string {
+	return append([]string{}, c.types...)
+}
+
+// Helper methods
+
+func (c *Codecs) defaultCodec() (Codec, string, error) {
+	if len(c.types) == 0 {
+		return nil, "", noCodecsErr
+	}
+	return c.codecs[c.types[0]], c.types[0], nil
+}
+
+// Exact media type, or else the codec of a structured syntax suffix:
+// application/vnd.api+json is decoded as application/json
+func (c *Codecs) lookup(mediaType string) (Codec, bool) {
+
+	if codec, ok := c.codecs[mediaType]; ok {
+		return codec, true
+	}
+
+	if i := strings.LastIndex(mediaType, "+"); i >= 0 {
+		codec, ok := c.codecs["application/"+mediaType[i+1:]]
+		return codec, ok
+	}
+
+	return nil, false
+}
+
+// Media ranges of an Accept header, most preferred first: by quality,
+// then exact types before type/* and */*.
+func parseAccept(accept string) []acceptRange {
+
+	ranges := []acceptRange{}
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType, q})
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].q != ranges[j].q {
+			return ranges[i].q > ranges[j].q
+		}
+		return ranges[i].specificity() > ranges[j].specificity()
+	})
+
+	return ranges
+}
+
+func (r acceptRange) specificity() int {
+	switch {
+	case r.mediaType == "*/*":
+		return 0
+	case strings.HasSuffix(r.mediaType, "/*"):
+		return 1
+	}
+	return 2
+}
+
+func (r acceptRange) matches(mediaType string) bool {
+	if r.mediaType == "*/*" {
+		return true
+	}
+	if prefix, wildcard := strings.CutSuffix(r.mediaType, "*"); wildcard {
+		return strings.HasPrefix(mediaType, prefix)
+	}
+	return r.mediaType == mediaType
+}
+
+// Index of the most specific range matching a media type, which sets
+// its quality (application/json;q=0 excludes JSON from */*), or
+// len(ranges) if none does
+func matchRange(ranges []acceptRange, mediaType string) int {
+
+	match := len(ranges)
+	for i, r := range ranges {
+		if r.matches(mediaType) && (match == len(ranges) || r.specificity() > ranges[match].specificity()) {
+			match = i
+		}
+	}
+
+	return match
+}
+
+// Public interface
+
+// Codec of a request body. Bodies without a Content-Type are decoded
+// with the default codec.
+func (c *Codecs) ForContentType(contentType string) (Codec, error) {
+
+	if strings.TrimSpace(contentType) == "" {
+		codec, _, err := c.defaultCodec()
+		return codec, err
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, UnsupportedMediaTypeErr
+	}
+
+	codec, ok := c.lookup(mediaType)
+	if !ok {
+		return nil, UnsupportedMediaTypeErr
+	}
+
+	return codec, nil
+}
+
+// Codec of a response and its media type, the most preferred of the
+// Accept header; types matched by the same range are preferred in the
+// order they were registered. Without an Accept header (or one that
+// cannot be parsed) the default codec is used.
+func (c *Codecs) ForAccept(accept string) (Codec, string, error) {
+
+	ranges := parseAccept(accept)
+	if len(ranges) == 0 {
+		return c.defaultCodec()
+	}
+
+	// ranges are sorted, so the best type has the first matching range
+	best, first := "", len(ranges)
+	for _, t := range c.types {
+		i := matchRange(ranges, t)
+		// q=0 means "not acceptable"
+		if i < first && ranges[i].q > 0 {
+			best, first = t, i
+		}
+	}
+
+	if best == "" {
+		return nil, "", NotAcceptableErr
+	}
+
+	return c.codecs[best], best, nil
+}
+
+// Encodes with the default codec
+func (c *Codecs) Encode(v interface{}) (catrina.Payload, error) {
+
+	codec, _, err := c.defaultCodec()
+	if err != nil {
+		return nil, err
+	}
+
+	return codec.Encode(v)
+}
+
+// Decodes with the default codec
+func (c *Codecs) Decode(payload catrina.Payload, v interface{}) error {
+
+	codec, _, err := c.defaultCodec()
+	if err != nil {
+		return err
+	}
+
+	return codec.Decode(payload, v)
+}
+
+func (c *Codecs) MediaType() string {
+	_, mediaType, _ := c.defaultCodec()
+	return mediaType
+}
+
+// Negotiation
+
+func mediaType(codec Codec) string {
+	if typer, ok := codec.(MediaTyper); ok {
+		return typer.MediaType()
+	}
+	return ""
+}
+
+// Picks the codecs of a request. A single codec is used for
+// everything, a Codecs registry is negotiated; decoding tells whether
+// the request has a body to decode.
+func negotiate(codec Codec, r *catrina.Request, decoding bool) (negotiated, *catrina.Response) {
+
+	codecs, ok := codec.(*Codecs)
+	if !ok {
+		return negotiated{codec, codec, mediaType(codec)}, nil
+	}
+
+	out, contentType, err := codecs.ForAccept(r.Headers.Get("Accept"))
+	if err != nil {
+		return negotiated{}, catrina.NewResponse(http.StatusNotAcceptable, catrina.EmptyBody, err)
+	}
+
+	in := Codec(codecs)
+	if decoding {
+		in, err = codecs.ForContentType(r.Headers.Get("Content-Type"))
+		if err != nil {
+			return negotiated{}, catrina.NewResponse(http.StatusUnsupportedMediaType, catrina.EmptyBody, err)
+		}
+	}
+
+	return negotiated{in, out, contentType}, nil
+}
+
+func (n negotiated) encode(code int, v interface{}) *catrina.Response {
+
+	body, err := n.out.Encode(v)
+	if err != nil {
+		return catrina.NewResponse(http.StatusInternalServerError, catrina.EmptyBody, err)
+	}
+
+	rs := catrina.NewResponse(code, body, nil)
+	if n.contentType != "" {
+		rs.WithHeader("Content-Type", n.contentType)
+	}
+
+	return rs
+}
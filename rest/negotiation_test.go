@@ -0,0 +1,78 @@
+package rest
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAccept(t *testing.T) {
+
+	tests := []struct {
+		accept   string
+		expected []acceptRange
+	}{
+		{"", []acceptRange{}},
+		{"application/json", []acceptRange{{"application/json", 1}}},
+		{"Application/XML; charset=utf-8", []acceptRange{{"application/xml", 1}}},
+		{"text/*, application/json;q=0.5, */*;q=0.1", []acceptRange{{"text/*", 1}, {"application/json", 0.5}, {"*/*", 0.1}}},
+		{"*/*, text/*, text/html", []acceptRange{{"text/html", 1}, {"text/*", 1}, {"*/*", 1}}},
+		{"application/xml, application/json", []acceptRange{{"application/xml", 1}, {"application/json", 1}}},
+		{"application/json;q=0, */*", []acceptRange{{"*/*", 1}, {"application/json", 0}}},
+		// unparseable ranges are ignored
+		{"application/json;q=x, ;, text/xml", []acceptRange{{"text/xml", 1}}},
+	}
+
+	for _, test := range tests {
+		ranges := parseAccept(test.accept)
+		if !reflect.DeepEqual(ranges, test.expected) {
+			t.Errorf("%q: expected %v, got %v", test.accept, test.expected, ranges)
+		}
+	}
+}
+
+func TestForAccept(t *testing.T) {
+
+	codecs := DefaultCodecs()
+
+	tests := []struct {
+		accept   string
+		expected string
+		err      error
+	}{
+		{"", "application/json", nil},
+		{"not a media type", "application/json", nil},
+		{"*/*", "application/json", nil},
+		{"application/cbor", "application/cbor", nil},
+		{"application/xml, application/json", "application/xml", nil},
+		{"application/xml;q=0.5, application/cbor", "application/cbor", nil},
+		{"text/html, application/xml;q=0.9", "application/xml", nil},
+		{"text/*", "text/xml", nil},
+		{"application/*;q=0.5, application/msgpack", "application/msgpack", nil},
+		{"*/*;q=0.1, application/cbor;q=0.5", "application/cbor", nil},
+
+		// q=0 excludes types from the wildcards matching them
+		{"application/json;q=0, */*", "application/xml", nil},
+		{"*/*, application/json;q=0, application/xml;q=0", "text/xml", nil},
+		{"application/*;q=0, */*", "text/xml", nil},
+		{"application/*;q=0, application/cbor", "application/cbor", nil},
+		{"application/json;q=0", "", NotAcceptableErr},
+		{"*/*;q=0", "", NotAcceptableErr},
+		{"text/*, text/xml;q=0", "", NotAcceptableErr},
+		{"text/csv", "", NotAcceptableErr},
+	}
+
+	for _, test := range tests {
+		codec, mediaType, err := codecs.ForAccept(test.accept)
+		if err != test.err || mediaType != test.expected {
+			t.Errorf("%q: expected %q (%v), got %q (%v)", test.accept, test.expected, test.err, mediaType, err)
+		}
+		if err == nil && codec != codecs.codecs[mediaType] {
+			t.Errorf("%q: unexpected codec %T", test.accept, codec)
+		}
+	}
+
+	_, _, err := NewCodecs().ForAccept("*/*")
+	if err != NotAcceptableErr {
+		t.Errorf("expected %v without codecs, got %v", NotAcceptableErr, err)
+	}
+}
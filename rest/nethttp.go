@@ -1,10 +1,8 @@
 package rest
 
 import (
-	"bytes"
+	"context"
 	"errors"
-	"strconv"
-	"io/ioutil"
 	"net/http"
 
 	"github.com/buduchail/catrina"
@@ -15,7 +13,10 @@ type (
 		root       *pathHandler
 		prefix     string
 		prefixLen  int
-		middleware []catrina.Middleware
+		middleware *middlewareChain
+		lifecycle  *lifecycle
+		spec       *openAPISpec
+		rendering  *errorRendering
 	}
 )
 
@@ -24,99 +25,139 @@ func NewNetHTTP(prefix string) (api *NetHTTP) {
 	api.prefix = normalizePrefix(prefix)
 	api.prefixLen = len(api.prefix)
 	api.root = NewPathHandler(api.prefix)
-	api.middleware = make([]catrina.Middleware, 0)
+	api.middleware = newMiddlewareChain()
+	api.lifecycle = newLifecycle()
+	api.spec = newOpenAPISpec(api.prefix)
+	api.rendering = newErrorRendering()
 	return api
 }
 
-func (api *NetHTTP) getBody(r *http.Request) catrina.Payload {
-	b, _ := ioutil.ReadAll(r.Body)
-	return bytes.NewBuffer(b).Bytes()
+func (api *NetHTTP) sendResponse(w http.ResponseWriter, r *http.Request, rs *catrina.Response) error {
+	return writeResponse(w, r, rs, api.rendering)
 }
 
-func (api *NetHTTP) getQueryParameters(r *http.Request) catrina.QueryParameters {
-	return catrina.QueryParameters(r.URL.Query())
-}
-
-func (api *NetHTTP) sendResponse(w http.ResponseWriter, code int, body catrina.Payload, err error) error {
-
-	w.WriteHeader(code)	
-	_, err = w.Write(body)
-
-	return err
-}
+func (api *NetHTTP) handleResource(method string, rq *catrina.Request, handler catrina.ResponseResourceHandler) *catrina.Response {
 
-func (api *NetHTTP) handleResource(method string, id string, parentIds []string, r *http.Request, handler catrina.ResourceHandler) (code int, body catrina.Payload, err error) {
+	id := rq.Id
 
 	switch method {
 	case "OPTIONS":
-		return  handler.Options()
+		return  handler.Options(rq)
 	case "POST":
 		if id != "" {
-			return http.StatusBadRequest, catrina.EmptyBody, errors.New("POST requests must not provide an ID")
+			return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, errors.New("POST requests must not provide an ID"))
 		}
-		return handler.Post(parentIds, api.getBody(r))
+		return handler.Post(rq)
 	case "GET":
 		if id != "" {
-			return handler.Get(id, parentIds)
+			return handler.Get(rq)
+		} else {
+			return handler.GetMany(rq)
+		}
+	case "HEAD":
+		if id != "" {
+			return headResponse(handler.Get(rq))
 		} else {
-			return handler.GetMany(parentIds, api.getQueryParameters(r))
+			return headResponse(handler.GetMany(rq))
 		}
 	case "PUT":
 		if id == "" {
-			return http.StatusBadRequest, catrina.EmptyBody, errors.New("PUT method must provide an ID")
+			return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, errors.New("PUT method must provide an ID"))
 		}
-		return handler.Put(id, parentIds, api.getBody(r))
+		return handler.Put(rq)
+	case "PATCH":
+		if id == "" {
+			return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, errors.New("PATCH method must provide an ID"))
+		}
+		return handler.Patch(rq)
 	case "DELETE":
 		if id == "" {
-			return http.StatusBadRequest, catrina.EmptyBody, errors.New("DELETE method must provide an ID")
+			return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, errors.New("DELETE method must provide an ID"))
 		}
-		return handler.Delete(id, parentIds)
+		return handler.Delete(rq)
 	}
 
-	return http.StatusMethodNotAllowed, catrina.EmptyBody, errors.New("Method not allowed")
+	return catrina.NewResponse(http.StatusMethodNotAllowed, catrina.EmptyBody, errors.New("Method not allowed"))
 }
 
 func (api *NetHTTP) handle(w http.ResponseWriter, r *http.Request) {
 
 	if len(r.URL.Path) > api.prefixLen+1 {
 
-		handler, id, parentIds := api.root.findHandler(r.URL.Path[api.prefixLen:])
+		handler, name, id, parentIds := api.root.findHandler(r.URL.Path[api.prefixLen:])
 		if handler == nil {
-			api.sendResponse(w, http.StatusNotFound, catrina.EmptyBody, nil)
+			api.sendResponse(w, r, catrina.NewResponse(http.StatusNotFound, catrina.EmptyBody, nil))
 			return
 		}
 
 		// apply middleware
-		for _, m := range api.middleware {
-			err := m.Handle(w, r)
-			if err != nil {
-				api.sendResponse(w, http.StatusInternalServerError, catrina.EmptyBody, *err)
-				return
-			}
+		r = withRequestValues(r)
+		err := api.middleware.apply(w, r)
+		if err != nil {
+			api.sendResponse(w, r, catrina.NewResponse(http.StatusInternalServerError, catrina.EmptyBody, err))
+			return
 		}
 
-		code, body, err := api.handleResource(r.Method, id, parentIds, r, handler)
-		api.sendResponse(w, code, body, err)
+		rs := api.handleResource(r.Method, newRequest(r, name, id, parentIds), handler)
+		api.sendResponse(w, r, rs)
 
 	} else {
-		api.sendResponse(w, http.StatusNotFound, catrina.EmptyBody, nil)
+		api.sendResponse(w, r, catrina.NewResponse(http.StatusNotFound, catrina.EmptyBody, nil))
 	}
 }
 
 func (api *NetHTTP) AddResource(name string, handler catrina.ResourceHandler) {
+	api.AddContextResource(name, NewContextAdapter(handler))
+}
+
+func (api *NetHTTP) AddContextResource(name string, handler catrina.ContextResourceHandler) {
+	api.AddResponseResource(name, NewResponseAdapter(handler))
+}
+
+func (api *NetHTTP) AddResponseResource(name string, handler catrina.ResponseResourceHandler) {
+	api.spec.add(name, handler)
 	api.root.addHandler(name, handler)
 }
 
 func (api *NetHTTP) AddMiddleware(m catrina.Middleware) {
-	api.middleware = append(api.middleware, m)
+	api.middleware.add(m)
+}
+
+func (api *NetHTTP) OpenAPI(title, version string) (catrina.Payload, error) {
+	return api.spec.json(title, version)
+}
+
+func (api *NetHTTP) ServeOpenAPI(path, title, version string) {
+	api.AddResponseResource(path, api.spec.handler(title, version))
 }
 
 func (api *NetHTTP) Run(port int) {
+	api.Start(port)
+}
+
+func (api *NetHTTP) Start(port int) error {
 
 	mux := http.NewServeMux()
 
+	// the prefix ends with a slash, so it matches every path below it
+	// (prefix+"/" would make the mux redirect /api/users to /api/users/)
 	mux.HandleFunc(api.prefix, api.handle)
-	mux.HandleFunc(api.prefix+"/", api.handle)
 
-	http.ListenAndServe(":"+strconv.Itoa(port), mux)
+	return api.lifecycle.serveHTTP(port, mux)
+}
+
+func (api *NetHTTP) Shutdown(ctx context.Context) error {
+	return api.lifecycle.stop(ctx)
+}
+
+func (api *NetHTTP) Ready() <-chan struct{} {
+	return api.lifecycle.ready
+}
+
+func (api *NetHTTP) OnShutdown(f func()) {
+	api.lifecycle.onShutdown(f)
+}
+
+func (api *NetHTTP) SetErrorRenderer(renderer catrina.ErrorRenderer) {
+	api.rendering.set(renderer)
 }
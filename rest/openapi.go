@@ -0,0 +1,388 @@
+package rest
+
+import (
+	"sync"
+	"time"
+	"reflect"
+	"strings"
+	"net/http"
+	"encoding/json"
+
+	"github.com/buduchail/catrina"
+)
+
+const (
+	OpenAPIVersion = "3.0.3"
+)
+
+type (
+	// Optional interface for handlers that describe themselves in the
+	// OpenAPI document. Other handlers are documented with every
+	// method and no schema.
+	OpenAPIDescriber interface {
+		OpenAPI() OpenAPIResource
+	}
+
+	OpenAPIResource struct {
+		Summary string
+		// Handler methods that are implemented: "Post", "Get",
+		// "GetMany", "Put", "Patch", "Delete" or "Options". Empty means
+		// all but Options.
+		Methods []string
+		// JSON schema of the resource object, e.g. from NewOpenAPISchema
+		Schema OpenAPISchema
+	}
+
+	OpenAPISchema map[string]interface{}
+
+	OpenAPIDocument struct {
+		OpenAPI string                     `json:"openapi"`
+		Info    OpenAPIInfo                `json:"info"`
+		Paths   map[string]*OpenAPIPathItem `json:"paths"`
+	}
+
+	OpenAPIInfo struct {
+		Title   string `json:"title"`
+		Version string `json:"version"`
+	}
+
+	OpenAPIPathItem struct {
+		Parameters []OpenAPIParameter `json:"parameters,omitempty"`
+		Get        *OpenAPIOperation  `json:"get,omitempty"`
+		Put        *OpenAPIOperation  `json:"put,omitempty"`
+		Post       *OpenAPIOperation  `json:"post,omitempty"`
+		Delete     *OpenAPIOperation  `json:"delete,omitempty"`
+		Options    *OpenAPIOperation  `json:"options,omitempty"`
+		Patch      *OpenAPIOperation  `json:"patch,omitempty"`
+	}
+
+	OpenAPIParameter struct {
+		Name     string        `json:"name"`
+		In       string        `json:"in"`
+		Required bool          `json:"required,omitempty"`
+		Schema   OpenAPISchema `json:"schema,omitempty"`
+	}
+
+	OpenAPIOperation struct {
+		Summary     string                     `json:"summary,omitempty"`
+		OperationId string                     `json:"operationId"`
+		Tags        []string                   `json:"tags,omitempty"`
+		RequestBody *OpenAPIRequestBody        `json:"requestBody,omitempty"`
+		Responses   map[string]OpenAPIResponse `json:"responses"`
+	}
+
+	OpenAPIRequestBody struct {
+		Required bool                        `json:"required,omitempty"`
+		Content  map[string]OpenAPIMediaType `json:"content"`
+	}
+
+	OpenAPIResponse struct {
+		Description string                      `json:"description"`
+		Content     map[string]OpenAPIMediaType `json:"content,omitempty"`
+	}
+
+	OpenAPIMediaType struct {
+		Schema OpenAPISchema `json:"schema,omitempty"`
+	}
+
+	// Resources registered on a RestAPI, kept behind a pointer like
+	// middlewareChain.
+	openAPISpec struct {
+		lock      sync.RWMutex
+		prefix    string
+		resources []openAPIEntry
+	}
+
+	openAPIEntry struct {
+		name    string
+		handler catrina.ResponseResourceHandler
+	}
+
+	// Serves the document of the API it is registered on
+	openAPIHandler struct {
+		ResponseResourceHandler
+		spec    *openAPISpec
+		title   string
+		version string
+	}
+)
+
+var (
+	defaultOpenAPIMethods = []string{"Post", "Get", "GetMany", "Put", "Patch", "Delete"}
+
+	timeType = reflect.TypeOf(time.Time{})
+)
+
+func newOpenAPISpec(prefix string) *openAPISpec {
+	return &openAPISpec{
+		prefix:    prefix,
+		resources: make([]openAPIEntry, 0),
+	}
+}
+
+func (s *openAPISpec) add(name string, handler catrina.ResponseResourceHandler) {
+
+	// the document does not describe itself
+	if _, ok := handler.(openAPIHandler); ok {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.resources = append(s.resources, openAPIEntry{name, handler})
+}
+
+func (s *openAPISpec) handler(title, version string) openAPIHandler {
+	return openAPIHandler{spec: s, title: title, version: version}
+}
+
+func (s *openAPISpec) json(title, version string) (catrina.Payload, error) {
+	return json.Marshal(s.document(title, version))
+}
+
+func (s *openAPISpec) document(title, version string) OpenAPIDocument {
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	doc := OpenAPIDocument{
+		OpenAPI: OpenAPIVersion,
+		Info:    OpenAPIInfo{title, version},
+		Paths:   map[string]*OpenAPIPathItem{},
+	}
+
+	for _, entry := range s.resources {
+
+		resource := OpenAPIResource{}
+		if d, ok := entry.handler.(OpenAPIDescriber); ok {
+			resource = d.OpenAPI()
+		}
+		if len(resource.Methods) == 0 {
+			resource.Methods = defaultOpenAPIMethods
+		}
+
+		path, parentIdParams, idParam := expandPath(entry.name, "{%s}")
+		collectionPath := s.prefix + path
+		itemPath := collectionPath + "/{" + idParam + "}"
+
+		collection := &OpenAPIPathItem{Parameters: pathParameters(parentIdParams)}
+		item := &OpenAPIPathItem{Parameters: append(pathParameters(parentIdParams), pathParameters([]string{idParam})...)}
+
+		// users/*/orders -> users.orders
+		tag := strings.Replace(entry.name, "/*/", ".", -1)
+
+		for _, method := range resource.Methods {
+			op := &OpenAPIOperation{
+				Summary:     resource.Summary,
+				OperationId: strings.ToLower(method[:1]) + method[1:] + "." + tag,
+				Tags:        []string{tag},
+				Responses:   map[string]OpenAPIResponse{},
+			}
+			switch method {
+			case "Options":
+				op.Responses["2XX"] = OpenAPIResponse{Description: "Allowed methods"}
+				collection.Options = op
+				// routers answer OPTIONS on objects too
+				itemOp := *op
+				itemOp.OperationId = "optionsItem." + tag
+				item.Options = &itemOp
+			case "Post":
+				op.RequestBody = jsonRequestBody(resource.Schema)
+				op.Responses["2XX"] = jsonResponse("Created", resource.Schema)
+				collection.Post = op
+			case "Get":
+				op.Responses["2XX"] = jsonResponse("Found", resource.Schema)
+				item.Get = op
+			case "GetMany":
+				op.Responses["2XX"] = jsonResponse("List", arraySchema(resource.Schema))
+				collection.Get = op
+			case "Put":
+				op.RequestBody = jsonRequestBody(resource.Schema)
+				op.Responses["2XX"] = jsonResponse("Updated", resource.Schema)
+				item.Put = op
+			case "Patch":
+				op.RequestBody = patchRequestBody(resource.Schema)
+				op.Responses["2XX"] = jsonResponse("Patched", resource.Schema)
+				item.Patch = op
+			case "Delete":
+				op.Responses["2XX"] = OpenAPIResponse{Description: "Deleted"}
+				item.Delete = op
+			}
+		}
+
+		if collection.Get != nil || collection.Post != nil || collection.Options != nil {
+			doc.Paths[collectionPath] = collection
+		}
+		if item.Get != nil || item.Put != nil || item.Patch != nil || item.Delete != nil {
+			doc.Paths[itemPath] = item
+		}
+	}
+
+	return doc
+}
+
+func pathParameters(names []string) []OpenAPIParameter {
+	params := make([]OpenAPIParameter, len(names))
+	for i, name := range names {
+		params[i] = OpenAPIParameter{
+			Name:     name,
+			In:       "path",
+			Required: true,
+			Schema:   OpenAPISchema{"type": "string"},
+		}
+	}
+	return params
+}
+
+func objectSchema(schema OpenAPISchema) OpenAPISchema {
+	if schema == nil {
+		return OpenAPISchema{"type": "object"}
+	}
+	return schema
+}
+
+func arraySchema(schema OpenAPISchema) OpenAPISchema {
+	return OpenAPISchema{"type": "array", "items": objectSchema(schema)}
+}
+
+func jsonRequestBody(schema OpenAPISchema) *OpenAPIRequestBody {
+	return &OpenAPIRequestBody{
+		Required: true,
+		Content: map[string]OpenAPIMediaType{
+			"application/json": {objectSchema(schema)},
+		},
+	}
+}
+
+func patchRequestBody(schema OpenAPISchema) *OpenAPIRequestBody {
+	return &OpenAPIRequestBody{
+		Required: true,
+		Content: map[string]OpenAPIMediaType{
+			MergePatchContentType: {objectSchema(schema)},
+			JSONPatchContentType: {OpenAPISchema{
+				"type": "array",
+				"items": OpenAPISchema{
+					"type":     "object",
+					"required": []string{"op", "path"},
+					"properties": OpenAPISchema{
+						"op":    OpenAPISchema{"type": "string", "enum": []string{"add", "remove", "replace", "move", "copy", "test"}},
+						"path":  OpenAPISchema{"type": "string"},
+						"from":  OpenAPISchema{"type": "string"},
+						"value": OpenAPISchema{},
+					},
+				},
+			}},
+		},
+	}
+}
+
+func jsonResponse(description string, schema OpenAPISchema) OpenAPIResponse {
+	return OpenAPIResponse{
+		Description: description,
+		Content: map[string]OpenAPIMediaType{
+			"application/json": {objectSchema(schema)},
+		},
+	}
+}
+
+// Builds the JSON schema of a Go value, following encoding/json rules
+// for struct fields. Typed handlers use it for the type they handle.
+func NewOpenAPISchema(v interface{}) OpenAPISchema {
+	return typeSchema(reflect.TypeOf(v), map[reflect.Type]bool{})
+}
+
+func typeSchema(t reflect.Type, visiting map[reflect.Type]bool) OpenAPISchema {
+
+	if t == nil {
+		return OpenAPISchema{}
+	}
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == timeType {
+		return OpenAPISchema{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return OpenAPISchema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return OpenAPISchema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return OpenAPISchema{"type": "number"}
+	case reflect.String:
+		return OpenAPISchema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return OpenAPISchema{"type": "string", "format": "byte"}
+		}
+		return OpenAPISchema{"type": "array", "items": typeSchema(t.Elem(), visiting)}
+	case reflect.Map:
+		return OpenAPISchema{"type": "object", "additionalProperties": typeSchema(t.Elem(), visiting)}
+	case reflect.Struct:
+		// recursive types are not expanded twice
+		if visiting[t] {
+			return OpenAPISchema{"type": "object"}
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+		properties := OpenAPISchema{}
+		structProperties(t, properties, visiting)
+		return OpenAPISchema{"type": "object", "properties": properties}
+	}
+
+	return OpenAPISchema{}
+}
+
+func structProperties(t reflect.Type, properties OpenAPISchema, visiting map[reflect.Type]bool) {
+
+	for i := 0; i < t.NumField(); i++ {
+
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name := strings.Split(tag, ",")[0]
+
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+
+		// untagged embedded structs are flattened, as encoding/json does
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			structProperties(ft, properties, visiting)
+			continue
+		}
+
+		if f.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		properties[name] = typeSchema(f.Type, visiting)
+	}
+}
+
+// Public interface
+
+func (h openAPIHandler) Get(r *catrina.Request) *catrina.Response {
+	return catrina.NewResponse(http.StatusNotFound, catrina.EmptyBody, nil)
+}
+
+func (h openAPIHandler) GetMany(r *catrina.Request) *catrina.Response {
+	body, err := h.spec.json(h.title, h.version)
+	if err != nil {
+		return catrina.NewResponse(http.StatusInternalServerError, catrina.EmptyBody, err)
+	}
+	return catrina.NewResponse(http.StatusOK, body, nil).
+		WithHeader("Content-Type", "application/json")
+}
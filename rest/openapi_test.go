@@ -0,0 +1,90 @@
+package rest_test
+
+import (
+	"testing"
+
+	"github.com/buduchail/catrina/rest"
+)
+
+type (
+	// Read-only orders of a user
+	describedOrders struct {
+		rest.ResponseResourceHandler
+	}
+)
+
+func (h describedOrders) OpenAPI() rest.OpenAPIResource {
+	return rest.OpenAPIResource{
+		Summary: "Orders of a user",
+		Methods: []string{"Options", "GetMany", "Get", "Delete"},
+		Schema:  rest.NewOpenAPISchema(struct{ Item string `json:"item"` }{}),
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+
+	api := rest.NewNetHTTP("api")
+	api.AddResponseResource("users/*/orders", describedOrders{})
+	api.ServeOpenAPI("openapi.json", "shop", "1")
+
+	doc, err := api.OpenAPI("shop", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{
+		"openapi": "3.0.3",
+		"info": {"title": "shop", "version": "1"},
+		"paths": {
+			"/api/users/{id1}/orders": {
+				"parameters": [
+					{"name": "id1", "in": "path", "required": true, "schema": {"type": "string"}}
+				],
+				"get": {
+					"summary": "Orders of a user",
+					"operationId": "getMany.users.orders",
+					"tags": ["users.orders"],
+					"responses": {"2XX": {"description": "List", "content": {"application/json": {"schema": {
+						"type": "array",
+						"items": {"type": "object", "properties": {"item": {"type": "string"}}}
+					}}}}}
+				},
+				"options": {
+					"summary": "Orders of a user",
+					"operationId": "options.users.orders",
+					"tags": ["users.orders"],
+					"responses": {"2XX": {"description": "Allowed methods"}}
+				}
+			},
+			"/api/users/{id1}/orders/{id2}": {
+				"parameters": [
+					{"name": "id1", "in": "path", "required": true, "schema": {"type": "string"}},
+					{"name": "id2", "in": "path", "required": true, "schema": {"type": "string"}}
+				],
+				"get": {
+					"summary": "Orders of a user",
+					"operationId": "get.users.orders",
+					"tags": ["users.orders"],
+					"responses": {"2XX": {"description": "Found", "content": {"application/json": {"schema": {
+						"type": "object", "properties": {"item": {"type": "string"}}
+					}}}}}
+				},
+				"delete": {
+					"summary": "Orders of a user",
+					"operationId": "delete.users.orders",
+					"tags": ["users.orders"],
+					"responses": {"2XX": {"description": "Deleted"}}
+				},
+				"options": {
+					"summary": "Orders of a user",
+					"operationId": "optionsItem.users.orders",
+					"tags": ["users.orders"],
+					"responses": {"2XX": {"description": "Allowed methods"}}
+				}
+			}
+		}
+	}`
+	if !sameJSON(t, expected, doc) {
+		t.Errorf("expected %s, got %s", expected, doc)
+	}
+}
@@ -0,0 +1,434 @@
+package rest
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"strconv"
+	"encoding/json"
+
+	"github.com/buduchail/catrina"
+)
+
+const (
+	MergePatchContentType = "application/merge-patch+json"
+	JSONPatchContentType  = "application/json-patch+json"
+)
+
+var (
+	InvalidPatchErr    = errors.New("Invalid patch")
+	PatchPathErr       = errors.New("Patch path does not exist")
+	PatchTestFailedErr = errors.New("Patch test operation failed")
+)
+
+type (
+	// A single JSON Patch (RFC 6902) operation
+	PatchOperation struct {
+		Op    string          `json:"op"`
+		Path  string          `json:"path"`
+		From  string          `json:"from,omitempty"`
+		Value json.RawMessage `json:"value,omitempty"`
+	}
+)
+
+// Applies a patch received in a PATCH request body to the current JSON
+// representation of a resource, choosing the format from the request
+// content type. JSON Patch is used for application/json-patch+json,
+// and JSON Merge Patch for anything else (plain application/json
+// bodies are treated as merge patches).
+func ApplyPatch(contentType string, doc catrina.Payload, patch catrina.Payload) (catrina.Payload, error) {
+
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+
+	if mediaType == JSONPatchContentType {
+		return JSONPatch(doc, patch)
+	}
+
+	return MergePatch(doc, patch)
+}
+
+// Applies a JSON Merge Patch (RFC 7396, which obsoletes RFC 7386):
+// objects in the patch are merged recursively, null removes a member
+// and any other value replaces the target.
+func MergePatch(doc catrina.Payload, patch catrina.Payload) (catrina.Payload, error) {
+
+	var target interface{}
+	if len(bytes.TrimSpace(doc)) > 0 {
+		err := decodeJSON(doc, &target)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var p interface{}
+	err := decodeJSON(patch, &p)
+	if err != nil {
+		return nil, InvalidPatchErr
+	}
+
+	return json.Marshal(mergePatch(target, p))
+}
+
+// Applies a JSON Patch (RFC 6902) document, a list of add, remove,
+// replace, move, copy and test operations. Operations are applied in
+// order and the patch is atomic: if any of them fails, the error is
+// returned and the original document is left untouched.
+func JSONPatch(doc catrina.Payload, patch catrina.Payload) (catrina.Payload, error) {
+
+	var target interface{}
+	err := decodeJSON(doc, &target)
+	if err != nil {
+		return nil, err
+	}
+
+	var ops []PatchOperation
+	err = json.Unmarshal(patch, &ops)
+	if err != nil {
+		return nil, InvalidPatchErr
+	}
+
+	for _, op := range ops {
+		target, err = applyOperation(target, op)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+// numbers are kept as json.Number, so that big integers survive
+// a decode/encode round trip
+func decodeJSON(data []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+func mergePatch(target interface{}, patch interface{}) interface{} {
+
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = mergePatch(t[k], v)
+		}
+	}
+
+	return t
+}
+
+func applyOperation(doc interface{}, op PatchOperation) (interface{}, error) {
+
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, InvalidPatchErr
+		}
+		err = decodeJSON(op.Value, &value)
+		if err != nil {
+			return nil, InvalidPatchErr
+		}
+	}
+
+	switch op.Op {
+	case "add":
+		return addValue(doc, path, value)
+	case "remove":
+		// the document itself cannot be removed, only replaced
+		if len(path) == 0 {
+			return nil, InvalidPatchErr
+		}
+		doc, _, err = removeValue(doc, path)
+		return doc, err
+	case "replace":
+		doc, _, err = removeValue(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(doc, path, value)
+	case "move":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		// a location cannot be moved into one of its children
+		if op.Path != op.From && strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, InvalidPatchErr
+		}
+		doc, value, err = removeValue(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(doc, path, value)
+	case "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err = getValue(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(doc, path, copyValue(value))
+	case "test":
+		current, err := getValue(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !equalValues(current, value) {
+			return nil, PatchTestFailedErr
+		}
+		return doc, nil
+	}
+
+	return nil, InvalidPatchErr
+}
+
+// Splits a JSON Pointer (RFC 6901) into unescaped reference tokens
+func parsePointer(pointer string) ([]string, error) {
+
+	if pointer == "" {
+		return []string{}, nil
+	}
+
+	if pointer[0] != '/' {
+		return nil, InvalidPatchErr
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1)
+	}
+
+	return tokens, nil
+}
+
+// Parses an array index; "-" (past the end) is only valid when adding
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+
+	if allowEnd && token == "-" {
+		return length, nil
+	}
+
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, PatchPathErr
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 {
+		return 0, PatchPathErr
+	}
+
+	max := length - 1
+	if allowEnd {
+		max = length
+	}
+	if i > max {
+		return 0, PatchPathErr
+	}
+
+	return i, nil
+}
+
+func getChild(node interface{}, token string) (interface{}, error) {
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, exists := n[token]
+		if !exists {
+			return nil, PatchPathErr
+		}
+		return child, nil
+	case []interface{}:
+		i, err := arrayIndex(token, len(n), false)
+		if err != nil {
+			return nil, err
+		}
+		return n[i], nil
+	}
+
+	return nil, PatchPathErr
+}
+
+func getValue(doc interface{}, path []string) (value interface{}, err error) {
+	value = doc
+	for _, token := range path {
+		value, err = getChild(value, token)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+// Walks down to the parent of the target location and calls f on it.
+// f returns the (possibly new) parent, which is stored back into its
+// own parent, as inserting into a slice may reallocate it.
+func updateParent(node interface{}, path []string, f func(parent interface{}, token string) (interface{}, error)) (interface{}, error) {
+
+	if len(path) == 1 {
+		return f(node, path[0])
+	}
+
+	child, err := getChild(node, path[0])
+	if err != nil {
+		return nil, err
+	}
+
+	child, err = updateParent(child, path[1:], f)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		n[path[0]] = child
+	case []interface{}:
+		i, _ := arrayIndex(path[0], len(n), false)
+		n[i] = child
+	}
+
+	return node, nil
+}
+
+func addValue(doc interface{}, path []string, value interface{}) (interface{}, error) {
+
+	if len(path) == 0 {
+		// replaces the whole document
+		return value, nil
+	}
+
+	return updateParent(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			p[token] = value
+			return p, nil
+		case []interface{}:
+			i, err := arrayIndex(token, len(p), true)
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, nil)
+			copy(p[i+1:], p[i:])
+			p[i] = value
+			return p, nil
+		}
+		return nil, PatchPathErr
+	})
+}
+
+// Returns the document without the value at path, and the value. An
+// empty path removes the whole document, which replace and move then
+// add back.
+func removeValue(doc interface{}, path []string) (interface{}, interface{}, error) {
+
+	if len(path) == 0 {
+		return nil, doc, nil
+	}
+
+	var removed interface{}
+
+	doc, err := updateParent(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			value, exists := p[token]
+			if !exists {
+				return nil, PatchPathErr
+			}
+			removed = value
+			delete(p, token)
+			return p, nil
+		case []interface{}:
+			i, err := arrayIndex(token, len(p), false)
+			if err != nil {
+				return nil, err
+			}
+			removed = p[i]
+			return append(p[:i], p[i+1:]...), nil
+		}
+		return nil, PatchPathErr
+	})
+
+	return doc, removed, err
+}
+
+func copyValue(value interface{}) interface{} {
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for k, child := range v {
+			c[k] = copyValue(child)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, child := range v {
+			c[i] = copyValue(child)
+		}
+		return c
+	}
+
+	return value
+}
+
+// Compares JSON values as RFC 6902 requires for "test": numbers by
+// value (1 equals 1.0), objects regardless of member order.
+func equalValues(a interface{}, b interface{}) bool {
+
+	switch x := a.(type) {
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			w, exists := y[k]
+			if !exists || !equalValues(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equalValues(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		if x == y {
+			return true
+		}
+		fx, errx := x.Float64()
+		fy, erry := y.Float64()
+		return errx == nil && erry == nil && fx == fy
+	}
+
+	return a == b
+}
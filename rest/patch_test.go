@@ -0,0 +1,150 @@
+package rest_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"encoding/json"
+
+	"github.com/buduchail/catrina"
+	"github.com/buduchail/catrina/rest"
+)
+
+type patchTest struct {
+	name     string
+	doc      string
+	patch    string
+	expected string
+	err      error
+}
+
+// Compares documents as decoded JSON, regardless of member order, with
+// numbers as they are written
+func sameJSON(t *testing.T, a string, b catrina.Payload) bool {
+
+	decode := func(data []byte, v interface{}) error {
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.UseNumber()
+		return d.Decode(v)
+	}
+
+	var x, y interface{}
+	err := decode([]byte(a), &x)
+	if err != nil {
+		t.Fatalf("%s: %v", a, err)
+	}
+	err = decode(b, &y)
+
+	return err == nil && reflect.DeepEqual(x, y)
+}
+
+func runPatchTests(t *testing.T, apply func(doc, patch catrina.Payload) (catrina.Payload, error), tests []patchTest) {
+
+	for _, test := range tests {
+		patched, err := apply(catrina.Payload(test.doc), catrina.Payload(test.patch))
+		if err != test.err {
+			t.Errorf("%s: expected error %v, got %v", test.name, test.err, err)
+			continue
+		}
+		if err == nil && !sameJSON(t, test.expected, patched) {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, patched)
+		}
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+
+	runPatchTests(t, rest.JSONPatch, []patchTest{
+		// RFC 6902, Appendix A
+		{"A.1 adding an object member", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`, nil},
+		{"A.2 adding an array element", `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`, nil},
+		{"A.3 removing an object member", `{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`, nil},
+		{"A.4 removing an array element", `{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`, nil},
+		{"A.5 replacing a value", `{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`, nil},
+		{"A.6 moving a value", `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`, nil},
+		{"A.7 moving an array element", `{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`, nil},
+		{"A.8 testing a value: success", `{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, `{"baz":"qux","foo":["a",2,"c"]}`, nil},
+		{"A.9 testing a value: error", `{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, "", rest.PatchTestFailedErr},
+		{"A.10 adding a nested member object", `{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`, nil},
+		{"A.11 ignoring unrecognized elements", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`, `{"foo":"bar","baz":"qux"}`, nil},
+		{"A.12 adding to a nonexistent target", `{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, "", rest.PatchPathErr},
+		// the last op wins, and there is no /baz to remove
+		{"A.13 invalid JSON patch document", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux","op":"remove"}]`, "", rest.PatchPathErr},
+		{"A.14 ~ escape ordering", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`, nil},
+		{"A.15 comparing strings and numbers", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":"10"}]`, "", rest.PatchTestFailedErr},
+		{"A.16 adding an array value", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`, nil},
+
+		// the whole document
+		{"replace the document", `{"foo":"bar"}`, `[{"op":"replace","path":"","value":[1,2]}]`, `[1,2]`, nil},
+		{"add the document", `{"foo":"bar"}`, `[{"op":"add","path":"","value":{"baz":1}}]`, `{"baz":1}`, nil},
+		{"test the document", `{"foo":[1]}`, `[{"op":"test","path":"","value":{"foo":[1.0]}}]`, `{"foo":[1]}`, nil},
+		{"move the document onto itself", `{"foo":"bar"}`, `[{"op":"move","from":"","path":""}]`, `{"foo":"bar"}`, nil},
+		{"move a member to the document", `{"foo":{"bar":1}}`, `[{"op":"move","from":"/foo","path":""}]`, `{"bar":1}`, nil},
+		{"copy the document", `{"foo":"bar"}`, `[{"op":"copy","from":"","path":"/self"}]`, `{"foo":"bar","self":{"foo":"bar"}}`, nil},
+		{"remove the document", `{"foo":"bar"}`, `[{"op":"remove","path":""}]`, "", rest.InvalidPatchErr},
+		{"move the document into a member", `{"foo":{}}`, `[{"op":"move","from":"","path":"/foo/bar"}]`, "", rest.InvalidPatchErr},
+
+		// other operations and errors
+		{"copy", `{"foo":{"bar":[1]}}`, `[{"op":"copy","from":"/foo","path":"/baz"},{"op":"add","path":"/baz/bar/-","value":2}]`, `{"foo":{"bar":[1]},"baz":{"bar":[1,2]}}`, nil},
+		{"add null", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":null}]`, `{"foo":"bar","baz":null}`, nil},
+		{"replace a missing member", `{"foo":"bar"}`, `[{"op":"replace","path":"/baz","value":1}]`, "", rest.PatchPathErr},
+		{"move into a child", `{"foo":{"bar":1}}`, `[{"op":"move","from":"/foo","path":"/foo/bar/baz"}]`, "", rest.InvalidPatchErr},
+		{"index with a leading zero", `{"foo":[1,2]}`, `[{"op":"remove","path":"/foo/01"}]`, "", rest.PatchPathErr},
+		{"index past the end", `{"foo":[1,2]}`, `[{"op":"add","path":"/foo/3","value":3}]`, "", rest.PatchPathErr},
+		{"remove past the end", `{"foo":[1,2]}`, `[{"op":"remove","path":"/foo/-"}]`, "", rest.PatchPathErr},
+		{"pointer without a slash", `{"foo":1}`, `[{"op":"remove","path":"foo"}]`, "", rest.InvalidPatchErr},
+		{"missing value", `{"foo":1}`, `[{"op":"add","path":"/bar"}]`, "", rest.InvalidPatchErr},
+		{"unknown operation", `{"foo":1}`, `[{"op":"merge","path":"/foo","value":2}]`, "", rest.InvalidPatchErr},
+		{"not a patch", `{"foo":1}`, `{"op":"remove","path":"/foo"}`, "", rest.InvalidPatchErr},
+		{"failed patches apply nothing", `{"foo":1}`, `[{"op":"remove","path":"/foo"},{"op":"test","path":"/foo","value":1}]`, "", rest.PatchPathErr},
+		{"big numbers", `{"id":12345678901234567890}`, `[{"op":"add","path":"/n","value":1.5}]`, `{"id":12345678901234567890,"n":1.5}`, nil},
+	})
+}
+
+func TestMergePatch(t *testing.T) {
+
+	runPatchTests(t, rest.MergePatch, []patchTest{
+		// RFC 7396, Appendix A
+		{"replace a member", `{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`, nil},
+		{"add a member", `{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`, nil},
+		{"remove a member", `{"a":"b"}`, `{"a":null}`, `{}`, nil},
+		{"remove one of the members", `{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`, nil},
+		{"replace a list", `{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`, nil},
+		{"replace with a list", `{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`, nil},
+		{"merge nested objects", `{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`, nil},
+		{"lists are not merged", `{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`, nil},
+		{"replace a list document", `["a","b"]`, `["c","d"]`, `["c","d"]`, nil},
+		{"replace with a list document", `{"a":"b"}`, `["c"]`, `["c"]`, nil},
+		{"null document", `{"a":"foo"}`, `null`, `null`, nil},
+		{"string document", `{"a":"foo"}`, `"bar"`, `"bar"`, nil},
+		{"null members are kept", `{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`, nil},
+		{"merge into a list", `[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`, nil},
+		{"nulls are not added", `{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`, nil},
+
+		{"empty document", ``, `{"a":1}`, `{"a":1}`, nil},
+		{"big numbers", `{"id":12345678901234567890}`, `{"a":1}`, `{"id":12345678901234567890,"a":1}`, nil},
+		{"not a patch", `{"a":1}`, `{"a":`, "", rest.InvalidPatchErr},
+	})
+}
+
+func TestApplyPatch(t *testing.T) {
+
+	tests := []struct {
+		contentType string
+		patch       string
+		expected    string
+	}{
+		{rest.JSONPatchContentType, `[{"op":"replace","path":"/a","value":2}]`, `{"a":2}`},
+		{"Application/JSON-Patch+JSON; charset=utf-8", `[{"op":"remove","path":"/a"}]`, `{}`},
+		{rest.MergePatchContentType, `{"a":null,"b":1}`, `{"b":1}`},
+		{"application/json", `{"a":3}`, `{"a":3}`},
+		{"", `{"c":1}`, `{"a":1,"c":1}`},
+	}
+
+	for _, test := range tests {
+		patched, err := rest.ApplyPatch(test.contentType, catrina.Payload(`{"a":1}`), catrina.Payload(test.patch))
+		if err != nil || !sameJSON(t, test.expected, patched) {
+			t.Errorf("%q: expected %s, got %s (%v)", test.contentType, test.expected, patched, err)
+		}
+	}
+}
@@ -8,7 +8,8 @@ import (
 
 type (
 	pathHandler struct {
-		handler  catrina.ResourceHandler
+		handler  catrina.ResponseResourceHandler
+		name     string
 		resource string
 		children map[string]*pathHandler
 	}
@@ -22,7 +23,7 @@ func NewPathHandler(resource string) *pathHandler {
 	return ph
 }
 
-func (ph *pathHandler) addHandler(path string, handler catrina.ResourceHandler) {
+func (ph *pathHandler) addHandler(path string, handler catrina.ResponseResourceHandler) {
 	var (
 		child, p *pathHandler
 		exists   bool
@@ -37,9 +38,10 @@ func (ph *pathHandler) addHandler(path string, handler catrina.ResourceHandler)
 		p = child
 	}
 	p.handler = handler
+	p.name = path
 }
 
-func (ph *pathHandler) findHandler(path string) (handler catrina.ResourceHandler, id string, parentIds []string) {
+func (ph *pathHandler) findHandler(path string) (handler catrina.ResponseResourceHandler, name string, id string, parentIds []string) {
 	handler = nil
 	id = ""
 	parentIds = make([]string, 0)
@@ -72,18 +74,19 @@ func (ph *pathHandler) findHandler(path string) (handler catrina.ResourceHandler
 			parts++
 			child, exists := p.children[scanner.Text()]
 			if !exists {
-				return nil, "", nil
+				return nil, "", "", nil
 			}
 			p = child
 		} else {
-			parentIds = append(parentIds, scanner.Text())
+			// prepend: /grandparent/1/parent/2/child/3 -> [3,2,1]
+			parentIds = append([]string{scanner.Text()}, parentIds...)
 		}
 	}
 
 	if parts == len(parentIds) {
-		id = parentIds[parts-1]
-		parentIds = parentIds[:parts-1]
+		id = parentIds[0]
+		parentIds = parentIds[1:]
 	}
 
-	return p.handler, id, parentIds
+	return p.handler, p.name, id, parentIds
 }
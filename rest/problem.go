@@ -0,0 +1,186 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"encoding/json"
+
+	"github.com/buduchail/catrina"
+)
+
+type (
+	// Problem details (RFC 7807), sent as application/problem+json
+	Problem struct {
+		Type          string               `json:"type"`
+		Title         string               `json:"title"`
+		Status        int                  `json:"status"`
+		Detail        string               `json:"detail,omitempty"`
+		Instance      string               `json:"instance,omitempty"`
+		CorrelationId string               `json:"correlationId,omitempty"`
+		Errors        []catrina.FieldError `json:"errors,omitempty"`
+	}
+
+	// Default ErrorRenderer of every RestAPI. The detail is the message
+	// of the response error, the instance the request path, and the
+	// errors those of a catrina.ValidationError. The correlation id is
+	// read from the X-Correlation-ID header, as set by the
+	// CorrelationID middleware.
+	//
+	// Messages of server errors (5xx) may reveal internals, e.g. SQL or
+	// host names, so their detail is only the kind of error, such as
+	// catrina.UnavailableErr, unless WithServerErrorDetail is set.
+	ProblemRenderer struct {
+		types             map[int]string
+		correlationHeader string
+		serverErrorDetail bool
+	}
+
+	// Renders errors as plain text: the error message, or else the
+	// status text
+	TextErrorRenderer struct{}
+
+	// Holds the renderer of a RestAPI. It is kept behind a pointer, like
+	// middlewareChain, so that value-receiver adapters can replace it.
+	errorRendering struct {
+		renderer catrina.ErrorRenderer
+	}
+)
+
+const (
+	ProblemContentType       = "application/problem+json"
+	DefaultCorrelationHeader = "X-Correlation-ID"
+)
+
+func NewProblemRenderer() *ProblemRenderer {
+	return &ProblemRenderer{
+		types:             map[int]string{},
+		correlationHeader: DefaultCorrelationHeader,
+	}
+}
+
+// Sets the type URI of the problems with the given status, which is
+// "about:blank" otherwise
+func (p *ProblemRenderer) WithType(code int, uri string) *ProblemRenderer {
+	p.types[code] = uri
+	return p
+}
+
+// Reads the correlation id from another header, or from none if name
+// is empty
+func (p *ProblemRenderer) WithCorrelationHeader(name string) *ProblemRenderer {
+	p.correlationHeader = name
+	return p
+}
+
+// Sends the message of server errors as the detail too, e.g. during
+// development
+func (p *ProblemRenderer) WithServerErrorDetail(send bool) *ProblemRenderer {
+	p.serverErrorDetail = send
+	return p
+}
+
+func (p *ProblemRenderer) Problem(r *http.Request, code int, err error) Problem {
+
+	problem := Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(code),
+		Status: code,
+	}
+
+	if uri, ok := p.types[code]; ok {
+		problem.Type = uri
+	}
+
+	if err != nil {
+		problem.Detail = p.detail(code, err)
+		var invalid *catrina.ValidationError
+		if errors.As(err, &invalid) {
+			problem.Errors = invalid.Fields
+		}
+	}
+
+	if r != nil {
+		problem.Instance = r.URL.Path
+		if p.correlationHeader != "" {
+			problem.CorrelationId = r.Header.Get(p.correlationHeader)
+		}
+	}
+
+	return problem
+}
+
+func (p *ProblemRenderer) detail(code int, err error) string {
+
+	if code < http.StatusInternalServerError || p.serverErrorDetail {
+		return err.Error()
+	}
+
+	for _, kind := range errorKinds {
+		if errors.Is(err, kind) {
+			return kind.Error()
+		}
+	}
+
+	return ""
+}
+
+func (p *ProblemRenderer) RenderError(r *http.Request, rs *catrina.Response) *catrina.Response {
+
+	body, err := json.Marshal(p.Problem(r, rs.Code, rs.Err))
+	if err != nil {
+		return rs
+	}
+
+	return withErrorBody(rs, ProblemContentType, body)
+}
+
+func (t TextErrorRenderer) RenderError(r *http.Request, rs *catrina.Response) *catrina.Response {
+	return withErrorBody(rs, "text/plain; charset=utf-8", getResponseBody(rs.Code, rs.Body, rs.Err))
+}
+
+// Copies the response, keeping its status and headers (e.g. Allow)
+func withErrorBody(rs *catrina.Response, contentType string, body catrina.Payload) *catrina.Response {
+
+	rendered := *rs
+	rendered.Headers = rs.Headers.Clone()
+	if rendered.Headers == nil {
+		rendered.Headers = http.Header{}
+	}
+	rendered.Headers.Set("Content-Type", contentType)
+	rendered.Body = body
+
+	return &rendered
+}
+
+func newErrorRendering() *errorRendering {
+	return &errorRendering{NewProblemRenderer()}
+}
+
+// A nil renderer restores the default one
+func (e *errorRendering) set(renderer catrina.ErrorRenderer) {
+	if renderer == nil {
+		renderer = NewProblemRenderer()
+	}
+	e.renderer = renderer
+}
+
+// Only error responses without a body are rendered, and HEAD responses
+// never have one
+func (e *errorRendering) wanted(method string, rs *catrina.Response) bool {
+	return rs.Code >= http.StatusBadRequest && len(rs.Body) == 0 && rs.Stream == nil &&
+		method != http.MethodHead
+}
+
+func (e *errorRendering) render(r *http.Request, rs *catrina.Response) *catrina.Response {
+
+	if !e.wanted(r.Method, rs) {
+		return rs
+	}
+
+	rendered := e.renderer.RenderError(r, rs)
+	if rendered == nil {
+		return rs
+	}
+
+	return rendered
+}
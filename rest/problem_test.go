@@ -0,0 +1,46 @@
+package rest_test
+
+import (
+	"fmt"
+	"errors"
+	"testing"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/buduchail/catrina"
+	"github.com/buduchail/catrina/rest"
+)
+
+func TestProblemDetail(t *testing.T) {
+
+	driverErr := errors.New("dial tcp 10.0.0.7:3306: connection refused")
+
+	tests := []struct {
+		name     string
+		renderer *rest.ProblemRenderer
+		code     int
+		err      error
+		expected string
+	}{
+		{"client error", rest.NewProblemRenderer(), 404, fmt.Errorf("user 1: %w", catrina.NotFoundErr), "user 1: No rows found"},
+		{"validation error", rest.NewProblemRenderer(), 422, catrina.NewValidationError("Name is required"), "Name is required"},
+		{"plain client error", rest.NewProblemRenderer(), 400, errors.New("Invalid cursor"), "Invalid cursor"},
+		{"server error", rest.NewProblemRenderer(), 500, errors.New("Table 'shop.users' doesn't exist"), ""},
+		{"server error kind", rest.NewProblemRenderer(), 503, fmt.Errorf("%w: %w", catrina.UnavailableErr, driverErr), "Service unavailable"},
+		{"kind with a server status", rest.NewProblemRenderer(), 500, fmt.Errorf("insert: %w", catrina.ConflictErr), "Version conflict"},
+		{"server error detail", rest.NewProblemRenderer().WithServerErrorDetail(true), 503, fmt.Errorf("%w: %w", catrina.UnavailableErr, driverErr), "Service unavailable: dial tcp 10.0.0.7:3306: connection refused"},
+		{"no error", rest.NewProblemRenderer(), 500, nil, ""},
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/users/1", nil)
+
+	for _, test := range tests {
+		problem := test.renderer.Problem(r, test.code, test.err)
+		if problem.Detail != test.expected {
+			t.Errorf("%s: expected detail %q, got %q", test.name, test.expected, problem.Detail)
+		}
+		if problem.Title != http.StatusText(test.code) || problem.Instance != "/api/users/1" {
+			t.Errorf("%s: unexpected %+v", test.name, problem)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package rest
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"net/url"
+	"encoding/json"
+	"encoding/base64"
+
+	"github.com/buduchail/catrina"
+)
+
+const (
+	// Query parameters mapped by ParseQuery, e.g.
+	// ?sort=-created,name&limit=50&cursor=...
+	SortParam   = "sort"
+	LimitParam  = "limit"
+	OffsetParam = "offset"
+	CursorParam = "cursor"
+
+	NextCursorHeader = "X-Next-Cursor"
+	TotalCountHeader = "X-Total-Count"
+)
+
+var (
+	InvalidLimitErr  = errors.New("Invalid limit")
+	InvalidOffsetErr = errors.New("Invalid offset")
+	InvalidCursorErr = errors.New("Invalid cursor")
+)
+
+// Maps the standard paging parameters to a catrina.Query (without a
+// filter). Without a limit parameter defaultLimit is used, and limits
+// above maxLimit are lowered to it; zero means no limit in both cases.
+func ParseQuery(query catrina.QueryParameters, defaultLimit, maxLimit int) (catrina.Query, error) {
+
+	q := catrina.Query{
+		Sort:  catrina.ParseSort(query.Get(SortParam)),
+		Limit: defaultLimit,
+	}
+
+	if limit := query.Get(LimitParam); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return q, InvalidLimitErr
+		}
+		q.Limit = n
+	}
+
+	if maxLimit > 0 && (q.Limit == 0 || q.Limit > maxLimit) {
+		q.Limit = maxLimit
+	}
+
+	if offset := query.Get(OffsetParam); offset != "" {
+		n, err := strconv.Atoi(offset)
+		if err != nil || n < 0 {
+			return q, InvalidOffsetErr
+		}
+		q.Offset = n
+	}
+
+	if cursor := query.Get(CursorParam); cursor != "" {
+		after, err := DecodeCursor(cursor)
+		if err != nil {
+			return q, err
+		}
+		q.After = after
+	}
+
+	return q, nil
+}
+
+// Encodes the values of catrina.Query.After as an opaque, URL-safe
+// cursor
+func EncodeCursor(values []catrina.Value) (string, error) {
+
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(encoded), nil
+}
+
+// Decodes a cursor made by EncodeCursor. Numbers are decoded as
+// json.Number, and times as RFC 3339 strings.
+func DecodeCursor(cursor string) ([]catrina.Value, error) {
+
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, InvalidCursorErr
+	}
+
+	values := []catrina.Value{}
+	d := json.NewDecoder(bytes.NewReader(decoded))
+	d.UseNumber()
+	if d.Decode(&values) != nil || len(values) == 0 {
+		return nil, InvalidCursorErr
+	}
+
+	return values, nil
+}
+
+// Link header value for the next page: the same query, relative to the
+// request URL, with the cursor replaced (and no offset, as the cursor
+// already skips the previous pages).
+func NextPageLink(query catrina.QueryParameters, cursor string) string {
+
+	next := url.Values{}
+	for param, values := range query {
+		if param != CursorParam && param != OffsetParam {
+			next[param] = values
+		}
+	}
+	next.Set(CursorParam, cursor)
+
+	return "<?" + next.Encode() + ">; rel=\"next\""
+}
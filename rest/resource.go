@@ -1,6 +1,7 @@
 package rest
 
 import (
+	"context"
 	"net/http"
 	"github.com/buduchail/catrina"
 )
@@ -36,6 +37,80 @@ func (s ResourceHandler) Put(id string, parentIds []string, payload catrina.Payl
 	return http.StatusMethodNotAllowed, catrina.EmptyBody, nil
 }
 
+func (s ResourceHandler) Patch(id string, parentIds []string, payload catrina.Payload) (code int, body catrina.Payload, err error) {
+	return http.StatusMethodNotAllowed, catrina.EmptyBody, nil
+}
+
 func (s ResourceHandler) Delete(id string, parentIds []string) (code int, body catrina.Payload, err error) {
 	return http.StatusMethodNotAllowed, catrina.EmptyBody, nil
 }
+
+type (
+	// Base implementation of ContextResourceHandler interface. Same as
+	// ResourceHandler, for handlers that need the request context.
+	ContextResourceHandler struct {
+	}
+)
+
+func (s ContextResourceHandler) Options(ctx context.Context) (code int, body catrina.Payload, err error) {
+	return http.StatusOK, catrina.EmptyBody, nil
+}
+
+func (s ContextResourceHandler) Post(ctx context.Context, parentIds []string, payload catrina.Payload) (code int, body catrina.Payload, err error) {
+	return http.StatusMethodNotAllowed, catrina.EmptyBody, nil
+}
+
+func (s ContextResourceHandler) Get(ctx context.Context, id string, parentIds []string) (code int, body catrina.Payload, err error) {
+	return http.StatusMethodNotAllowed, catrina.EmptyBody, nil
+}
+
+func (s ContextResourceHandler) GetMany(ctx context.Context, parentIds []string, params catrina.QueryParameters) (code int, body catrina.Payload, err error) {
+	return http.StatusMethodNotAllowed, catrina.EmptyBody, nil
+}
+
+func (s ContextResourceHandler) Put(ctx context.Context, id string, parentIds []string, payload catrina.Payload) (code int, body catrina.Payload, err error) {
+	return http.StatusMethodNotAllowed, catrina.EmptyBody, nil
+}
+
+func (s ContextResourceHandler) Patch(ctx context.Context, id string, parentIds []string, payload catrina.Payload) (code int, body catrina.Payload, err error) {
+	return http.StatusMethodNotAllowed, catrina.EmptyBody, nil
+}
+
+func (s ContextResourceHandler) Delete(ctx context.Context, id string, parentIds []string) (code int, body catrina.Payload, err error) {
+	return http.StatusMethodNotAllowed, catrina.EmptyBody, nil
+}
+
+type (
+	// Base implementation of ResponseResourceHandler interface. Embed
+	// it in handlers that need the full request or response.
+	ResponseResourceHandler struct {
+	}
+)
+
+func (s ResponseResourceHandler) Options(r *catrina.Request) *catrina.Response {
+	return catrina.NewResponse(http.StatusOK, catrina.EmptyBody, nil)
+}
+
+func (s ResponseResourceHandler) Post(r *catrina.Request) *catrina.Response {
+	return catrina.NewResponse(http.StatusMethodNotAllowed, catrina.EmptyBody, nil)
+}
+
+func (s ResponseResourceHandler) Get(r *catrina.Request) *catrina.Response {
+	return catrina.NewResponse(http.StatusMethodNotAllowed, catrina.EmptyBody, nil)
+}
+
+func (s ResponseResourceHandler) GetMany(r *catrina.Request) *catrina.Response {
+	return catrina.NewResponse(http.StatusMethodNotAllowed, catrina.EmptyBody, nil)
+}
+
+func (s ResponseResourceHandler) Put(r *catrina.Request) *catrina.Response {
+	return catrina.NewResponse(http.StatusMethodNotAllowed, catrina.EmptyBody, nil)
+}
+
+func (s ResponseResourceHandler) Patch(r *catrina.Request) *catrina.Response {
+	return catrina.NewResponse(http.StatusMethodNotAllowed, catrina.EmptyBody, nil)
+}
+
+func (s ResponseResourceHandler) Delete(r *catrina.Request) *catrina.Response {
+	return catrina.NewResponse(http.StatusMethodNotAllowed, catrina.EmptyBody, nil)
+}
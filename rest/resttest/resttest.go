@@ -0,0 +1,471 @@
+// Package resttest provides a conformance suite for catrina.RestAPI
+// implementations. Every router returned by rest.NewApi is expected
+// to pass it, which is what makes them interchangeable:
+//
+//	func TestGin(t *testing.T) {
+//		resttest.Run(t, func(prefix string) catrina.RestAPI {
+//			return rest.NewGin(prefix)
+//		})
+//	}
+package resttest
+
+import (
+	"fmt"
+	"net"
+	"time"
+	"regexp"
+	"errors"
+	"context"
+	"strings"
+	"testing"
+	"net/http"
+	"io/ioutil"
+
+	"github.com/buduchail/catrina"
+	"github.com/buduchail/catrina/rest"
+	"github.com/buduchail/catrina/middleware"
+)
+
+const (
+	Prefix = "api"
+
+	// response header set by the conformance middleware on every request
+	MiddlewareHeader = "X-Resttest"
+	// request header that makes the conformance middleware fail
+	FailMiddlewareHeader = "X-Resttest-Fail"
+	// request header echoed back by handlers
+	RequestHeader = "X-Resttest-Request"
+	// key of the request value set by the conformance middleware
+	ValueKey = "resttest"
+	// request header of the CorrelationID middleware, installed before
+	// the conformance one
+	CorrelationHeader = "X-Correlation-ID"
+	// where the OpenAPI document is served
+	OpenAPIPath = "openapi.json"
+)
+
+type (
+	// Builds the RestAPI under test, e.g. rest.NewGin
+	Constructor func(prefix string) catrina.RestAPI
+
+	Scenario struct {
+		Name    string
+		Method  string
+		Path    string
+		Headers map[string]string
+		Body    string
+
+		ExpectCode int
+		ExpectBody *string
+		// regular expression the body must match, for generated values
+		ExpectBodyPattern string
+		ExpectHeaders     map[string]string
+	}
+
+	// Handler used by the suite. Every response describes the call it
+	// received, so scenarios can assert on ids, parent ids, query and
+	// payload as seen by the handler.
+	echoHandler struct {
+		name string
+	}
+
+	// Handler whose Get responds with the status encoded in the id,
+	// e.g. GET /status/e404
+	statusHandler struct {
+		name string
+	}
+
+	// Handler returning full responses, to check headers and streams
+	responseHandler struct {
+		name string
+	}
+
+	// Typed handler registered with rest.DefaultCodecs, so that request
+	// and response bodies are negotiated, and validated with mediaSchema
+	mediaHandler struct {
+		rest.TypedResourceHandler[map[string]interface{}]
+	}
+
+	conformanceMiddleware struct {
+	}
+)
+
+var (
+	// The resources registered on every API under test
+	Resources = []string{
+		"users",
+		"users/*/orders",
+		"users/*/orders/*/items",
+		"status",
+		"responses",
+		"users/*/requests",
+		"media",
+	}
+
+	DefaultScenarios = []Scenario{
+		// nested resources
+		{Name: "get many", Method: "GET", Path: "users", ExpectCode: 200, ExpectBody: body("users GETMANY [] q=")},
+		{Name: "get many trailing slash", Method: "GET", Path: "users/", ExpectCode: 200, ExpectBody: body("users GETMANY [] q=")},
+		{Name: "get many query", Method: "GET", Path: "users?q=x", ExpectCode: 200, ExpectBody: body("users GETMANY [] q=x")},
+		{Name: "get", Method: "GET", Path: "users/1", ExpectCode: 200, ExpectBody: body("users GET 1 []")},
+		{Name: "get nested many", Method: "GET", Path: "users/1/orders", ExpectCode: 200, ExpectBody: body("users/*/orders GETMANY [1] q=")},
+		{Name: "get nested", Method: "GET", Path: "users/1/orders/2", ExpectCode: 200, ExpectBody: body("users/*/orders GET 2 [1]")},
+		{Name: "get deeply nested", Method: "GET", Path: "users/1/orders/2/items/3", ExpectCode: 200, ExpectBody: body("users/*/orders/*/items GET 3 [2 1]")},
+		{Name: "post", Method: "POST", Path: "users", Body: `{"a":1}`, ExpectCode: 201, ExpectBody: body(`users POST [] {"a":1}`)},
+		{Name: "post nested", Method: "POST", Path: "users/1/orders", Body: `{"a":1}`, ExpectCode: 201, ExpectBody: body(`users/*/orders POST [1] {"a":1}`)},
+		{Name: "put nested", Method: "PUT", Path: "users/1/orders/2", Body: `{"a":2}`, ExpectCode: 200, ExpectBody: body(`users/*/orders PUT 2 [1] {"a":2}`)},
+		{Name: "patch nested", Method: "PATCH", Path: "users/1/orders/2", Body: `{"a":3}`, ExpectCode: 200, ExpectBody: body(`users/*/orders PATCH 2 [1] {"a":3}`)},
+		{Name: "merge patch", Method: "PATCH", Path: "users/1", Headers: map[string]string{"Content-Type": "application/merge-patch+json"}, Body: `{"a":null}`, ExpectCode: 200, ExpectBody: body(`users PATCH 1 [] {"a":null}`)},
+		{Name: "json patch", Method: "PATCH", Path: "users/1", Headers: map[string]string{"Content-Type": "application/json-patch+json"}, Body: `[{"op":"remove","path":"/a"}]`, ExpectCode: 200, ExpectBody: body(`users PATCH 1 [] [{"op":"remove","path":"/a"}]`)},
+		{Name: "head", Method: "HEAD", Path: "users/1", ExpectCode: 200, ExpectBody: body(""), ExpectHeaders: map[string]string{MiddlewareHeader: "1"}},
+		{Name: "head many", Method: "HEAD", Path: "users", ExpectCode: 200, ExpectBody: body("")},
+		{Name: "head nested", Method: "HEAD", Path: "users/1/orders/2", ExpectCode: 200, ExpectBody: body("")},
+		{Name: "delete nested", Method: "DELETE", Path: "users/1/orders/2", ExpectCode: 200, ExpectBody: body("users/*/orders DELETE 2 [1]")},
+		{Name: "options", Method: "OPTIONS", Path: "users", ExpectCode: 200, ExpectBody: body("users OPTIONS")},
+		{Name: "options with id", Method: "OPTIONS", Path: "users/1/orders/2", ExpectCode: 200, ExpectBody: body("users/*/orders OPTIONS")},
+		{Name: "unknown resource", Method: "GET", Path: "unknown", ExpectCode: 404},
+
+		// status codes and bodies
+		{Name: "error body is sent verbatim", Method: "GET", Path: "status/b409", ExpectCode: 409, ExpectBody: body("status 409")},
+		{Name: "empty error body is a problem", Method: "GET", Path: "status/e500", Headers: map[string]string{CorrelationHeader: "c1"}, ExpectCode: 500, ExpectBody: body(`{"type":"about:blank","title":"Internal Server Error","status":500,"instance":"/api/status/e500","correlationId":"c1"}`), ExpectHeaders: map[string]string{"Content-Type": "application/problem+json"}},
+		{Name: "client error detail", Method: "GET", Path: "status/e409", Headers: map[string]string{CorrelationHeader: "c1"}, ExpectCode: 409, ExpectBody: body(`{"type":"about:blank","title":"Conflict","status":409,"detail":"status 409","instance":"/api/status/e409","correlationId":"c1"}`)},
+		{Name: "problem without error", Method: "GET", Path: "status/s404", Headers: map[string]string{CorrelationHeader: "c1"}, ExpectCode: 404, ExpectBody: body(`{"type":"about:blank","title":"Not Found","status":404,"instance":"/api/status/s404","correlationId":"c1"}`)},
+		{Name: "problem generated correlation id", Method: "GET", Path: "status/s404", ExpectCode: 404, ExpectBodyPattern: `^\{"type":"about:blank","title":"Not Found","status":404,"instance":"/api/status/s404","correlationId":"[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}"\}$`},
+		{Name: "non-200 success body", Method: "GET", Path: "status/b202", ExpectCode: 202, ExpectBody: body("status 202")},
+		{Name: "unimplemented verb", Method: "DELETE", Path: "status/1", ExpectCode: 405},
+		{Name: "unimplemented patch", Method: "PATCH", Path: "status/1", ExpectCode: 405},
+		{Name: "head keeps status", Method: "HEAD", Path: "status/s404", ExpectCode: 404, ExpectBody: body("")},
+
+		// full responses
+		{Name: "response headers", Method: "POST", Path: "responses", Body: `{}`, ExpectCode: 201, ExpectBody: body("created"), ExpectHeaders: map[string]string{"Location": "/api/responses/42"}},
+		{Name: "response header overrides middleware", Method: "GET", Path: "responses/42", ExpectCode: 200, ExpectHeaders: map[string]string{MiddlewareHeader: "handler"}},
+		{Name: "streamed response", Method: "GET", Path: "responses", ExpectCode: 200, ExpectBody: body("streamed body")},
+		{Name: "head drops stream", Method: "HEAD", Path: "responses", ExpectCode: 200, ExpectBody: body("")},
+		{Name: "head keeps headers", Method: "HEAD", Path: "responses/42", ExpectCode: 200, ExpectHeaders: map[string]string{MiddlewareHeader: "handler"}},
+
+		// requests
+		{Name: "request on put", Method: "PUT", Path: "users/1/requests/2?q=x", Headers: map[string]string{RequestHeader: "h"}, Body: `{"a":1}`, ExpectCode: 200, ExpectBody: body(`users/*/requests PUT map[requests:2 users:1] q=x h=h v=mw {"a":1}`)},
+		{Name: "request on get", Method: "GET", Path: "users/1/requests/2?q=y", Headers: map[string]string{RequestHeader: "h"}, ExpectCode: 200, ExpectBody: body("users/*/requests GET map[requests:2 users:1] q=y h=h v=mw ")},
+		{Name: "request on patch", Method: "PATCH", Path: "users/1/requests/2?q=p", Body: `{"a":1}`, ExpectCode: 200, ExpectBody: body(`users/*/requests PATCH map[requests:2 users:1] q=p h= v=mw {"a":1}`)},
+		{Name: "request on delete", Method: "DELETE", Path: "users/1/requests/2?q=z", ExpectCode: 200, ExpectBody: body("users/*/requests DELETE map[requests:2 users:1] q=z h= v=mw ")},
+
+		// media types are negotiated by handlers, not routers
+		{Name: "xml", Method: "POST", Path: "media", Headers: map[string]string{"Content-Type": "application/xml", "Accept": "application/xml"}, Body: `<object><name>pen</name></object>`, ExpectCode: 201, ExpectBody: body(`<object><name>pen</name></object>`), ExpectHeaders: map[string]string{"Content-Type": "application/xml"}},
+		{Name: "msgpack", Method: "PUT", Path: "media/1", Headers: map[string]string{"Content-Type": "application/msgpack", "Accept": "application/msgpack"}, Body: "\x81\xa4name\xa3pen", ExpectCode: 200, ExpectBody: body("\x81\xa4name\xa3pen"), ExpectHeaders: map[string]string{"Content-Type": "application/msgpack"}},
+		{Name: "cbor", Method: "POST", Path: "media", Headers: map[string]string{"Content-Type": "application/cbor", "Accept": "application/cbor"}, Body: "\xa1dnamecpen", ExpectCode: 201, ExpectBody: body("\xa1dnamecpen"), ExpectHeaders: map[string]string{"Content-Type": "application/cbor"}},
+		{Name: "form", Method: "POST", Path: "media", Headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, Body: "name=pen", ExpectCode: 201, ExpectBody: body(`{"name":"pen"}`), ExpectHeaders: map[string]string{"Content-Type": "application/json"}},
+		{Name: "xml to msgpack", Method: "PUT", Path: "media/1", Headers: map[string]string{"Content-Type": "text/xml", "Accept": "application/x-msgpack"}, Body: `<object><name>pen</name></object>`, ExpectCode: 200, ExpectBody: body("\x81\xa4name\xa3pen"), ExpectHeaders: map[string]string{"Content-Type": "application/x-msgpack"}},
+		{Name: "structured syntax suffix", Method: "POST", Path: "media", Headers: map[string]string{"Content-Type": "application/vnd.resttest+json"}, Body: `{"name":"pen"}`, ExpectCode: 201, ExpectBody: body(`{"name":"pen"}`)},
+		{Name: "accept weights", Method: "GET", Path: "media/1", Headers: map[string]string{"Accept": "application/xml;q=0.5, application/cbor"}, ExpectCode: 200, ExpectBody: body("\xa1bida1"), ExpectHeaders: map[string]string{"Content-Type": "application/cbor"}},
+		{Name: "accept list", Method: "GET", Path: "media/1", Headers: map[string]string{"Accept": "text/html, application/xml;q=0.9"}, ExpectCode: 200, ExpectBody: body("<object><id>1</id></object>"), ExpectHeaders: map[string]string{"Content-Type": "application/xml"}},
+		{Name: "accept anything", Method: "GET", Path: "media/1", Headers: map[string]string{"Accept": "*/*"}, ExpectCode: 200, ExpectBody: body(`{"id":"1"}`), ExpectHeaders: map[string]string{"Content-Type": "application/json"}},
+		{Name: "accept type wildcard", Method: "GET", Path: "media/1", Headers: map[string]string{"Accept": "text/*"}, ExpectCode: 200, ExpectHeaders: map[string]string{"Content-Type": "text/xml"}},
+		{Name: "accept anything but json", Method: "GET", Path: "media/1", Headers: map[string]string{"Accept": "application/json;q=0, */*"}, ExpectCode: 200, ExpectHeaders: map[string]string{"Content-Type": "application/xml"}},
+		{Name: "accept only json", Method: "GET", Path: "media/1", Headers: map[string]string{"Accept": "application/json, */*;q=0"}, ExpectCode: 200, ExpectHeaders: map[string]string{"Content-Type": "application/json"}},
+		{Name: "not acceptable", Method: "GET", Path: "media/1", Headers: map[string]string{"Accept": "text/csv"}, ExpectCode: 406},
+		{Name: "excluded type", Method: "GET", Path: "media/1", Headers: map[string]string{"Accept": "application/json;q=0"}, ExpectCode: 406},
+		{Name: "excluded wildcard", Method: "GET", Path: "media/1", Headers: map[string]string{"Accept": "text/*, text/xml;q=0"}, ExpectCode: 406},
+		{Name: "unsupported media type", Method: "POST", Path: "media", Headers: map[string]string{"Content-Type": "text/csv"}, Body: "name,pen", ExpectCode: 415},
+		{Name: "invalid body", Method: "POST", Path: "media", Headers: map[string]string{CorrelationHeader: "c1"}, Body: `{"name":"Pen","size":2}`, ExpectCode: 422, ExpectBody: body(`{"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"name: Must match ^[a-z]+$, size: Unknown field","instance":"/api/media","correlationId":"c1","errors":[{"field":"name","message":"Must match ^[a-z]+$"},{"field":"size","message":"Unknown field"}]}`), ExpectHeaders: map[string]string{"Content-Type": "application/problem+json"}},
+		{Name: "invalid form body", Method: "PUT", Path: "media/1", Headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded", CorrelationHeader: "c1"}, Body: "size=2", ExpectCode: 422, ExpectBody: body(`{"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"name: Is required, size: Unknown field","instance":"/api/media/1","correlationId":"c1","errors":[{"field":"name","message":"Is required"},{"field":"size","message":"Unknown field"}]}`)},
+
+		// OpenAPI document
+		{Name: "openapi document", Method: "GET", Path: OpenAPIPath, ExpectCode: 200, ExpectHeaders: map[string]string{"Content-Type": "application/json"}},
+
+		// middleware
+		{Name: "middleware header", Method: "GET", Path: "users/1", ExpectCode: 200, ExpectHeaders: map[string]string{MiddlewareHeader: "1"}},
+		{Name: "middleware header on error", Method: "GET", Path: "status/s404", ExpectCode: 404, ExpectHeaders: map[string]string{MiddlewareHeader: "1"}},
+		{Name: "middleware failure", Method: "GET", Path: "users/1", Headers: map[string]string{FailMiddlewareHeader: "1", CorrelationHeader: "c2"}, ExpectCode: 500, ExpectBody: body(`{"type":"about:blank","title":"Internal Server Error","status":500,"instance":"/api/users/1","correlationId":"c2"}`)},
+	}
+
+	errMiddleware = errors.New("middleware failed")
+
+	mediaSchema = rest.OpenAPISchema{
+		"type":                 "object",
+		"required":             []string{"name"},
+		"properties":           rest.OpenAPISchema{"name": rest.OpenAPISchema{"type": "string", "pattern": "^[a-z]+$"}},
+		"additionalProperties": false,
+	}
+)
+
+func body(s string) *string {
+	return &s
+}
+
+// Runs DefaultScenarios against the API built by newApi
+func Run(t *testing.T, newApi Constructor) {
+	RunScenarios(t, newApi, DefaultScenarios)
+}
+
+// Registers Resources, the CorrelationID middleware and the conformance
+// middleware on a new API, starts it and runs every scenario against it.
+func RunScenarios(t *testing.T, newApi Constructor, scenarios []Scenario) {
+
+	validator, err := rest.NewSchemaValidator(mediaSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codecs := rest.DefaultCodecs()
+
+	api := newApi(Prefix)
+	for _, name := range Resources {
+		switch name {
+		case "status":
+			api.AddResource(name, statusHandler{name})
+		case "responses", "users/*/requests":
+			api.AddResponseResource(name, responseHandler{name})
+		case "media":
+			media := rest.NewTypedAdapter[map[string]interface{}](mediaHandler{}, codecs)
+			api.AddResponseResource(name, rest.NewValidatedHandler(media, codecs, validator))
+		default:
+			api.AddContextResource(name, echoHandler{name})
+		}
+	}
+	api.AddMiddleware(middleware.NewCorrelationID(CorrelationHeader))
+	api.AddMiddleware(conformanceMiddleware{})
+	api.ServeOpenAPI(OpenAPIPath, "resttest", "1")
+
+	port, err := freePort()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan error, 1)
+	go func() {
+		started <- api.Start(port)
+	}()
+
+	select {
+	case <-api.Ready():
+	case err := <-started:
+		t.Fatalf("server did not start: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not start in time")
+	}
+
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := api.Shutdown(ctx)
+		if err != nil {
+			t.Errorf("shutdown: %v", err)
+		}
+	}()
+
+	base := fmt.Sprintf("http://127.0.0.1:%d/%s/", port, Prefix)
+	for _, s := range scenarios {
+		s := s
+		t.Run(s.Name, func(t *testing.T) {
+			check(t, base, s)
+		})
+	}
+}
+
+func check(t *testing.T, base string, s Scenario) {
+
+	var payload *strings.Reader
+	if s.Body != "" {
+		payload = strings.NewReader(s.Body)
+	} else {
+		payload = strings.NewReader("")
+	}
+
+	r, err := http.NewRequest(s.Method, base+s.Path, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// some routers only accept JSON bodies
+	r.Header.Set("Content-Type", "application/json")
+	for k, v := range s.Headers {
+		r.Header.Set(k, v)
+	}
+
+	rs, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+
+	b, err := ioutil.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rs.StatusCode != s.ExpectCode {
+		t.Errorf("%s /%s: expected status %d, got %d (%q)", s.Method, s.Path, s.ExpectCode, rs.StatusCode, b)
+	}
+
+	if s.ExpectBody != nil && string(b) != *s.ExpectBody {
+		t.Errorf("%s /%s: expected body %q, got %q", s.Method, s.Path, *s.ExpectBody, b)
+	}
+
+	if s.ExpectBodyPattern != "" && !regexp.MustCompile(s.ExpectBodyPattern).Match(b) {
+		t.Errorf("%s /%s: expected body matching %q, got %q", s.Method, s.Path, s.ExpectBodyPattern, b)
+	}
+
+	for k, v := range s.ExpectHeaders {
+		if rs.Header.Get(k) != v {
+			t.Errorf("%s /%s: expected header %s: %q, got %q", s.Method, s.Path, k, v, rs.Header.Get(k))
+		}
+	}
+}
+
+func freePort() (int, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port, nil
+}
+
+func (h echoHandler) respond(code int, format string, a ...interface{}) (int, catrina.Payload, error) {
+	return code, catrina.Payload(h.name + " " + fmt.Sprintf(format, a...)), nil
+}
+
+func (h echoHandler) Options(ctx context.Context) (code int, body catrina.Payload, err error) {
+	return h.respond(http.StatusOK, "OPTIONS")
+}
+
+func (h echoHandler) Post(ctx context.Context, parentIds []string, payload catrina.Payload) (code int, body catrina.Payload, err error) {
+	return h.respond(http.StatusCreated, "POST %v %s", parentIds, payload)
+}
+
+func (h echoHandler) Get(ctx context.Context, id string, parentIds []string) (code int, body catrina.Payload, err error) {
+	return h.respond(http.StatusOK, "GET %s %v", id, parentIds)
+}
+
+func (h echoHandler) GetMany(ctx context.Context, parentIds []string, query catrina.QueryParameters) (code int, body catrina.Payload, err error) {
+	q := ""
+	if values, exists := query["q"]; exists && len(values) > 0 {
+		q = values[0]
+	}
+	return h.respond(http.StatusOK, "GETMANY %v q=%s", parentIds, q)
+}
+
+func (h echoHandler) Put(ctx context.Context, id string, parentIds []string, payload catrina.Payload) (code int, body catrina.Payload, err error) {
+	return h.respond(http.StatusOK, "PUT %s %v %s", id, parentIds, payload)
+}
+
+func (h echoHandler) Patch(ctx context.Context, id string, parentIds []string, payload catrina.Payload) (code int, body catrina.Payload, err error) {
+	return h.respond(http.StatusOK, "PATCH %s %v %s", id, parentIds, payload)
+}
+
+func (h echoHandler) Delete(ctx context.Context, id string, parentIds []string) (code int, body catrina.Payload, err error) {
+	return h.respond(http.StatusOK, "DELETE %s %v", id, parentIds)
+}
+
+// statusHandler implements the plain catrina.ResourceHandler, so the
+// suite also covers AddResource.
+
+func (h statusHandler) Options() (code int, body catrina.Payload, err error) {
+	return http.StatusOK, catrina.EmptyBody, nil
+}
+
+func (h statusHandler) Post(parentIds []string, payload catrina.Payload) (code int, body catrina.Payload, err error) {
+	return http.StatusMethodNotAllowed, catrina.EmptyBody, nil
+}
+
+// ids look like b409 (body), e500 (error) or s404 (status only)
+func (h statusHandler) Get(id string, parentIds []string) (code int, body catrina.Payload, err error) {
+
+	if len(id) < 2 {
+		return http.StatusBadRequest, catrina.EmptyBody, nil
+	}
+
+	_, err = fmt.Sscanf(id[1:], "%d", &code)
+	if err != nil {
+		return http.StatusBadRequest, catrina.EmptyBody, nil
+	}
+
+	switch id[0] {
+	case 'b':
+		return code, catrina.Payload(fmt.Sprintf("%s %d", h.name, code)), nil
+	case 'e':
+		return code, catrina.EmptyBody, fmt.Errorf("%s %d", h.name, code)
+	}
+
+	return code, catrina.EmptyBody, nil
+}
+
+func (h statusHandler) GetMany(parentIds []string, query catrina.QueryParameters) (code int, body catrina.Payload, err error) {
+	return http.StatusMethodNotAllowed, catrina.EmptyBody, nil
+}
+
+func (h statusHandler) Put(id string, parentIds []string, payload catrina.Payload) (code int, body catrina.Payload, err error) {
+	return http.StatusMethodNotAllowed, catrina.EmptyBody, nil
+}
+
+func (h statusHandler) Patch(id string, parentIds []string, payload catrina.Payload) (code int, body catrina.Payload, err error) {
+	return http.StatusMethodNotAllowed, catrina.EmptyBody, nil
+}
+
+func (h statusHandler) Delete(id string, parentIds []string) (code int, body catrina.Payload, err error) {
+	return http.StatusMethodNotAllowed, catrina.EmptyBody, nil
+}
+
+func (h responseHandler) describe(r *catrina.Request) *catrina.Response {
+	payload, _ := r.Payload()
+	return catrina.NewResponse(http.StatusOK, catrina.Payload(fmt.Sprintf(
+		"%s %s %v q=%s h=%s v=%v %s",
+		h.name, r.Method, r.PathIds, r.Query.Get("q"), r.Headers.Get(RequestHeader), r.Value(ValueKey), payload,
+	)), nil)
+}
+
+func (h responseHandler) Options(r *catrina.Request) *catrina.Response {
+	return catrina.NewResponse(http.StatusOK, catrina.EmptyBody, nil)
+}
+
+func (h responseHandler) Post(r *catrina.Request) *catrina.Response {
+	return catrina.NewResponse(http.StatusCreated, catrina.Payload("created"), nil).
+		WithHeader("Location", "/"+Prefix+"/"+h.name+"/42")
+}
+
+func (h responseHandler) Get(r *catrina.Request) *catrina.Response {
+	if len(r.PathIds) > 1 {
+		return h.describe(r)
+	}
+	return catrina.NewResponse(http.StatusOK, catrina.Payload(r.Id), nil).
+		WithHeader(MiddlewareHeader, "handler")
+}
+
+func (h responseHandler) GetMany(r *catrina.Request) *catrina.Response {
+	return catrina.NewResponse(http.StatusOK, catrina.EmptyBody, nil).
+		WithStream(strings.NewReader("streamed body"))
+}
+
+func (h responseHandler) Put(r *catrina.Request) *catrina.Response {
+	return h.describe(r)
+}
+
+func (h responseHandler) Patch(r *catrina.Request) *catrina.Response {
+	return h.describe(r)
+}
+
+func (h responseHandler) Delete(r *catrina.Request) *catrina.Response {
+	return h.describe(r)
+}
+
+// mediaHandler echoes the objects it is sent, and the id it is asked
+// for, as single members so that every codec encodes them the same.
+
+func (h mediaHandler) Post(ctx context.Context, parentIds []string, object map[string]interface{}) (code int, body map[string]interface{}, err error) {
+	return http.StatusCreated, object, nil
+}
+
+func (h mediaHandler) Get(ctx context.Context, id string, parentIds []string) (code int, body map[string]interface{}, err error) {
+	return http.StatusOK, map[string]interface{}{"id": id}, nil
+}
+
+func (h mediaHandler) Put(ctx context.Context, id string, parentIds []string, object map[string]interface{}) (code int, body map[string]interface{}, err error) {
+	return http.StatusOK, object, nil
+}
+
+func (m conformanceMiddleware) Handle(w http.ResponseWriter, r *http.Request) *error {
+	w.Header().Set(MiddlewareHeader, "1")
+	catrina.SetRequestValue(r, ValueKey, "mw")
+	if r.Header.Get(FailMiddlewareHeader) != "" {
+		return &errMiddleware
+	}
+	return nil
+}
@@ -0,0 +1,584 @@
+package rest
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+	"regexp"
+	"strconv"
+	"strings"
+	"net/url"
+	"net/mail"
+	"encoding/json"
+
+	"github.com/buduchail/catrina"
+)
+
+type (
+	// Validates bodies against a JSON schema, in the dialect of the
+	// OpenAPI document: type (and nullable), properties, required,
+	// additionalProperties, items, minItems/maxItems, uniqueItems, enum,
+	// minimum/maximum (and their exclusive forms), multipleOf,
+	// minLength/maxLength, pattern, format (date-time, date, email,
+	// uuid, uri) and the allOf/anyOf/oneOf/not combinators. Unknown
+	// keywords are ignored.
+	//
+	// XML and form bodies hold strings only, so for them strings that
+	// parse as the expected number or boolean are accepted, and single
+	// strings where a list is expected.
+	SchemaValidator struct {
+		schema OpenAPISchema
+		// compiled patterns of the schema and its subschemas
+		patterns map[string]*regexp.Regexp
+	}
+
+	// Walks a decoded body, collecting the problems of each field
+	schemaCheck struct {
+		// values of XML and form bodies are strings
+		lenient  bool
+		patterns map[string]*regexp.Regexp
+		invalid  *catrina.ValidationError
+	}
+)
+
+var (
+	uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+// The schema may be written by hand or built with NewOpenAPISchema,
+// and is also used to document the resource. Its patterns are
+// compiled once, and must be valid regular expressions.
+func NewSchemaValidator(schema OpenAPISchema) (*SchemaValidator, error) {
+
+	patterns := map[string]*regexp.Regexp{}
+	err := compilePatterns(schema, patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SchemaValidator{schema, patterns}, nil
+}
+
+// Parses a JSON schema document
+func NewSchemaValidatorJSON(schema []byte) (*SchemaValidator, error) {
+
+	parsed := OpenAPISchema{}
+	err := json.Unmarshal(schema, &parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSchemaValidator(parsed)
+}
+
+// Helper functions
+
+// Schemas built in Go and decoded from JSON differ in their types:
+// nested schemas may be OpenAPISchema or plain maps, lists []string or
+// []interface{}, and numbers int or float64.
+
+func subschema(v interface{}) (OpenAPISchema, bool) {
+	switch s := v.(type) {
+	case OpenAPISchema:
+		return s, true
+	case map[string]interface{}:
+		return OpenAPISchema(s), true
+	}
+	return nil, false
+}
+
+func subschemas(v interface{}) []OpenAPISchema {
+
+	schemas := []OpenAPISchema{}
+	switch list := v.(type) {
+	case []OpenAPISchema:
+		schemas = append(schemas, list...)
+	case []interface{}:
+		for _, item := range list {
+			if s, ok := subschema(item); ok {
+				schemas = append(schemas, s)
+			}
+		}
+	}
+
+	return schemas
+}
+
+func stringList(v interface{}) []string {
+
+	switch list := v.(type) {
+	case string:
+		return []string{list}
+	case []string:
+		return list
+	case []interface{}:
+		strs := make([]string, 0, len(list))
+		for _, item := range list {
+			if s, ok := item.(string); ok {
+				strs = append(strs, s)
+			}
+		}
+		return strs
+	}
+
+	return nil
+}
+
+func valueList(v interface{}) []interface{} {
+
+	switch list := v.(type) {
+	case []interface{}:
+		return list
+	case []string:
+		values := make([]interface{}, len(list))
+		for i, s := range list {
+			values[i] = s
+		}
+		return values
+	}
+
+	return nil
+}
+
+// Numbers as float64, whatever codec decoded them
+func toNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func keywordNumber(schema OpenAPISchema, keyword string) (float64, bool) {
+	v, ok := schema[keyword]
+	if !ok {
+		return 0, false
+	}
+	return toNumber(v)
+}
+
+func formatNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// Compiles the patterns of a schema and of every subschema check may
+// walk into
+func compilePatterns(schema OpenAPISchema, patterns map[string]*regexp.Regexp) error {
+
+	if pattern, ok := schema["pattern"].(string); ok {
+		if _, compiled := patterns[pattern]; !compiled {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("Invalid pattern %s: %s", pattern, err)
+			}
+			patterns[pattern] = re
+		}
+	}
+
+	nested := []OpenAPISchema{}
+	if properties, ok := subschema(schema["properties"]); ok {
+		for _, raw := range properties {
+			if property, ok := subschema(raw); ok {
+				nested = append(nested, property)
+			}
+		}
+	}
+	for _, keyword := range []string{"additionalProperties", "items", "not"} {
+		if s, ok := subschema(schema[keyword]); ok {
+			nested = append(nested, s)
+		}
+	}
+	for _, keyword := range []string{"allOf", "anyOf", "oneOf"} {
+		nested = append(nested, subschemas(schema[keyword])...)
+	}
+
+	for _, s := range nested {
+		err := compilePatterns(s, patterns)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Field paths: name, name.child, list[0].name
+func fieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func itemPath(path string, i int) string {
+	return fmt.Sprintf("%s[%d]", path, i)
+}
+
+// Values compared as JSON, so that 1 and 1.0 are the same
+func sameValue(a, b interface{}) bool {
+	if x, ok := toNumber(a); ok {
+		y, ok := toNumber(b)
+		return ok && x == y
+	}
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(ja) == string(jb)
+}
+
+// Helper methods
+
+func (c *schemaCheck) fail(path, format string, args ...interface{}) {
+	c.invalid.WithField(path, fmt.Sprintf(format, args...))
+}
+
+// Runs a check on its own, to see if a value matches a subschema
+func (c *schemaCheck) matches(path string, schema OpenAPISchema, v interface{}) bool {
+	sub := &schemaCheck{lenient: c.lenient, patterns: c.patterns, invalid: catrina.NewValidationError("")}
+	sub.check(path, schema, v)
+	return len(sub.invalid.Fields) == 0
+}
+
+func (c *schemaCheck) check(path string, schema OpenAPISchema, v interface{}) {
+
+	if v == nil {
+		if schema["nullable"] == true || c.hasType(schema, "null") || schema["type"] == nil {
+			c.combinators(path, schema, v)
+			return
+		}
+		c.fail(path, "Must not be null")
+		return
+	}
+
+	v, ok := c.checkType(path, schema, v)
+	if !ok {
+		return
+	}
+
+	if enum, ok := schema["enum"]; ok {
+		values := valueList(enum)
+		found := false
+		for _, e := range values {
+			if sameValue(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			options := make([]string, len(values))
+			for i, e := range values {
+				options[i] = fmt.Sprint(e)
+			}
+			c.fail(path, "Must be one of %s", strings.Join(options, ", "))
+		}
+	}
+
+	switch value := v.(type) {
+	case map[string]interface{}:
+		c.checkObject(path, schema, value)
+	case []interface{}:
+		c.checkArray(path, schema, value)
+	case string:
+		c.checkString(path, schema, value)
+	default:
+		if n, ok := toNumber(value); ok {
+			c.checkNumber(path, schema, n)
+		}
+	}
+
+	c.combinators(path, schema, v)
+}
+
+func (c *schemaCheck) hasType(schema OpenAPISchema, name string) bool {
+	for _, t := range stringList(schema["type"]) {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks the type of a value, returning it converted when a lenient
+// string stands for a number or boolean
+func (c *schemaCheck) checkType(path string, schema OpenAPISchema, v interface{}) (interface{}, bool) {
+
+	types := stringList(schema["type"])
+	if len(types) == 0 {
+		return v, true
+	}
+
+	for _, t := range types {
+		if converted, ok := c.ofType(t, v); ok {
+			return converted, true
+		}
+	}
+
+	c.fail(path, "Must be of type %s", strings.Join(types, " or "))
+	return v, false
+}
+
+func (c *schemaCheck) ofType(t string, v interface{}) (interface{}, bool) {
+
+	if s, isString := v.(string); isString && c.lenient {
+		switch t {
+		// a form field given once
+		case "array":
+			v = []interface{}{s}
+		case "integer", "number":
+			f, err := strconv.ParseFloat(s, 64)
+			if err == nil {
+				v = f
+			}
+		case "boolean":
+			b, err := strconv.ParseBool(s)
+			if err == nil {
+				v = b
+			}
+		}
+	}
+
+	switch t {
+	case "object":
+		_, ok := v.(map[string]interface{})
+		return v, ok
+	case "array":
+		_, ok := v.([]interface{})
+		return v, ok
+	case "string":
+		_, ok := v.(string)
+		return v, ok
+	case "boolean":
+		_, ok := v.(bool)
+		return v, ok
+	case "number":
+		_, ok := toNumber(v)
+		return v, ok
+	case "integer":
+		n, ok := toNumber(v)
+		return v, ok && n == math.Trunc(n)
+	}
+
+	return v, false
+}
+
+func (c *schemaCheck) checkObject(path string, schema OpenAPISchema, object map[string]interface{}) {
+
+	for _, name := range stringList(schema["required"]) {
+		if _, ok := object[name]; !ok {
+			c.fail(fieldPath(path, name), "Is required")
+		}
+	}
+
+	properties, _ := subschema(schema["properties"])
+	additional := schema["additionalProperties"]
+
+	// sorted, so that errors are always reported in the same order
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if raw, ok := properties[name]; ok {
+			if property, ok := subschema(raw); ok {
+				c.check(fieldPath(path, name), property, object[name])
+			}
+			continue
+		}
+		if additional == false {
+			c.fail(fieldPath(path, name), "Unknown field")
+			continue
+		}
+		if s, ok := subschema(additional); ok {
+			c.check(fieldPath(path, name), s, object[name])
+		}
+	}
+}
+
+func (c *schemaCheck) checkArray(path string, schema OpenAPISchema, list []interface{}) {
+
+	if min, ok := keywordNumber(schema, "minItems"); ok && float64(len(list)) < min {
+		c.fail(path, "Must have at least %s items", formatNumber(min))
+	}
+	if max, ok := keywordNumber(schema, "maxItems"); ok && float64(len(list)) > max {
+		c.fail(path, "Must have at most %s items", formatNumber(max))
+	}
+
+	if schema["uniqueItems"] == true {
+	unique:
+		for i := range list {
+			for j := 0; j < i; j++ {
+				if sameValue(list[i], list[j]) {
+					c.fail(path, "Items must be unique")
+					break unique
+				}
+			}
+		}
+	}
+
+	if items, ok := subschema(schema["items"]); ok {
+		for i, item := range list {
+			c.check(itemPath(path, i), items, item)
+		}
+	}
+}
+
+func (c *schemaCheck) checkString(path string, schema OpenAPISchema, s string) {
+
+	length := float64(len([]rune(s)))
+	if min, ok := keywordNumber(schema, "minLength"); ok && length < min {
+		c.fail(path, "Must be at least %s characters long", formatNumber(min))
+	}
+	if max, ok := keywordNumber(schema, "maxLength"); ok && length > max {
+		c.fail(path, "Must be at most %s characters long", formatNumber(max))
+	}
+
+	if pattern, ok := schema["pattern"].(string); ok && !c.patterns[pattern].MatchString(s) {
+		c.fail(path, "Must match %s", pattern)
+	}
+
+	format, _ := schema["format"].(string)
+	valid := true
+	switch format {
+	case "date-time":
+		_, err := time.Parse(time.RFC3339, s)
+		valid = err == nil
+	case "date":
+		_, err := time.Parse("2006-01-02", s)
+		valid = err == nil
+	case "email":
+		a, err := mail.ParseAddress(s)
+		valid = err == nil && a.Address == s
+	case "uuid":
+		valid = uuidPattern.MatchString(s)
+	case "uri":
+		u, err := url.Parse(s)
+		valid = err == nil && u.Scheme != ""
+	}
+	if !valid {
+		c.fail(path, "Must be a valid %s", format)
+	}
+}
+
+func (c *schemaCheck) checkNumber(path string, schema OpenAPISchema, n float64) {
+
+	// OpenAPI 3.0 makes the bounds exclusive with a boolean, JSON
+	// schema gives the exclusive bounds themselves
+	if min, ok := keywordNumber(schema, "minimum"); ok {
+		if schema["exclusiveMinimum"] == true && n <= min {
+			c.fail(path, "Must be more than %s", formatNumber(min))
+		} else if n < min {
+			c.fail(path, "Must be at least %s", formatNumber(min))
+		}
+	}
+	if max, ok := keywordNumber(schema, "maximum"); ok {
+		if schema["exclusiveMaximum"] == true && n >= max {
+			c.fail(path, "Must be less than %s", formatNumber(max))
+		} else if n > max {
+			c.fail(path, "Must be at most %s", formatNumber(max))
+		}
+	}
+	if min, ok := keywordNumber(schema, "exclusiveMinimum"); ok && n <= min {
+		c.fail(path, "Must be more than %s", formatNumber(min))
+	}
+	if max, ok := keywordNumber(schema, "exclusiveMaximum"); ok && n >= max {
+		c.fail(path, "Must be less than %s", formatNumber(max))
+	}
+
+	if m, ok := keywordNumber(schema, "multipleOf"); ok && m > 0 {
+		q := n / m
+		if math.Abs(q-math.Round(q)) > 1e-9 {
+			c.fail(path, "Must be a multiple of %s", formatNumber(m))
+		}
+	}
+}
+
+func (c *schemaCheck) combinators(path string, schema OpenAPISchema, v interface{}) {
+
+	// the problems of allOf subschemas are the value's own
+	for _, s := range subschemas(schema["allOf"]) {
+		c.check(path, s, v)
+	}
+
+	if anyOf := subschemas(schema["anyOf"]); len(anyOf) > 0 {
+		matched := false
+		for _, s := range anyOf {
+			if c.matches(path, s, v) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			c.fail(path, "Must match at least one of the allowed schemas")
+		}
+	}
+
+	if oneOf := subschemas(schema["oneOf"]); len(oneOf) > 0 {
+		matched := 0
+		for _, s := range oneOf {
+			if c.matches(path, s, v) {
+				matched++
+			}
+		}
+		if matched != 1 {
+			c.fail(path, "Must match exactly one of the allowed schemas")
+		}
+	}
+
+	if not, ok := subschema(schema["not"]); ok && c.matches(path, not, v) {
+		c.fail(path, "Must not match the disallowed schema")
+	}
+}
+
+// Public interface
+
+func (s *SchemaValidator) Schema() OpenAPISchema {
+	return s.schema
+}
+
+func (s *SchemaValidator) Validate(codec Codec, payload catrina.Payload) error {
+
+	var body interface{}
+	err := codec.Decode(payload, &body)
+	if err != nil {
+		return err
+	}
+
+	_, isXML := codec.(XMLCodec)
+	_, isForm := codec.(FormCodec)
+
+	c := &schemaCheck{lenient: isXML || isForm, patterns: s.patterns, invalid: catrina.NewValidationError("")}
+	c.check("", s.schema, body)
+
+	if len(c.invalid.Fields) > 0 {
+		return c.invalid
+	}
+
+	return nil
+}
@@ -0,0 +1,197 @@
+package rest_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/buduchail/catrina"
+	"github.com/buduchail/catrina/rest"
+)
+
+// Field errors of a body, nil if it is valid
+func schemaErrors(t *testing.T, v *rest.SchemaValidator, codec rest.Codec, body string) []catrina.FieldError {
+
+	err := v.Validate(codec, catrina.Payload(body))
+	if err == nil {
+		return nil
+	}
+
+	var invalid *catrina.ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("%s: unexpected %v", body, err)
+	}
+
+	return invalid.Fields
+}
+
+func field(name, message string) catrina.FieldError {
+	return catrina.FieldError{Field: name, Message: message}
+}
+
+func TestSchemaValidator(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		schema   string
+		body     string
+		expected []catrina.FieldError
+	}{
+		// type
+		{"type", `{"type":"string"}`, `"a"`, nil},
+		{"wrong type", `{"type":"string"}`, `1`, []catrina.FieldError{field("", "Must be of type string")}},
+		{"integer", `{"type":"integer"}`, `2.0`, nil},
+		{"not an integer", `{"type":"integer"}`, `2.5`, []catrina.FieldError{field("", "Must be of type integer")}},
+		{"number", `{"type":"number"}`, `2.5`, nil},
+		{"boolean", `{"type":"boolean"}`, `"true"`, []catrina.FieldError{field("", "Must be of type boolean")}},
+		{"type list", `{"type":["string","integer"]}`, `1`, nil},
+		{"not in type list", `{"type":["string","integer"]}`, `true`, []catrina.FieldError{field("", "Must be of type string or integer")}},
+		{"no type", `{}`, `[1,"a"]`, nil},
+
+		// null
+		{"null", `{"type":"string"}`, `null`, []catrina.FieldError{field("", "Must not be null")}},
+		{"nullable", `{"type":"string","nullable":true}`, `null`, nil},
+		{"null type", `{"type":["string","null"]}`, `null`, nil},
+		{"null without type", `{"properties":{}}`, `null`, nil},
+
+		// objects
+		{"required", `{"type":"object","required":["a","b"]}`, `{"a":1}`, []catrina.FieldError{field("b", "Is required")}},
+		{"properties", `{"type":"object","properties":{"a":{"type":"string"},"b":{"type":"integer"}}}`, `{"a":1,"b":"x","c":true}`, []catrina.FieldError{field("a", "Must be of type string"), field("b", "Must be of type integer")}},
+		{"nested properties", `{"type":"object","properties":{"a":{"type":"object","required":["c"],"properties":{"b":{"type":"string"}}}}}`, `{"a":{"b":1}}`, []catrina.FieldError{field("a.c", "Is required"), field("a.b", "Must be of type string")}},
+		{"no additional properties", `{"type":"object","properties":{"a":{}},"additionalProperties":false}`, `{"a":1,"c":2,"b":3}`, []catrina.FieldError{field("b", "Unknown field"), field("c", "Unknown field")}},
+		{"additional properties schema", `{"type":"object","additionalProperties":{"type":"integer"}}`, `{"a":1,"b":"x"}`, []catrina.FieldError{field("b", "Must be of type integer")}},
+
+		// arrays
+		{"items", `{"type":"array","items":{"type":"object","properties":{"a":{"type":"string"}}}}`, `[{"a":"x"},{"a":1}]`, []catrina.FieldError{field("[1].a", "Must be of type string")}},
+		{"nested items", `{"type":"object","properties":{"a":{"type":"array","items":{"type":"integer"}}}}`, `{"a":[1,"x"]}`, []catrina.FieldError{field("a[1]", "Must be of type integer")}},
+		{"min items", `{"type":"array","minItems":2}`, `[1]`, []catrina.FieldError{field("", "Must have at least 2 items")}},
+		{"max items", `{"type":"array","maxItems":1}`, `[1,2]`, []catrina.FieldError{field("", "Must have at most 1 items")}},
+		{"unique items", `{"type":"array","uniqueItems":true}`, `[1,{"a":1},1.0,{"a":1}]`, []catrina.FieldError{field("", "Items must be unique")}},
+		{"distinct items", `{"type":"array","uniqueItems":true}`, `[1,"1",{"a":1},{"a":2}]`, nil},
+
+		// enum
+		{"enum", `{"enum":["a",1]}`, `1.0`, nil},
+		{"not in enum", `{"enum":["a",1]}`, `"b"`, []catrina.FieldError{field("", "Must be one of a, 1")}},
+
+		// numbers
+		{"minimum", `{"type":"number","minimum":1}`, `0.5`, []catrina.FieldError{field("", "Must be at least 1")}},
+		{"at minimum", `{"type":"number","minimum":1}`, `1`, nil},
+		{"maximum", `{"type":"number","maximum":1.5}`, `2`, []catrina.FieldError{field("", "Must be at most 1.5")}},
+		{"exclusive minimum flag", `{"type":"number","minimum":1,"exclusiveMinimum":true}`, `1`, []catrina.FieldError{field("", "Must be more than 1")}},
+		{"exclusive maximum flag", `{"type":"number","maximum":1,"exclusiveMaximum":true}`, `1`, []catrina.FieldError{field("", "Must be less than 1")}},
+		{"exclusive minimum", `{"type":"number","exclusiveMinimum":1}`, `1`, []catrina.FieldError{field("", "Must be more than 1")}},
+		{"exclusive maximum", `{"type":"number","exclusiveMaximum":1}`, `0.9`, nil},
+		{"multiple of", `{"type":"number","multipleOf":0.1}`, `0.3`, nil},
+		{"not a multiple of", `{"type":"integer","multipleOf":3}`, `7`, []catrina.FieldError{field("", "Must be a multiple of 3")}},
+
+		// strings
+		{"min length", `{"type":"string","minLength":3}`, `"ñá"`, []catrina.FieldError{field("", "Must be at least 3 characters long")}},
+		{"max length", `{"type":"string","maxLength":2}`, `"ñá"`, nil},
+		{"over max length", `{"type":"string","maxLength":2}`, `"abc"`, []catrina.FieldError{field("", "Must be at most 2 characters long")}},
+		{"pattern", `{"type":"string","pattern":"^[a-z]+$"}`, `"abc"`, nil},
+		{"not matching pattern", `{"type":"string","pattern":"^[a-z]+$"}`, `"ab1"`, []catrina.FieldError{field("", "Must match ^[a-z]+$")}},
+		{"nested pattern", `{"type":"array","items":{"anyOf":[{"type":"string","pattern":"^a"},{"type":"string","pattern":"^b"}]}}`, `["ax","bx","cx"]`, []catrina.FieldError{field("[2]", "Must match at least one of the allowed schemas")}},
+
+		// formats
+		{"date-time", `{"type":"string","format":"date-time"}`, `"2024-05-01T10:00:00+02:00"`, nil},
+		{"invalid date-time", `{"type":"string","format":"date-time"}`, `"2024-05-01 10:00"`, []catrina.FieldError{field("", "Must be a valid date-time")}},
+		{"date", `{"type":"string","format":"date"}`, `"2024-05-01"`, nil},
+		{"invalid date", `{"type":"string","format":"date"}`, `"2024-13-01"`, []catrina.FieldError{field("", "Must be a valid date")}},
+		{"email", `{"type":"string","format":"email"}`, `"ana@example.com"`, nil},
+		{"email with name", `{"type":"string","format":"email"}`, `"Ana <ana@example.com>"`, []catrina.FieldError{field("", "Must be a valid email")}},
+		{"uuid", `{"type":"string","format":"uuid"}`, `"123e4567-e89b-12d3-a456-426614174000"`, nil},
+		{"invalid uuid", `{"type":"string","format":"uuid"}`, `"123e4567"`, []catrina.FieldError{field("", "Must be a valid uuid")}},
+		{"uri", `{"type":"string","format":"uri"}`, `"https://example.com/a"`, nil},
+		{"relative uri", `{"type":"string","format":"uri"}`, `"/a"`, []catrina.FieldError{field("", "Must be a valid uri")}},
+		{"unknown format", `{"type":"string","format":"color"}`, `"red"`, nil},
+
+		// combinators
+		{"all of", `{"allOf":[{"type":"integer"},{"minimum":2}]}`, `1`, []catrina.FieldError{field("", "Must be at least 2")}},
+		{"any of", `{"anyOf":[{"type":"string"},{"type":"integer"}]}`, `1`, nil},
+		{"none of any of", `{"anyOf":[{"type":"string"},{"type":"integer"}]}`, `true`, []catrina.FieldError{field("", "Must match at least one of the allowed schemas")}},
+		{"one of", `{"oneOf":[{"type":"integer"},{"minimum":2}]}`, `1`, nil},
+		{"two of one of", `{"oneOf":[{"type":"integer"},{"minimum":2}]}`, `3`, []catrina.FieldError{field("", "Must match exactly one of the allowed schemas")}},
+		{"not", `{"not":{"type":"string"}}`, `"a"`, []catrina.FieldError{field("", "Must not match the disallowed schema")}},
+		{"null any of", `{"anyOf":[{"type":"string"},{"type":"integer"}]}`, `null`, []catrina.FieldError{field("", "Must match at least one of the allowed schemas")}},
+
+		{"unknown keyword", `{"type":"string","contentEncoding":"base64"}`, `"a"`, nil},
+	}
+
+	for _, test := range tests {
+		v, err := rest.NewSchemaValidatorJSON([]byte(test.schema))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		fields := schemaErrors(t, v, rest.JSONCodec{}, test.body)
+		if !reflect.DeepEqual(fields, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, fields)
+		}
+	}
+}
+
+// Values of XML and form bodies are strings
+func TestSchemaValidatorLenient(t *testing.T) {
+
+	v, err := rest.NewSchemaValidator(rest.OpenAPISchema{
+		"type": "object",
+		"properties": rest.OpenAPISchema{
+			"age":    rest.OpenAPISchema{"type": "integer", "minimum": 18},
+			"active": rest.OpenAPISchema{"type": "boolean"},
+			"tags":   rest.OpenAPISchema{"type": "array", "items": rest.OpenAPISchema{"type": "string", "pattern": "^[a-z]+$"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		codec    rest.Codec
+		body     string
+		expected []catrina.FieldError
+	}{
+		{"form", rest.FormCodec{}, "age=20&active=true&tags=a&tags=b", nil},
+		{"form single item", rest.FormCodec{}, "tags=a", nil},
+		{"form invalid", rest.FormCodec{}, "age=17&active=maybe&tags=A", []catrina.FieldError{field("active", "Must be of type boolean"), field("age", "Must be at least 18"), field("tags[0]", "Must match ^[a-z]+$")}},
+		{"xml", rest.XMLCodec{}, "<object><age>20</age><active>false</active></object>", nil},
+		{"xml invalid", rest.XMLCodec{}, "<object><age>twenty</age></object>", []catrina.FieldError{field("age", "Must be of type integer")}},
+		// JSON bodies have types of their own
+		{"json strings", rest.JSONCodec{}, `{"age":"20","tags":"a"}`, []catrina.FieldError{field("age", "Must be of type integer"), field("tags", "Must be of type array")}},
+	}
+
+	for _, test := range tests {
+		fields := schemaErrors(t, v, test.codec, test.body)
+		if !reflect.DeepEqual(fields, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, fields)
+		}
+	}
+}
+
+func TestSchemaValidatorErrors(t *testing.T) {
+
+	schemas := []string{
+		`{"type":`,
+		`{"type":"string","pattern":"("}`,
+		`{"properties":{"a":{"type":"array","items":{"pattern":"[a-"}}}}`,
+		`{"oneOf":[{"not":{"pattern":"*"}}]}`,
+	}
+
+	for _, schema := range schemas {
+		_, err := rest.NewSchemaValidatorJSON([]byte(schema))
+		if err == nil {
+			t.Errorf("%s: expected an error", schema)
+		}
+	}
+
+	_, err := rest.NewSchemaValidator(rest.OpenAPISchema{"additionalProperties": rest.OpenAPISchema{"pattern": "(?<"}})
+	if err == nil {
+		t.Error("expected an invalid pattern error")
+	}
+
+	// bodies that cannot be decoded are not validation errors
+	v, _ := rest.NewSchemaValidatorJSON([]byte(`{"type":"object"}`))
+	err = v.Validate(rest.JSONCodec{}, catrina.Payload(`{"a":`))
+	if err == nil || errors.Is(err, catrina.ValidationErr) {
+		t.Errorf("expected a decoding error, got %v", err)
+	}
+}
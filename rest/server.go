@@ -0,0 +1,150 @@
+package rest
+
+import (
+	"net"
+	"sync"
+	"errors"
+	"context"
+	"strconv"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	"net/http"
+
+	"github.com/buduchail/catrina"
+)
+
+var (
+	errServerStarted = errors.New("Server already started")
+	errServerClosed  = errors.New("Server closed")
+)
+
+type (
+	// Shared lifecycle state for RestAPI implementations: readiness,
+	// shutdown hooks and a way to stop whichever server is running.
+	// Adapters keep it behind a pointer, like middlewareChain.
+	lifecycle struct {
+		lock     sync.Mutex
+		ready    chan struct{}
+		hooks    []func()
+		started  bool
+		closed   bool
+		shutdown func(ctx context.Context) error
+	}
+)
+
+func newLifecycle() *lifecycle {
+	return &lifecycle{
+		ready: make(chan struct{}),
+		hooks: make([]func(), 0),
+	}
+}
+
+// Opens the listener and registers the function that will stop the
+// server. The ready channel is closed once the listener is open.
+func (lc *lifecycle) listen(port int, shutdown func(ctx context.Context) error) (net.Listener, error) {
+
+	lc.lock.Lock()
+	defer lc.lock.Unlock()
+
+	if lc.closed {
+		return nil, errServerClosed
+	}
+	if lc.started {
+		return nil, errServerStarted
+	}
+
+	ln, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		return nil, err
+	}
+
+	lc.started = true
+	lc.shutdown = shutdown
+	close(lc.ready)
+
+	return ln, nil
+}
+
+// Serves any http.Handler on a net/http server.
+func (lc *lifecycle) serveHTTP(port int, handler http.Handler) error {
+
+	server := &http.Server{Handler: handler}
+
+	ln, err := lc.listen(port, server.Shutdown)
+	if err != nil {
+		return err
+	}
+
+	err = server.Serve(ln)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+
+	return err
+}
+
+func (lc *lifecycle) onShutdown(f func()) {
+	lc.lock.Lock()
+	defer lc.lock.Unlock()
+	lc.hooks = append(lc.hooks, f)
+}
+
+func (lc *lifecycle) stop(ctx context.Context) (err error) {
+
+	lc.lock.Lock()
+	if lc.closed {
+		lc.lock.Unlock()
+		return nil
+	}
+	lc.closed = true
+	shutdown := lc.shutdown
+	hooks := lc.hooks
+	lc.lock.Unlock()
+
+	if shutdown != nil {
+		err = shutdown(ctx)
+	}
+
+	for _, f := range hooks {
+		f()
+	}
+
+	return err
+}
+
+// Starts the API and blocks until one of the given signals (SIGINT
+// and SIGTERM by default) is received, then shuts it down allowing
+// in-flight requests up to timeout to complete.
+func ListenAndServeGracefully(api catrina.RestAPI, port int, timeout time.Duration, signals ...os.Signal) error {
+
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, signals...)
+	defer signal.Stop(quit)
+
+	result := make(chan error, 1)
+	go func() {
+		result <- api.Start(port)
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-quit:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := api.Shutdown(ctx)
+	if err != nil {
+		return err
+	}
+
+	return <-result
+}
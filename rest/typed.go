@@ -0,0 +1,202 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"github.com/buduchail/catrina"
+)
+
+type (
+	// Adapter that decodes request bodies for a TypedResourceHandler
+	// and encodes the values it returns, so it can be registered with
+	// AddResponseResource on any RestAPI. Bodies are negotiated when the
+	// codec is a Codecs registry.
+	typedAdapter[T any] struct {
+		handler catrina.TypedResourceHandler[T]
+		codec   Codec
+		// set per request by negotiate
+		negotiated
+	}
+
+	// Base implementation of catrina.TypedResourceHandler, same as
+	// ResourceHandler for typed handlers.
+	TypedResourceHandler[T any] struct {
+	}
+)
+
+func NewTypedAdapter[T any](handler catrina.TypedResourceHandler[T], codec Codec) catrina.ResponseResourceHandler {
+	return typedAdapter[T]{handler: handler, codec: codec}
+}
+
+// Copy of the adapter with the codecs of the request, picked before
+// calling the handler so that nothing changes before a 406 or 415
+func (a typedAdapter[T]) negotiate(r *catrina.Request, decoding bool) (typedAdapter[T], *catrina.Response) {
+	n, rs := negotiate(a.codec, r, decoding)
+	a.negotiated = n
+	return a, rs
+}
+
+// Errors are sent as they are, values are only encoded on success
+func (a typedAdapter[T]) encode(code int, v interface{}, err error) *catrina.Response {
+
+	if err != nil || code >= http.StatusBadRequest {
+		return catrina.NewResponse(code, catrina.EmptyBody, err)
+	}
+
+	return a.negotiated.encode(code, v)
+}
+
+func (a typedAdapter[T]) decode(codec Codec, payload catrina.Payload) (object T, err error) {
+	err = codec.Decode(payload, &object)
+	return object, err
+}
+
+func (a typedAdapter[T]) Options(r *catrina.Request) *catrina.Response {
+	code, err := a.handler.Options(r.Context())
+	return catrina.NewResponse(code, catrina.EmptyBody, err)
+}
+
+func (a typedAdapter[T]) Post(r *catrina.Request) *catrina.Response {
+
+	a, rs := a.negotiate(r, true)
+	if rs != nil {
+		return rs
+	}
+
+	payload, err := r.Payload()
+	if err != nil {
+		return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, err)
+	}
+
+	object, err := a.decode(a.in, payload)
+	if err != nil {
+		return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, err)
+	}
+
+	return a.encode(a.handler.Post(r.Context(), r.ParentIds, object))
+}
+
+func (a typedAdapter[T]) Get(r *catrina.Request) *catrina.Response {
+
+	a, rs := a.negotiate(r, false)
+	if rs != nil {
+		return rs
+	}
+
+	return a.encode(a.handler.Get(r.Context(), r.Id, r.ParentIds))
+}
+
+func (a typedAdapter[T]) GetMany(r *catrina.Request) *catrina.Response {
+
+	a, rs := a.negotiate(r, false)
+	if rs != nil {
+		return rs
+	}
+
+	code, objects, err := a.handler.GetMany(r.Context(), r.ParentIds, r.Query)
+	if objects == nil {
+		// encode an empty list rather than null
+		objects = []T{}
+	}
+	return a.encode(code, objects, err)
+}
+
+func (a typedAdapter[T]) Put(r *catrina.Request) *catrina.Response {
+
+	a, rs := a.negotiate(r, true)
+	if rs != nil {
+		return rs
+	}
+
+	payload, err := r.Payload()
+	if err != nil {
+		return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, err)
+	}
+
+	object, err := a.decode(a.in, payload)
+	if err != nil {
+		return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, err)
+	}
+
+	return a.encode(a.handler.Put(r.Context(), r.Id, r.ParentIds, object))
+}
+
+// Gets the current object, applies the patch (see ApplyPatch) to its
+// encoded form and puts the result. Only works with codecs producing
+// JSON.
+func (a typedAdapter[T]) Patch(r *catrina.Request) *catrina.Response {
+
+	a, rs := a.negotiate(r, false)
+	if rs != nil {
+		return rs
+	}
+
+	payload, err := r.Payload()
+	if err != nil {
+		return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, err)
+	}
+
+	code, current, err := a.handler.Get(r.Context(), r.Id, r.ParentIds)
+	if err != nil || code >= http.StatusBadRequest {
+		return catrina.NewResponse(code, catrina.EmptyBody, err)
+	}
+
+	doc, err := a.codec.Encode(current)
+	if err != nil {
+		return catrina.NewResponse(http.StatusInternalServerError, catrina.EmptyBody, err)
+	}
+
+	doc, err = ApplyPatch(r.Headers.Get("Content-Type"), doc, payload)
+	if err == PatchTestFailedErr {
+		return catrina.NewResponse(http.StatusConflict, catrina.EmptyBody, err)
+	}
+	if err != nil {
+		return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, err)
+	}
+
+	object, err := a.decode(a.codec, doc)
+	if err != nil {
+		return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, err)
+	}
+
+	return a.encode(a.handler.Put(r.Context(), r.Id, r.ParentIds, object))
+}
+
+func (a typedAdapter[T]) Delete(r *catrina.Request) *catrina.Response {
+	code, err := a.handler.Delete(r.Context(), r.Id, r.ParentIds)
+	return catrina.NewResponse(code, catrina.EmptyBody, err)
+}
+
+// The schema is derived from T, unless the handler provides its own
+func (a typedAdapter[T]) OpenAPI() OpenAPIResource {
+	resource := describe(a.handler)
+	if resource.Schema == nil {
+		var object T
+		resource.Schema = NewOpenAPISchema(object)
+	}
+	return resource
+}
+
+func (s TypedResourceHandler[T]) Options(ctx context.Context) (code int, err error) {
+	return http.StatusOK, nil
+}
+
+func (s TypedResourceHandler[T]) Post(ctx context.Context, parentIds []string, object T) (code int, body T, err error) {
+	return http.StatusMethodNotAllowed, body, nil
+}
+
+func (s TypedResourceHandler[T]) Get(ctx context.Context, id string, parentIds []string) (code int, body T, err error) {
+	return http.StatusMethodNotAllowed, body, nil
+}
+
+func (s TypedResourceHandler[T]) GetMany(ctx context.Context, parentIds []string, query catrina.QueryParameters) (code int, body []T, err error) {
+	return http.StatusMethodNotAllowed, body, nil
+}
+
+func (s TypedResourceHandler[T]) Put(ctx context.Context, id string, parentIds []string, object T) (code int, body T, err error) {
+	return http.StatusMethodNotAllowed, body, nil
+}
+
+func (s TypedResourceHandler[T]) Delete(ctx context.Context, id string, parentIds []string) (code int, err error) {
+	return http.StatusMethodNotAllowed, nil
+}
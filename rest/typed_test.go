@@ -0,0 +1,172 @@
+package rest_test
+
+import (
+	"fmt"
+	"context"
+	"reflect"
+	"testing"
+	"net/http"
+
+	"github.com/buduchail/catrina"
+	"github.com/buduchail/catrina/crud"
+	"github.com/buduchail/catrina/rest"
+)
+
+type (
+	note struct {
+		Id   string `json:"id"`
+		User string `json:"user"`
+		Text string `json:"text"`
+	}
+
+	// Typed handler of the notes of a user, without Delete
+	noteHandler struct {
+		rest.TypedResourceHandler[note]
+		notes catrina.TypedCRUD[note, string]
+	}
+)
+
+func newNotes(t *testing.T) catrina.ResponseResourceHandler {
+
+	memory, err := crud.NewMemoryCRUD([]string{"id", "user", "text"}, func(values []catrina.Value) (interface{}, error) {
+		return note{Id: fmt.Sprint(values[0]), User: values[1].(string), Text: values[2].(string)}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notes := crud.NewTypedCRUD[note, string](memory, func(n note) []catrina.Value {
+		return []catrina.Value{n.User, n.Text}
+	})
+
+	return rest.NewTypedAdapter[note](noteHandler{notes: notes}, rest.DefaultCodecs())
+}
+
+func (h noteHandler) find(ctx context.Context, id string, parentIds []string) (note, error) {
+	n, err := h.notes.Select(ctx, id)
+	if err == nil && n.User != parentIds[0] {
+		err = catrina.NotFoundErr
+	}
+	return n, err
+}
+
+func (h noteHandler) Post(ctx context.Context, parentIds []string, n note) (int, note, error) {
+	n.User = parentIds[0]
+	id, err := h.notes.Insert(ctx, n)
+	if err != nil {
+		return rest.ErrorStatus(err), n, err
+	}
+	n.Id = id
+	return http.StatusCreated, n, nil
+}
+
+func (h noteHandler) Get(ctx context.Context, id string, parentIds []string) (int, note, error) {
+	n, err := h.find(ctx, id, parentIds)
+	return rest.ErrorStatus(err), n, err
+}
+
+func (h noteHandler) GetMany(ctx context.Context, parentIds []string, query catrina.QueryParameters) (int, []note, error) {
+
+	rows, err := h.notes.SelectWhere(ctx, catrina.Eq("user", parentIds[0]))
+	if err != nil {
+		return rest.ErrorStatus(err), nil, err
+	}
+
+	var notes []note
+	for row := range rows {
+		notes = append(notes, row.Result)
+	}
+
+	return http.StatusOK, notes, nil
+}
+
+func (h noteHandler) Put(ctx context.Context, id string, parentIds []string, n note) (int, note, error) {
+
+	_, err := h.find(ctx, id, parentIds)
+	if err != nil {
+		return rest.ErrorStatus(err), n, err
+	}
+
+	n.Id, n.User = id, parentIds[0]
+
+	return http.StatusOK, n, h.notes.Update(ctx, id, n)
+}
+
+func TestTypedAdapter(t *testing.T) {
+
+	h := newNotes(t)
+
+	tests := []struct {
+		name        string
+		request     *catrina.Request
+		contentType string
+		expected    int
+		body        interface{}
+	}{
+		{"post", newRequest(http.MethodPost, "ana", "", `{"text":"hi","user":"bob"}`), "", http.StatusCreated, note{"1", "ana", "hi"}},
+		{"post form", newRequest(http.MethodPost, "ana", "", "text=bye"), "application/x-www-form-urlencoded", http.StatusCreated, note{"2", "ana", "bye"}},
+		{"post csv", newRequest(http.MethodPost, "ana", "", "text,hi"), "text/csv", http.StatusUnsupportedMediaType, nil},
+		{"undecodable post", newRequest(http.MethodPost, "ana", "", `{"text":1}`), "", http.StatusBadRequest, nil},
+		{"get", newRequest(http.MethodGet, "ana", "1", ""), "", http.StatusOK, note{"1", "ana", "hi"}},
+		{"get of another user", newRequest(http.MethodGet, "bob", "1", ""), "", http.StatusNotFound, nil},
+		{"get missing", newRequest(http.MethodGet, "ana", "9", ""), "", http.StatusNotFound, nil},
+		{"get many", newRequest(http.MethodGet, "ana", "", ""), "", http.StatusOK, []note{{"1", "ana", "hi"}, {"2", "ana", "bye"}}},
+		// an empty list rather than null
+		{"get many empty", newRequest(http.MethodGet, "bob", "", ""), "", http.StatusOK, []note{}},
+		{"put", newRequest(http.MethodPut, "ana", "1", `{"text":"hello"}`), "", http.StatusOK, note{"1", "ana", "hello"}},
+		{"put of another user", newRequest(http.MethodPut, "bob", "1", `{"text":"hello"}`), "", http.StatusNotFound, nil},
+		{"merge patch", newRequest(http.MethodPatch, "ana", "2", `{"text":"ciao"}`), "", http.StatusOK, note{"2", "ana", "ciao"}},
+		{"json patch", newRequest(http.MethodPatch, "ana", "2", `[{"op":"replace","path":"/text","value":"adios"}]`), rest.JSONPatchContentType, http.StatusOK, note{"2", "ana", "adios"}},
+		{"failed patch test", newRequest(http.MethodPatch, "ana", "2", `[{"op":"test","path":"/text","value":"ciao"}]`), rest.JSONPatchContentType, http.StatusConflict, nil},
+		{"patch of another user", newRequest(http.MethodPatch, "bob", "2", `{"text":"ciao"}`), "", http.StatusNotFound, nil},
+		// not implemented by the handler
+		{"delete", newRequest(http.MethodDelete, "ana", "1", ""), "", http.StatusMethodNotAllowed, nil},
+		{"options", newRequest(http.MethodOptions, "ana", "", ""), "", http.StatusOK, nil},
+	}
+
+	for _, test := range tests {
+
+		r := test.request
+		if test.contentType != "" {
+			r.Headers.Set("Content-Type", test.contentType)
+		}
+
+		var rs *catrina.Response
+		switch {
+		case r.Method == http.MethodPost:
+			rs = h.Post(r)
+		case r.Method == http.MethodGet && r.Id != "":
+			rs = h.Get(r)
+		case r.Method == http.MethodGet:
+			rs = h.GetMany(r)
+		case r.Method == http.MethodPut:
+			rs = h.Put(r)
+		case r.Method == http.MethodPatch:
+			rs = h.Patch(r)
+		case r.Method == http.MethodDelete:
+			rs = h.Delete(r)
+		default:
+			rs = h.Options(r)
+		}
+
+		if rs.Code != test.expected {
+			t.Errorf("%s: expected %d, got %d (%v)", test.name, test.expected, rs.Code, rs.Err)
+			continue
+		}
+		if test.body == nil {
+			continue
+		}
+
+		body := reflect.New(reflect.TypeOf(test.body))
+		decodeBody(t, rs, body.Interface())
+		if !reflect.DeepEqual(body.Elem().Interface(), test.body) {
+			t.Errorf("%s: expected %v, got %s", test.name, test.body, rs.Body)
+		}
+	}
+
+	// the schema is derived from the type
+	schema := h.(rest.OpenAPIDescriber).OpenAPI().Schema
+	if !reflect.DeepEqual(schema["properties"], rest.NewOpenAPISchema(note{})["properties"]) {
+		t.Errorf("unexpected schema %v", schema)
+	}
+}
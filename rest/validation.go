@@ -0,0 +1,255 @@
+package rest
+
+import (
+	"fmt"
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"net/http"
+	"encoding/json"
+
+	"github.com/buduchail/catrina"
+	"github.com/go-playground/validator/v10"
+)
+
+type (
+	// Checks a request body, decoded with the given codec. Invalid
+	// bodies are reported with a *catrina.ValidationError listing the
+	// problem of each field; any other error means the body could not
+	// be decoded at all.
+	Validator interface {
+		Validate(codec Codec, payload catrina.Payload) error
+	}
+
+	// Adapter that validates the bodies of POST and PUT requests before
+	// the handler sees them. Invalid bodies are answered with a 422,
+	// whose problem document lists the field errors, and bodies that
+	// cannot be decoded with a 400. PATCH bodies are patches, not
+	// objects, so they are passed on unchecked.
+	//
+	//	api.AddResponseResource("users", rest.NewValidatedHandler(
+	//		users, rest.DefaultCodecs(), rest.NewStructValidator[User](),
+	//	))
+	validatedHandler struct {
+		handler   catrina.ResponseResourceHandler
+		codec     Codec
+		validator Validator
+	}
+
+	// Validates bodies against the `validate` tags of T, a struct type,
+	// as understood by github.com/go-playground/validator. Fields are
+	// reported by their JSON names, e.g. "address.city".
+	//
+	//	type User struct {
+	//		Name  string `json:"name" validate:"required,max=64"`
+	//		Email string `json:"email" validate:"required,email"`
+	//	}
+	StructValidator[T any] struct {
+		validate *validator.Validate
+	}
+)
+
+func NewValidatedHandler(handler catrina.ResponseResourceHandler, codec Codec, validator Validator) catrina.ResponseResourceHandler {
+	return validatedHandler{handler, codec, validator}
+}
+
+// Same as NewValidatedHandler, for the handlers given to AddResource
+func NewValidatedResource(handler catrina.ResourceHandler, codec Codec, validator Validator) catrina.ResponseResourceHandler {
+	return NewValidatedHandler(NewResponseAdapter(NewContextAdapter(handler)), codec, validator)
+}
+
+func NewStructValidator[T any]() *StructValidator[T] {
+
+	v := validator.New()
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		switch name {
+		case "-":
+			return ""
+		case "":
+			return f.Name
+		}
+		return name
+	})
+
+	return &StructValidator[T]{v}
+}
+
+// Gives access to the underlying validator, e.g. to register custom
+// validation tags
+func (s *StructValidator[T]) Validator() *validator.Validate {
+	return s.validate
+}
+
+// Helper methods
+
+// Returns the response to send instead of calling the handler, if any
+func (h validatedHandler) check(r *catrina.Request) *catrina.Response {
+
+	n, rs := negotiate(h.codec, r, true)
+	if rs != nil {
+		return rs
+	}
+
+	payload, err := r.Payload()
+	if err != nil {
+		return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, err)
+	}
+	// handlers reading the body themselves get it again
+	r.Body = bytes.NewReader(payload)
+
+	err = h.validator.Validate(n.in, payload)
+	if errors.Is(err, catrina.ValidationErr) {
+		return catrina.NewResponse(http.StatusUnprocessableEntity, catrina.EmptyBody, err)
+	}
+	if err != nil {
+		return catrina.NewResponse(http.StatusBadRequest, catrina.EmptyBody, err)
+	}
+
+	return nil
+}
+
+// Values of the wrong type are field errors too
+func decodeValidationError(err error) error {
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return catrina.NewValidationError("").
+			WithField(typeErr.Field, "Must be of type "+jsonTypeName(typeErr.Type))
+	}
+
+	return err
+}
+
+func jsonTypeName(t reflect.Type) string {
+	schema := typeSchema(t, map[reflect.Type]bool{})
+	if name, ok := schema["type"].(string); ok {
+		return name
+	}
+	return t.String()
+}
+
+func fieldErrorMessage(e validator.FieldError) string {
+
+	switch e.Tag() {
+	case "required":
+		return "Is required"
+	case "email", "url", "uri", "uuid", "ip", "hostname", "datetime":
+		return "Must be a valid " + e.Tag()
+	case "oneof":
+		return "Must be one of " + e.Param()
+	}
+
+	// sizes of strings, slices and maps, or numeric bounds
+	sized := e.Kind() == reflect.String || e.Kind() == reflect.Slice || e.Kind() == reflect.Map
+	bound := e.Param()
+	if sized {
+		bound += " characters long"
+		if e.Kind() != reflect.String {
+			bound = e.Param() + " items long"
+		}
+	}
+
+	switch e.Tag() {
+	case "len", "eq":
+		if sized {
+			return "Must be " + bound
+		}
+		return "Must be equal to " + bound
+	case "min", "gte":
+		return "Must be at least " + bound
+	case "max", "lte":
+		return "Must be at most " + bound
+	case "gt":
+		return "Must be more than " + bound
+	case "lt":
+		return "Must be less than " + bound
+	case "ne":
+		return "Must not be " + e.Param()
+	}
+
+	if e.Param() != "" {
+		return fmt.Sprintf("Failed the %s=%s check", e.Tag(), e.Param())
+	}
+	return fmt.Sprintf("Failed the %s check", e.Tag())
+}
+
+// Namespaces start with the name of the struct type, which is dropped
+func fieldErrorPath(e validator.FieldError) string {
+	_, path, _ := strings.Cut(e.Namespace(), ".")
+	return path
+}
+
+// Public interface
+
+func (h validatedHandler) Options(r *catrina.Request) *catrina.Response {
+	return h.handler.Options(r)
+}
+
+func (h validatedHandler) Post(r *catrina.Request) *catrina.Response {
+	if rs := h.check(r); rs != nil {
+		return rs
+	}
+	return h.handler.Post(r)
+}
+
+func (h validatedHandler) Get(r *catrina.Request) *catrina.Response {
+	return h.handler.Get(r)
+}
+
+func (h validatedHandler) GetMany(r *catrina.Request) *catrina.Response {
+	return h.handler.GetMany(r)
+}
+
+func (h validatedHandler) Put(r *catrina.Request) *catrina.Response {
+	if rs := h.check(r); rs != nil {
+		return rs
+	}
+	return h.handler.Put(r)
+}
+
+func (h validatedHandler) Patch(r *catrina.Request) *catrina.Response {
+	return h.handler.Patch(r)
+}
+
+func (h validatedHandler) Delete(r *catrina.Request) *catrina.Response {
+	return h.handler.Delete(r)
+}
+
+// The schema of the validator documents handlers that have none
+func (h validatedHandler) OpenAPI() OpenAPIResource {
+	resource := describe(h.handler)
+	if s, ok := h.validator.(interface{ Schema() OpenAPISchema }); ok && resource.Schema == nil {
+		resource.Schema = s.Schema()
+	}
+	return resource
+}
+
+func (s *StructValidator[T]) Schema() OpenAPISchema {
+	var object T
+	return NewOpenAPISchema(object)
+}
+
+func (s *StructValidator[T]) Validate(codec Codec, payload catrina.Payload) error {
+
+	var object T
+	err := codec.Decode(payload, &object)
+	if err != nil {
+		return decodeValidationError(err)
+	}
+
+	err = s.validate.Struct(&object)
+
+	var fieldErrs validator.ValidationErrors
+	if !errors.As(err, &fieldErrs) {
+		return err
+	}
+
+	invalid := catrina.NewValidationError("")
+	for _, e := range fieldErrs {
+		invalid.WithField(fieldErrorPath(e), fieldErrorMessage(e))
+	}
+
+	return invalid
+}
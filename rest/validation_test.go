@@ -0,0 +1,137 @@
+package rest_test
+
+import (
+	"errors"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"net/http"
+
+	"github.com/buduchail/catrina"
+	"github.com/buduchail/catrina/rest"
+)
+
+type (
+	validatedAddress struct {
+		City string `json:"city" validate:"required"`
+		Zip  string `json:"zip" validate:"omitempty,len=5"`
+	}
+
+	validatedAccount struct {
+		Name     string             `json:"name" validate:"required,max=8"`
+		Email    string             `json:"email" validate:"omitempty,email"`
+		Age      int                `json:"age" validate:"gte=18,lt=130"`
+		Role     string             `json:"role,omitempty" validate:"omitempty,oneof=admin user"`
+		Tags     []string           `json:"tags" validate:"max=2,dive,min=2"`
+		Score    int                `json:"score" validate:"omitempty,eq=3"`
+		Prefix   string             `json:"prefix" validate:"omitempty,startswith=a"`
+		Letters  string             `json:"letters" validate:"omitempty,alpha"`
+		Code     string             `validate:"ne=x"`
+		Address  validatedAddress   `json:"address"`
+		Contacts []validatedAddress `json:"contacts" validate:"dive"`
+	}
+
+	// Records the bodies of the requests it is passed
+	bodyRecorder struct {
+		rest.ResponseResourceHandler
+		bodies *[]string
+	}
+)
+
+func (h bodyRecorder) Post(r *catrina.Request) *catrina.Response {
+	payload, _ := r.Payload()
+	*h.bodies = append(*h.bodies, string(payload))
+	return catrina.NewResponse(http.StatusCreated, payload, nil)
+}
+
+const validAccount = `{"name":"ana","age":30,"address":{"city":"Lima"}}`
+
+func TestStructValidator(t *testing.T) {
+
+	v := rest.NewStructValidator[validatedAccount]()
+
+	tests := []struct {
+		name     string
+		body     string
+		expected []catrina.FieldError
+	}{
+		{"valid", validAccount, nil},
+		{"required", `{"age":30,"Code":"y"}`, []catrina.FieldError{field("name", "Is required"), field("address.city", "Is required")}},
+		{"string size", `{"name":"anastasia","age":30,"address":{"city":"Lima","zip":"123"}}`, []catrina.FieldError{field("name", "Must be at most 8 characters long"), field("address.zip", "Must be 5 characters long")}},
+		{"numeric bounds", `{"name":"ana","age":17,"address":{"city":"Lima"}}`, []catrina.FieldError{field("age", "Must be at least 18")}},
+		{"exclusive bound", `{"name":"ana","age":130,"address":{"city":"Lima"}}`, []catrina.FieldError{field("age", "Must be less than 130")}},
+		{"equal", `{"name":"ana","age":30,"score":2,"address":{"city":"Lima"}}`, []catrina.FieldError{field("score", "Must be equal to 3")}},
+		{"format", `{"name":"ana","email":"ana","age":30,"address":{"city":"Lima"}}`, []catrina.FieldError{field("email", "Must be a valid email")}},
+		{"one of", `{"name":"ana","age":30,"role":"root","address":{"city":"Lima"}}`, []catrina.FieldError{field("role", "Must be one of admin user")}},
+		{"list size", `{"name":"ana","age":30,"tags":["ab","cd","ef"],"address":{"city":"Lima"}}`, []catrina.FieldError{field("tags", "Must be at most 2 items long")}},
+		{"list items", `{"name":"ana","age":30,"tags":["ab","c"],"contacts":[{"city":"Quito"},{}],"address":{"city":"Lima"}}`, []catrina.FieldError{field("tags[1]", "Must be at least 2 characters long"), field("contacts[1].city", "Is required")}},
+		{"other tags", `{"name":"ana","age":30,"prefix":"b","letters":"a1","address":{"city":"Lima"}}`, []catrina.FieldError{field("prefix", "Failed the startswith=a check"), field("letters", "Failed the alpha check")}},
+		{"field without json name", `{"name":"ana","age":30,"Code":"x","address":{"city":"Lima"}}`, []catrina.FieldError{field("Code", "Must not be x")}},
+
+		// values of the wrong type cannot be decoded
+		{"wrong type", `{"name":"ana","age":"thirty"}`, []catrina.FieldError{field("age", "Must be of type integer")}},
+		{"nested wrong type", `{"name":"ana","age":30,"address":{"city":1}}`, []catrina.FieldError{field("address.city", "Must be of type string")}},
+	}
+
+	for _, test := range tests {
+		fields := validationErrors(t, v, test.body)
+		if !reflect.DeepEqual(fields, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, fields)
+		}
+	}
+
+	err := v.Validate(rest.JSONCodec{}, catrina.Payload(`{"name":`))
+	if err == nil || errors.Is(err, catrina.ValidationErr) {
+		t.Errorf("expected a decoding error, got %v", err)
+	}
+}
+
+func validationErrors(t *testing.T, v rest.Validator, body string) []catrina.FieldError {
+
+	err := v.Validate(rest.JSONCodec{}, catrina.Payload(body))
+	if err == nil {
+		return nil
+	}
+
+	var invalid *catrina.ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("%s: unexpected %v", body, err)
+	}
+
+	return invalid.Fields
+}
+
+func TestValidatedHandler(t *testing.T) {
+
+	bodies := []string{}
+	h := rest.NewValidatedHandler(bodyRecorder{bodies: &bodies}, rest.DefaultCodecs(), rest.NewStructValidator[validatedAccount]())
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		expected    int
+	}{
+		{"valid", "application/json", validAccount, http.StatusCreated},
+		{"invalid", "application/json", `{"age":30}`, http.StatusUnprocessableEntity},
+		{"undecodable", "application/json", `{"age":`, http.StatusBadRequest},
+		{"unsupported", "text/csv", "age,30", http.StatusUnsupportedMediaType},
+	}
+
+	for _, test := range tests {
+		r := catrina.NewRequest(context.Background(), http.MethodPost)
+		r.Headers.Set("Content-Type", test.contentType)
+		r.Body = strings.NewReader(test.body)
+
+		rs := h.Post(r)
+		if rs.Code != test.expected {
+			t.Errorf("%s: expected %d, got %d (%v)", test.name, test.expected, rs.Code, rs.Err)
+		}
+	}
+
+	// the handler reads the body that was validated
+	if !reflect.DeepEqual(bodies, []string{validAccount}) {
+		t.Errorf("unexpected bodies %q", bodies)
+	}
+}
@@ -0,0 +1,243 @@
+package rest
+
+import (
+	"io"
+	"fmt"
+	"sort"
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"encoding/xml"
+
+	"github.com/buduchail/catrina"
+)
+
+type (
+	// XML mirroring the JSON encoding of values: objects are <object>
+	// elements with a child per member, lists <list> elements with an
+	// <item> per value. Structs use encoding/xml instead, so that their
+	// xml tags apply, both to encode and decode them. Objects decoded
+	// into maps or interface{} values hold strings, and repeated
+	// elements become lists.
+	XMLCodec struct {
+	}
+)
+
+var (
+	xmlObjectErr = errors.New("Expected an XML object")
+)
+
+func (c XMLCodec) MediaType() string {
+	return "application/xml"
+}
+
+func (c XMLCodec) Encode(v interface{}) (catrina.Payload, error) {
+
+	buf := bytes.Buffer{}
+	e := xml.NewEncoder(&buf)
+
+	err := c.encode(e, "", v)
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (c XMLCodec) Decode(payload catrina.Payload, v interface{}) error {
+
+	switch target := v.(type) {
+	case *interface{}:
+		value, err := decodeXMLDocument(payload)
+		if err != nil {
+			return err
+		}
+		*target = value
+		return nil
+	case *map[string]interface{}:
+		value, err := decodeXMLDocument(payload)
+		if err != nil {
+			return err
+		}
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			// an empty element is an empty object
+			if value != "" {
+				return xmlObjectErr
+			}
+			object = map[string]interface{}{}
+		}
+		*target = object
+		return nil
+	}
+
+	return xml.Unmarshal(payload, v)
+}
+
+// Helper methods
+
+// name is the element of values other than structs, or "" for the
+// default one of their kind
+func (c XMLCodec) encode(e *xml.Encoder, name string, v interface{}) error {
+
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Ptr && !value.IsNil() {
+		value = value.Elem()
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		if value.Type() != timeType {
+			return e.Encode(value.Interface())
+		}
+	case reflect.Slice, reflect.Array:
+		// []byte is base64 text, as in JSON
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			break
+		}
+		if name == "" {
+			name = "list"
+		}
+		start := xml.StartElement{Name: xml.Name{Local: name}}
+		err := e.EncodeToken(start)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < value.Len(); i++ {
+			err = c.encode(e, "item", value.Index(i).Interface())
+			if err != nil {
+				return err
+			}
+		}
+		return e.EncodeToken(start.End())
+	}
+
+	generic, err := toGeneric(v)
+	if err != nil {
+		return err
+	}
+
+	if name == "" {
+		name = "object"
+	}
+
+	return encodeXMLValue(e, name, generic)
+}
+
+// Encodes a value decoded from JSON
+func encodeXMLValue(e *xml.Encoder, name string, v interface{}) error {
+
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		err := e.EncodeToken(start)
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			err = encodeXMLValue(e, k, v[k])
+			if err != nil {
+				return err
+			}
+		}
+		return e.EncodeToken(start.End())
+	case []interface{}:
+		err := e.EncodeToken(start)
+		if err != nil {
+			return err
+		}
+		for _, item := range v {
+			err = encodeXMLValue(e, "item", item)
+			if err != nil {
+				return err
+			}
+		}
+		return e.EncodeToken(start.End())
+	case nil:
+		err := e.EncodeToken(start)
+		if err != nil {
+			return err
+		}
+		return e.EncodeToken(start.End())
+	}
+
+	return e.EncodeElement(fmt.Sprint(v), start)
+}
+
+func decodeXMLDocument(payload catrina.Payload) (interface{}, error) {
+
+	d := xml.NewDecoder(bytes.NewReader(payload))
+
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			return nil, xmlObjectErr
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := token.(xml.StartElement); ok {
+			return decodeXMLElement(d)
+		}
+	}
+}
+
+// Reads the content of an element up to its end: its text if it has
+// no children, a list if they are all <item> elements, and an object
+// otherwise.
+func decodeXMLElement(d *xml.Decoder) (interface{}, error) {
+
+	text := strings.Builder{}
+	names := []string{}
+	children := map[string][]interface{}{}
+
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.CharData:
+			text.Write(t)
+		case xml.StartElement:
+			child, err := decodeXMLElement(d)
+			if err != nil {
+				return nil, err
+			}
+			name := t.Name.Local
+			if _, exists := children[name]; !exists {
+				names = append(names, name)
+			}
+			children[name] = append(children[name], child)
+		case xml.EndElement:
+			if len(names) == 0 {
+				return text.String(), nil
+			}
+			if len(names) == 1 && names[0] == "item" {
+				return children["item"], nil
+			}
+			object := make(map[string]interface{}, len(names))
+			for _, name := range names {
+				if len(children[name]) == 1 {
+					object[name] = children[name][0]
+				} else {
+					object[name] = children[name]
+				}
+			}
+			return object, nil
+		}
+	}
+}
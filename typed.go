@@ -0,0 +1,56 @@
+package catrina
+
+import "context"
+
+type (
+	// Typed variant of ContextCRUD: objects are T values instead of
+	// interface{}, and ids are ID values.
+	TypedCRUD[T any, ID any] interface {
+		Insert(ctx context.Context, object T) (id ID, e error)
+		Select(ctx context.Context, id ID) (T, error)
+		SelectWhereFields(ctx context.Context, fields []string, values []Value) (<-chan TypedRow[T], error)
+		SelectWhereRange(ctx context.Context, field string, min, max Value) (<-chan TypedRow[T], error)
+		SelectWhere(ctx context.Context, filter Filter) (<-chan TypedRow[T], error)
+		SelectQuery(ctx context.Context, query Query) (<-chan TypedRow[T], error)
+		Count(ctx context.Context, filter Filter) (int64, error)
+		SelectWhereExpression(ctx context.Context, expr string, values []Value) (<-chan TypedRow[T], error)
+		Update(ctx context.Context, id ID, object T) error
+		// The zero ID is generated as by Insert
+		Upsert(ctx context.Context, id ID, object T) (ID, error)
+		UpdateIfVersion(ctx context.Context, id ID, field string, version Value, object T) error
+		Delete(ctx context.Context, id ID) error
+		DeleteIfVersion(ctx context.Context, id ID, field string, version Value) error
+		InsertMany(ctx context.Context, objects []T) ([]TypedBatchResult[ID], error)
+		UpdateMany(ctx context.Context, ids []ID, objects []T) ([]TypedBatchResult[ID], error)
+		DeleteMany(ctx context.Context, ids []ID) ([]TypedBatchResult[ID], error)
+	}
+
+	TypedRow[T any] struct {
+		Result T
+		Error  error
+	}
+
+	// Typed variant of ContextResourceHandler: request bodies are
+	// decoded into T values, and returned values are encoded into the
+	// response body. PATCH is derived from Get and Put.
+	TypedResourceHandler[T any] interface {
+		Options(ctx context.Context) (
+			code int, err error,
+		)
+		Post(ctx context.Context, parentIds []string, object T) (
+			code int, body T, err error,
+		)
+		Get(ctx context.Context, id string, parentIds []string) (
+			code int, body T, err error,
+		)
+		GetMany(ctx context.Context, parentIds []string, query QueryParameters) (
+			code int, body []T, err error,
+		)
+		Put(ctx context.Context, id string, parentIds []string, object T) (
+			code int, body T, err error,
+		)
+		Delete(ctx context.Context, id string, parentIds []string) (
+			code int, err error,
+		)
+	}
+)